@@ -0,0 +1,162 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/secure"
+)
+
+// SaveAttachment encrypts the content of the attachment and stores it for the user.
+func (r *Repository) SaveAttachment(creds *entity.Credentials, attachment entity.Attachment, content []byte) error {
+	err := attachment.Verify(content)
+	if err != nil {
+		return err
+	}
+
+	key, err := r.attachmentsKey(creds)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := secure.EncryptAESGCM(key, content)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt attachment: %w", err)
+	}
+
+	path, err := r.attachmentPath(creds.Username, attachment.ID)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create attachments directory: %w", err)
+	}
+	err = os.WriteFile(path, ciphertext, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write attachment: %w", err)
+	}
+	return nil
+}
+
+// GetAttachment returns the content of an attachment stored for the user, or ErrNotFound.
+func (r *Repository) GetAttachment(creds *entity.Credentials, attachment entity.Attachment) ([]byte, error) {
+	path, err := r.attachmentPath(creds.Username, attachment.ID)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: attachment %s is not stored", ErrNotFound, attachment.ID)
+		}
+		return nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+
+	key, err := r.attachmentsKey(creds)
+	if err != nil {
+		return nil, err
+	}
+	content, err := secure.DecryptAESGCM(key, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt attachment: %w", err)
+	}
+	err = attachment.Verify(content)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// HasAttachment reports whether the attachment is stored for the user.
+func (r *Repository) HasAttachment(username string, attachmentID uuid.UUID) bool {
+	path, err := r.attachmentPath(username, attachmentID)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// attachmentsKey returns the key which the user's attachments are encrypted with, creating it the first time.
+// The key is stored encrypted with the key derived from the password, so that changing the password
+// does not require every attachment to be re-encrypted.
+func (r *Repository) attachmentsKey(creds *entity.Credentials) ([]byte, error) {
+	uc, err := getUserConversations(r.db, creds.Username)
+	if err != nil {
+		return nil, err
+	}
+	passwordKey, err := secure.DeriveKey(creds.Password, uc.EncryptionParams, r.keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	if uc.AttachmentsKey != "" {
+		return decryptAttachmentsKey(passwordKey, uc.AttachmentsKey)
+	}
+
+	// Make sure the password is correct before the new key is encrypted with it.
+	_, err = decryptConversations(passwordKey, uc.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, r.keyLength)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate attachments key: %w", err)
+	}
+	uc.AttachmentsKey, err = encryptAttachmentsKey(passwordKey, key)
+	if err != nil {
+		return nil, err
+	}
+	uc.UpdatedAt = time.Now()
+	err = updateUserConversations(r.db, uc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store attachments key: %w", err)
+	}
+	return key, nil
+}
+
+// encryptAttachmentsKey returns the base64 encoded attachments key encrypted with the given key.
+func encryptAttachmentsKey(key, attachmentsKey []byte) (string, error) {
+	ciphertext, err := secure.EncryptAESGCM(key, attachmentsKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt attachments key: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptAttachmentsKey decodes and decrypts the attachments key using the given key.
+func decryptAttachmentsKey(key []byte, ciphertext string) ([]byte, error) {
+	decoded, err := base64.RawStdEncoding.Strict().DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attachments key: %w", err)
+	}
+	attachmentsKey, err := secure.DecryptAESGCM(key, decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt attachments key: %w", err)
+	}
+	return attachmentsKey, nil
+}
+
+func (r *Repository) userAttachmentsDir(username string) (string, error) {
+	if !filepath.IsLocal(username) {
+		return "", fmt.Errorf("username %q cannot be used as a directory name", username)
+	}
+	return filepath.Join(r.attachmentsDir, username), nil
+}
+
+func (r *Repository) attachmentPath(username string, attachmentID uuid.UUID) (string, error) {
+	dir, err := r.userAttachmentsDir(username)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, attachmentID.String()), nil
+}
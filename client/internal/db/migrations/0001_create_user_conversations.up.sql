@@ -0,0 +1,9 @@
+-- Databases created before migrations were introduced already have this table,
+-- so this migration must be safe to apply on top of them.
+CREATE TABLE IF NOT EXISTS user_conversations (
+	username TEXT PRIMARY KEY,
+	ciphertext TEXT NOT NULL,
+	encryption_params TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS server_pins (
+	server_addr TEXT PRIMARY KEY,
+	public_key_pin TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_settings (
+	username TEXT PRIMARY KEY,
+	send_read_receipts BOOLEAN NOT NULL DEFAULT TRUE,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- The key which attachments are encrypted with is created when the user first stores one.
+ALTER TABLE user_conversations ADD COLUMN attachments_key TEXT NOT NULL DEFAULT '';
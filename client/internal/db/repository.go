@@ -2,10 +2,12 @@ package db
 
 import (
 	"database/sql"
+	"embed"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"runtime"
 	"time"
 
@@ -13,20 +15,33 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/migrate"
 	"github.com/Broderick-Westrope/teatime/internal/secure"
 )
 
 var (
 	ErrNotFound = errors.New("not found")
+
+	// ErrOutOfSync is returned when the server password was changed but the local data could not be
+	// re-encrypted, and the server password could not be reverted.
+	ErrOutOfSync = errors.New("server and local passwords are out of sync")
 )
 
+// migrationsFS holds the schema migrations for the client database.
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
 type Repository struct {
 	db          *sql.DB
 	argonParams *secure.ArgonParams
 	keyLength   uint32
+	// attachmentsDir is where the encrypted attachments of each user are stored.
+	attachmentsDir string
 }
 
-func NewRepository(dbConn string) (*Repository, error) {
+// NewRepository opens the database and stores attachments in a directory for each user inside attachmentsDir.
+func NewRepository(dbConn, attachmentsDir string) (*Repository, error) {
 	db, err := initDB(dbConn)
 	if err != nil {
 		return nil, err
@@ -40,7 +55,8 @@ func NewRepository(dbConn string) (*Repository, error) {
 			Parallelism: uint8(runtime.NumCPU()),
 			SaltLength:  16,
 		},
-		keyLength: 24,
+		keyLength:      24,
+		attachmentsDir: attachmentsDir,
 	}, nil
 }
 
@@ -50,18 +66,10 @@ func initDB(dbConn string) (*sql.DB, error) {
 		return nil, err
 	}
 
-	// Create the user_conversations table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS user_conversations (
-		username TEXT PRIMARY KEY,
-		ciphertext TEXT NOT NULL,
-		encryption_params TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-	if _, err = db.Exec(createTableSQL); err != nil {
-		return nil, fmt.Errorf("create table failed: %w", err)
+	// Older databases are upgraded to the latest schema
+	err = migrate.LoadAndUp(db, migrationsFS, "migrations", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 	return db, nil
 }
@@ -80,70 +88,142 @@ func (r *Repository) GetConversations(username, password string) ([]entity.Conve
 		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
 	}
 
-	decodedCiphertext, err := base64.RawStdEncoding.Strict().DecodeString(uc.Ciphertext)
+	return decryptConversations(key, uc.Ciphertext)
+}
+
+func (r *Repository) UpdateConversations(creds *entity.Credentials, conversations []entity.Conversation) error {
+	uc, err := getUserConversations(r.db, creds.Username)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
+		return err
 	}
 
-	plaintextBytes, err := secure.DecryptAESGCM(key, decodedCiphertext)
+	key, err := secure.DeriveKey(creds.Password, uc.EncryptionParams, r.keyLength)
 	if err != nil {
-		if errors.Is(err, secure.ErrFailedToDecrypt) {
-			return nil, fmt.Errorf("failed to decrypt ciphertext: %w", err)
-		}
-		return nil, fmt.Errorf("failed to decrypt ciphertext: %w", err)
+		return fmt.Errorf("failed to derive encryption key: %w", err)
 	}
 
-	var conversations []entity.Conversation
-	err = json.Unmarshal(plaintextBytes, &conversations)
+	uc.Ciphertext, err = encryptConversations(key, conversations)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal decrypted conversations: %w", err)
+		return err
 	}
+	uc.UpdatedAt = time.Now()
 
-	return conversations, nil
+	return updateUserConversations(r.db, uc)
 }
 
-func (r *Repository) UpdateConversations(creds *entity.Credentials, conversations []entity.Conversation) error {
+// ChangePassword re-encrypts the conversations using a key derived from the new password with fresh parameters.
+// The server is updated using updateServer while the local changes are held in a transaction, so that
+// the local changes are only committed once the server has accepted the new password. If committing fails
+// then revertServer is used to restore the old password on the server. ErrOutOfSync is returned if this also fails.
+func (r *Repository) ChangePassword(
+	creds *entity.Credentials, newPassword string, conversations []entity.Conversation,
+	updateServer, revertServer func() error,
+) error {
 	uc, err := getUserConversations(r.db, creds.Username)
 	if err != nil {
 		return err
 	}
 
-	key, err := secure.DeriveKey(creds.Password, uc.EncryptionParams, r.keyLength)
+	// Make sure the old password is correct before changing anything.
+	oldKey, err := secure.DeriveKey(creds.Password, uc.EncryptionParams, r.keyLength)
 	if err != nil {
-		return fmt.Errorf("failed to derive encryption key: %w", err)
+		return fmt.Errorf("failed to derive old encryption key: %w", err)
+	}
+	_, err = decryptConversations(oldKey, uc.Ciphertext)
+	if err != nil {
+		return err
 	}
 
-	jsonBytes, err := json.Marshal(&conversations)
+	newKey, newParams, err := secure.CreateKey(newPassword, r.argonParams, r.keyLength)
+	if err != nil {
+		return fmt.Errorf("failed to create new encryption key: %w", err)
+	}
+	uc.Ciphertext, err = encryptConversations(newKey, conversations)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal decrypted conversations: %w", err)
+		return err
 	}
+	uc.EncryptionParams = newParams
+	uc.UpdatedAt = time.Now()
 
-	ciphertextBytes, err := secure.EncryptAESGCM(key, jsonBytes)
+	// The attachments themselves are encrypted with a key which does not change, so only that key is re-encrypted.
+	if uc.AttachmentsKey != "" {
+		attachmentsKey, err := decryptAttachmentsKey(oldKey, uc.AttachmentsKey)
+		if err != nil {
+			return err
+		}
+		uc.AttachmentsKey, err = encryptAttachmentsKey(newKey, attachmentsKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := r.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to encrypt ciphertext: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	encodedCiphertext := base64.RawStdEncoding.EncodeToString(ciphertextBytes)
+	err = updateUserConversations(tx, uc)
+	if err != nil {
+		return fmt.Errorf("failed to update user conversations: %w", err)
+	}
 
-	uc.UpdatedAt = time.Now()
-	uc.Ciphertext = encodedCiphertext
+	err = updateServer()
+	if err != nil {
+		return fmt.Errorf("failed to update server password: %w", err)
+	}
 
-	return updateUserConversations(r.db, uc)
+	err = tx.Commit()
+	if err != nil {
+		commitErr := fmt.Errorf("failed to commit transaction: %w", err)
+		if err = revertServer(); err != nil {
+			return fmt.Errorf("%w: %w: failed to revert server password: %w", ErrOutOfSync, commitErr, err)
+		}
+		return commitErr
+	}
+	return nil
 }
 
-func (r *Repository) setupNewUser(username, password string) ([]entity.Conversation, error) {
-	key, hash, err := secure.CreateKey(password, r.argonParams, r.keyLength)
+// DeleteConversations erases all locally stored data for the user.
+func (r *Repository) DeleteConversations(username string) error {
+	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	initialConversations := make([]entity.Conversation, 0)
-	jsonBytes, err := json.Marshal(initialConversations)
+	err = deleteUserConversations(tx, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user conversations: %w", err)
+	}
+	err = deleteUserSettings(tx, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user settings: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	dir, err := r.userAttachmentsDir(username)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) setupNewUser(username, password string) ([]entity.Conversation, error) {
+	key, hash, err := secure.CreateKey(password, r.argonParams, r.keyLength)
 	if err != nil {
 		return nil, err
 	}
 
-	ciphertextBytes, err := secure.EncryptAESGCM(key, jsonBytes)
+	initialConversations := make([]entity.Conversation, 0)
+	ciphertext, err := encryptConversations(key, initialConversations)
 	if err != nil {
 		return nil, err
 	}
@@ -153,10 +233,44 @@ func (r *Repository) setupNewUser(username, password string) ([]entity.Conversat
 		Username:         username,
 		CreatedAt:        now,
 		UpdatedAt:        now,
-		Ciphertext:       base64.RawStdEncoding.EncodeToString(ciphertextBytes),
+		Ciphertext:       ciphertext,
 		EncryptionParams: hash,
 	}
 
 	err = insertUserConversations(r.db, uc)
 	return initialConversations, err
 }
+
+// encryptConversations returns the base64 encoded ciphertext of the conversations encrypted with the given key.
+func encryptConversations(key []byte, conversations []entity.Conversation) (string, error) {
+	jsonBytes, err := json.Marshal(&conversations)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal conversations: %w", err)
+	}
+
+	ciphertextBytes, err := secure.EncryptAESGCM(key, jsonBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt ciphertext: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(ciphertextBytes), nil
+}
+
+// decryptConversations decodes and decrypts the base64 encoded ciphertext using the given key.
+func decryptConversations(key []byte, ciphertext string) ([]entity.Conversation, error) {
+	decodedCiphertext, err := base64.RawStdEncoding.Strict().DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+
+	plaintextBytes, err := secure.DecryptAESGCM(key, decodedCiphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt ciphertext: %w", err)
+	}
+
+	var conversations []entity.Conversation
+	err = json.Unmarshal(plaintextBytes, &conversations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal decrypted conversations: %w", err)
+	}
+	return conversations, nil
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GetServerPin returns the public key pinned for the server address and whether one exists.
+func (r *Repository) GetServerPin(serverAddr string) (string, bool, error) {
+	var pin string
+	err := r.db.QueryRow(`SELECT public_key_pin FROM server_pins WHERE server_addr = ?`, serverAddr).Scan(&pin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return pin, true, nil
+}
+
+// SetServerPin pins the public key for the server address, replacing any existing pin.
+func (r *Repository) SetServerPin(serverAddr, pin string) error {
+	query := `
+	INSERT INTO server_pins (server_addr, public_key_pin, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(server_addr) DO UPDATE SET
+		public_key_pin=excluded.public_key_pin,
+		created_at=excluded.created_at;
+	`
+	_, err := r.db.Exec(query, serverAddr, pin, time.Now())
+	return err
+}
+
+// DeleteServerPin forgets the public key pinned for the server address so that
+// the key presented on the next connection is pinned instead.
+func (r *Repository) DeleteServerPin(serverAddr string) error {
+	_, err := r.db.Exec(`DELETE FROM server_pins WHERE server_addr = ?`, serverAddr)
+	return err
+}
@@ -7,38 +7,48 @@ import (
 	"time"
 )
 
+// dbtx is implemented by both *sql.DB and *sql.Tx so that queries can be run inside or outside a transaction.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 type UserConversations struct {
 	Username         string
 	Ciphertext       string // Base64 encoded, encrypted JSON data containing all conversations
 	EncryptionParams string // Encoded string containing parameters for deriving the encryption key from the password
+	AttachmentsKey   string // Base64 encoded key for attachments, encrypted with the key derived from the password
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-func insertUserConversations(db *sql.DB, uc *UserConversations) error {
+func insertUserConversations(db dbtx, uc *UserConversations) error {
 	query := `
-	INSERT INTO user_conversations (username, ciphertext, encryption_params, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?)
+	INSERT INTO user_conversations (username, ciphertext, encryption_params, attachments_key, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?)
 	ON CONFLICT(username) DO UPDATE SET
 		updated_at=excluded.updated_at,
 		ciphertext=excluded.ciphertext,
-		encryption_params=excluded.encryption_params;
+		encryption_params=excluded.encryption_params,
+		attachments_key=excluded.attachments_key;
 	`
-	_, err := db.Exec(query, uc.Username, uc.Ciphertext, uc.EncryptionParams, uc.CreatedAt, uc.UpdatedAt)
+	_, err := db.Exec(query,
+		uc.Username, uc.Ciphertext, uc.EncryptionParams, uc.AttachmentsKey, uc.CreatedAt, uc.UpdatedAt)
 	return err
 }
 
-func getUserConversations(db *sql.DB, username string) (*UserConversations, error) {
+func getUserConversations(db dbtx, username string) (*UserConversations, error) {
 	query := `
-	SELECT username, ciphertext, encryption_params, created_at, updated_at
+	SELECT username, ciphertext, encryption_params, attachments_key, created_at, updated_at
 	FROM user_conversations
 	WHERE username = ?
 	`
 	row := db.QueryRow(query, username)
 
 	var result UserConversations
-	err := row.Scan(&result.Username, &result.Ciphertext, &result.EncryptionParams, &result.CreatedAt, &result.UpdatedAt)
+	err := row.Scan(&result.Username, &result.Ciphertext, &result.EncryptionParams, &result.AttachmentsKey,
+		&result.CreatedAt, &result.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%w: no user found with username %q: %w", ErrNotFound, username, err)
@@ -48,18 +58,17 @@ func getUserConversations(db *sql.DB, username string) (*UserConversations, erro
 	return &result, nil
 }
 
-func updateUserConversations(db *sql.DB, uc *UserConversations) error {
+func updateUserConversations(db dbtx, uc *UserConversations) error {
 	updateSQL := `
 	UPDATE user_conversations
-	SET ciphertext = ?, encryption_params = ?, updated_at = ?
+	SET ciphertext = ?, encryption_params = ?, attachments_key = ?, updated_at = ?
 	WHERE username = ?
 	`
-	_, err := db.Exec(updateSQL, uc.Ciphertext, uc.EncryptionParams, uc.UpdatedAt, uc.Username)
+	_, err := db.Exec(updateSQL, uc.Ciphertext, uc.EncryptionParams, uc.AttachmentsKey, uc.UpdatedAt, uc.Username)
 	return err
 }
 
-//nolint:unused // Will be used in the future for erasing user data.
-func deleteUserConversations(db *sql.DB, username string) error {
+func deleteUserConversations(db dbtx, username string) error {
 	deleteSQL := `DELETE FROM user_conversations WHERE username = ?`
 	_, err := db.Exec(deleteSQL, username)
 	return err
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Settings are the preferences of a user on this device.
+type Settings struct {
+	// SendReadReceipts is whether other users are told when the user has read their messages.
+	SendReadReceipts bool
+}
+
+// DefaultSettings returns the settings used for users who have not changed any.
+func DefaultSettings() Settings {
+	return Settings{
+		SendReadReceipts: true,
+	}
+}
+
+// GetSettings returns the settings for the user, or the default settings if they have not changed any.
+func (r *Repository) GetSettings(username string) (Settings, error) {
+	settings := DefaultSettings()
+	err := r.db.QueryRow(`SELECT send_read_receipts FROM user_settings WHERE username = ?`, username).
+		Scan(&settings.SendReadReceipts)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Settings{}, err
+	}
+	return settings, nil
+}
+
+// SaveSettings stores the settings for the user, replacing any existing settings.
+func (r *Repository) SaveSettings(username string, settings Settings) error {
+	query := `
+	INSERT INTO user_settings (username, send_read_receipts, updated_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(username) DO UPDATE SET
+		send_read_receipts=excluded.send_read_receipts,
+		updated_at=excluded.updated_at;
+	`
+	_, err := r.db.Exec(query, username, settings.SendReadReceipts, time.Now())
+	return err
+}
+
+func deleteUserSettings(db dbtx, username string) error {
+	_, err := db.Exec(`DELETE FROM user_settings WHERE username = ?`, username)
+	return err
+}
@@ -1,6 +1,10 @@
 package components
 
 import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,16 +16,54 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/client/internal/tui/modals"
 	"github.com/Broderick-Westrope/teatime/internal/entity"
 )
 
 var _ tea.Model = &ChatModel{}
 
+const (
+	// typingRefreshInterval is how often the active user is announced as typing while they keep typing.
+	typingRefreshInterval = 3 * time.Second
+	// typingIdleTimeout is how long after the last keystroke the active user stops being announced as typing.
+	typingIdleTimeout = 5 * time.Second
+	// typingExpiry is how long another user is shown as typing without hearing from them again.
+	typingExpiry = 2 * typingRefreshInterval
+)
+
+// typingIdleMsg signals that the active user may have stopped typing.
+type typingIdleMsg struct {
+	seq int
+}
+
 type ChatModel struct {
 	conversation entity.Conversation
 	username     string
 	input        textinput.Model
 	vp           viewport.Model
+	presence     map[string]entity.Presence
+	enabled      bool
+	// editingID is the ID of the message being edited using the input. It is uuid.Nil when not editing.
+	editingID uuid.UUID
+	// selecting is whether the active user is choosing a message to act on instead of using the input.
+	selecting bool
+	// selectedIdx is the index of the selected message while selecting.
+	selectedIdx int
+	// selectedTop and selectedBottom are the first and last lines of the selected message in the viewport content.
+	selectedTop, selectedBottom int
+	// replyTo quotes the message which the input is replying to. It is nil when not replying.
+	replyTo *entity.MessageQuote
+	// threadID is the ID of the message whose thread is open. It is uuid.Nil when the whole conversation is shown.
+	threadID uuid.UUID
+
+	// typingSentAt is when the active user was last announced as typing. It is zero when they are not typing.
+	typingSentAt time.Time
+	// typingSeq identifies the latest keystroke so that earlier idle timers can be ignored.
+	typingSeq int
+	// typing holds when each user typing in each conversation should stop being shown as typing.
+	typing map[uuid.UUID]map[string]time.Time
+	// transfers holds the progress of each attachment which is being uploaded or downloaded, or whose transfer failed.
+	transfers map[uuid.UUID]tui.AttachmentTransferMsg
 
 	styles    *chatStyles
 	styleFunc func(width, height int) *chatStyles
@@ -47,6 +89,9 @@ func NewChatModel(conversation entity.Conversation, username string, enabled boo
 		conversation: conversation,
 		username:     username,
 		input:        input,
+		typing:       make(map[uuid.UUID]map[string]time.Time),
+		transfers:    make(map[uuid.UUID]tui.AttachmentTransferMsg),
+		enabled:      enabled,
 
 		styleFunc: styleFunc,
 		styles:    styleFunc(0, 0),
@@ -67,35 +112,384 @@ func (m *ChatModel) Init() tea.Cmd {
 }
 
 func (m *ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	if msg, ok := msg.(tea.KeyMsg); ok {
-		if msg.String() == "enter" {
+	switch msg := msg.(type) {
+	case typingIdleMsg:
+		if msg.seq != m.typingSeq {
+			return m, nil
+		}
+		return m, m.StopTyping()
+
+	case tea.KeyMsg:
+		if m.selecting {
+			return m, m.updateSelecting(msg)
+		}
+
+		switch {
+		case msg.String() == "tab" && !m.IsEditing():
+			m.startSelecting()
+			return m, nil
+
+		case msg.String() == "ctrl+o" && !m.IsEditing():
+			return m, tui.OpenModalCmd(modals.NewAttachFileModel(m.conversation.Metadata))
+
+		case msg.String() == "up" && m.input.Value() == "" && !m.IsEditing():
+			if m.startEditingLastMessage() {
+				return m, nil
+			}
+
+		case msg.String() == "enter" && m.IsEditing():
+			return m, m.finishEditing()
+
+		case msg.String() == "enter":
 			value := m.input.Value()
 			if len(strings.TrimSpace(value)) == 0 {
 				return m, nil
 			}
 			newMsg := entity.Message{
+				ID:      uuid.New(),
 				Content: value,
 				Author:  m.username,
 				SentAt:  time.Now(),
+				ReplyTo: m.replyQuote(),
 			}
+			m.replyTo = nil
 			m.input.Reset()
-			return m, tui.SendMessageCmd(newMsg, m.conversation.Metadata)
+			return m, tea.Batch(m.StopTyping(), tui.SendMessageCmd(newMsg, m.conversation.Metadata))
 		}
 	}
 
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
+	prevValue := m.input.Value()
 	m.input, cmd = m.input.Update(msg)
 	cmds = append(cmds, cmd)
+	if m.input.Value() != prevValue {
+		cmds = append(cmds, m.handleInputChanged())
+	}
 	m.vp, cmd = m.vp.Update(msg)
 	cmds = append(cmds, cmd)
 
 	return m, tea.Batch(cmds...)
 }
 
+// IsEditing reports whether the input is being used to edit a message rather than write a new one.
+func (m *ChatModel) IsEditing() bool {
+	return m.editingID != uuid.Nil
+}
+
+// CancelEdit stops editing a message without changing it.
+func (m *ChatModel) CancelEdit() {
+	m.editingID = uuid.Nil
+	m.input.Reset()
+}
+
+// startEditingLastMessage fills the input with the last message sent by the active user so that it can be edited.
+// It reports whether there was a message to edit.
+func (m *ChatModel) startEditingLastMessage() bool {
+	for i := len(m.conversation.Messages) - 1; i >= 0; i-- {
+		msg := m.conversation.Messages[i]
+		if msg.Author == m.username {
+			return m.startEditing(msg)
+		}
+	}
+	return false
+}
+
+// startEditing fills the input with the message so that it can be edited.
+// It reports whether the message can be edited, which is only the case for messages sent by the active user.
+func (m *ChatModel) startEditing(msg entity.Message) bool {
+	if msg.Author != m.username || msg.ID == uuid.Nil {
+		// Messages without an ID were sent before edits were supported.
+		return false
+	}
+	m.editingID = msg.ID
+	m.input.SetValue(msg.Content)
+	m.input.CursorEnd()
+	return true
+}
+
+// IsSelecting reports whether the active user is choosing a message to act on.
+func (m *ChatModel) IsSelecting() bool {
+	return m.selecting
+}
+
+// startSelecting moves the focus from the input to the messages, starting with the newest one selected.
+func (m *ChatModel) startSelecting() {
+	messages := m.visibleMessages()
+	if len(messages) == 0 {
+		return
+	}
+	m.selecting = true
+	m.selectedIdx = len(messages) - 1
+	m.input.Blur()
+	m.refreshViewportContent()
+}
+
+// StopSelecting moves the focus from the messages back to the input.
+func (m *ChatModel) StopSelecting() tea.Cmd {
+	m.selecting = false
+	m.refreshViewportContent()
+	return m.input.Focus()
+}
+
+// updateSelecting handles the keys used to choose a message and act on it.
+func (m *ChatModel) updateSelecting(msg tea.KeyMsg) tea.Cmd {
+	messages := m.visibleMessages()
+	selected := messages[m.selectedIdx]
+
+	switch msg.String() {
+	case "up", "k":
+		m.selectedIdx = max(m.selectedIdx-1, 0)
+		m.refreshViewportContent()
+
+	case "down", "j":
+		m.selectedIdx = min(m.selectedIdx+1, len(messages)-1)
+		m.refreshViewportContent()
+
+	case "tab":
+		return m.StopSelecting()
+
+	case "enter":
+		if selected.ID == uuid.Nil {
+			// Messages without an ID were sent before replies were supported.
+			return nil
+		}
+		m.replyTo = selected.Quote()
+		return m.StopSelecting()
+
+	case "g":
+		if selected.ReplyTo == nil {
+			return nil
+		}
+		for i, msg := range messages {
+			if msg.ID == selected.ReplyTo.ID {
+				m.selectedIdx = i
+				m.refreshViewportContent()
+				break
+			}
+		}
+
+	case "t":
+		if selected.ID == uuid.Nil || selected.ID == m.threadID {
+			return nil
+		}
+		m.threadID = selected.ID
+		m.replyTo = nil
+		return m.StopSelecting()
+
+	case "e":
+		if m.startEditing(selected) {
+			return m.StopSelecting()
+		}
+
+	case "r":
+		if selected.ID == uuid.Nil {
+			// Messages without an ID were sent before reactions were supported.
+			return nil
+		}
+		return tui.OpenModalCmd(modals.NewReactionModel(m.conversation.Metadata, selected, m.username))
+
+	case "d", "delete", "backspace":
+		if selected.ID == uuid.Nil {
+			// Messages without an ID were sent before deletion was supported.
+			return nil
+		}
+		canDeleteForEveryone := selected.Author == m.username
+		return tui.OpenModalCmd(modals.NewDeleteMessageModel(m.conversation.Metadata, selected, canDeleteForEveryone))
+
+	case "s":
+		if selected.Attachment == nil {
+			return nil
+		}
+		if transfer, ok := m.transfers[selected.Attachment.ID]; ok && transfer.Err == nil {
+			// The attachment is already being transferred.
+			return nil
+		}
+		return tui.SaveAttachmentCmd(*selected.Attachment)
+	}
+	return nil
+}
+
+// finishEditing saves the edited message, unless it is empty or unchanged.
+func (m *ChatModel) finishEditing() tea.Cmd {
+	messageID := m.editingID
+	value := m.input.Value()
+	m.CancelEdit()
+
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil
+	}
+	for _, msg := range m.conversation.Messages {
+		if msg.ID == messageID && msg.Content != value {
+			return tui.EditMessageCmd(m.conversation.Metadata, messageID, value, time.Now())
+		}
+	}
+	return nil
+}
+
+// EditMessage replaces the content of the message if it is in the open conversation and was sent by the author.
+func (m *ChatModel) EditMessage(
+	conversationID, messageID uuid.UUID, author, content string, editedAt time.Time,
+) {
+	if conversationID != m.conversation.Metadata.ID {
+		return
+	}
+	if editMessage(m.conversation.Messages, messageID, author, content, editedAt) {
+		m.refreshViewportContent()
+	}
+}
+
+// SetReaction adds or removes the user's reaction to the message if it is in the open conversation.
+func (m *ChatModel) SetReaction(conversationID, messageID uuid.UUID, emoji, username string, reacted bool) {
+	if conversationID != m.conversation.Metadata.ID {
+		return
+	}
+	if setReaction(m.conversation.Messages, messageID, emoji, username, reacted) {
+		m.refreshViewportContent()
+	}
+}
+
+// DeleteMessage removes the message if it is in the open conversation.
+// An empty author deletes the message regardless of who sent it, as described by deleteMessage.
+func (m *ChatModel) DeleteMessage(conversationID, messageID uuid.UUID, author string) {
+	if conversationID != m.conversation.Metadata.ID {
+		return
+	}
+	if !deleteMessage(&m.conversation, messageID, author) {
+		return
+	}
+	if messageID == m.editingID {
+		m.CancelEdit()
+	}
+	if m.replyTo != nil && m.replyTo.ID == messageID {
+		m.replyTo = nil
+	}
+	visible := len(m.visibleMessages())
+	if visible == 0 {
+		m.selecting = false
+	}
+	m.selectedIdx = min(m.selectedIdx, visible-1)
+	m.refreshViewportContent()
+}
+
+// SetTransfer shows how far an attachment has been uploaded or downloaded on the messages it is attached to.
+// Transfers are forgotten once they complete.
+func (m *ChatModel) SetTransfer(transfer tui.AttachmentTransferMsg) {
+	if transfer.Err == nil && transfer.Done == transfer.Size {
+		delete(m.transfers, transfer.AttachmentID)
+	} else {
+		m.transfers[transfer.AttachmentID] = transfer
+	}
+	m.refreshViewportContent()
+}
+
+// IsReplying reports whether the input is being used to reply to a message.
+func (m *ChatModel) IsReplying() bool {
+	return m.replyTo != nil
+}
+
+// CancelReply stops replying to a message, leaving anything written in the input.
+func (m *ChatModel) CancelReply() {
+	m.replyTo = nil
+}
+
+// replyQuote returns the quote of the message which a new message replies to. Messages written while a thread
+// is open reply to the message which started it unless the active user chose to reply to another message.
+func (m *ChatModel) replyQuote() *entity.MessageQuote {
+	if m.replyTo != nil || m.threadID == uuid.Nil {
+		return m.replyTo
+	}
+	for _, msg := range m.conversation.Messages {
+		if msg.ID == m.threadID {
+			return msg.Quote()
+		}
+	}
+	// The message which started the thread has been deleted, but replies can still be linked to it.
+	return &entity.MessageQuote{ID: m.threadID}
+}
+
+// IsInThread reports whether a thread is open instead of the whole conversation.
+func (m *ChatModel) IsInThread() bool {
+	return m.threadID != uuid.Nil
+}
+
+// CloseThread goes back to showing the whole conversation.
+func (m *ChatModel) CloseThread() {
+	m.threadID = uuid.Nil
+	m.replyTo = nil
+	m.refreshViewportContent()
+}
+
+// visibleMessages returns the messages shown in the viewport, which are only those in the thread while one is open.
+func (m *ChatModel) visibleMessages() []entity.Message {
+	if m.threadID == uuid.Nil {
+		return m.conversation.Messages
+	}
+	return threadMessages(m.conversation.Messages, m.threadID)
+}
+
+// handleInputChanged announces that the active user is typing. The announcement is repeated while they
+// keep typing so that it does not expire for the other participants, and is withdrawn once they go idle.
+func (m *ChatModel) handleInputChanged() tea.Cmd {
+	if m.IsEditing() {
+		// Editing a message is not announced since the others are not waiting on a new message.
+		return nil
+	}
+	if m.input.Value() == "" {
+		return m.StopTyping()
+	}
+
+	var cmds []tea.Cmd
+	if time.Since(m.typingSentAt) >= typingRefreshInterval {
+		m.typingSentAt = time.Now()
+		cmds = append(cmds, tui.SendTypingCmd(m.conversation.Metadata, true))
+	}
+
+	m.typingSeq++
+	seq := m.typingSeq
+	cmds = append(cmds, tea.Tick(typingIdleTimeout, func(time.Time) tea.Msg {
+		return typingIdleMsg{seq: seq}
+	}))
+	return tea.Batch(cmds...)
+}
+
+// StopTyping withdraws the announcement that the active user is typing, if there is one.
+func (m *ChatModel) StopTyping() tea.Cmd {
+	if m.typingSentAt.IsZero() {
+		return nil
+	}
+	m.typingSentAt = time.Time{}
+	return tui.SendTypingCmd(m.conversation.Metadata, false)
+}
+
+// SetTyping shows or hides that the user is typing in the conversation. The indicator is hidden
+// automatically once typingExpiry has passed, in case the message saying they stopped is lost.
+func (m *ChatModel) SetTyping(conversationID uuid.UUID, username string, typing bool) tea.Cmd {
+	if !typing {
+		delete(m.typing[conversationID], username)
+		return nil
+	}
+
+	if m.typing[conversationID] == nil {
+		m.typing[conversationID] = make(map[string]time.Time)
+	}
+	m.typing[conversationID][username] = time.Now().Add(typingExpiry)
+	return tea.Tick(typingExpiry, func(time.Time) tea.Msg {
+		return tui.TypingExpiredMsg{}
+	})
+}
+
 // SetConversation updates the model state to have the given messages and chatName.
 // It also refreshes the viewport content.
 func (m *ChatModel) SetConversation(conversation entity.Conversation) {
+	m.typingSentAt = time.Time{}
+	m.editingID = uuid.Nil
+	m.replyTo = nil
+	m.threadID = uuid.Nil
+	if m.selecting {
+		m.selecting = false
+		m.input.Focus()
+	}
 	m.conversation = conversation
 	m.refreshViewportContent()
 }
@@ -104,18 +498,54 @@ func (m *ChatModel) GetConversationID() uuid.UUID {
 	return m.conversation.Metadata.ID
 }
 
+// SetPresence updates the presence of users shown in the header.
+func (m *ChatModel) SetPresence(presence map[string]entity.Presence) {
+	m.presence = presence
+}
+
 func (m *ChatModel) AddNewMessage(msg entity.Message) {
 	m.conversation.Messages = append(m.conversation.Messages, msg)
 	m.refreshViewportContent()
 }
 
+// SetMessageStatus advances the status of the messages if they are in the open conversation.
+func (m *ChatModel) SetMessageStatus(conversationID uuid.UUID, messageIDs []uuid.UUID, status entity.MessageStatus) {
+	if conversationID != m.conversation.Metadata.ID {
+		return
+	}
+	if setMessageStatus(m.conversation.Messages, messageIDs, status) {
+		m.refreshViewportContent()
+	}
+}
+
+// MarkRead marks the messages from other users in the open conversation as read, but only while
+// the chat is focussed since that is when the user is actually looking at them.
+func (m *ChatModel) MarkRead() tea.Cmd {
+	if !m.enabled {
+		return nil
+	}
+
+	var read []entity.Message
+	for i, msg := range m.conversation.Messages {
+		if msg.Author == m.username || msg.ID == uuid.Nil || !msg.Status.IsBefore(entity.MessageStatusRead) {
+			continue
+		}
+		m.conversation.Messages[i].Status = entity.MessageStatusRead
+		read = append(read, m.conversation.Messages[i])
+	}
+	if len(read) == 0 {
+		return nil
+	}
+	return tui.MessagesReadCmd(m.conversation.Metadata, read)
+}
+
 // SetSize calculates and applies the correct size to its nested components.
 // The given width and height should be the dimensions for this component not the window.
 func (m *ChatModel) SetSize(width, height int) {
 	m.styles = m.styleFunc(width, height)
 	m.input.Width = width - (lipgloss.Width(m.input.Prompt) + lipgloss.Width(m.input.Cursor.View()))
 
-	verticalMargin := lipgloss.Height(m.viewHeader()) +
+	verticalMargin := lipgloss.Height(m.viewHeader()) + lipgloss.Height(m.viewTyping()) +
 		m.styles.Header.GetVerticalFrameSize() +
 		m.styles.Conversation.GetVerticalFrameSize()
 	m.vp.Width = width
@@ -126,12 +556,14 @@ func (m *ChatModel) SetSize(width, height int) {
 
 // Enable makes the model appear as though it is active/focussed.
 func (m *ChatModel) Enable() {
+	m.enabled = true
 	m.switchStyleFunc(enabledChatStyleFunc)
 	m.refreshViewportContent()
 }
 
 // Disable makes the model appear as though it is not active/focussed.
 func (m *ChatModel) Disable() {
+	m.enabled = false
 	m.switchStyleFunc(disabledChatStyleFunc)
 	m.refreshViewportContent()
 }
@@ -155,46 +587,113 @@ func (m *ChatModel) switchStyleFunc(styleFunc chatStyleFunc) {
 }
 
 // refreshViewportContent recalculates the messages and sets the viewport content to the result.
-// It also scrolls the viewport to the bottom to mimick the behaviour of common messaging apps. This should
-// be done after updating any styles since it will update the viewport content with the styled messages.
+// It also scrolls the viewport to the bottom to mimick the behaviour of common messaging apps, or just far
+// enough to show the selected message while selecting. This should be done after updating any styles
+// since it will update the viewport content with the styled messages.
 func (m *ChatModel) refreshViewportContent() {
 	m.vp.SetContent(m.viewConversation())
-	m.vp.GotoBottom()
+	if !m.selecting {
+		m.vp.GotoBottom()
+		return
+	}
+
+	switch {
+	case m.selectedTop < m.vp.YOffset:
+		m.vp.SetYOffset(m.selectedTop)
+	case m.selectedBottom >= m.vp.YOffset+m.vp.Height:
+		m.vp.SetYOffset(m.selectedBottom - m.vp.Height + 1)
+	}
 }
 
 func (m *ChatModel) View() string {
 	return lipgloss.JoinVertical(lipgloss.Center,
 		m.viewHeader(),
 		m.vp.View(),
+		m.viewTyping(),
 		m.input.View(),
 	)
 }
 
-// viewHeader returns the styled output for the header.
+// viewTyping returns the styled output for the users typing in the conversation.
+// It always takes up a line so that the layout does not shift when someone starts typing.
+func (m *ChatModel) viewTyping() string {
+	if m.IsEditing() {
+		return m.styles.Typing.Render(ansi.Truncate("Editing message · enter to save, esc to cancel", m.width, "…"))
+	}
+	if m.selecting {
+		hint := "↑/↓ select · enter reply · t thread · g original · r react · e edit · d delete"
+		if m.visibleMessages()[m.selectedIdx].Attachment != nil {
+			hint += " · s save"
+		}
+		return m.styles.Typing.Render(ansi.Truncate(hint, m.width, "…"))
+	}
+	if m.replyTo != nil {
+		output := fmt.Sprintf("Replying to %s: %s · esc to cancel", m.replyTo.Author, m.replyTo.Snippet)
+		return m.styles.Typing.Render(ansi.Truncate(output, m.width, "…"))
+	}
+
+	now := time.Now()
+	var usernames []string
+	for username, expiresAt := range m.typing[m.conversation.Metadata.ID] {
+		if now.Before(expiresAt) {
+			usernames = append(usernames, username)
+		}
+	}
+	slices.Sort(usernames)
+
+	var output string
+	switch len(usernames) {
+	case 0:
+		output = " "
+	case 1:
+		output = usernames[0] + " is typing…"
+	case 2:
+		output = usernames[0] + " and " + usernames[1] + " are typing…"
+	default:
+		output = fmt.Sprintf("%d people are typing…", len(usernames))
+	}
+	return m.styles.Typing.Render(ansi.Truncate(output, m.width, "…"))
+}
+
+// viewHeader returns the styled output for the header, including whether the other participants are online.
 func (m *ChatModel) viewHeader() string {
-	return m.styles.Header.Render(ansi.Truncate(m.conversation.Metadata.Name, m.width/2, "…")) + "\n"
+	header := m.conversation.Metadata.Name
+	if m.IsInThread() {
+		header += " › thread"
+	}
+	summary := summarisePresence(m.conversation.Metadata.Participants, m.username, m.presence)
+	if description := summary.description(); description != "" {
+		header = summary.indicator() + " " + header + " · " + description
+	}
+	return m.styles.Header.Render(ansi.Truncate(header, m.width/2, "…")) + "\n"
 }
 
 // viewConversation returns the styled output for the messages.
 func (m *ChatModel) viewConversation() string {
 	var output string
-	for i, msg := range m.conversation.Messages {
-		wasSentByThisUser := msg.Author == m.username
-		bubble := m.viewChatBubble(msg.Content, wasSentByThisUser)
+	messages := m.visibleMessages()
+	replies := countReplies(messages)
+	for i, msg := range messages {
+		selected := m.selecting && i == m.selectedIdx
+		bubble := m.viewChatBubble(msg, selected, replies[msg.ID])
 
 		if i == 0 {
 			output += m.viewTimestamp(msg.SentAt)
-			output += bubble
-			continue
+		} else {
+			prevMsg := messages[i-1]
+			switch {
+			case msg.SentAt.Sub(prevMsg.SentAt).Hours() > 12:
+				fallthrough
+			case msg.SentAt.Sub(prevMsg.SentAt).Hours() > 3 &&
+				prevMsg.SentAt.Day() < msg.SentAt.Day():
+				output += m.viewTimestamp(msg.SentAt)
+			}
 		}
 
-		prevMsg := m.conversation.Messages[i-1]
-		switch {
-		case msg.SentAt.Sub(prevMsg.SentAt).Hours() > 12:
-			fallthrough
-		case msg.SentAt.Sub(prevMsg.SentAt).Hours() > 3 &&
-			prevMsg.SentAt.Day() < msg.SentAt.Day():
-			output += m.viewTimestamp(msg.SentAt)
+		if selected {
+			// Both the output and the bubble end with a newline, so their last line is empty.
+			m.selectedTop = lipgloss.Height(output) - 1
+			m.selectedBottom = m.selectedTop + lipgloss.Height(bubble) - 2
 		}
 		output += bubble
 	}
@@ -202,8 +701,115 @@ func (m *ChatModel) viewConversation() string {
 }
 
 // viewChatBubble returns the styled output for a single chat bubble.
-func (m *ChatModel) viewChatBubble(msg string, placeOnRight bool) string {
-	return m.styles.BubbleStyleFunc(msg, placeOnRight, len(msg)) + "\n"
+// Messages sent by the active user are placed on the right along with ticks showing their status.
+// Replies are shown below the message they quote, and the number of replies to the message is shown underneath it.
+func (m *ChatModel) viewChatBubble(msg entity.Message, selected bool, replies int) string {
+	wasSentByThisUser := msg.Author == m.username
+	content, textLen := msg.Content, len(msg.Content)
+
+	if msg.Attachment != nil {
+		details := m.viewAttachmentDetails(*msg.Attachment)
+		content += " " + m.styles.Ticks.Render(details)
+		textLen += 1 + lipgloss.Width(details)
+	}
+
+	if msg.IsEdited() {
+		const editedMarker = "(edited)"
+		content += " " + m.styles.Ticks.Render(editedMarker)
+		textLen += 1 + len(editedMarker)
+	}
+
+	if wasSentByThisUser && msg.ID != uuid.Nil {
+		ticksStyle := m.styles.Ticks
+		switch msg.Status {
+		case entity.MessageStatusRead:
+			ticksStyle = m.styles.TicksRead
+		case entity.MessageStatusFailed:
+			ticksStyle = m.styles.TicksFailed
+		}
+		ticks := statusTicks(msg.Status)
+		content += " " + ticksStyle.Render(ticks)
+		textLen += 1 + lipgloss.Width(ticks)
+	}
+
+	bubble := chatBubble{
+		Content:    content,
+		TextLen:    textLen,
+		Reactions:  m.viewReactions(msg),
+		AlignRight: wasSentByThisUser,
+		Selected:   selected,
+	}
+	if msg.ReplyTo != nil {
+		quote := msg.ReplyTo.Author + ": " + msg.ReplyTo.Snippet
+		if msg.ReplyTo.Author == "" {
+			// Quotes of deleted messages only keep their ID.
+			quote = "deleted message"
+		}
+		bubble.Quote = m.styles.Quote.Render(quote)
+	}
+	if replies > 0 && !m.IsInThread() {
+		count := "↳ 1 reply"
+		if replies > 1 {
+			count = fmt.Sprintf("↳ %d replies", replies)
+		}
+		if bubble.Reactions != "" {
+			bubble.Reactions += "  "
+		}
+		bubble.Reactions += m.styles.Reaction.Render(count)
+	}
+	return m.styles.BubbleStyleFunc(bubble) + "\n"
+}
+
+// viewAttachmentDetails returns the size of the attachment, along with how far it has been transferred if it is
+// being uploaded or downloaded.
+func (m *ChatModel) viewAttachmentDetails(attachment entity.Attachment) string {
+	details := formatSize(attachment.Size)
+	transfer, ok := m.transfers[attachment.ID]
+	switch {
+	case !ok:
+	case transfer.Err != nil && transfer.Upload:
+		details += " · upload failed"
+	case transfer.Err != nil:
+		details += " · download failed"
+	case transfer.Upload:
+		details += fmt.Sprintf(" · ↑ %d%%", transfer.Done*100/attachment.Size)
+	default:
+		details += fmt.Sprintf(" · ↓ %d%%", transfer.Done*100/attachment.Size)
+	}
+	return "(" + details + ")"
+}
+
+// formatSize returns the number of bytes in the largest unit which keeps it at least one.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+// viewReactions returns the styled count of each reaction to the message, with the most common first.
+// Reactions made by the active user are highlighted. It is empty if there are no reactions.
+func (m *ChatModel) viewReactions(msg entity.Message) string {
+	emojis := slices.SortedFunc(maps.Keys(msg.Reactions), func(a, b string) int {
+		if byCount := cmp.Compare(len(msg.Reactions[b]), len(msg.Reactions[a])); byCount != 0 {
+			return byCount
+		}
+		return strings.Compare(a, b)
+	})
+
+	reactions := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		style := m.styles.Reaction
+		if msg.HasReacted(emoji, m.username) {
+			style = m.styles.ReactionOwn
+		}
+		reactions[i] = style.Render(fmt.Sprintf("%s %d", emoji, len(msg.Reactions[emoji])))
+	}
+	return strings.Join(reactions, "  ")
 }
 
 // viewTimestamp returns the styled output for a single timestamp value.
@@ -1,6 +1,9 @@
 package components
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
 
 var (
 	// The border to use for a bubble aligned to the left of the messages.
@@ -44,8 +47,15 @@ type chatStyles struct {
 	Header       lipgloss.Style
 	Conversation lipgloss.Style
 	Timestamp    lipgloss.Style
+	Typing       lipgloss.Style
+	Ticks        lipgloss.Style
+	TicksRead    lipgloss.Style
+	TicksFailed  lipgloss.Style
+	Reaction     lipgloss.Style
+	ReactionOwn  lipgloss.Style
+	Quote        lipgloss.Style
 
-	BubbleStyleFunc func(value string, alignRight bool, textLen int) string
+	BubbleStyleFunc func(bubble chatBubble) string
 
 	InputPrompt      lipgloss.Style
 	InputText        lipgloss.Style
@@ -56,9 +66,23 @@ type chatStyles struct {
 
 type chatStyleFunc func(width, height int) *chatStyles
 
+// chatBubble is a single message ready to be rendered by chatStyles.BubbleStyleFunc.
+type chatBubble struct {
+	// Content is the styled content of the bubble, and TextLen is its width once rendered.
+	Content string
+	TextLen int
+	// Quote is shown above the bubble and Reactions underneath it. Either may be empty.
+	Quote     string
+	Reactions string
+	// AlignRight places the bubble on the right, which is where messages sent by the active user go.
+	AlignRight bool
+	Selected   bool
+}
+
 func enabledChatStyleFunc(width, height int) *chatStyles {
 	leftBubble := lipgloss.NewStyle().Padding(0, 1).Border(leftBubbleBorder, true).BorderForeground(lipgloss.Color("6"))
 	rightBubble := lipgloss.NewStyle().Padding(0, 1).Border(rightBubbleBorder, true).BorderForeground(lipgloss.Color("5"))
+	selectedForeground := lipgloss.Color("3")
 	fullWidth := lipgloss.NewStyle().Width(width)
 	leftAlign := fullWidth.AlignHorizontal(lipgloss.Left)
 	rightAlign := fullWidth.AlignHorizontal(lipgloss.Right)
@@ -73,20 +97,34 @@ func enabledChatStyleFunc(width, height int) *chatStyles {
 			BorderStyle(lipgloss.NormalBorder()).BorderBottom(true).Padding(0, 4),
 		Conversation: lipgloss.NewStyle().Height(height - (6)), // accounting for the header and input heights
 		Timestamp:    fullWidth.AlignHorizontal(lipgloss.Center),
+		Typing:       lipgloss.NewStyle().Width(width).Foreground(lipgloss.Color("240")).Italic(true),
+		Ticks:        lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+		TicksRead:    lipgloss.NewStyle().Foreground(lipgloss.Color("6")),
+		TicksFailed:  lipgloss.NewStyle().Foreground(lipgloss.Color("1")),
+		Reaction:     lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+		ReactionOwn:  lipgloss.NewStyle().Foreground(lipgloss.Color("6")),
+		Quote: lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true).PaddingLeft(1).
+			Border(lipgloss.ThickBorder(), false, false, false, true).BorderForeground(lipgloss.Color("240")),
+
+		BubbleStyleFunc: func(b chatBubble) string {
+			bubble, align, position := leftBubble, leftAlign, lipgloss.Left
+			if b.AlignRight {
+				bubble, align, position = rightBubble, rightAlign, lipgloss.Right
+			}
+			if b.Selected {
+				bubble = bubble.BorderForeground(selectedForeground)
+			}
 
-		BubbleStyleFunc: func(value string, alignRight bool, textLen int) string {
-			value = lipgloss.NewStyle().Width(min(textLen, bubbleMaxWidth)).Render(value)
-
-			switch alignRight {
-			case true:
-				value = rightBubble.Render(value)
-				value = rightAlign.Render(value)
-
-			case false:
-				value = leftBubble.Render(value)
-				value = leftAlign.Render(value)
+			content := lipgloss.NewStyle().Width(min(b.TextLen, bubbleMaxWidth)).Render(b.Content)
+			rows := make([]string, 0, 3)
+			if b.Quote != "" {
+				rows = append(rows, ansi.Truncate(b.Quote, bubbleMaxWidth, "…"))
+			}
+			rows = append(rows, bubble.Render(content))
+			if b.Reactions != "" {
+				rows = append(rows, ansi.Truncate(b.Reactions, bubbleMaxWidth, "…"))
 			}
-			return value
+			return align.Render(lipgloss.JoinVertical(position, rows...))
 		},
 
 		InputPrompt:      lipgloss.NewStyle(),
@@ -104,27 +142,41 @@ func disabledChatStyleFunc(width, height int) *chatStyles {
 
 	styles.Header = styles.Header.Inherit(disabledForeground)
 	styles.Timestamp = styles.Timestamp.Inherit(disabledForeground)
+	styles.Typing = styles.Typing.Inherit(disabledForeground)
+	styles.Ticks = lipgloss.NewStyle().Inherit(disabledForeground)
+	styles.TicksRead = lipgloss.NewStyle().Inherit(disabledForeground)
+	styles.TicksFailed = lipgloss.NewStyle().Inherit(disabledForeground)
+	styles.Reaction = lipgloss.NewStyle().Inherit(disabledForeground)
+	styles.ReactionOwn = lipgloss.NewStyle().Inherit(disabledForeground)
+	styles.Quote = styles.Quote.Inherit(disabledForeground)
 
 	leftBubble := lipgloss.NewStyle().Padding(0, 1).Border(leftBubbleBorder, true).Inherit(disabledForeground)
 	rightBubble := lipgloss.NewStyle().Padding(0, 1).Border(rightBubbleBorder, true).Inherit(disabledForeground)
+	selectedForeground := lipgloss.Color("250")
 	fullWidth := lipgloss.NewStyle().Width(width).Inherit(disabledForeground)
 	leftAlign := fullWidth.AlignHorizontal(lipgloss.Left)
 	rightAlign := fullWidth.AlignHorizontal(lipgloss.Right)
 	bubbleMaxWidth := (width / 10) * 7
 
-	styles.BubbleStyleFunc = func(value string, alignRight bool, textLen int) string {
-		value = lipgloss.NewStyle().Width(min(textLen, bubbleMaxWidth)).Inherit(disabledForeground).Render(value)
-
-		switch alignRight {
-		case true:
-			value = rightBubble.Render(value)
-			value = rightAlign.Render(value)
+	styles.BubbleStyleFunc = func(b chatBubble) string {
+		bubble, align, position := leftBubble, leftAlign, lipgloss.Left
+		if b.AlignRight {
+			bubble, align, position = rightBubble, rightAlign, lipgloss.Right
+		}
+		if b.Selected {
+			bubble = bubble.BorderForeground(selectedForeground)
+		}
 
-		case false:
-			value = leftBubble.Render(value)
-			value = leftAlign.Render(value)
+		content := lipgloss.NewStyle().Width(min(b.TextLen, bubbleMaxWidth)).Inherit(disabledForeground).Render(b.Content)
+		rows := make([]string, 0, 3)
+		if b.Quote != "" {
+			rows = append(rows, ansi.Truncate(b.Quote, bubbleMaxWidth, "…"))
+		}
+		rows = append(rows, bubble.Render(content))
+		if b.Reactions != "" {
+			rows = append(rows, ansi.Truncate(b.Reactions, bubbleMaxWidth, "…"))
 		}
-		return value
+		return align.Render(lipgloss.JoinVertical(position, rows...))
 	}
 
 	styles.InputPrompt = lipgloss.NewStyle().Inherit(disabledForeground)
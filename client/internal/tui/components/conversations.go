@@ -2,25 +2,30 @@ package components
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/Broderick-Westrope/charmutils"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
 
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
 	"github.com/Broderick-Westrope/teatime/internal/entity"
 )
 
 var _ tea.Model = &ConversationsModel{}
 
 type ConversationsModel struct {
-	list   list.Model
-	styles *conversationsStyles
+	list     list.Model
+	styles   *conversationsStyles
+	username string
+	presence map[string]entity.Presence
 }
 
-func NewConversationsModel(conversations []entity.Conversation, enabled bool) *ConversationsModel {
+func NewConversationsModel(conversations []entity.Conversation, username string, enabled bool) *ConversationsModel {
 	var items = make([]list.Item, len(conversations))
 	for i, d := range conversations {
-		items[i] = Conversation(d)
+		items[i] = Conversation{Conversation: d}
 	}
 
 	styles := disabledConversationsStyles()
@@ -34,8 +39,9 @@ func NewConversationsModel(conversations []entity.Conversation, enabled bool) *C
 	conversationsList.DisableQuitKeybindings()
 
 	return &ConversationsModel{
-		list:   conversationsList,
-		styles: styles,
+		list:     conversationsList,
+		styles:   styles,
+		username: username,
 	}
 }
 
@@ -60,7 +66,7 @@ func (m *ConversationsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m *ConversationsModel) AddNewConversation(conversation entity.Conversation) tea.Cmd {
-	return m.list.InsertItem(0, Conversation(conversation))
+	return m.list.InsertItem(0, m.newItem(conversation))
 }
 
 // AddNewMessage will add the given message to the chat with the given chatName.
@@ -87,10 +93,10 @@ func (m *ConversationsModel) AddNewMessage(conversationMD entity.ConversationMet
 
 	switch {
 	case foundIdx < 0:
-		item := Conversation{
+		item := m.newItem(entity.Conversation{
 			Metadata: conversationMD,
 			Messages: []entity.Message{message},
-		}
+		})
 		items = append([]list.Item{item}, items...)
 		m.list.Select(0)
 		return m.list.SetItems(items), nil
@@ -132,11 +138,122 @@ func (m *ConversationsModel) GetConversations() ([]entity.Conversation, error) {
 		if !ok {
 			return nil, fmt.Errorf("failed to get conversations: %w", charmutils.ErrInvalidTypeAssertion)
 		}
-		conversations[i] = entity.Conversation(conversation)
+		conversations[i] = conversation.Conversation
 	}
 	return conversations, nil
 }
 
+// SetMessageStatus advances the status of the messages in the conversation with the given ID.
+func (m *ConversationsModel) SetMessageStatus(
+	conversationID uuid.UUID, messageIDs []uuid.UUID, status entity.MessageStatus,
+) error {
+	for _, item := range m.list.Items() {
+		conversation, ok := item.(Conversation)
+		if !ok {
+			return fmt.Errorf("failed to set message status: (list item) %w", charmutils.ErrInvalidTypeAssertion)
+		}
+
+		if conversation.Metadata.ID == conversationID {
+			setMessageStatus(conversation.Messages, messageIDs, status)
+			return nil
+		}
+	}
+	return nil
+}
+
+// EditMessage replaces the content of the message in the conversation with the given ID.
+func (m *ConversationsModel) EditMessage(
+	conversationID, messageID uuid.UUID, author, content string, editedAt time.Time,
+) error {
+	for _, item := range m.list.Items() {
+		conversation, ok := item.(Conversation)
+		if !ok {
+			return fmt.Errorf("failed to edit message: (list item) %w", charmutils.ErrInvalidTypeAssertion)
+		}
+
+		if conversation.Metadata.ID == conversationID {
+			editMessage(conversation.Messages, messageID, author, content, editedAt)
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetReaction adds or removes the user's reaction to the message in the conversation with the given ID.
+func (m *ConversationsModel) SetReaction(
+	conversationID, messageID uuid.UUID, emoji, username string, reacted bool,
+) error {
+	for _, item := range m.list.Items() {
+		conversation, ok := item.(Conversation)
+		if !ok {
+			return fmt.Errorf("failed to set reaction: (list item) %w", charmutils.ErrInvalidTypeAssertion)
+		}
+
+		if conversation.Metadata.ID == conversationID {
+			setReaction(conversation.Messages, messageID, emoji, username, reacted)
+			return nil
+		}
+	}
+	return nil
+}
+
+// DeleteMessage removes the message from the conversation with the given ID.
+// An empty author deletes the message regardless of who sent it, as described by deleteMessage.
+func (m *ConversationsModel) DeleteMessage(conversationID, messageID uuid.UUID, author string) (tea.Cmd, error) {
+	for i, item := range m.list.Items() {
+		conversation, ok := item.(Conversation)
+		if !ok {
+			return nil, fmt.Errorf("failed to delete message: (list item) %w", charmutils.ErrInvalidTypeAssertion)
+		}
+
+		if conversation.Metadata.ID == conversationID {
+			if !deleteMessage(&conversation.Conversation, messageID, author) {
+				return nil, nil
+			}
+			return m.list.SetItem(i, conversation), nil
+		}
+	}
+	return nil, nil
+}
+
+// IsMessageDeleted reports whether the message has been deleted from the conversation with the given ID.
+func (m *ConversationsModel) IsMessageDeleted(conversationID, messageID uuid.UUID) (bool, error) {
+	for _, item := range m.list.Items() {
+		conversation, ok := item.(Conversation)
+		if !ok {
+			return false, fmt.Errorf("failed to check for deleted message: (list item) %w", charmutils.ErrInvalidTypeAssertion)
+		}
+
+		if conversation.Metadata.ID == conversationID {
+			return conversation.IsDeleted(messageID), nil
+		}
+	}
+	return false, nil
+}
+
+// SetPresence updates the presence shown for each conversation.
+func (m *ConversationsModel) SetPresence(presence map[string]entity.Presence) tea.Cmd {
+	m.presence = presence
+
+	items := m.list.Items()
+	for i, item := range items {
+		conversation, ok := item.(Conversation)
+		if !ok {
+			return tui.FatalErrorCmd(fmt.Errorf("failed to set presence: (list item) %w", charmutils.ErrInvalidTypeAssertion))
+		}
+		items[i] = m.newItem(conversation.Conversation)
+	}
+	return m.list.SetItems(items)
+}
+
+// newItem returns the list item for the conversation, including the presence of its participants.
+func (m *ConversationsModel) newItem(conversation entity.Conversation) Conversation {
+	return Conversation{
+		Conversation: conversation,
+		presence:     summarisePresence(conversation.Metadata.Participants, m.username, m.presence),
+	}
+}
+
 // Enable makes the model appear as though it is active/focussed.
 func (m *ConversationsModel) Enable() {
 	m.switchStyles(enabledConversationsStyles())
@@ -13,10 +13,24 @@ import (
 	"github.com/Broderick-Westrope/teatime/internal/entity"
 )
 
-type Conversation entity.Conversation
+// Conversation is a list item for a conversation along with the presence of its participants.
+type Conversation struct {
+	entity.Conversation
+	presence presenceSummary
+}
 
-func (c Conversation) Title() string       { return c.Metadata.Name }
 func (c Conversation) FilterValue() string { return c.Metadata.Name }
+func (c Conversation) Title() string {
+	title := c.Metadata.Name
+	if indicator := c.presence.indicator(); indicator != "" {
+		title = indicator + " " + title
+	}
+	if lastSeen := c.presence.shortDescription(); lastSeen != "" {
+		title += " · " + lastSeen
+	}
+	return title
+}
+
 func (c Conversation) Description() string {
 	if len(c.Messages) == 0 {
 		return ""
@@ -36,8 +50,17 @@ func NewListDelegate(keys *ListDelegateKeyMap, styles list.DefaultItemStyles) li
 	d.UpdateFunc = func(msg tea.Msg, m *list.Model) tea.Cmd {
 		// Operations that do not require a selected conversation
 		if msg, ok := msg.(tea.KeyMsg); ok {
-			if key.Matches(msg, keys.new) {
+			switch {
+			case key.Matches(msg, keys.new):
 				return tui.OpenModalCmd(modals.NewCreateConversationModel())
+			case key.Matches(msg, keys.changePassword):
+				return tui.OpenModalCmd(modals.NewChangePasswordModel())
+			case key.Matches(msg, keys.deleteAccount):
+				return tui.OpenModalCmd(modals.NewDeleteAccountModel())
+			case key.Matches(msg, keys.enableTOTP):
+				return tui.EnrollTOTPCmd
+			case key.Matches(msg, keys.settings):
+				return tui.OpenSettingsCmd
 			}
 		}
 
@@ -58,7 +81,7 @@ func NewListDelegate(keys *ListDelegateKeyMap, styles list.DefaultItemStyles) li
 		if keyMsg, ok = msg.(tea.KeyMsg); ok {
 			switch {
 			case key.Matches(keyMsg, keys.submit):
-				return tui.SetConversationCmd(entity.Conversation(conversation))
+				return tui.SetConversationCmd(conversation.Conversation)
 
 			case key.Matches(keyMsg, keys.delete):
 				if m.FilterState() == list.FilterApplied {
@@ -73,9 +96,13 @@ func NewListDelegate(keys *ListDelegateKeyMap, styles list.DefaultItemStyles) li
 }
 
 type ListDelegateKeyMap struct {
-	submit key.Binding
-	new    key.Binding
-	delete key.Binding
+	submit         key.Binding
+	new            key.Binding
+	delete         key.Binding
+	changePassword key.Binding
+	deleteAccount  key.Binding
+	enableTOTP     key.Binding
+	settings       key.Binding
 }
 
 func DefaultListDelegateKeyMap() *ListDelegateKeyMap {
@@ -92,6 +119,22 @@ func DefaultListDelegateKeyMap() *ListDelegateKeyMap {
 			key.WithKeys("backspace"),
 			key.WithHelp("delete/backspace", "delete contact"),
 		),
+		changePassword: key.NewBinding(
+			key.WithKeys("ctrl+p"),
+			key.WithHelp("ctrl+p", "change password"),
+		),
+		deleteAccount: key.NewBinding(
+			key.WithKeys("ctrl+d"),
+			key.WithHelp("ctrl+d", "delete account"),
+		),
+		enableTOTP: key.NewBinding(
+			key.WithKeys("ctrl+t"),
+			key.WithHelp("ctrl+t", "enable two-factor auth"),
+		),
+		settings: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "settings"),
+		),
 	}
 }
 
@@ -100,5 +143,9 @@ func (d ListDelegateKeyMap) ShortHelp() []key.Binding {
 		d.submit,
 		d.new,
 		d.delete,
+		d.changePassword,
+		d.deleteAccount,
+		d.enableTOTP,
+		d.settings,
 	}
 }
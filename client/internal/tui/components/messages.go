@@ -0,0 +1,113 @@
+package components
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// setMessageStatus advances the status of each message with one of the IDs.
+// Statuses never move backwards, since receipts can arrive out of order.
+// It reports whether any of the messages were changed.
+func setMessageStatus(messages []entity.Message, ids []uuid.UUID, status entity.MessageStatus) bool {
+	var changed bool
+	for i := range messages {
+		if !slices.Contains(ids, messages[i].ID) || !messages[i].Status.IsBefore(status) {
+			continue
+		}
+		messages[i].Status = status
+		changed = true
+	}
+	return changed
+}
+
+// editMessage replaces the content of the message with the ID, keeping its previous content in its history.
+// The edit is ignored unless it was made by the author of the message.
+// It reports whether the message was changed.
+func editMessage(
+	messages []entity.Message, messageID uuid.UUID, author, content string, editedAt time.Time,
+) bool {
+	for i := range messages {
+		if messages[i].ID != messageID {
+			continue
+		}
+		if messages[i].Author != author {
+			return false
+		}
+		return messages[i].Edit(content, editedAt)
+	}
+	return false
+}
+
+// setReaction adds or removes the user's reaction to the message with the ID.
+// It reports whether the message was changed.
+func setReaction(messages []entity.Message, messageID uuid.UUID, emoji, username string, reacted bool) bool {
+	for i := range messages {
+		if messages[i].ID == messageID {
+			return messages[i].SetReaction(emoji, username, reacted)
+		}
+	}
+	return false
+}
+
+// deleteMessage removes the message with the ID from the conversation, leaving a tombstone in its place.
+// The deletion is ignored if the message is known to have been sent by someone other than the author, unless
+// the author is empty, which is how the active user deletes any message for themselves.
+// It reports whether the conversation was changed.
+func deleteMessage(conversation *entity.Conversation, messageID uuid.UUID, author string) bool {
+	if messageID == uuid.Nil || conversation.IsDeleted(messageID) {
+		return false
+	}
+	if author != "" {
+		for _, msg := range conversation.Messages {
+			if msg.ID == messageID && msg.Author != author {
+				return false
+			}
+		}
+	}
+	conversation.DeleteMessage(messageID)
+	return true
+}
+
+// threadMessages returns the message with the ID followed by the replies to it, including replies to those replies.
+// The replies must come after the messages they reply to, which is the case since messages are ordered by when
+// they were sent.
+func threadMessages(messages []entity.Message, messageID uuid.UUID) []entity.Message {
+	inThread := map[uuid.UUID]bool{messageID: true}
+	var thread []entity.Message
+	for _, msg := range messages {
+		if msg.ID == messageID || (msg.ReplyTo != nil && inThread[msg.ReplyTo.ID]) {
+			inThread[msg.ID] = true
+			thread = append(thread, msg)
+		}
+	}
+	return thread
+}
+
+// countReplies returns the number of direct replies to each of the messages.
+func countReplies(messages []entity.Message) map[uuid.UUID]int {
+	replies := make(map[uuid.UUID]int)
+	for _, msg := range messages {
+		if msg.ReplyTo != nil {
+			replies[msg.ReplyTo.ID]++
+		}
+	}
+	return replies
+}
+
+// statusTicks returns the ticks shown next to a message sent by the active user.
+func statusTicks(status entity.MessageStatus) string {
+	switch status {
+	case entity.MessageStatusSent:
+		return "✓"
+	case entity.MessageStatusDelivered, entity.MessageStatusRead:
+		return "✓✓"
+	case entity.MessageStatusFailed:
+		return "! not sent"
+	default:
+		return "○"
+	}
+}
@@ -0,0 +1,97 @@
+package components
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// presenceSummary describes the presence of the participants in a conversation, excluding the active user.
+type presenceSummary struct {
+	online int
+	known  int
+	total  int
+	// lastSeen is only set when the conversation has a single other participant who is offline.
+	lastSeen time.Time
+}
+
+func summarisePresence(participants []string, username string, presence map[string]entity.Presence) presenceSummary {
+	var s presenceSummary
+	var lastSeen time.Time
+	for _, participant := range participants {
+		if participant == username {
+			continue
+		}
+		s.total++
+
+		p, ok := presence[participant]
+		if !ok {
+			continue
+		}
+		s.known++
+		if p.Online {
+			s.online++
+		}
+		lastSeen = p.LastSeen
+	}
+	if s.total == 1 && s.online == 0 {
+		s.lastSeen = lastSeen
+	}
+	return s
+}
+
+// indicator returns a dot which is filled if any other participant is online.
+// It is empty until the presence of at least one participant is known.
+func (s presenceSummary) indicator() string {
+	switch {
+	case s.online > 0:
+		return "●"
+	case s.known > 0:
+		return "○"
+	default:
+		return ""
+	}
+}
+
+// shortDescription is like description but omits details for group conversations.
+func (s presenceSummary) shortDescription() string {
+	if s.total != 1 || s.online > 0 || s.lastSeen.IsZero() {
+		return ""
+	}
+	return formatLastSeen(s.lastSeen, time.Now())
+}
+
+// description returns a description of who is online, eg. "online", "last seen 5m ago" or "2 of 3 online".
+// It is empty until the presence of at least one participant is known.
+func (s presenceSummary) description() string {
+	switch {
+	case s.known == 0:
+		return ""
+	case s.total > 1:
+		return fmt.Sprintf("%d of %d online", s.online, s.total)
+	case s.online > 0:
+		return "online"
+	case !s.lastSeen.IsZero():
+		return "last seen " + formatLastSeen(s.lastSeen, time.Now())
+	default:
+		return "offline"
+	}
+}
+
+// formatLastSeen returns how long ago the time was, relative to now.
+func formatLastSeen(lastSeen, now time.Time) string {
+	elapsed := now.Sub(lastSeen)
+	switch {
+	case elapsed < time.Minute:
+		return "just now"
+	case elapsed < time.Hour:
+		return fmt.Sprintf("%dm ago", int(elapsed.Minutes()))
+	case elapsed < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(elapsed.Hours()))
+	case elapsed < 7*24*time.Hour:
+		return lastSeen.Local().Format("Monday")
+	default:
+		return lastSeen.Local().Format("02 Jan")
+	}
+}
@@ -1,7 +1,10 @@
 package tui
 
 import (
+	"time"
+
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
 
 	"github.com/Broderick-Westrope/teatime/internal/entity"
 )
@@ -35,6 +38,49 @@ func AuthenticateCmd(isSignup bool, username, password string) tea.Cmd {
 	}
 }
 
+// VerifyTOTPMsg encloses the second factor code for completing a login.
+type VerifyTOTPMsg struct {
+	Code string
+}
+
+// VerifyTOTPCmd returns a command for creating a new VerifyTOTPMsg.
+func VerifyTOTPCmd(code string) tea.Cmd {
+	return func() tea.Msg {
+		return VerifyTOTPMsg{
+			Code: code,
+		}
+	}
+}
+
+// EnrollTOTPMsg signals that the active user wants to begin enabling two-factor authentication.
+type EnrollTOTPMsg struct{}
+
+// EnrollTOTPCmd is a command for creating a new EnrollTOTPMsg.
+func EnrollTOTPCmd() tea.Msg {
+	return EnrollTOTPMsg{}
+}
+
+// ConfirmTOTPMsg encloses the code used to confirm that the user's authenticator app has been set up.
+type ConfirmTOTPMsg struct {
+	Code string
+}
+
+// ConfirmTOTPCmd returns a command for creating a new ConfirmTOTPMsg.
+func ConfirmTOTPCmd(code string) tea.Cmd {
+	return func() tea.Msg {
+		return ConfirmTOTPMsg{
+			Code: code,
+		}
+	}
+}
+
+// TOTPEnabledMsg encloses the result of confirming TOTP enrollment.
+// Err is nil if two-factor authentication was enabled, in which case RecoveryCodes is set.
+type TOTPEnabledMsg struct {
+	RecoveryCodes []string
+	Err           error
+}
+
 // CreateConversationMsg encloses the details for creating a new conversation.
 type CreateConversationMsg struct {
 	Name               string
@@ -99,6 +145,282 @@ type ReceiveMessageMsg struct {
 	Message        entity.Message
 }
 
+// EditMessageMsg encloses the new content for a message sent by the active user.
+type EditMessageMsg struct {
+	ConversationMD entity.ConversationMetadata
+	MessageID      uuid.UUID
+	Content        string
+	EditedAt       time.Time
+}
+
+// EditMessageCmd returns a command for creating a new EditMessageMsg.
+func EditMessageCmd(
+	conversationMD entity.ConversationMetadata, messageID uuid.UUID, content string, editedAt time.Time,
+) tea.Cmd {
+	return func() tea.Msg {
+		return EditMessageMsg{
+			ConversationMD: conversationMD,
+			MessageID:      messageID,
+			Content:        content,
+			EditedAt:       editedAt,
+		}
+	}
+}
+
+// MessageEditedMsg encloses the new content of a message which needs to be updated locally.
+type MessageEditedMsg struct {
+	ConversationID uuid.UUID
+	MessageID      uuid.UUID
+	// Author is who edited the message. The edit is ignored unless they are the author of the message.
+	Author   string
+	Content  string
+	EditedAt time.Time
+}
+
+// DeleteMessageMsg encloses a message which the active user wants to delete.
+// Only the author of a message may delete it for everyone, otherwise it is only deleted for the active user.
+type DeleteMessageMsg struct {
+	ConversationMD entity.ConversationMetadata
+	MessageID      uuid.UUID
+	ForEveryone    bool
+}
+
+// DeleteMessageCmd returns a command for creating a new DeleteMessageMsg.
+func DeleteMessageCmd(conversationMD entity.ConversationMetadata, messageID uuid.UUID, forEveryone bool) tea.Cmd {
+	return func() tea.Msg {
+		return DeleteMessageMsg{
+			ConversationMD: conversationMD,
+			MessageID:      messageID,
+			ForEveryone:    forEveryone,
+		}
+	}
+}
+
+// MessageDeletedMsg encloses a message which needs to be deleted locally.
+type MessageDeletedMsg struct {
+	ConversationID uuid.UUID
+	MessageID      uuid.UUID
+	// Author is who deleted the message. The deletion is ignored unless they are the author of the message.
+	Author string
+}
+
+// ReactMsg encloses a reaction which the active user wants to add to or remove from a message.
+type ReactMsg struct {
+	ConversationMD entity.ConversationMetadata
+	MessageID      uuid.UUID
+	Emoji          string
+	Reacted        bool
+}
+
+// ReactCmd returns a command for creating a new ReactMsg.
+func ReactCmd(conversationMD entity.ConversationMetadata, messageID uuid.UUID, emoji string, reacted bool) tea.Cmd {
+	return func() tea.Msg {
+		return ReactMsg{
+			ConversationMD: conversationMD,
+			MessageID:      messageID,
+			Emoji:          emoji,
+			Reacted:        reacted,
+		}
+	}
+}
+
+// ReactionMsg encloses a reaction to a message which needs to be updated locally.
+type ReactionMsg struct {
+	ConversationID uuid.UUID
+	MessageID      uuid.UUID
+	Emoji          string
+	Username       string
+	Reacted        bool
+}
+
+// SendAttachmentMsg encloses a file which the active user wants to send to the conversation.
+type SendAttachmentMsg struct {
+	ConversationMD entity.ConversationMetadata
+	Path           string
+}
+
+// SendAttachmentCmd returns a command for creating a new SendAttachmentMsg.
+func SendAttachmentCmd(conversationMD entity.ConversationMetadata, path string) tea.Cmd {
+	return func() tea.Msg {
+		return SendAttachmentMsg{
+			ConversationMD: conversationMD,
+			Path:           path,
+		}
+	}
+}
+
+// SaveAttachmentMsg encloses an attachment which the active user wants to save as a file.
+// The attachment is downloaded first if it is not stored locally.
+type SaveAttachmentMsg struct {
+	Attachment entity.Attachment
+}
+
+// SaveAttachmentCmd returns a command for creating a new SaveAttachmentMsg.
+func SaveAttachmentCmd(attachment entity.Attachment) tea.Cmd {
+	return func() tea.Msg {
+		return SaveAttachmentMsg{
+			Attachment: attachment,
+		}
+	}
+}
+
+// ExportAttachmentMsg encloses the path which the active user has chosen to save an attachment to.
+type ExportAttachmentMsg struct {
+	Attachment entity.Attachment
+	Path       string
+}
+
+// ExportAttachmentCmd returns a command for creating a new ExportAttachmentMsg.
+func ExportAttachmentCmd(attachment entity.Attachment, path string) tea.Cmd {
+	return func() tea.Msg {
+		return ExportAttachmentMsg{
+			Attachment: attachment,
+			Path:       path,
+		}
+	}
+}
+
+// AttachmentExportedMsg encloses the result of saving an attachment as a file.
+// Err is nil if the attachment was saved successfully.
+type AttachmentExportedMsg struct {
+	Path string
+	Err  error
+}
+
+// AttachmentTransferMsg encloses how far an attachment has been uploaded or downloaded.
+type AttachmentTransferMsg struct {
+	AttachmentID uuid.UUID
+	Upload       bool
+	// Done is how many bytes of the attachment have been transferred, out of its Size.
+	Done int64
+	Size int64
+	// Err is set if the transfer failed, in which case it has stopped.
+	Err error
+}
+
+// MessageStatusMsg encloses the new status of messages sent by the active user.
+type MessageStatusMsg struct {
+	ConversationID uuid.UUID
+	MessageIDs     []uuid.UUID
+	Status         entity.MessageStatus
+}
+
+// MessagesReadMsg encloses messages which the active user has just been shown.
+type MessagesReadMsg struct {
+	ConversationMD entity.ConversationMetadata
+	Messages       []entity.Message
+}
+
+// MessagesReadCmd returns a command for creating a new MessagesReadMsg.
+func MessagesReadCmd(conversationMD entity.ConversationMetadata, messages []entity.Message) tea.Cmd {
+	return func() tea.Msg {
+		return MessagesReadMsg{
+			ConversationMD: conversationMD,
+			Messages:       messages,
+		}
+	}
+}
+
+// SendTypingMsg signals that the active user has started or stopped typing in the conversation.
+type SendTypingMsg struct {
+	ConversationMD entity.ConversationMetadata
+	Typing         bool
+}
+
+// SendTypingCmd returns a command for creating a new SendTypingMsg.
+func SendTypingCmd(conversationMD entity.ConversationMetadata, typing bool) tea.Cmd {
+	return func() tea.Msg {
+		return SendTypingMsg{
+			ConversationMD: conversationMD,
+			Typing:         typing,
+		}
+	}
+}
+
+// TypingMsg encloses whether another user is typing in a conversation.
+type TypingMsg struct {
+	ConversationID uuid.UUID
+	Username       string
+	Typing         bool
+}
+
+// TypingExpiredMsg signals that a typing indicator may have expired and the view should be refreshed.
+type TypingExpiredMsg struct{}
+
+// PresenceMsg encloses the presence of users who share a conversation with the active user.
+type PresenceMsg struct {
+	Users []entity.Presence
+}
+
+// ChangePasswordMsg encloses the details for changing the password of the active user.
+type ChangePasswordMsg struct {
+	OldPassword string
+	NewPassword string
+}
+
+// ChangePasswordCmd returns a command for creating a new ChangePasswordMsg.
+func ChangePasswordCmd(oldPassword, newPassword string) tea.Cmd {
+	return func() tea.Msg {
+		return ChangePasswordMsg{
+			OldPassword: oldPassword,
+			NewPassword: newPassword,
+		}
+	}
+}
+
+// PasswordChangedMsg encloses the result of attempting to change the password.
+// Err is nil if the password was changed successfully.
+type PasswordChangedMsg struct {
+	Err error
+}
+
+// OpenSettingsMsg signals that the active user wants to view or change their settings.
+type OpenSettingsMsg struct{}
+
+// OpenSettingsCmd is a command for creating a new OpenSettingsMsg.
+func OpenSettingsCmd() tea.Msg {
+	return OpenSettingsMsg{}
+}
+
+// SaveSettingsMsg encloses the settings chosen by the active user.
+type SaveSettingsMsg struct {
+	SendReadReceipts bool
+}
+
+// SaveSettingsCmd returns a command for creating a new SaveSettingsMsg.
+func SaveSettingsCmd(sendReadReceipts bool) tea.Cmd {
+	return func() tea.Msg {
+		return SaveSettingsMsg{
+			SendReadReceipts: sendReadReceipts,
+		}
+	}
+}
+
+// SettingsSavedMsg encloses the result of saving the settings.
+// Err is nil if the settings were saved successfully.
+type SettingsSavedMsg struct {
+	Err error
+}
+
+// DeleteAccountMsg encloses the password confirming that the active user's account should be deleted.
+type DeleteAccountMsg struct {
+	Password string
+}
+
+// DeleteAccountCmd returns a command for creating a new DeleteAccountMsg.
+func DeleteAccountCmd(password string) tea.Cmd {
+	return func() tea.Msg {
+		return DeleteAccountMsg{
+			Password: password,
+		}
+	}
+}
+
+// AccountDeletionFailedMsg encloses the error which prevented the account from being deleted.
+type AccountDeletionFailedMsg struct {
+	Err error
+}
+
 // OpenModalMsg encloses a modal which should be opened on top of the current content.
 type OpenModalMsg struct {
 	Modal Modal
@@ -0,0 +1,106 @@
+package modals
+
+import (
+	"errors"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+var _ tui.Modal = &AttachFileModel{}
+
+type AttachFileModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	conversationMD         entity.ConversationMetadata
+	path                   string
+}
+
+// NewAttachFileModel creates a modal for choosing a file to send to the conversation as an attachment.
+func NewAttachFileModel(conversationMD entity.ConversationMetadata) *AttachFileModel {
+	m := &AttachFileModel{
+		conversationMD: conversationMD,
+	}
+
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewFilePicker().
+				Title("Choose a file to send").
+				Picking(true).
+				ShowSize(true).
+				Height(10).
+				Validate(validateAttachmentFile).
+				Value(&m.path),
+		),
+	)
+	return m
+}
+
+// validateAttachmentFile checks that the file at the path can be sent as an attachment.
+func validateAttachmentFile(path string) error {
+	if path == "" {
+		return errors.New("a file is required")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !info.Mode().IsRegular():
+		return errors.New("only regular files can be sent")
+	case info.Size() == 0:
+		return errors.New("empty files cannot be sent")
+	case info.Size() > entity.MaxAttachmentSize:
+		return entity.ErrAttachmentTooLarge
+	}
+	return nil
+}
+
+func (m *AttachFileModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *AttachFileModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *AttachFileModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	return tea.Batch(
+		tui.CloseModalCmd,
+		tui.SendAttachmentCmd(m.conversationMD, m.path),
+	)
+}
+
+func (m *AttachFileModel) View() string {
+	return lipgloss.JoinVertical(lipgloss.Center,
+		"Attach a File\n",
+		m.form.View(),
+	)
+}
+
+func (m *AttachFileModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
@@ -0,0 +1,125 @@
+package modals
+
+import (
+	"errors"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+)
+
+const (
+	formKeyOldPassword = "old_password"
+	formKeyNewPassword = "new_password"
+)
+
+var _ tui.Modal = &ChangePasswordModel{}
+
+type ChangePasswordModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	newPassword            string
+	result                 string
+}
+
+func NewChangePasswordModel() *ChangePasswordModel {
+	m := &ChangePasswordModel{}
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().Key(formKeyOldPassword).
+				Title("Current Password").CharLimit(100).
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("current password is required")
+					}
+					return nil
+				}),
+			huh.NewInput().Key(formKeyNewPassword).
+				Title("New Password").CharLimit(100).
+				EchoMode(huh.EchoModePassword).
+				Value(&m.newPassword).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("empty password not allowed")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Title("Confirm New Password").CharLimit(100).
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s != m.newPassword {
+						return errors.New("passwords do not match")
+					}
+					return nil
+				}),
+		),
+	)
+	return m
+}
+
+func (m *ChangePasswordModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *ChangePasswordModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tui.PasswordChangedMsg); ok {
+		switch msg.Err {
+		case nil:
+			m.result = "Your password has been changed."
+		default:
+			m.result = "Failed to change password: " + msg.Err.Error()
+		}
+		return m, nil
+	}
+
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *ChangePasswordModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	m.result = "Changing password..."
+
+	return tui.ChangePasswordCmd(
+		m.form.GetString(formKeyOldPassword),
+		m.form.GetString(formKeyNewPassword),
+	)
+}
+
+func (m *ChangePasswordModel) View() string {
+	if m.hasAnnouncedCompletion {
+		return lipgloss.JoinVertical(lipgloss.Center,
+			"Change Password\n",
+			m.result,
+			"\nPress esc to close.",
+		)
+	}
+	return lipgloss.JoinVertical(lipgloss.Center,
+		"Change Password:\n",
+		m.form.View(),
+	)
+}
+
+func (m *ChangePasswordModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
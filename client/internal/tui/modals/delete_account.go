@@ -0,0 +1,103 @@
+package modals
+
+import (
+	"errors"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+)
+
+const (
+	formKeyPassword          = "password"
+	formKeyConfirmDeleteUser = "confirm_delete_user"
+)
+
+var _ tui.Modal = &DeleteAccountModel{}
+
+type DeleteAccountModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	result                 string
+}
+
+func NewDeleteAccountModel() *DeleteAccountModel {
+	return &DeleteAccountModel{
+		form: huh.NewForm(
+			huh.NewGroup(
+				huh.NewInput().Key(formKeyPassword).
+					Title("Password").CharLimit(100).
+					EchoMode(huh.EchoModePassword).
+					Validate(func(s string) error {
+						if s == "" {
+							return errors.New("password is required")
+						}
+						return nil
+					}),
+				huh.NewConfirm().Key(formKeyConfirmDeleteUser).
+					Title("Delete your account and all local data?").
+					Description("This cannot be undone."),
+			),
+		),
+	}
+}
+
+func (m *DeleteAccountModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *DeleteAccountModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tui.AccountDeletionFailedMsg); ok {
+		m.result = "Failed to delete account: " + msg.Err.Error()
+		return m, nil
+	}
+
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *DeleteAccountModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+
+	if !m.form.GetBool(formKeyConfirmDeleteUser) {
+		return tui.CloseModalCmd
+	}
+	m.result = "Deleting account..."
+	return tui.DeleteAccountCmd(m.form.GetString(formKeyPassword))
+}
+
+func (m *DeleteAccountModel) View() string {
+	if m.hasAnnouncedCompletion {
+		return lipgloss.JoinVertical(lipgloss.Center,
+			"Delete Account\n",
+			m.result,
+			"\nPress esc to close.",
+		)
+	}
+	return lipgloss.JoinVertical(lipgloss.Center,
+		"Delete Account:\n",
+		m.form.View(),
+	)
+}
+
+func (m *DeleteAccountModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
@@ -0,0 +1,111 @@
+package modals
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// deleteMessageChoice is how the active user has chosen to delete a message.
+type deleteMessageChoice int
+
+const (
+	deleteMessageChoiceCancel deleteMessageChoice = iota
+	deleteMessageChoiceForMe
+	deleteMessageChoiceForEveryone
+)
+
+var _ tui.Modal = &DeleteMessageModel{}
+
+type DeleteMessageModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	conversationMD         entity.ConversationMetadata
+	message                entity.Message
+	choice                 deleteMessageChoice
+}
+
+// NewDeleteMessageModel creates a modal for choosing how to delete the message.
+// The option to delete it for everyone is only offered when canDeleteForEveryone is true,
+// which should only be the case for messages sent by the active user.
+func NewDeleteMessageModel(
+	conversationMD entity.ConversationMetadata, message entity.Message, canDeleteForEveryone bool,
+) *DeleteMessageModel {
+	m := &DeleteMessageModel{
+		conversationMD: conversationMD,
+		message:        message,
+	}
+
+	options := []huh.Option[deleteMessageChoice]{
+		huh.NewOption("Delete for me", deleteMessageChoiceForMe),
+	}
+	if canDeleteForEveryone {
+		options = append(options, huh.NewOption("Delete for everyone", deleteMessageChoiceForEveryone))
+	}
+	options = append(options, huh.NewOption("Cancel", deleteMessageChoiceCancel))
+
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[deleteMessageChoice]().
+				Options(options...).
+				Value(&m.choice),
+		),
+	)
+	return m
+}
+
+func (m *DeleteMessageModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *DeleteMessageModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *DeleteMessageModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	cmds := []tea.Cmd{tui.CloseModalCmd}
+
+	switch m.choice {
+	case deleteMessageChoiceForMe:
+		cmds = append(cmds, tui.DeleteMessageCmd(m.conversationMD, m.message.ID, false))
+	case deleteMessageChoiceForEveryone:
+		cmds = append(cmds, tui.DeleteMessageCmd(m.conversationMD, m.message.ID, true))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+func (m *DeleteMessageModel) View() string {
+	return lipgloss.JoinVertical(lipgloss.Center,
+		fmt.Sprintf("Delete the message %q?\n", ansi.Truncate(m.message.Content, 35, "…")),
+		m.form.View(),
+	)
+}
+
+func (m *DeleteMessageModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
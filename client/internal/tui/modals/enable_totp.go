@@ -0,0 +1,125 @@
+package modals
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+)
+
+const formKeyTOTPCode = "totp_code"
+
+var _ tui.Modal = &EnableTOTPModel{}
+
+type EnableTOTPModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	qrCode                 string
+	secret                 string
+	result                 string
+}
+
+// NewEnableTOTPModel creates a modal which shows the otpauth:// URI as a QR code and asks for
+// a code to confirm the authenticator app has been set up. If enrollErr is not nil then
+// enrollment could not be started and only the error is shown.
+func NewEnableTOTPModel(uri string, enrollErr error) *EnableTOTPModel {
+	m := &EnableTOTPModel{
+		form: huh.NewForm(
+			huh.NewGroup(
+				huh.NewInput().Key(formKeyTOTPCode).
+					Title("Code").CharLimit(6).
+					Validate(func(s string) error {
+						if len(s) != 6 {
+							return errors.New("code must be 6 digits")
+						}
+						return nil
+					}),
+			),
+		),
+	}
+
+	if enrollErr != nil {
+		m.hasAnnouncedCompletion = true
+		m.result = "Failed to enable two-factor authentication: " + enrollErr.Error()
+		return m
+	}
+
+	qr, err := qrcode.New(uri, qrcode.Medium)
+	if err == nil {
+		m.qrCode = qr.ToSmallString(false)
+	}
+	if u, err := url.Parse(uri); err == nil {
+		m.secret = u.Query().Get("secret")
+	}
+	return m
+}
+
+func (m *EnableTOTPModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *EnableTOTPModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tui.TOTPEnabledMsg); ok {
+		switch msg.Err {
+		case nil:
+			m.result = "Two-factor authentication is enabled.\n" +
+				"Store these recovery codes somewhere safe, they will not be shown again:\n\n" +
+				strings.Join(msg.RecoveryCodes, "\n")
+		default:
+			m.result = "Failed to enable two-factor authentication: " + msg.Err.Error()
+		}
+		return m, nil
+	}
+
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *EnableTOTPModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	m.result = "Enabling two-factor authentication..."
+	return tui.ConfirmTOTPCmd(m.form.GetString(formKeyTOTPCode))
+}
+
+func (m *EnableTOTPModel) View() string {
+	if m.hasAnnouncedCompletion {
+		return lipgloss.JoinVertical(lipgloss.Center,
+			"Enable Two-Factor Authentication\n",
+			m.result,
+			"\nPress esc to close.",
+		)
+	}
+	return lipgloss.JoinVertical(lipgloss.Center,
+		"Enable Two-Factor Authentication:\n",
+		"Scan the QR code with your authenticator app.",
+		m.qrCode,
+		"Or enter this secret manually: "+m.secret+"\n",
+		m.form.View(),
+	)
+}
+
+func (m *EnableTOTPModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
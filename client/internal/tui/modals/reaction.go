@@ -0,0 +1,172 @@
+package modals
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// quickReactions are the shortcodes of the reactions offered in the picker, in the order they are shown.
+var quickReactions = []string{"thumbsup", "heart", "joy", "open_mouth", "cry", "tada"}
+
+// shortcodes maps the shortcodes which can be typed to react to a message to their emoji.
+var shortcodes = map[string]string{
+	"thumbsup":   "👍",
+	"thumbsdown": "👎",
+	"heart":      "💖",
+	"joy":        "😂",
+	"smile":      "😄",
+	"open_mouth": "😮",
+	"cry":        "😢",
+	"angry":      "😠",
+	"tada":       "🎉",
+	"fire":       "🔥",
+	"eyes":       "👀",
+	"pray":       "🙏",
+	"clap":       "👏",
+	"rocket":     "🚀",
+	"thinking":   "🤔",
+	"100":        "💯",
+	"tea":        "🍵",
+}
+
+// otherReaction is the picker option for typing a shortcode instead.
+const otherReaction = ""
+
+var _ tui.Modal = &ReactionModel{}
+
+type ReactionModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	conversationMD         entity.ConversationMetadata
+	message                entity.Message
+	username               string
+	choice                 string
+	shortcode              string
+}
+
+// NewReactionModel creates a modal for choosing a reaction to the message, either from a picker or by
+// typing its :shortcode:. Choosing a reaction which the active user has already made removes it.
+func NewReactionModel(conversationMD entity.ConversationMetadata, message entity.Message, username string) *ReactionModel {
+	m := &ReactionModel{
+		conversationMD: conversationMD,
+		message:        message,
+		username:       username,
+	}
+
+	options := make([]huh.Option[string], 0, len(quickReactions)+1)
+	for _, shortcode := range quickReactions {
+		emoji := shortcodes[shortcode]
+		label := fmt.Sprintf("%s :%s:", emoji, shortcode)
+		if message.HasReacted(emoji, username) {
+			label += " (remove)"
+		}
+		options = append(options, huh.NewOption(label, emoji))
+	}
+	options = append(options, huh.NewOption("Type a :shortcode:…", otherReaction))
+
+	suggestions := make([]string, 0, len(shortcodes))
+	for _, shortcode := range slices.Sorted(maps.Keys(shortcodes)) {
+		suggestions = append(suggestions, ":"+shortcode+":")
+	}
+
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Options(options...).
+				Value(&m.choice),
+		),
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Shortcode").
+				Placeholder(":thumbsup:").
+				Suggestions(suggestions).
+				Validate(func(value string) error {
+					_, err := parseShortcode(value)
+					return err
+				}).
+				Value(&m.shortcode),
+		).WithHideFunc(func() bool {
+			return m.choice != otherReaction
+		}),
+	)
+	return m
+}
+
+// parseShortcode returns the emoji for the shortcode, which may be given with or without its surrounding colons.
+func parseShortcode(value string) (string, error) {
+	value = strings.Trim(strings.TrimSpace(value), ":")
+	if value == "" {
+		return "", errors.New("shortcode is required")
+	}
+	emoji, ok := shortcodes[value]
+	if !ok {
+		return "", fmt.Errorf("unknown shortcode :%s:", value)
+	}
+	return emoji, nil
+}
+
+func (m *ReactionModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *ReactionModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *ReactionModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	cmds := []tea.Cmd{tui.CloseModalCmd}
+
+	emoji := m.choice
+	if emoji == otherReaction {
+		var err error
+		emoji, err = parseShortcode(m.shortcode)
+		if err != nil {
+			// The input has already been validated, so this should not happen.
+			return tea.Batch(append(cmds, tui.DebugLogCmd(err.Error()))...)
+		}
+	}
+
+	reacted := !m.message.HasReacted(emoji, m.username)
+	cmds = append(cmds, tui.ReactCmd(m.conversationMD, m.message.ID, emoji, reacted))
+	return tea.Batch(cmds...)
+}
+
+func (m *ReactionModel) View() string {
+	return lipgloss.JoinVertical(lipgloss.Center,
+		fmt.Sprintf("React to %q\n", ansi.Truncate(m.message.Content, 35, "…")),
+		m.form.View(),
+	)
+}
+
+func (m *ReactionModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
@@ -0,0 +1,113 @@
+package modals
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+var _ tui.Modal = &SaveAttachmentModel{}
+
+type SaveAttachmentModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	attachment             entity.Attachment
+	path                   string
+	result                 string
+}
+
+// NewSaveAttachmentModel creates a modal for choosing where to save the attachment.
+// The path defaults to the name of the attachment in the working directory.
+func NewSaveAttachmentModel(attachment entity.Attachment) *SaveAttachmentModel {
+	m := &SaveAttachmentModel{
+		attachment: attachment,
+		path:       attachment.Name,
+	}
+
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Save as").
+				Value(&m.path).
+				Validate(func(path string) error {
+					if path == "" {
+						return errors.New("a path is required")
+					}
+					if _, err := os.Stat(path); err == nil {
+						return errors.New("a file already exists at this path")
+					}
+					if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+						return errors.New("the directory does not exist")
+					}
+					return nil
+				}),
+		),
+	)
+	return m
+}
+
+func (m *SaveAttachmentModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *SaveAttachmentModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tui.AttachmentExportedMsg); ok {
+		switch msg.Err {
+		case nil:
+			m.result = "Saved to " + msg.Path
+		default:
+			m.result = "Failed to save attachment: " + msg.Err.Error()
+		}
+		return m, nil
+	}
+
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *SaveAttachmentModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	m.result = "Saving attachment..."
+	return tui.ExportAttachmentCmd(m.attachment, m.path)
+}
+
+func (m *SaveAttachmentModel) View() string {
+	if m.hasAnnouncedCompletion {
+		return lipgloss.JoinVertical(lipgloss.Center,
+			"Save "+m.attachment.Name+"\n",
+			m.result,
+			"\nPress esc to close.",
+		)
+	}
+	return lipgloss.JoinVertical(lipgloss.Center,
+		"Save "+m.attachment.Name+":\n",
+		m.form.View(),
+	)
+}
+
+func (m *SaveAttachmentModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
@@ -0,0 +1,94 @@
+package modals
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+)
+
+var _ tui.Modal = &SettingsModel{}
+
+type SettingsModel struct {
+	form                   *huh.Form
+	hasAnnouncedCompletion bool
+	sendReadReceipts       bool
+	result                 string
+}
+
+// NewSettingsModel creates a modal for changing the settings, starting from their current values.
+func NewSettingsModel(sendReadReceipts bool) *SettingsModel {
+	m := &SettingsModel{
+		sendReadReceipts: sendReadReceipts,
+	}
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Send read receipts?").
+				Description("When turned off, others are not told when you have read their messages.").
+				Value(&m.sendReadReceipts),
+		),
+	)
+	return m
+}
+
+func (m *SettingsModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *SettingsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tui.SettingsSavedMsg); ok {
+		switch msg.Err {
+		case nil:
+			m.result = "Your settings have been saved."
+		default:
+			m.result = "Failed to save settings: " + msg.Err.Error()
+		}
+		return m, nil
+	}
+
+	var cmds []tea.Cmd
+
+	form, cmd := m.form.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		m.form = f
+		cmds = append(cmds, cmd)
+	}
+
+	if m.form.State == huh.StateCompleted {
+		switch m.hasAnnouncedCompletion {
+		case false:
+			cmds = append(cmds, m.announceCompletion())
+		default:
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+func (m *SettingsModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	m.result = "Saving settings..."
+
+	return tui.SaveSettingsCmd(m.sendReadReceipts)
+}
+
+func (m *SettingsModel) View() string {
+	if m.hasAnnouncedCompletion {
+		return lipgloss.JoinVertical(lipgloss.Center,
+			"Settings\n",
+			m.result,
+			"\nPress esc to close.",
+		)
+	}
+	return lipgloss.JoinVertical(lipgloss.Center,
+		"Settings:\n",
+		m.form.View(),
+	)
+}
+
+func (m *SettingsModel) SetSize(width, _ int) {
+	m.form = m.form.WithWidth(width)
+}
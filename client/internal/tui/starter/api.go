@@ -0,0 +1,274 @@
+package starter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// apiVersionPath is the prefix for every route of the server API.
+const apiVersionPath = "/v1"
+
+// doRequest sends a POST request to the server API with the body encoded as JSON, if there is one.
+// The session cookie is included if a session ID is provided.
+func (m *Model) doRequest(ctx context.Context, path, sessionID string, body any) (*http.Response, error) {
+	route, err := url.JoinPath(m.serverAddr, apiVersionPath, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join url path: %w", err)
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		var bodyBytes []byte
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal body: %w", err)
+		}
+		bodyReader = bytes.NewBuffer(bodyBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, route, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request to %q: %w", route, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if sessionID != "" {
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request to %q: %w", route, err)
+	}
+	return resp, nil
+}
+
+func (m *Model) authenticate(ctx context.Context, isSignup bool, creds *entity.Credentials) (*session, error) {
+	route := "/auth/login"
+	if isSignup {
+		route = "/auth/signup"
+	}
+
+	resp, err := m.doRequest(ctx, route, "", creds)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return sessionFromResponse(resp)
+	case http.StatusAccepted:
+		var challenge entity.LoginChallenge
+		err = json.NewDecoder(resp.Body).Decode(&challenge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode login challenge: %w", err)
+		}
+		return nil, &totpRequiredError{MFAToken: challenge.MFAToken}
+	}
+
+	apiErr := decodeAPIError(resp)
+	switch apiErr.Code {
+	case entity.ErrorCodeInvalidCredentials:
+		return nil, errInvalidCredentials
+	case entity.ErrorCodeUsernameTaken:
+		return nil, errUsernameTaken
+	case entity.ErrorCodeRateLimited:
+		return nil, &lockedOutError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	case entity.ErrorCodeInvalidUsername, entity.ErrorCodeInvalidRequest:
+		return nil, fmt.Errorf("%w: %s", errBadRequest, apiErr.Message)
+	default:
+		return nil, apiErr
+	}
+}
+
+// authenticateTOTP completes a login which required a second factor.
+func (m *Model) authenticateTOTP(ctx context.Context, mfaToken, code string) (*session, error) {
+	resp, err := m.doRequest(ctx, "/auth/login/totp", "", entity.TOTPLogin{
+		MFAToken: mfaToken,
+		Code:     code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return sessionFromResponse(resp)
+	}
+
+	apiErr := decodeAPIError(resp)
+	switch apiErr.Code {
+	case entity.ErrorCodeInvalidCredentials:
+		return nil, errInvalidCredentials
+	case entity.ErrorCodeRateLimited:
+		return nil, &lockedOutError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	default:
+		return nil, apiErr
+	}
+}
+
+func (m *Model) doSessionRefresh(ctx context.Context, sessionID string) (*session, error) {
+	resp, err := m.doRequest(ctx, "/auth/refresh", sessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return sessionFromResponse(resp)
+	}
+
+	apiErr := decodeAPIError(resp)
+	switch apiErr.Code {
+	case entity.ErrorCodeUnauthenticated:
+		return nil, errUnauthorised
+	default:
+		return nil, apiErr
+	}
+}
+
+// doPasswordChange changes the password on the server. The server revokes every session when the
+// password changes, so the session is replaced with the new one it returns.
+func (m *Model) doPasswordChange(ctx context.Context, oldPassword, newPassword string) error {
+	resp, err := m.doRequest(ctx, "/auth/password", m.session.id, entity.PasswordChange{
+		OldPassword: oldPassword,
+		NewPassword: newPassword,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return authenticatedRequestError(decodeAPIError(resp))
+	}
+	sess, err := sessionFromResponse(resp)
+	if err != nil {
+		return err
+	}
+	m.setSession(sess)
+	return nil
+}
+
+func (m *Model) doAccountDeletion(ctx context.Context, password string) error {
+	resp, err := m.doRequest(ctx, "/auth/account/delete", m.session.id, entity.AccountDeletion{
+		Password: password,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return authenticatedRequestError(decodeAPIError(resp))
+}
+
+// doTOTPEnrollment begins enabling two-factor authentication and returns the otpauth:// URI.
+func (m *Model) doTOTPEnrollment(ctx context.Context) (string, error) {
+	resp, err := m.doRequest(ctx, "/auth/totp/enroll", m.session.id, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", authenticatedRequestError(decodeAPIError(resp))
+	}
+
+	var enrollment entity.TOTPEnrollment
+	err = json.NewDecoder(resp.Body).Decode(&enrollment)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode totp enrollment: %w", err)
+	}
+	return enrollment.URI, nil
+}
+
+// doTOTPConfirmation enables two-factor authentication and returns the recovery codes.
+func (m *Model) doTOTPConfirmation(ctx context.Context, code string) ([]string, error) {
+	resp, err := m.doRequest(ctx, "/auth/totp/confirm", m.session.id, entity.TOTPCode{
+		Code: code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, authenticatedRequestError(decodeAPIError(resp))
+	}
+
+	var recoveryCodes entity.TOTPRecoveryCodes
+	err = json.NewDecoder(resp.Body).Decode(&recoveryCodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode recovery codes: %w", err)
+	}
+	return recoveryCodes.RecoveryCodes, nil
+}
+
+// authenticatedRequestError converts the error from a request made with an active session
+// into an error which can be shown to the user.
+func authenticatedRequestError(apiErr *entity.APIError) error {
+	switch apiErr.Code {
+	case entity.ErrorCodeUnauthenticated:
+		return errUnauthorised
+	case entity.ErrorCodeInvalidCredentials:
+		return errInvalidCredentials
+	case entity.ErrorCodeInvalidCode:
+		return errInvalidCode
+	case entity.ErrorCodeInternal:
+		return apiErr
+	default:
+		return errors.New(apiErr.Message)
+	}
+}
+
+// decodeAPIError reads the error envelope from an unsuccessful response.
+// If the body is not a valid envelope then an error is made from the status code.
+func decodeAPIError(resp *http.Response) *entity.APIError {
+	var errResp entity.ErrorResponse
+	err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&errResp)
+	if err != nil || errResp.Error.Code == "" {
+		return &entity.APIError{
+			Code:    entity.ErrorCodeInternal,
+			Message: fmt.Sprintf("unexpected status code '%d'", resp.StatusCode),
+		}
+	}
+	return &errResp.Error
+}
+
+// parseRetryAfter returns the duration from a Retry-After header given in seconds.
+// Zero is returned if the value is missing or not a number of seconds.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// sessionFromResponse gets the session details from the cookie set in the response.
+func sessionFromResponse(resp *http.Response) (*session, error) {
+	for _, c := range resp.Cookies() {
+		if c.Name == "session_id" {
+			return &session{
+				id:        c.Value,
+				expiresAt: c.Expires,
+			}, nil
+		}
+	}
+	return nil, errors.New("failed to find session ID cookie in response")
+}
@@ -0,0 +1,196 @@
+package starter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/client/internal/tui/modals"
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// pendingUpload is a chat message which is sent once its attachment has been uploaded.
+type pendingUpload struct {
+	message        entity.Message
+	conversationMD entity.ConversationMetadata
+}
+
+// sendAttachment stores a copy of the file locally, adds a message for it to the conversation and starts
+// uploading it. The message is only sent to the recipients once the upload is complete.
+func (m *Model) sendAttachment(msg tui.SendAttachmentMsg) tea.Cmd {
+	content, err := os.ReadFile(msg.Path)
+	if err != nil {
+		return tui.DebugLogCmd(fmt.Sprintf("failed to read attachment: %v", err))
+	}
+	attachment, err := entity.NewAttachment(msg.Path, content)
+	if err != nil {
+		return tui.DebugLogCmd(fmt.Sprintf("failed to create attachment: %v", err))
+	}
+	err = m.repo.SaveAttachment(m.creds, attachment, content)
+	if err != nil {
+		return tui.DebugLogCmd(fmt.Sprintf("failed to store attachment: %v", err))
+	}
+
+	message := entity.Message{
+		ID:         uuid.New(),
+		Content:    "📎 " + attachment.Name,
+		Author:     m.creds.Username,
+		SentAt:     time.Now(),
+		Attachment: &attachment,
+	}
+	m.uploads[attachment.ID] = pendingUpload{message: message, conversationMD: msg.ConversationMD}
+
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(tui.ReceiveMessageMsg{
+		ConversationMD: msg.ConversationMD,
+		Message:        message,
+	})
+	cmds := []tea.Cmd{cmd, m.updateTransfer(websocket.TransferProgress{Attachment: attachment, Upload: true}, nil)}
+
+	err = m.wsClient.UploadAttachment(attachment, content)
+	if err != nil {
+		// The upload is retried until the server acknowledges it or the client gives up on it.
+		cmds = append(cmds, tui.DebugLogCmd(fmt.Sprintf("failed to start upload: %v", err)))
+	}
+	return tea.Batch(cmds...)
+}
+
+// handleUploadProgress shows how far an upload has got, and sends its chat message once it is complete.
+func (m *Model) handleUploadProgress(progress websocket.TransferProgress, err error) tea.Cmd {
+	if errors.Is(err, websocket.ErrUnknownTransfer) {
+		// The upload has already completed or been cancelled.
+		return nil
+	}
+
+	cmds := []tea.Cmd{m.updateTransfer(progress, nil)}
+	if err != nil {
+		// Chunks which could not be written are retried until the server acknowledges them or the client gives up.
+		cmds = append(cmds, tui.DebugLogCmd(fmt.Sprintf("failed to send attachment chunks: %v", err)))
+	}
+	if !progress.IsComplete() {
+		return tea.Batch(cmds...)
+	}
+
+	upload, ok := m.uploads[progress.Attachment.ID]
+	if !ok {
+		return tea.Batch(cmds...)
+	}
+	delete(m.uploads, progress.Attachment.ID)
+	err = m.wsClient.SendChatMessage(upload.message, upload.conversationMD, m.recipients(upload.conversationMD))
+	if err != nil {
+		// The message is retried until the server acknowledges it or the client gives up on it.
+		cmds = append(cmds, tui.DebugLogCmd(fmt.Sprintf("failed to send chat message: %v", err)))
+	}
+	return tea.Batch(cmds...)
+}
+
+// saveAttachment asks where to save the attachment, downloading it first if it is not stored locally.
+func (m *Model) saveAttachment(attachment entity.Attachment) tea.Cmd {
+	if m.repo.HasAttachment(m.creds.Username, attachment.ID) {
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.OpenModalMsg{Modal: modals.NewSaveAttachmentModel(attachment)})
+		return cmd
+	}
+
+	err := attachment.Validate()
+	if err != nil {
+		return m.updateTransfer(websocket.TransferProgress{Attachment: attachment}, err)
+	}
+	cmd := m.updateTransfer(websocket.TransferProgress{Attachment: attachment}, nil)
+	err = m.wsClient.DownloadAttachment(attachment)
+	if err != nil {
+		// The chunk queries are retried until the server answers them or the client gives up on them.
+		return tea.Batch(cmd, tui.DebugLogCmd(fmt.Sprintf("failed to start download: %v", err)))
+	}
+	return cmd
+}
+
+// handleDownloadProgress shows how far a download has got. Once it is complete the attachment is
+// stored locally and the active user is asked where to save it.
+func (m *Model) handleDownloadProgress(progress websocket.TransferProgress, err error) tea.Cmd {
+	if errors.Is(err, websocket.ErrUnknownTransfer) {
+		// The download has already completed or been cancelled.
+		return nil
+	}
+	if err != nil {
+		m.wsClient.CancelTransfer(progress.Attachment.ID)
+		return m.updateTransfer(progress, err)
+	}
+	if !progress.IsComplete() {
+		return m.updateTransfer(progress, nil)
+	}
+
+	err = m.repo.SaveAttachment(m.creds, progress.Attachment, progress.Content)
+	if err != nil {
+		return m.updateTransfer(progress, fmt.Errorf("failed to store attachment: %w", err))
+	}
+	cmd := m.updateTransfer(progress, nil)
+	var modalCmd tea.Cmd
+	m.child, modalCmd = m.child.Update(tui.OpenModalMsg{Modal: modals.NewSaveAttachmentModel(progress.Attachment)})
+	return tea.Batch(cmd, modalCmd)
+}
+
+// handleTransferFailed stops a transfer which the server did not accept. The chat message of a failed
+// upload is never sent, so it is marked as failed.
+func (m *Model) handleTransferFailed(msg transferFailedMsg) tea.Cmd {
+	m.wsClient.CancelTransfer(msg.attachmentID)
+
+	var attachment entity.Attachment
+	var statusCmd tea.Cmd
+	if upload, ok := m.uploads[msg.attachmentID]; ok {
+		delete(m.uploads, msg.attachmentID)
+		attachment = *upload.message.Attachment
+		m.child, statusCmd = m.child.Update(tui.MessageStatusMsg{
+			ConversationID: upload.conversationMD.ID,
+			MessageIDs:     []uuid.UUID{upload.message.ID},
+			Status:         entity.MessageStatusFailed,
+		})
+	} else {
+		attachment.ID = msg.attachmentID
+	}
+
+	progress := websocket.TransferProgress{Attachment: attachment, Upload: msg.upload}
+	return tea.Batch(statusCmd, m.updateTransfer(progress, errTransferFailed))
+}
+
+// exportAttachment decrypts the stored attachment and writes it to the path chosen by the active user.
+// Existing files are never overwritten.
+func (m *Model) exportAttachment(msg tui.ExportAttachmentMsg) tea.Cmd {
+	err := m.writeAttachment(msg.Attachment, msg.Path)
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(tui.AttachmentExportedMsg{Path: msg.Path, Err: err})
+	return cmd
+}
+
+func (m *Model) writeAttachment(attachment entity.Attachment, path string) error {
+	content, err := m.repo.GetAttachment(m.creds, attachment)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	return errors.Join(err, file.Close())
+}
+
+// updateTransfer shows the progress of the transfer on the chat.
+func (m *Model) updateTransfer(progress websocket.TransferProgress, err error) tea.Cmd {
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(tui.AttachmentTransferMsg{
+		AttachmentID: progress.Attachment.ID,
+		Upload:       progress.Upload,
+		Done:         progress.Done,
+		Size:         progress.Attachment.Size,
+		Err:          err,
+	})
+	return cmd
+}
@@ -1,7 +1,44 @@
 package starter
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
-	errUnauthorised = errors.New("unauthorised")
+	errUnauthorised       = errors.New("session is invalid or has expired")
+	errInvalidCredentials = errors.New("password is incorrect")
+	errInvalidCode        = errors.New("code is incorrect")
+	errUsernameTaken      = errors.New("username is already taken")
+	errBadRequest         = errors.New("bad request")
+	errTransferFailed     = errors.New("the server did not accept the transfer")
 )
+
+// pinMismatchMessage is shown when the server presents a different public key to the one pinned on first use.
+const pinMismatchMessage = "WARNING: The server's public key has changed since you first connected.\n" +
+	"Someone may be intercepting your connection, so it has been refused.\n" +
+	"If the server's key was rotated legitimately, restart the client with -repin."
+
+// lockedOutError is returned when the server refuses login attempts after too many failures.
+type lockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *lockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.RetryAfter)
+}
+
+// totpRequiredError is returned when the password was accepted but a second factor is needed to log in.
+type totpRequiredError struct {
+	MFAToken string
+}
+
+func (e *totpRequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+// lockedOutMessage returns the message shown to the user when they are locked out of logging in.
+func lockedOutMessage(err *lockedOutError) string {
+	return fmt.Sprintf("Too many failed attempts, please try again in %s.", err.RetryAfter.Round(time.Second))
+}
@@ -0,0 +1,45 @@
+package starter
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// refreshSessionMsg signals that the session with the given ID is due to be refreshed.
+type refreshSessionMsg struct {
+	sessionID string
+}
+
+// sessionRefreshedMsg encloses the result of attempting to refresh the session with the given ID.
+type sessionRefreshedMsg struct {
+	oldSessionID string
+	session      *session
+	err          error
+}
+
+// retryRequestsMsg signals that unanswered requests on the given WebSocket client are due to be retried.
+type retryRequestsMsg struct {
+	wsClient *websocket.Client
+}
+
+// attachmentStatusMsg encloses the chunks of an attachment being uploaded which the server already has.
+type attachmentStatusMsg struct {
+	status websocket.PayloadAttachmentStatus
+}
+
+// chunkAckMsg encloses a chunk of an attachment being uploaded which the server has stored.
+type chunkAckMsg struct {
+	chunk websocket.PayloadAttachmentChunk
+}
+
+// chunkReceivedMsg encloses a chunk of an attachment being downloaded.
+type chunkReceivedMsg struct {
+	chunk websocket.PayloadAttachmentChunk
+}
+
+// transferFailedMsg signals that a request for uploading or downloading an attachment has failed.
+type transferFailedMsg struct {
+	attachmentID uuid.UUID
+	upload       bool
+}
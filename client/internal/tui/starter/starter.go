@@ -1,28 +1,46 @@
 package starter
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/Broderick-Westrope/charmutils"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/google/uuid"
 
 	"github.com/Broderick-Westrope/teatime/client/internal/db"
 	"github.com/Broderick-Westrope/teatime/client/internal/tui"
+	"github.com/Broderick-Westrope/teatime/client/internal/tui/modals"
 	"github.com/Broderick-Westrope/teatime/client/internal/tui/views"
 	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/secure"
 	"github.com/Broderick-Westrope/teatime/internal/websocket"
 )
 
+const (
+	// requestRetryInterval is how often unanswered WebSocket requests are checked and retried.
+	requestRetryInterval = 5 * time.Second
+	// sessionRefreshMargin is how long before the session expires that it should be refreshed.
+	sessionRefreshMargin = 10 * time.Minute
+	// sessionRefreshRetryDelay is how long to wait before retrying a failed session refresh.
+	sessionRefreshRetryDelay = 30 * time.Second
+)
+
 var _ tea.Model = &Model{}
 
+// session holds the details of an authenticated session with the server.
+type session struct {
+	id        string
+	expiresAt time.Time
+}
+
 type Model struct {
 	child       tea.Model
 	wsClient    *websocket.Client
@@ -30,7 +48,18 @@ type Model struct {
 	messagesLog io.Writer
 
 	creds      *entity.Credentials
+	session    *session
+	settings   db.Settings
 	serverAddr string
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+
+	// uploads holds the chat messages waiting for their attachment to be uploaded, keyed by attachment ID.
+	uploads map[uuid.UUID]pendingUpload
+
+	// pendingCreds and mfaToken are held while waiting for the second factor of a login.
+	pendingCreds *entity.Credentials
+	mfaToken     string
 
 	width  int
 	height int
@@ -40,13 +69,21 @@ type Model struct {
 	ExitError      error
 }
 
-func NewModel(msgChan chan tea.Msg, serverAddr string, repo *db.Repository, messagesLog io.Writer) (*Model, error) {
+// NewModel creates the starter model. The tlsConfig is used for all connections to
+// the server and may be nil to use the system defaults.
+func NewModel(
+	msgChan chan tea.Msg, serverAddr string, tlsConfig *tls.Config, repo *db.Repository, messagesLog io.Writer,
+) (*Model, error) {
 	return &Model{
 		child:       views.NewLockModel(""),
 		repo:        repo,
 		messagesLog: messagesLog,
 		serverAddr:  serverAddr,
-		msgCh:       msgChan,
+		tlsConfig:   tlsConfig,
+		httpClient: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		msgCh: msgChan,
 	}, nil
 }
 
@@ -64,16 +101,81 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tui.AuthenticateMsg:
-		sessionID, err := m.authenticate(context.Background(), msg.IsSignup, msg.Credentials)
+		if msg.IsSignup {
+			if err := entity.ValidateUsername(msg.Credentials.Username); err != nil {
+				cmd := m.setChildToLock(fmt.Sprintf("Signup failed, %s.", err))
+				return m, cmd
+			}
+		}
+
+		sess, err := m.authenticate(context.Background(), msg.IsSignup, msg.Credentials)
 		if err != nil {
-			if errors.Is(err, errUnauthorised) {
+			switch {
+			case errors.Is(err, errInvalidCredentials):
 				cmd := m.setChildToLock("Authentication failed, please try again.")
 				return m, cmd
+			case errors.Is(err, errUsernameTaken):
+				cmd := m.setChildToLock("That username is already taken, please choose another.")
+				return m, cmd
+			case errors.Is(err, errBadRequest):
+				cmd := m.setChildToLock(fmt.Sprintf("Authentication failed, %s.", err))
+				return m, cmd
+			}
+			var lockedOutErr *lockedOutError
+			if errors.As(err, &lockedOutErr) {
+				cmd := m.setChildToLock(lockedOutMessage(lockedOutErr))
+				return m, cmd
+			}
+			var pinMismatchErr *secure.PinMismatchError
+			if errors.As(err, &pinMismatchErr) {
+				cmd := m.setChildToLock(pinMismatchMessage)
+				return m, cmd
+			}
+			var totpRequiredErr *totpRequiredError
+			if errors.As(err, &totpRequiredErr) {
+				m.pendingCreds = msg.Credentials
+				m.mfaToken = totpRequiredErr.MFAToken
+				cmd := m.setChildToTOTPLock("")
+				return m, cmd
 			}
 			return m, tui.FatalErrorCmd(fmt.Errorf("failed to authenticate: %w", err))
 		}
-		m.creds = msg.Credentials
-		cmd := m.setChildToApp(sessionID)
+		cmd := m.startApp(msg.Credentials, sess)
+		return m, cmd
+
+	case tui.VerifyTOTPMsg:
+		cmd := m.verifyTOTP(context.Background(), msg.Code)
+		return m, cmd
+
+	case tui.EnrollTOTPMsg:
+		uri, err := m.doTOTPEnrollment(context.Background())
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.OpenModalMsg{Modal: modals.NewEnableTOTPModel(uri, err)})
+		return m, cmd
+
+	case tui.ConfirmTOTPMsg:
+		recoveryCodes, err := m.doTOTPConfirmation(context.Background(), msg.Code)
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.TOTPEnabledMsg{RecoveryCodes: recoveryCodes, Err: err})
+		return m, cmd
+
+	case refreshSessionMsg:
+		if m.session == nil || m.session.id != msg.sessionID {
+			// The session has been replaced or ended since this refresh was scheduled.
+			return m, nil
+		}
+		return m, m.refreshSession(m.session.id)
+
+	case sessionRefreshedMsg:
+		cmd := m.handleSessionRefreshed(msg)
+		return m, cmd
+
+	case retryRequestsMsg:
+		if m.wsClient == nil || m.wsClient != msg.wsClient {
+			// The connection has been closed or replaced since this retry was scheduled.
+			return m, nil
+		}
+		cmd := m.retryRequests()
 		return m, cmd
 
 	case tui.QuitMsg:
@@ -83,6 +185,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if err != nil {
 				return m, tui.FatalErrorCmd(fmt.Errorf("failed to save user data on exit: %w", err))
 			}
+			m.session = nil
 			cmd := m.setChildToLock("")
 			return m, cmd
 		default:
@@ -94,9 +197,105 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		_ = m.appExitCleanup()
 		return m, tea.Quit
 
+	case tui.ChangePasswordMsg:
+		sessionID := m.session.id
+		err := m.changePassword(context.Background(), msg.OldPassword, msg.NewPassword)
+		if err != nil && errors.Is(err, db.ErrOutOfSync) {
+			return m, tui.FatalErrorCmd(fmt.Errorf("failed to change password: %w", err))
+		}
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.PasswordChangedMsg{Err: err})
+		if m.session.id != sessionID {
+			// The refresh scheduled for the replaced session will be ignored, so one is scheduled for the new session.
+			cmd = tea.Batch(cmd, m.scheduleSessionRefresh(time.Until(m.session.expiresAt)-sessionRefreshMargin))
+		}
+		return m, cmd
+
+	case tui.DeleteAccountMsg:
+		cmd := m.deleteAccount(context.Background(), msg.Password)
+		return m, cmd
+
 	case tui.SendMessageMsg:
 		return m, m.sendMessage(msg.Message, msg.ConversationMD)
 
+	case tui.SendTypingMsg:
+		return m, m.sendTyping(msg.ConversationMD, msg.Typing)
+
+	case tui.EditMessageMsg:
+		return m, m.editMessage(msg)
+
+	case tui.DeleteMessageMsg:
+		return m, m.deleteMessage(msg)
+
+	case tui.ReactMsg:
+		return m, m.react(msg)
+
+	case tui.SendAttachmentMsg:
+		return m, m.sendAttachment(msg)
+
+	case tui.SaveAttachmentMsg:
+		return m, m.saveAttachment(msg.Attachment)
+
+	case tui.ExportAttachmentMsg:
+		return m, m.exportAttachment(msg)
+
+	case attachmentStatusMsg:
+		if m.wsClient == nil {
+			return m, nil
+		}
+		return m, m.handleUploadProgress(m.wsClient.HandleAttachmentStatus(msg.status))
+
+	case chunkAckMsg:
+		if m.wsClient == nil {
+			return m, nil
+		}
+		return m, m.handleUploadProgress(m.wsClient.HandleChunkAck(msg.chunk))
+
+	case chunkReceivedMsg:
+		if m.wsClient == nil {
+			return m, nil
+		}
+		return m, m.handleDownloadProgress(m.wsClient.HandleChunk(msg.chunk))
+
+	case transferFailedMsg:
+		if m.wsClient == nil {
+			return m, nil
+		}
+		return m, m.handleTransferFailed(msg)
+
+	case tui.ReceiveMessageMsg:
+		// Messages sent by the active user are added to the child directly, so this is a message from someone else.
+		cmd := m.sendReceipt(msg.ConversationMD, []entity.Message{msg.Message}, entity.MessageStatusDelivered)
+		msg.Message.Status = entity.MessageStatusDelivered
+		var childCmd tea.Cmd
+		m.child, childCmd = m.child.Update(msg)
+		return m, tea.Batch(cmd, childCmd)
+
+	case tui.MessagesReadMsg:
+		var cmd tea.Cmd
+		if m.settings.SendReadReceipts {
+			cmd = m.sendReceipt(msg.ConversationMD, msg.Messages, entity.MessageStatusRead)
+		}
+		var childCmd tea.Cmd
+		m.child, childCmd = m.child.Update(msg)
+		return m, tea.Batch(cmd, childCmd)
+
+	case tui.OpenSettingsMsg:
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.OpenModalMsg{Modal: modals.NewSettingsModel(m.settings.SendReadReceipts)})
+		return m, cmd
+
+	case tui.SaveSettingsMsg:
+		settings := m.settings
+		settings.SendReadReceipts = msg.SendReadReceipts
+		err := m.repo.SaveSettings(m.creds.Username, settings)
+		if err == nil {
+			m.settings = settings
+		}
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.SettingsSavedMsg{Err: err})
+		return m, cmd
+
 	case tea.KeyMsg:
 		if msg.String() == "ctrl+c" {
 			_ = m.appExitCleanup()
@@ -128,66 +327,352 @@ func (m *Model) sendMessage(msg entity.Message, conversationMD entity.Conversati
 	})
 
 	// Send message to recipients via WebSockets
-	recipients := conversationMD.Participants
-	for i, v := range recipients {
-		if v == msg.Author {
-			recipients = append(recipients[:i], recipients[i+1:]...)
-			break
-		}
+	err := m.wsClient.SendChatMessage(msg, conversationMD, m.recipients(conversationMD))
+	if err != nil {
+		// The message is retried until the server acknowledges it or the client gives up on it.
+		return tea.Batch(cmd, tui.DebugLogCmd(fmt.Sprintf("failed to send chat message: %v", err)))
 	}
 
-	err := m.wsClient.SendChatMessage(msg, conversationMD, recipients)
+	return cmd
+}
+
+// editMessage updates a message sent by the active user locally and sends the new content to the recipients.
+func (m *Model) editMessage(msg tui.EditMessageMsg) tea.Cmd {
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(tui.MessageEditedMsg{
+		ConversationID: msg.ConversationMD.ID,
+		MessageID:      msg.MessageID,
+		Author:         m.creds.Username,
+		Content:        msg.Content,
+		EditedAt:       msg.EditedAt,
+	})
+
+	err := m.wsClient.EditMessage(msg.ConversationMD.ID, msg.MessageID, msg.Content, msg.EditedAt,
+		m.recipients(msg.ConversationMD))
 	if err != nil {
-		return tui.FatalErrorCmd(fmt.Errorf("failed to send chat message: %w", err))
+		// The edit is retried until the server acknowledges it or the client gives up on it.
+		return tea.Batch(cmd, tui.DebugLogCmd(fmt.Sprintf("failed to edit message: %v", err)))
+	}
+	return cmd
+}
+
+// react updates the active user's reaction to a message locally and sends it to the recipients.
+func (m *Model) react(msg tui.ReactMsg) tea.Cmd {
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(tui.ReactionMsg{
+		ConversationID: msg.ConversationMD.ID,
+		MessageID:      msg.MessageID,
+		Emoji:          msg.Emoji,
+		Username:       m.creds.Username,
+		Reacted:        msg.Reacted,
+	})
+
+	err := m.wsClient.React(msg.ConversationMD.ID, msg.MessageID, msg.Emoji, msg.Reacted,
+		m.recipients(msg.ConversationMD))
+	if err != nil {
+		// The reaction is retried until the server acknowledges it or the client gives up on it.
+		return tea.Batch(cmd, tui.DebugLogCmd(fmt.Sprintf("failed to send reaction: %v", err)))
+	}
+	return cmd
+}
+
+// deleteMessage deletes a message locally and, if it is for everyone, sends the deletion to the recipients.
+func (m *Model) deleteMessage(msg tui.DeleteMessageMsg) tea.Cmd {
+	deleted := tui.MessageDeletedMsg{
+		ConversationID: msg.ConversationMD.ID,
+		MessageID:      msg.MessageID,
+	}
+	if msg.ForEveryone {
+		deleted.Author = m.creds.Username
+	}
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(deleted)
+	if !msg.ForEveryone {
+		return cmd
 	}
 
+	err := m.wsClient.DeleteMessage(msg.ConversationMD.ID, msg.MessageID, m.recipients(msg.ConversationMD))
+	if err != nil {
+		// The deletion is retried until the server acknowledges it or the client gives up on it.
+		return tea.Batch(cmd, tui.DebugLogCmd(fmt.Sprintf("failed to delete message: %v", err)))
+	}
 	return cmd
 }
 
-func (m *Model) authenticate(ctx context.Context, isSignup bool, creds *entity.Credentials) (string, error) {
-	route := "/auth/login"
-	if isSignup {
-		route = "/auth/signup"
+// recipients returns the participants of the conversation other than the active user.
+func (m *Model) recipients(conversationMD entity.ConversationMetadata) []string {
+	recipients := make([]string, 0, len(conversationMD.Participants))
+	for _, participant := range conversationMD.Participants {
+		if participant != m.creds.Username {
+			recipients = append(recipients, participant)
+		}
+	}
+	return recipients
+}
+
+// retryRequests sends the WebSocket requests which the server has not answered again, and marks any chat
+// messages which the client has given up on as failed. It then schedules the next retry.
+func (m *Model) retryRequests() tea.Cmd {
+	cmds := []tea.Cmd{m.scheduleRequestRetry()}
+
+	failed, err := m.wsClient.RetryRequests()
+	if err != nil {
+		cmds = append(cmds, tui.DebugLogCmd(fmt.Sprintf("failed to retry requests: %v", err)))
+	}
+	for _, req := range failed {
+		if msg := failedRequestMsg(req); msg != nil {
+			cmds = append(cmds, func() tea.Msg { return msg })
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// scheduleRequestRetry returns a command which triggers a retry of unanswered WebSocket requests.
+func (m *Model) scheduleRequestRetry() tea.Cmd {
+	wsClient := m.wsClient
+	return tea.Tick(requestRetryInterval, func(time.Time) tea.Msg {
+		return retryRequestsMsg{wsClient: wsClient}
+	})
+}
+
+// failedRequestMsg returns the message telling the user that the request failed,
+// or nil if the failure is not worth showing them.
+func failedRequestMsg(req websocket.Msg) tea.Msg {
+	switch payload := req.Payload.(type) {
+	case websocket.PayloadSendChatMessage:
+		return tui.MessageStatusMsg{
+			ConversationID: payload.ConversationMD.ID,
+			MessageIDs:     []uuid.UUID{payload.Message.ID},
+			Status:         entity.MessageStatusFailed,
+		}
+	case websocket.PayloadAttachmentUpload:
+		return transferFailedMsg{attachmentID: payload.Attachment.ID, upload: true}
+	case websocket.PayloadAttachmentChunk:
+		return transferFailedMsg{attachmentID: payload.AttachmentID, upload: true}
+	case websocket.PayloadAttachmentChunkQuery:
+		return transferFailedMsg{attachmentID: payload.AttachmentID}
+	default:
+		return tui.DebugLogMsg(fmt.Sprintf("request %s of type %d failed", req.RequestID, req.Type))
+	}
+}
+
+// acknowledgedRequestMsg returns the message telling the user that the request succeeded, or nil if there is none.
+func acknowledgedRequestMsg(req websocket.Msg) tea.Msg {
+	switch payload := req.Payload.(type) {
+	case websocket.PayloadSendChatMessage:
+		return tui.MessageStatusMsg{
+			ConversationID: payload.ConversationMD.ID,
+			MessageIDs:     []uuid.UUID{payload.Message.ID},
+			Status:         entity.MessageStatusSent,
+		}
+	case websocket.PayloadAttachmentChunk:
+		return chunkAckMsg{chunk: payload}
+	default:
+		return nil
+	}
+}
+
+// sendTyping tells the other participants of the conversation whether the active user is typing.
+// Failing to do so is not fatal since the indicator expires on its own.
+func (m *Model) sendTyping(conversationMD entity.ConversationMetadata, typing bool) tea.Cmd {
+	if m.wsClient == nil {
+		return nil
 	}
-	route, err := url.JoinPath(m.serverAddr, route)
+
+	recipients := m.recipients(conversationMD)
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	err := m.wsClient.SendTyping(conversationMD.ID, typing, recipients)
+	if err != nil {
+		return tui.DebugLogCmd(fmt.Sprintf("failed to send typing indicator: %v", err))
+	}
+	return nil
+}
+
+// sendReceipt tells the authors of the messages that they have reached the given status.
+// Messages without an ID are skipped since they were sent by clients which cannot track their status.
+// Failing to send a receipt is not fatal since it only affects what the author sees.
+func (m *Model) sendReceipt(
+	conversationMD entity.ConversationMetadata, messages []entity.Message, status entity.MessageStatus,
+) tea.Cmd {
+	if m.wsClient == nil {
+		return nil
+	}
+
+	messageIDs := make(map[string][]uuid.UUID)
+	for _, msg := range messages {
+		if msg.ID == uuid.Nil || msg.Author == m.creds.Username {
+			continue
+		}
+		messageIDs[msg.Author] = append(messageIDs[msg.Author], msg.ID)
+	}
+
+	var cmds []tea.Cmd
+	for author, ids := range messageIDs {
+		err := m.wsClient.SendReceipt(conversationMD.ID, ids, status, author)
+		if err != nil {
+			cmds = append(cmds, tui.DebugLogCmd(fmt.Sprintf("failed to send %s receipt: %v", status, err)))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// changePassword changes the password on the server and re-encrypts the local data with the new password.
+// Either both succeed or neither are changed.
+func (m *Model) changePassword(ctx context.Context, oldPassword, newPassword string) error {
+	if oldPassword != m.creds.Password {
+		return errors.New("current password is incorrect")
+	}
+
+	appModel, ok := m.child.(*views.AppModel)
+	if !ok {
+		return fmt.Errorf("failed to cast starter child to app model: %w", charmutils.ErrInvalidTypeAssertion)
+	}
+	conversations, err := appModel.GetConversations()
+	if err != nil {
+		return err
+	}
+
+	err = m.repo.ChangePassword(m.creds, newPassword, conversations,
+		func() error { return m.doPasswordChange(ctx, oldPassword, newPassword) },
+		func() error { return m.doPasswordChange(ctx, newPassword, oldPassword) },
+	)
 	if err != nil {
-		return "", fmt.Errorf("failed to join url path: %w", err)
+		return err
+	}
+
+	m.creds.Password = newPassword
+	return nil
+}
+
+// deleteAccount deletes the account on the server and then erases the local data for the user.
+// The local data is only erased once the server has deleted the account.
+func (m *Model) deleteAccount(ctx context.Context, password string) tea.Cmd {
+	if password != m.creds.Password {
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.AccountDeletionFailedMsg{Err: errors.New("password is incorrect")})
+		return cmd
 	}
 
-	body, err := json.Marshal(creds)
+	err := m.doAccountDeletion(ctx, password)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal body: %w", err)
+		var cmd tea.Cmd
+		m.child, cmd = m.child.Update(tui.AccountDeletionFailedMsg{Err: err})
+		return cmd
+	}
+
+	// The server closes the WebSocket connection, so there is nothing to save or close gracefully.
+	if m.cancelWsReader != nil {
+		m.cancelWsReader()
+		m.cancelWsReader = nil
 	}
+	m.wsClient = nil
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, route, bytes.NewBuffer(body))
+	err = m.repo.DeleteConversations(m.creds.Username)
 	if err != nil {
-		return "", fmt.Errorf("failed to build request to %q: %w", route, err)
+		return tui.FatalErrorCmd(fmt.Errorf("account was deleted but failed to erase local data: %w", err))
 	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	m.creds = nil
+	m.session = nil
+	return m.setChildToLock("Your account has been deleted.")
+}
+
+// verifyTOTP completes a pending login using the given second factor code.
+// The pending login is abandoned if the server no longer accepts it.
+func (m *Model) verifyTOTP(ctx context.Context, code string) tea.Cmd {
+	if m.pendingCreds == nil {
+		return m.setChildToLock("")
+	}
+
+	sess, err := m.authenticateTOTP(ctx, m.mfaToken, code)
 	if err != nil {
-		return "", fmt.Errorf("failed to do request to %q: %w", route, err)
+		var lockedOutErr *lockedOutError
+		switch {
+		case errors.Is(err, errInvalidCredentials):
+			m.pendingCreds = nil
+			m.mfaToken = ""
+			return m.setChildToLock("Two-factor authentication failed, please log in again.")
+		case errors.As(err, &lockedOutErr):
+			m.pendingCreds = nil
+			m.mfaToken = ""
+			return m.setChildToLock(lockedOutMessage(lockedOutErr))
+		}
+		return tui.FatalErrorCmd(fmt.Errorf("failed to verify two-factor code: %w", err))
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		break
-	case http.StatusCreated:
-		break
-	case http.StatusUnauthorized:
-		return "", errUnauthorised
-	default:
-		return "", fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+	creds := m.pendingCreds
+	m.pendingCreds = nil
+	m.mfaToken = ""
+	return m.startApp(creds, sess)
+}
+
+// startApp stores the authenticated session, opens the app and schedules the first session refresh.
+func (m *Model) startApp(creds *entity.Credentials, sess *session) tea.Cmd {
+	m.creds = creds
+	m.session = sess
+	cmd := m.setChildToApp()
+	return tea.Batch(cmd, m.scheduleSessionRefresh(time.Until(sess.expiresAt)-sessionRefreshMargin))
+}
+
+// refreshSession returns a command which rotates the session with the given ID for a new one.
+func (m *Model) refreshSession(sessionID string) tea.Cmd {
+	return func() tea.Msg {
+		sess, err := m.doSessionRefresh(context.Background(), sessionID)
+		return sessionRefreshedMsg{
+			oldSessionID: sessionID,
+			session:      sess,
+			err:          err,
+		}
+	}
+}
+
+// handleSessionRefreshed stores the refreshed session and schedules the next refresh.
+// Failed refreshes are retried until the session expires.
+func (m *Model) handleSessionRefreshed(msg sessionRefreshedMsg) tea.Cmd {
+	if m.session == nil || m.session.id != msg.oldSessionID {
+		return nil
 	}
 
-	for _, c := range resp.Cookies() {
-		if c.Name == "session_id" {
-			return c.Value, nil
+	if msg.err != nil {
+		if errors.Is(msg.err, errUnauthorised) {
+			err := m.appExitCleanup()
+			if err != nil {
+				return tui.FatalErrorCmd(fmt.Errorf("failed to save user data after session expired: %w", err))
+			}
+			m.session = nil
+			return m.setChildToLock("Your session has expired, please log in again.")
 		}
+		if time.Until(m.session.expiresAt) > sessionRefreshRetryDelay {
+			return m.scheduleSessionRefresh(sessionRefreshRetryDelay)
+		}
+		return tui.FatalErrorCmd(fmt.Errorf("failed to refresh session: %w", msg.err))
+	}
+
+	m.setSession(msg.session)
+	return m.scheduleSessionRefresh(time.Until(m.session.expiresAt) - sessionRefreshMargin)
+}
+
+// setSession replaces the current session with one the server issued in its place.
+func (m *Model) setSession(sess *session) {
+	m.session = sess
+	if m.wsClient != nil {
+		m.wsClient.SetSessionID(m.session.id)
 	}
-	return "", errors.New("failed to find session ID cookie in response")
+}
+
+// scheduleSessionRefresh returns a command which triggers a refresh of the current session after the given delay.
+// Nothing is scheduled if the server did not provide an expiry for the session.
+func (m *Model) scheduleSessionRefresh(delay time.Duration) tea.Cmd {
+	if m.session == nil || m.session.expiresAt.IsZero() {
+		return nil
+	}
+	sessionID := m.session.id
+	return tea.Tick(max(delay, 0), func(time.Time) tea.Msg {
+		return refreshSessionMsg{sessionID: sessionID}
+	})
 }
 
 func (m *Model) setChildToLock(errMessage string) tea.Cmd {
@@ -201,12 +686,23 @@ func (m *Model) setChildToLock(errMessage string) tea.Cmd {
 	return tea.Batch(cmds...)
 }
 
-func (m *Model) setChildToApp(sessionID string) tea.Cmd {
-	wsAddr, err := url.JoinPath(m.serverAddr, "/ws")
+func (m *Model) setChildToTOTPLock(errMessage string) tea.Cmd {
+	m.child = views.NewTOTPLockModel(errMessage)
+	cmds := []tea.Cmd{m.child.Init()}
+
+	var cmd tea.Cmd
+	m.child, cmd = m.child.Update(tea.WindowSizeMsg{Width: m.width, Height: m.height})
+	cmds = append(cmds, cmd)
+
+	return tea.Batch(cmds...)
+}
+
+func (m *Model) setChildToApp() tea.Cmd {
+	wsAddr, err := url.JoinPath(m.serverAddr, apiVersionPath, "/ws")
 	if err != nil {
 		return tui.FatalErrorCmd(fmt.Errorf("failed to create WebSocket path: %w", err))
 	}
-	m.wsClient, err = websocket.NewClient(wsAddr, sessionID)
+	m.wsClient, err = websocket.NewClient(wsAddr, m.session.id, m.tlsConfig)
 	if err != nil {
 		return tui.FatalErrorCmd(fmt.Errorf("failed to create WebSocket client: %w", err))
 	}
@@ -216,9 +712,20 @@ func (m *Model) setChildToApp(sessionID string) tea.Cmd {
 		return tui.FatalErrorCmd(fmt.Errorf("failed to get conversations: %w", err))
 	}
 
+	m.settings, err = m.repo.GetSettings(m.creds.Username)
+	if err != nil {
+		return tui.FatalErrorCmd(fmt.Errorf("failed to get settings: %w", err))
+	}
+
+	m.uploads = make(map[uuid.UUID]pendingUpload)
 	m.child = views.NewAppModel(conversations, m.creds.Username)
 	cmds := []tea.Cmd{m.child.Init()}
 
+	err = m.wsClient.QueryPresence(conversationParticipants(conversations, m.creds.Username))
+	if err != nil {
+		return tui.FatalErrorCmd(fmt.Errorf("failed to query presence: %w", err))
+	}
+
 	var cmd tea.Cmd
 	m.child, cmd = m.child.Update(tea.WindowSizeMsg{Width: m.width, Height: m.height})
 	cmds = append(cmds, cmd)
@@ -227,9 +734,26 @@ func (m *Model) setChildToApp(sessionID string) tea.Cmd {
 	ctx, m.cancelWsReader = context.WithCancel(context.Background())
 	go m.readFromWebSocket(ctx)
 
+	cmds = append(cmds, m.scheduleRequestRetry())
 	return tea.Batch(cmds...)
 }
 
+// conversationParticipants returns every user who shares a conversation with the given user.
+func conversationParticipants(conversations []entity.Conversation, username string) []string {
+	seen := make(map[string]bool)
+	var participants []string
+	for _, conversation := range conversations {
+		for _, participant := range conversation.Metadata.Participants {
+			if participant == username || seen[participant] {
+				continue
+			}
+			seen[participant] = true
+			participants = append(participants, participant)
+		}
+	}
+	return participants
+}
+
 func (m *Model) appExitCleanup() error {
 	var err error
 	// close WS connection
@@ -296,7 +820,73 @@ func (m *Model) readFromWebSocket(ctx context.Context) {
 					ConversationMD: payload.ConversationMD,
 					Message:        payload.Message,
 				}
-				return
+
+			case websocket.PayloadPresence:
+				m.msgCh <- tui.PresenceMsg{Users: payload.Users}
+
+			case websocket.PayloadAck:
+				req, ok := m.wsClient.Resolve(msg.RequestID)
+				if !ok {
+					continue
+				}
+				if reqMsg := acknowledgedRequestMsg(req); reqMsg != nil {
+					m.msgCh <- reqMsg
+				}
+
+			case websocket.PayloadError:
+				req, hasFailed := m.wsClient.HandleError(msg.RequestID, payload.Code)
+				if !hasFailed {
+					continue
+				}
+				m.msgCh <- tui.DebugLogMsg(fmt.Sprintf("request %s failed: %s: %s", msg.RequestID, payload.Code, payload.Message))
+				if reqMsg := failedRequestMsg(req); reqMsg != nil {
+					m.msgCh <- reqMsg
+				}
+
+			case websocket.PayloadReceipt:
+				m.msgCh <- tui.MessageStatusMsg{
+					ConversationID: payload.ConversationID,
+					MessageIDs:     payload.MessageIDs,
+					Status:         payload.Status,
+				}
+
+			case websocket.PayloadEditMessage:
+				m.msgCh <- tui.MessageEditedMsg{
+					ConversationID: payload.ConversationID,
+					MessageID:      payload.MessageID,
+					Author:         payload.Username,
+					Content:        payload.Content,
+					EditedAt:       payload.EditedAt,
+				}
+
+			case websocket.PayloadDeleteMessage:
+				m.msgCh <- tui.MessageDeletedMsg{
+					ConversationID: payload.ConversationID,
+					MessageID:      payload.MessageID,
+					Author:         payload.Username,
+				}
+
+			case websocket.PayloadReaction:
+				m.msgCh <- tui.ReactionMsg{
+					ConversationID: payload.ConversationID,
+					MessageID:      payload.MessageID,
+					Emoji:          payload.Emoji,
+					Username:       payload.Username,
+					Reacted:        payload.Reacted,
+				}
+
+			case websocket.PayloadAttachmentStatus:
+				m.msgCh <- attachmentStatusMsg{status: payload}
+
+			case websocket.PayloadAttachmentChunk:
+				m.msgCh <- chunkReceivedMsg{chunk: payload}
+
+			case websocket.PayloadTyping:
+				m.msgCh <- tui.TypingMsg{
+					ConversationID: payload.ConversationID,
+					Username:       payload.Username,
+					Typing:         payload.Typing,
+				}
 
 			default:
 				m.msgCh <- tui.FatalErrorMsg(fmt.Errorf("unknown WebSocket message payload, type=%d", msg.Type))
@@ -44,6 +44,7 @@ type AppModel struct {
 	prevFocus   appFocusRegion
 	styles      *AppStyles
 	username    string
+	presence    map[string]entity.Presence
 	modalWidth  int
 	modalHeight int
 }
@@ -56,11 +57,12 @@ func NewAppModel(conversations []entity.Conversation, username string) *AppModel
 
 	focus := appFocusRegionContacts
 	return &AppModel{
-		conversations: components.NewConversationsModel(conversations, focus == appFocusRegionContacts),
+		conversations: components.NewConversationsModel(conversations, username, focus == appFocusRegionContacts),
 		chat:          components.NewChatModel(openConversation, username, focus == appFocusRegionChat),
 		focus:         focus,
 		styles:        DefaultAppStyles(),
 		username:      username,
+		presence:      make(map[string]entity.Presence),
 	}
 }
 
@@ -103,17 +105,116 @@ func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmd := m.setConversation(entity.Conversation(msg))
 		return m, cmd
 
+	case tui.TypingMsg:
+		cmd := m.chat.SetTyping(msg.ConversationID, msg.Username, msg.Typing)
+		return m, cmd
+
+	case tui.TypingExpiredMsg:
+		// Nothing needs to change, the view is refreshed to hide any expired typing indicators.
+		return m, nil
+
 	case tui.ReceiveMessageMsg:
-		m.chat.AddNewMessage(msg.Message)
+		// A message means the author has finished typing it.
+		m.chat.SetTyping(msg.ConversationMD.ID, msg.Message.Author, false)
+		deleted, err := m.conversations.IsMessageDeleted(msg.ConversationMD.ID, msg.Message.ID)
+		if err != nil {
+			return m, tui.FatalErrorCmd(err)
+		} else if deleted {
+			// The message was deleted before this copy of it arrived.
+			return m, nil
+		}
+		if msg.Message.ReplyTo != nil {
+			deleted, err = m.conversations.IsMessageDeleted(msg.ConversationMD.ID, msg.Message.ReplyTo.ID)
+			if err != nil {
+				return m, tui.FatalErrorCmd(err)
+			} else if deleted {
+				// The quote should not keep the content of a message which has been deleted.
+				msg.Message.ReplyTo = &entity.MessageQuote{ID: msg.Message.ReplyTo.ID}
+			}
+		}
+		if m.chat.GetConversationID() == msg.ConversationMD.ID {
+			m.chat.AddNewMessage(msg.Message)
+		}
 		cmd, err := m.conversations.AddNewMessage(msg.ConversationMD, msg.Message)
 		if err != nil {
 			return m, tui.FatalErrorCmd(err)
 		}
+		return m, tea.Batch(cmd, m.chat.MarkRead())
+
+	case tui.MessageEditedMsg:
+		m.chat.EditMessage(msg.ConversationID, msg.MessageID, msg.Author, msg.Content, msg.EditedAt)
+		err := m.conversations.EditMessage(msg.ConversationID, msg.MessageID, msg.Author, msg.Content, msg.EditedAt)
+		if err != nil {
+			return m, tui.FatalErrorCmd(err)
+		}
+		return m, nil
+
+	case tui.ReactionMsg:
+		m.chat.SetReaction(msg.ConversationID, msg.MessageID, msg.Emoji, msg.Username, msg.Reacted)
+		err := m.conversations.SetReaction(msg.ConversationID, msg.MessageID, msg.Emoji, msg.Username, msg.Reacted)
+		if err != nil {
+			return m, tui.FatalErrorCmd(err)
+		}
+		return m, nil
+
+	case tui.MessageDeletedMsg:
+		m.chat.DeleteMessage(msg.ConversationID, msg.MessageID, msg.Author)
+		cmd, err := m.conversations.DeleteMessage(msg.ConversationID, msg.MessageID, msg.Author)
+		if err != nil {
+			return m, tui.FatalErrorCmd(err)
+		}
 		return m, cmd
 
+	case tui.AttachmentTransferMsg:
+		m.chat.SetTransfer(msg)
+		return m, nil
+
+	case tui.MessageStatusMsg:
+		m.chat.SetMessageStatus(msg.ConversationID, msg.MessageIDs, msg.Status)
+		err := m.conversations.SetMessageStatus(msg.ConversationID, msg.MessageIDs, msg.Status)
+		if err != nil {
+			return m, tui.FatalErrorCmd(err)
+		}
+		return m, nil
+
+	case tui.MessagesReadMsg:
+		// The chat has already marked these messages as read, so only the stored conversation needs updating.
+		messageIDs := make([]uuid.UUID, len(msg.Messages))
+		for i, message := range msg.Messages {
+			messageIDs[i] = message.ID
+		}
+		err := m.conversations.SetMessageStatus(msg.ConversationMD.ID, messageIDs, entity.MessageStatusRead)
+		if err != nil {
+			return m, tui.FatalErrorCmd(err)
+		}
+		return m, nil
+
+	case tui.PresenceMsg:
+		for _, presence := range msg.Users {
+			m.presence[presence.Username] = presence
+		}
+		m.chat.SetPresence(m.presence)
+		return m, m.conversations.SetPresence(m.presence)
+
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "esc":
+			if m.focus == appFocusRegionChat && m.chat.IsEditing() {
+				m.chat.CancelEdit()
+				return m, nil
+			}
+			if m.focus == appFocusRegionChat && m.chat.IsReplying() {
+				m.chat.CancelReply()
+				return m, nil
+			}
+			if m.focus == appFocusRegionChat && m.chat.IsSelecting() {
+				return m, m.chat.StopSelecting()
+			}
+			if m.focus == appFocusRegionChat && m.chat.IsInThread() {
+				m.chat.CloseThread()
+				return m, nil
+			}
+
 			var newFocus appFocusRegion
 			switch m.focus {
 			case appFocusRegionContacts:
@@ -123,11 +224,12 @@ func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case appFocusRegionModal:
 				newFocus = m.prevFocus
 			}
+			cmd := m.chat.StopTyping()
 			err := m.setFocus(newFocus)
 			if err != nil {
 				return m, tui.FatalErrorCmd(err)
 			}
-			return m, nil
+			return m, tea.Batch(cmd, m.chat.MarkRead())
 
 		case "q":
 			if m.focus != appFocusRegionContacts {
@@ -256,7 +358,7 @@ func (m *AppModel) setConversation(conversation entity.Conversation) tea.Cmd {
 		return tui.FatalErrorCmd(err)
 	}
 	m.chat.SetConversation(conversation)
-	return nil
+	return m.chat.MarkRead()
 }
 
 func (m *AppModel) setModal(modal tui.Modal) tea.Cmd {
@@ -275,7 +377,7 @@ func (m *AppModel) setModal(modal tui.Modal) tea.Cmd {
 		if err != nil {
 			return tui.FatalErrorCmd(err)
 		}
-		return nil
+		return m.chat.MarkRead()
 	}
 }
 
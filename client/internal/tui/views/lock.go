@@ -38,6 +38,7 @@ const (
 	formKeyAuthMode = "authMode"
 	formKeyUsername = "username"
 	formKeyPassword = "password"
+	formKeyTOTPCode = "totp_code"
 )
 
 var _ tea.Model = &LockModel{}
@@ -48,38 +49,65 @@ type LockModel struct {
 	styles                 *lockStyles
 	errMessage             string
 
+	// requireTOTP is true when the password has been accepted and the second factor is needed.
+	requireTOTP bool
+
 	width  int
 	height int
 }
 
+// NewLockModel creates a LockModel which asks for the username and password.
 func NewLockModel(errMessage string) *LockModel {
-	return &LockModel{
-		form: huh.NewForm(
-			huh.NewGroup(
-				huh.NewConfirm().Key(formKeyAuthMode).
-					Affirmative("Signup").Negative("Login"),
-				huh.NewInput().Key(formKeyUsername).
-					Title("Username:").CharLimit(100).
-					Validate(func(s string) error {
-						if s == "" {
-							return errors.New("empty username not allowed")
-						}
-						return nil
-					}),
-				huh.NewInput().Key(formKeyPassword).
-					Title("Password:").CharLimit(100).
-					EchoMode(huh.EchoModePassword).
-					Validate(func(s string) error {
-						if s == "" {
-							return errors.New("empty password not allowed")
-						}
-						return nil
-					}),
-			),
-		),
-		styles:     defaultLockStyles(),
-		errMessage: errMessage,
+	return newLockModel(errMessage, false)
+}
+
+// NewTOTPLockModel creates a LockModel which asks for the TOTP or recovery code to complete a login.
+func NewTOTPLockModel(errMessage string) *LockModel {
+	return newLockModel(errMessage, true)
+}
+
+func newLockModel(errMessage string, requireTOTP bool) *LockModel {
+	m := &LockModel{
+		styles:      defaultLockStyles(),
+		errMessage:  errMessage,
+		requireTOTP: requireTOTP,
 	}
+	m.form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().Key(formKeyAuthMode).
+				Affirmative("Signup").Negative("Login"),
+			huh.NewInput().Key(formKeyUsername).
+				Title("Username:").CharLimit(100).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("empty username not allowed")
+					}
+					return nil
+				}),
+			huh.NewInput().Key(formKeyPassword).
+				Title("Password:").CharLimit(100).
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("empty password not allowed")
+					}
+					return nil
+				}),
+		).WithHideFunc(func() bool { return m.requireTOTP }),
+		huh.NewGroup(
+			huh.NewInput().Key(formKeyTOTPCode).
+				Title("Two-Factor Code:").
+				Description("Enter the code from your authenticator app or a recovery code.").
+				CharLimit(20).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("empty code not allowed")
+					}
+					return nil
+				}),
+		).WithHideFunc(func() bool { return !m.requireTOTP }),
+	)
+	return m
 }
 
 func (m *LockModel) Init() tea.Cmd {
@@ -117,11 +145,14 @@ func (m *LockModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m *LockModel) announceCompletion() tea.Cmd {
+	m.hasAnnouncedCompletion = true
+	if m.requireTOTP {
+		return tui.VerifyTOTPCmd(m.form.GetString(formKeyTOTPCode))
+	}
+
 	isSignup := m.form.GetBool(formKeyAuthMode)
 	username := m.form.GetString(formKeyUsername)
 	password := m.form.GetString(formKeyPassword)
-
-	m.hasAnnouncedCompletion = true
 	return tui.AuthenticateCmd(isSignup, username, password)
 }
 
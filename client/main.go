@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -14,6 +17,7 @@ import (
 
 	"github.com/Broderick-Westrope/teatime/client/internal/db"
 	"github.com/Broderick-Westrope/teatime/client/internal/tui/starter"
+	"github.com/Broderick-Westrope/teatime/internal/secure"
 	"github.com/Broderick-Westrope/teatime/internal/websocket"
 )
 
@@ -39,6 +43,9 @@ func run() int {
 
 type application struct {
 	serverAddr string
+	tlsConfig  *tls.Config
+	// repin is true when the pinned public key of the server should be replaced.
+	repin bool
 
 	log         *slog.Logger
 	debugID     string
@@ -51,6 +58,10 @@ func newApp() (*application, error) {
 		msgCh: make(chan tea.Msg),
 	}
 
+	flag.BoolVar(&app.repin, "repin", false,
+		"trust the server's current public key, replacing the pinned key (use after a legitimate key rotation)")
+	flag.Parse()
+
 	err := app.loadEnvVars()
 	if err != nil {
 		return nil, err
@@ -84,6 +95,11 @@ func (app *application) loadEnvVars() error {
 	if app.serverAddr, found = os.LookupEnv("SERVER_ADDR"); !found {
 		return fmt.Errorf("SERVER_ADDR env variable is required")
 	}
+
+	app.tlsConfig, err = secure.ClientTLSConfig(os.Getenv("CA_FILE"), os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		return fmt.Errorf("failed to setup TLS: %w", err)
+	}
 	return nil
 }
 
@@ -104,12 +120,19 @@ func (app *application) runTui() error {
 	if err != nil {
 		return fmt.Errorf("failed to setup database file: %w", err)
 	}
-	repo, err := db.NewRepository(fmt.Sprintf("file:%s", databaseFilePath))
+	// Attachments are stored alongside the database.
+	attachmentsDir := filepath.Join(filepath.Dir(databaseFilePath), "attachments")
+	repo, err := db.NewRepository(fmt.Sprintf("file:%s", databaseFilePath), attachmentsDir)
 	if err != nil {
 		return fmt.Errorf("failed to setup database repository: %w", err)
 	}
 
-	m, err := starter.NewModel(app.msgCh, app.serverAddr, repo, messagesDump)
+	err = app.setupPinning(repo)
+	if err != nil {
+		return fmt.Errorf("failed to setup certificate pinning: %w", err)
+	}
+
+	m, err := starter.NewModel(app.msgCh, app.serverAddr, app.tlsConfig, repo, messagesDump)
 	if err != nil {
 		return fmt.Errorf("failed to create starter model: %w", err)
 	}
@@ -161,6 +184,27 @@ func createFilepath(path string) (*os.File, error) {
 	return file, nil
 }
 
+// setupPinning pins the server's public key on first use so that later connections
+// are refused if the key changes. The pin is stored per server host and port.
+func (app *application) setupPinning(repo *db.Repository) error {
+	serverURL, err := url.Parse(app.serverAddr)
+	if err != nil {
+		return fmt.Errorf("failed to parse server address: %w", err)
+	}
+	pinAddr := serverURL.Host
+
+	if app.repin {
+		err = repo.DeleteServerPin(pinAddr)
+		if err != nil {
+			return fmt.Errorf("failed to delete pinned key: %w", err)
+		}
+		app.log.Info("deleted pinned key", slog.String("server", pinAddr))
+	}
+
+	app.tlsConfig.VerifyConnection = secure.PinOnFirstUse(repo, pinAddr)
+	return nil
+}
+
 func setupDatabaseFile() (string, error) {
 	path, err := xdg.DataFile("TeaTime/client.db")
 	if err != nil {
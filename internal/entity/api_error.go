@@ -0,0 +1,41 @@
+package entity
+
+import "fmt"
+
+// ErrorCode is a machine-readable identifier for an API error.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest       ErrorCode = "invalid_request"
+	ErrorCodeUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	ErrorCodeInvalidUsername      ErrorCode = "invalid_username"
+	ErrorCodeUsernameTaken        ErrorCode = "username_taken"
+	ErrorCodeInvalidCredentials   ErrorCode = "invalid_credentials"
+	ErrorCodeInvalidCode          ErrorCode = "invalid_code"
+	ErrorCodeRateLimited          ErrorCode = "rate_limited"
+	ErrorCodeUnauthenticated      ErrorCode = "unauthenticated"
+	ErrorCodeTOTPAlreadyEnabled   ErrorCode = "totp_already_enabled"
+	ErrorCodeTOTPNotEnrolled      ErrorCode = "totp_not_enrolled"
+	ErrorCodeInternal             ErrorCode = "internal_error"
+	ErrorCodeInvalidMessage       ErrorCode = "invalid_message"
+	ErrorCodeUnknownMessageType   ErrorCode = "unknown_message_type"
+	ErrorCodeForbidden            ErrorCode = "forbidden"
+	ErrorCodeNotFound             ErrorCode = "not_found"
+	ErrorCodeUnavailable          ErrorCode = "unavailable"
+)
+
+// APIError describes why a request to the API failed.
+// The Message is intended for humans and may change, so clients should branch on the Code.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// ErrorResponse is the body of every unsuccessful API response.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
@@ -0,0 +1,81 @@
+package entity_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+func TestErrorResponse_JSON(t *testing.T) {
+	tt := map[string]struct {
+		resp entity.ErrorResponse
+		want string
+	}{
+		"with message": {
+			resp: entity.ErrorResponse{Error: entity.APIError{
+				Code:    entity.ErrorCodeInvalidCredentials,
+				Message: "Failed authentication",
+			}},
+			want: `{"error":{"code":"invalid_credentials","message":"Failed authentication"}}`,
+		},
+		"without message": {
+			resp: entity.ErrorResponse{Error: entity.APIError{Code: entity.ErrorCodeRateLimited}},
+			want: `{"error":{"code":"rate_limited","message":""}}`,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.Marshal(tc.resp)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.want, string(got))
+
+			var decoded entity.ErrorResponse
+			require.NoError(t, json.Unmarshal(got, &decoded))
+			assert.Equal(t, tc.resp, decoded)
+		})
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tt := map[string]struct {
+		err      error
+		wantCode entity.ErrorCode
+		wantOK   bool
+	}{
+		"api error": {
+			err:      &entity.APIError{Code: entity.ErrorCodeNotFound, Message: "message is unknown"},
+			wantCode: entity.ErrorCodeNotFound,
+			wantOK:   true,
+		},
+		"wrapped api error": {
+			err:      fmt.Errorf("failed to relay: %w", &entity.APIError{Code: entity.ErrorCodeForbidden}),
+			wantCode: entity.ErrorCodeForbidden,
+			wantOK:   true,
+		},
+		"other error": {
+			err:    errors.New("connection refused"),
+			wantOK: false,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			var apiErr *entity.APIError
+			ok := errors.As(tc.err, &apiErr)
+			assert.Equal(t, tc.wantOK, ok)
+			if ok {
+				assert.Equal(t, tc.wantCode, apiErr.Code)
+			}
+		})
+	}
+
+	err := &entity.APIError{Code: entity.ErrorCodeInvalidCode, Message: "code is incorrect"}
+	assert.Equal(t, "invalid_code: code is incorrect", err.Error())
+}
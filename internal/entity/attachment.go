@@ -0,0 +1,91 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxAttachmentSize is the largest file which can be sent as an attachment, in bytes.
+	MaxAttachmentSize = 10 << 20
+	// AttachmentChunkSize is the size of each chunk an attachment is split into for transfer, in bytes.
+	// Only the last chunk may be smaller.
+	AttachmentChunkSize = 32 << 10
+)
+
+var (
+	ErrAttachmentTooLarge = fmt.Errorf("attachments can be at most %d MiB", MaxAttachmentSize>>20)
+	ErrChecksumMismatch   = errors.New("attachment content does not match its checksum")
+)
+
+// Attachment is a file sent with a chat message. Its content is transferred separately from the message, in chunks.
+type Attachment struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	// Checksum is the hex encoded SHA-256 hash of the content.
+	Checksum string `json:"checksum"`
+}
+
+// NewAttachment creates an attachment for the file with the given name and content.
+// Any directories in the name are dropped so that only the base name of the file is shared.
+func NewAttachment(name string, content []byte) (Attachment, error) {
+	attachment := Attachment{
+		ID:       uuid.New(),
+		Name:     filepath.Base(name),
+		Size:     int64(len(content)),
+		Checksum: checksum(content),
+	}
+	return attachment, attachment.Validate()
+}
+
+// Validate checks that the attachment is within the size limit and has a name and a well formed checksum.
+func (a Attachment) Validate() error {
+	switch {
+	case a.ID == uuid.Nil:
+		return errors.New("attachment ID is required")
+	case a.Name == "" || a.Name != filepath.Base(a.Name):
+		return errors.New("attachment name must be a file name")
+	case a.Size <= 0:
+		return errors.New("attachment must not be empty")
+	case a.Size > MaxAttachmentSize:
+		return ErrAttachmentTooLarge
+	}
+
+	decoded, err := hex.DecodeString(a.Checksum)
+	if err != nil || len(decoded) != sha256.Size {
+		return errors.New("attachment checksum must be a hex encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// Verify returns ErrChecksumMismatch if the content is not the content of the attachment.
+func (a Attachment) Verify(content []byte) error {
+	if int64(len(content)) != a.Size || checksum(content) != a.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// ChunkCount returns how many chunks the attachment is split into for transfer.
+func (a Attachment) ChunkCount() int {
+	return int((a.Size + AttachmentChunkSize - 1) / AttachmentChunkSize)
+}
+
+// ChunkSize returns the size of the chunk with the given index, which is zero if there is no such chunk.
+func (a Attachment) ChunkSize(index int) int {
+	if index < 0 || index >= a.ChunkCount() {
+		return 0
+	}
+	return int(min(AttachmentChunkSize, a.Size-int64(index)*AttachmentChunkSize))
+}
+
+func checksum(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,95 @@
+package entity_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+func TestNewAttachment(t *testing.T) {
+	tt := map[string]struct {
+		name     string
+		content  []byte
+		wantName string
+		wantErr  error
+	}{
+		"file name": {
+			name:     "server.log",
+			content:  []byte("started"),
+			wantName: "server.log",
+		},
+		"path": {
+			name:     "/var/log/server.log",
+			content:  []byte("started"),
+			wantName: "server.log",
+		},
+		"largest allowed": {
+			name:     "big.bin",
+			content:  make([]byte, entity.MaxAttachmentSize),
+			wantName: "big.bin",
+		},
+		"too large": {
+			name:    "huge.bin",
+			content: make([]byte, entity.MaxAttachmentSize+1),
+			wantErr: entity.ErrAttachmentTooLarge,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			attachment, err := entity.NewAttachment(tc.name, tc.content)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantName, attachment.Name)
+			assert.Equal(t, int64(len(tc.content)), attachment.Size)
+			assert.NoError(t, attachment.Verify(tc.content))
+		})
+	}
+}
+
+func TestAttachment_Verify(t *testing.T) {
+	content := []byte("diff --git a/main.go b/main.go")
+	attachment, err := entity.NewAttachment("fix.patch", content)
+	require.NoError(t, err)
+
+	tt := map[string]struct {
+		content []byte
+		wantErr error
+	}{
+		"same content": {
+			content: content,
+		},
+		"changed content": {
+			content: bytes.ToUpper(content),
+			wantErr: entity.ErrChecksumMismatch,
+		},
+		"truncated content": {
+			content: content[:10],
+			wantErr: entity.ErrChecksumMismatch,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, attachment.Verify(tc.content), tc.wantErr)
+		})
+	}
+}
+
+func TestAttachment_ChunkSize(t *testing.T) {
+	attachment := entity.Attachment{Size: 2*entity.AttachmentChunkSize + 10}
+
+	assert.Equal(t, 3, attachment.ChunkCount())
+	assert.Equal(t, entity.AttachmentChunkSize, attachment.ChunkSize(0))
+	assert.Equal(t, entity.AttachmentChunkSize, attachment.ChunkSize(1))
+	assert.Equal(t, 10, attachment.ChunkSize(2))
+	assert.Equal(t, 0, attachment.ChunkSize(3))
+	assert.Equal(t, 0, attachment.ChunkSize(-1))
+}
@@ -1,11 +1,18 @@
 package entity
 
-import "github.com/google/uuid"
+import (
+	"slices"
+
+	"github.com/google/uuid"
+)
 
 // Conversation is a list of messages between several participants.
 type Conversation struct {
 	Metadata ConversationMetadata `json:"metadata"`
 	Messages []Message            `json:"messages"`
+	// DeletedMessageIDs are the tombstones of messages which have been deleted from the conversation.
+	// They stop a deleted message from being added again if another copy of it arrives later.
+	DeletedMessageIDs []uuid.UUID `json:"deleted_message_ids,omitempty"`
 }
 
 type ConversationMetadata struct {
@@ -13,3 +20,27 @@ type ConversationMetadata struct {
 	Name         string    `json:"name"`
 	Participants []string  `json:"participants"`
 }
+
+// DeleteMessage removes the message with the ID and records a tombstone for it, and removes its content
+// from the quotes in any replies to it. The tombstone is recorded even if the message has not arrived yet,
+// in case it arrives after being deleted. The messages are copied rather than changed in place since other
+// copies of the conversation may share them.
+func (c *Conversation) DeleteMessage(messageID uuid.UUID) {
+	if messageID == uuid.Nil || c.IsDeleted(messageID) {
+		return
+	}
+	c.Messages = slices.DeleteFunc(slices.Clone(c.Messages), func(msg Message) bool {
+		return msg.ID == messageID
+	})
+	for i := range c.Messages {
+		if c.Messages[i].IsReplyTo(messageID) {
+			c.Messages[i].ReplyTo = &MessageQuote{ID: messageID}
+		}
+	}
+	c.DeletedMessageIDs = append(slices.Clip(c.DeletedMessageIDs), messageID)
+}
+
+// IsDeleted reports whether the message with the ID has been deleted from the conversation.
+func (c *Conversation) IsDeleted(messageID uuid.UUID) bool {
+	return slices.Contains(c.DeletedMessageIDs, messageID)
+}
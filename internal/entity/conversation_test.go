@@ -0,0 +1,61 @@
+package entity_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+func TestConversation_DeleteMessage(t *testing.T) {
+	kept, deleted, reply := uuid.New(), uuid.New(), uuid.New()
+
+	tt := map[string]struct {
+		messageID    uuid.UUID
+		wantMessages []uuid.UUID
+	}{
+		"existing message": {
+			messageID:    deleted,
+			wantMessages: []uuid.UUID{kept, reply},
+		},
+		"message which has not arrived": {
+			messageID:    uuid.New(),
+			wantMessages: []uuid.UUID{kept, deleted, reply},
+		},
+		"message without an ID": {
+			messageID:    uuid.Nil,
+			wantMessages: []uuid.UUID{kept, deleted, reply},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			quote := &entity.MessageQuote{ID: deleted, Author: "alice", Snippet: "hello"}
+			messages := []entity.Message{{ID: kept}, {ID: deleted}, {ID: reply, ReplyTo: quote}}
+			conversation := entity.Conversation{Messages: messages}
+
+			conversation.DeleteMessage(tc.messageID)
+
+			ids := make([]uuid.UUID, len(conversation.Messages))
+			for i, msg := range conversation.Messages {
+				ids[i] = msg.ID
+			}
+			assert.Equal(t, tc.wantMessages, ids)
+			assert.Equal(t, tc.messageID != uuid.Nil, conversation.IsDeleted(tc.messageID))
+
+			// The quote of a deleted message keeps only its ID.
+			gotQuote := conversation.Messages[len(conversation.Messages)-1].ReplyTo
+			if tc.messageID == deleted {
+				assert.Equal(t, &entity.MessageQuote{ID: deleted}, gotQuote)
+			} else {
+				assert.Equal(t, quote, gotQuote)
+			}
+
+			// The original messages are shared with other copies of the conversation so must not change.
+			assert.Equal(t, []entity.Message{{ID: kept}, {ID: deleted}, {ID: reply, ReplyTo: quote}}, messages)
+			assert.Equal(t, &entity.MessageQuote{ID: deleted, Author: "alice", Snippet: "hello"}, quote)
+		})
+	}
+}
@@ -4,3 +4,14 @@ type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+// PasswordChange is the request body for changing the password of the authenticated user.
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// AccountDeletion is the request body for deleting the account of the authenticated user.
+type AccountDeletion struct {
+	Password string `json:"password"`
+}
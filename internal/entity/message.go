@@ -1,10 +1,162 @@
 package entity
 
-import "time"
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageStatus is how far a message has got on its way to the recipients.
+// The statuses are ordered, so a message never moves back to an earlier status.
+type MessageStatus string
+
+const (
+	// MessageStatusPending means the server has not yet confirmed that it relayed the message.
+	MessageStatusPending MessageStatus = ""
+	// MessageStatusFailed means the client gave up on sending the message. It comes before
+	// MessageStatusSent since the server may still relay an earlier attempt.
+	MessageStatusFailed MessageStatus = "failed"
+	// MessageStatusSent means the server has relayed or queued the message.
+	MessageStatusSent MessageStatus = "sent"
+	// MessageStatusDelivered means a recipient's client has received the message.
+	MessageStatusDelivered MessageStatus = "delivered"
+	// MessageStatusRead means a recipient has seen the message.
+	MessageStatusRead MessageStatus = "read"
+)
+
+// rank returns the position of the status in the order that messages move through them.
+func (s MessageStatus) rank() int {
+	switch s {
+	case MessageStatusFailed:
+		return 1
+	case MessageStatusSent:
+		return 2
+	case MessageStatusDelivered:
+		return 3
+	case MessageStatusRead:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// IsBefore reports whether the status comes before the other status.
+func (s MessageStatus) IsBefore(other MessageStatus) bool {
+	return s.rank() < other.rank()
+}
 
 // Message is a single chat message sent from a user.
 type Message struct {
+	// ID identifies the message within its conversation. Messages created before IDs were
+	// introduced have the zero ID and cannot receive receipts.
+	ID      uuid.UUID `json:"id"`
 	Content string    `json:"content"`
 	Author  string    `json:"author"`
 	SentAt  time.Time `json:"sent_at"`
+	// Status is the furthest any recipient has got with a message sent by the active user.
+	// For messages received by the active user, it is the furthest status they have reported back to the author.
+	Status MessageStatus `json:"status,omitempty"`
+	// EditedAt is when the message was last edited. It is zero if the message has never been edited.
+	EditedAt time.Time `json:"edited_at,omitempty"`
+	// History holds the previous versions of an edited message, from oldest to newest.
+	History []MessageEdit `json:"history,omitempty"`
+	// Reactions holds the users who have reacted to the message with each emoji.
+	Reactions map[string][]string `json:"reactions,omitempty"`
+	// ReplyTo quotes the message which this message replies to. It is nil if the message is not a reply.
+	ReplyTo *MessageQuote `json:"reply_to,omitempty"`
+	// Attachment is a file sent with the message. It is nil if the message has no attachment.
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+// maxQuoteLength is the most characters of a message kept when quoting it in a reply.
+const maxQuoteLength = 80
+
+// MessageQuote is a reference to a message which has been replied to, with a snippet of its content
+// so that the reply can be shown in context even if the original message is not available.
+type MessageQuote struct {
+	ID      uuid.UUID `json:"id"`
+	Author  string    `json:"author"`
+	Snippet string    `json:"snippet"`
+}
+
+// Quote returns a reference to the message for a reply to it.
+// Long messages are shortened so that the reply does not repeat all of their content.
+func (m *Message) Quote() *MessageQuote {
+	snippet := m.Content
+	if runes := []rune(snippet); len(runes) > maxQuoteLength {
+		snippet = string(runes[:maxQuoteLength-1]) + "…"
+	}
+	return &MessageQuote{
+		ID:      m.ID,
+		Author:  m.Author,
+		Snippet: snippet,
+	}
+}
+
+// IsReplyTo reports whether the message is a reply to the message with the ID.
+func (m *Message) IsReplyTo(messageID uuid.UUID) bool {
+	return m.ReplyTo != nil && m.ReplyTo.ID == messageID
+}
+
+// MessageEdit is a previous version of an edited message.
+type MessageEdit struct {
+	Content string `json:"content"`
+	// EditedAt is when this version was written. It is the time the message was sent for the original version.
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// IsEdited reports whether the message has been edited.
+func (m *Message) IsEdited() bool {
+	return !m.EditedAt.IsZero()
+}
+
+// Edit replaces the content of the message, keeping the previous content in its history.
+// Edits which are not newer than the current version are ignored since they may arrive out of order,
+// and it reports whether the message was changed.
+func (m *Message) Edit(content string, editedAt time.Time) bool {
+	versionAt := m.SentAt
+	if m.IsEdited() {
+		versionAt = m.EditedAt
+	}
+	if !editedAt.After(versionAt) {
+		return false
+	}
+
+	m.History = append(m.History, MessageEdit{Content: m.Content, EditedAt: versionAt})
+	m.Content = content
+	m.EditedAt = editedAt
+	return true
+}
+
+// HasReacted reports whether the user has reacted to the message with the emoji.
+func (m *Message) HasReacted(emoji, username string) bool {
+	return slices.Contains(m.Reactions[emoji], username)
+}
+
+// SetReaction adds or removes the user's reaction to the message with the emoji,
+// and reports whether the reactions were changed. The users who reacted are copied rather
+// than changed in place since other copies of the message may share them.
+func (m *Message) SetReaction(emoji, username string, reacted bool) bool {
+	if m.HasReacted(emoji, username) == reacted {
+		return false
+	}
+
+	if reacted {
+		if m.Reactions == nil {
+			m.Reactions = make(map[string][]string)
+		}
+		m.Reactions[emoji] = append(slices.Clip(m.Reactions[emoji]), username)
+		return true
+	}
+
+	users := slices.DeleteFunc(slices.Clone(m.Reactions[emoji]), func(user string) bool {
+		return user == username
+	})
+	if len(users) == 0 {
+		delete(m.Reactions, emoji)
+	} else {
+		m.Reactions[emoji] = users
+	}
+	return true
 }
@@ -0,0 +1,177 @@
+package entity_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+func TestMessageStatus_IsBefore(t *testing.T) {
+	tt := map[string]struct {
+		status entity.MessageStatus
+		other  entity.MessageStatus
+		want   bool
+	}{
+		"pending before sent": {
+			status: entity.MessageStatusPending,
+			other:  entity.MessageStatusSent,
+			want:   true,
+		},
+		"pending before failed": {
+			status: entity.MessageStatusPending,
+			other:  entity.MessageStatusFailed,
+			want:   true,
+		},
+		"failed before sent": {
+			status: entity.MessageStatusFailed,
+			other:  entity.MessageStatusSent,
+			want:   true,
+		},
+		"sent before delivered": {
+			status: entity.MessageStatusSent,
+			other:  entity.MessageStatusDelivered,
+			want:   true,
+		},
+		"delivered before read": {
+			status: entity.MessageStatusDelivered,
+			other:  entity.MessageStatusRead,
+			want:   true,
+		},
+		"read not before delivered": {
+			status: entity.MessageStatusRead,
+			other:  entity.MessageStatusDelivered,
+			want:   false,
+		},
+		"same status": {
+			status: entity.MessageStatusDelivered,
+			other:  entity.MessageStatusDelivered,
+			want:   false,
+		},
+		"unknown treated as pending": {
+			status: entity.MessageStatus("unknown"),
+			other:  entity.MessageStatusSent,
+			want:   true,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.status.IsBefore(tc.other))
+		})
+	}
+}
+
+func TestMessage_Edit(t *testing.T) {
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := entity.Message{Content: "helo", SentAt: sentAt}
+	assert.False(t, msg.IsEdited())
+
+	assert.True(t, msg.Edit("hello", sentAt.Add(time.Minute)))
+	assert.True(t, msg.Edit("hello!", sentAt.Add(2*time.Minute)))
+	// Edits which arrive out of order are ignored.
+	assert.False(t, msg.Edit("hello", sentAt.Add(time.Minute)))
+
+	assert.True(t, msg.IsEdited())
+	assert.Equal(t, "hello!", msg.Content)
+	assert.Equal(t, sentAt.Add(2*time.Minute), msg.EditedAt)
+	assert.Equal(t, []entity.MessageEdit{
+		{Content: "helo", EditedAt: sentAt},
+		{Content: "hello", EditedAt: sentAt.Add(time.Minute)},
+	}, msg.History)
+}
+
+func TestMessage_SetReaction(t *testing.T) {
+	tt := map[string]struct {
+		reactions map[string][]string
+		emoji     string
+		reacted   bool
+		want      map[string][]string
+		wantOK    bool
+	}{
+		"first reaction": {
+			emoji:   "👍",
+			reacted: true,
+			want:    map[string][]string{"👍": {"alice"}},
+			wantOK:  true,
+		},
+		"add to existing reaction": {
+			reactions: map[string][]string{"👍": {"bob"}},
+			emoji:     "👍",
+			reacted:   true,
+			want:      map[string][]string{"👍": {"bob", "alice"}},
+			wantOK:    true,
+		},
+		"already reacted": {
+			reactions: map[string][]string{"👍": {"alice"}},
+			emoji:     "👍",
+			reacted:   true,
+			want:      map[string][]string{"👍": {"alice"}},
+		},
+		"remove reaction": {
+			reactions: map[string][]string{"👍": {"alice", "bob"}},
+			emoji:     "👍",
+			want:      map[string][]string{"👍": {"bob"}},
+			wantOK:    true,
+		},
+		"remove last reaction with emoji": {
+			reactions: map[string][]string{"👍": {"alice"}, "🎉": {"bob"}},
+			emoji:     "👍",
+			want:      map[string][]string{"🎉": {"bob"}},
+			wantOK:    true,
+		},
+		"remove reaction which was not made": {
+			reactions: map[string][]string{"👍": {"bob"}},
+			emoji:     "👍",
+			want:      map[string][]string{"👍": {"bob"}},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			msg := entity.Message{Reactions: tc.reactions}
+
+			ok := msg.SetReaction(tc.emoji, "alice", tc.reacted)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.want, msg.Reactions)
+			assert.Equal(t, tc.reacted, msg.HasReacted(tc.emoji, "alice"))
+		})
+	}
+}
+
+func TestMessage_Quote(t *testing.T) {
+	tt := map[string]struct {
+		content     string
+		wantSnippet string
+	}{
+		"short message": {
+			content:     "hello there",
+			wantSnippet: "hello there",
+		},
+		"long message": {
+			content:     strings.Repeat("a", 100),
+			wantSnippet: strings.Repeat("a", 79) + "…",
+		},
+		"long message with multi-byte characters": {
+			content:     strings.Repeat("🍵", 100),
+			wantSnippet: strings.Repeat("🍵", 79) + "…",
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			msg := entity.Message{ID: uuid.New(), Author: "alice", Content: tc.content}
+
+			quote := msg.Quote()
+			assert.Equal(t, &entity.MessageQuote{ID: msg.ID, Author: "alice", Snippet: tc.wantSnippet}, quote)
+
+			reply := entity.Message{ID: uuid.New(), ReplyTo: quote}
+			assert.True(t, reply.IsReplyTo(msg.ID))
+			assert.False(t, msg.IsReplyTo(reply.ID))
+		})
+	}
+}
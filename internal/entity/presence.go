@@ -0,0 +1,12 @@
+package entity
+
+import "time"
+
+// Presence is whether a user is currently connected to the server.
+type Presence struct {
+	Username string `json:"username"`
+	Online   bool   `json:"online"`
+	// LastSeen is when the user last disconnected. It is zero while they are online
+	// or if the server does not know when they were last seen.
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
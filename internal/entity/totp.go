@@ -0,0 +1,31 @@
+package entity
+
+// LoginChallenge is returned by the server when the password was correct but a second factor is required.
+// The MFAToken identifies the pending login and must be sent along with the code.
+type LoginChallenge struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// TOTPLogin is the request body for completing a login with a second factor.
+// The code may be a TOTP code or one of the user's recovery codes.
+type TOTPLogin struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// TOTPEnrollment is returned by the server when starting TOTP enrollment.
+// The URI is an otpauth:// URI which can be imported into an authenticator app.
+type TOTPEnrollment struct {
+	URI string `json:"uri"`
+}
+
+// TOTPCode is the request body for confirming TOTP enrollment.
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+// TOTPRecoveryCodes is returned by the server once TOTP is enabled.
+// Each code can be used once in place of a TOTP code and they are never shown again.
+type TOTPRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
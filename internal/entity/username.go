@@ -0,0 +1,52 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 32
+)
+
+var (
+	ErrUsernameLength = fmt.Errorf("username must be between %d and %d characters long",
+		UsernameMinLength, UsernameMaxLength)
+	ErrUsernameCharset = errors.New(
+		"username must start with a letter or number and contain only letters, numbers, '_', '-' and '.'")
+	ErrUsernameReserved = errors.New("username is reserved")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// reservedUsernames cannot be registered, regardless of case.
+var reservedUsernames = map[string]struct{}{
+	"admin":         {},
+	"administrator": {},
+	"root":          {},
+	"system":        {},
+	"server":        {},
+	"support":       {},
+	"teatime":       {},
+	"moderator":     {},
+	"null":          {},
+	"undefined":     {},
+}
+
+// ValidateUsername checks that the username meets the policy for new accounts.
+// Uniqueness is not checked here since it requires the user store.
+func ValidateUsername(username string) error {
+	if len(username) < UsernameMinLength || len(username) > UsernameMaxLength {
+		return ErrUsernameLength
+	}
+	if !usernamePattern.MatchString(username) {
+		return ErrUsernameCharset
+	}
+	if _, ok := reservedUsernames[strings.ToLower(username)]; ok {
+		return ErrUsernameReserved
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package entity_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tt := map[string]struct {
+		username string
+		wantErr  error
+	}{
+		"valid": {
+			username: "alice",
+			wantErr:  nil,
+		},
+		"valid with symbols": {
+			username: "alice.b_c-d",
+			wantErr:  nil,
+		},
+		"too short": {
+			username: "al",
+			wantErr:  entity.ErrUsernameLength,
+		},
+		"too long": {
+			username: strings.Repeat("a", entity.UsernameMaxLength+1),
+			wantErr:  entity.ErrUsernameLength,
+		},
+		"space": {
+			username: "alice b",
+			wantErr:  entity.ErrUsernameCharset,
+		},
+		"leading symbol": {
+			username: ".alice",
+			wantErr:  entity.ErrUsernameCharset,
+		},
+		"non-ascii": {
+			username: "alicé",
+			wantErr:  entity.ErrUsernameCharset,
+		},
+		"reserved": {
+			username: "admin",
+			wantErr:  entity.ErrUsernameReserved,
+		},
+		"reserved with different case": {
+			username: "AdMiN",
+			wantErr:  entity.ErrUsernameReserved,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			err := entity.ValidateUsername(tc.username)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Package migrate applies versioned SQL schema migrations which are embedded in the binary.
+//
+// Migrations are files named NNNN_description.up.sql, where NNNN is the version. They are applied
+// in order of version, each in its own transaction, and recorded in the schema_migrations table
+// along with a checksum of their contents. Applied migrations must never be edited; a checksum
+// mismatch is reported as ErrChecksumMismatch rather than silently ignored. Databases shared by
+// several processes should be migrated under a Lock so that they do not apply migrations at once.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidFileName  = errors.New("invalid migration file name")
+	ErrDuplicateVersion = errors.New("duplicate migration version")
+	ErrChecksumMismatch = errors.New("migration checksum does not match the applied migration")
+	ErrUnknownVersion   = errors.New("database has a migration which is unknown to this version")
+)
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.up\.sql$`)
+
+// Lock stops other processes from migrating the database until the returned unlock function is called.
+type Lock func(db *sql.DB) (unlock func() error, err error)
+
+// postgresLockID is an arbitrary key which identifies the advisory lock held while migrating.
+const postgresLockID = 7_283_464_029
+
+// PostgresLock holds a Postgres advisory lock while migrating, so that servers which start at
+// the same time wait for each other rather than applying the same migrations.
+func PostgresLock(db *sql.DB) (func() error, error) {
+	// Advisory locks belong to a session, so the same connection must be used to release it.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgresLockID)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to take advisory lock: %w", err), conn.Close())
+	}
+
+	return func() error {
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, postgresLockID)
+		if err != nil {
+			err = fmt.Errorf("failed to release advisory lock: %w", err)
+		}
+		return errors.Join(err, conn.Close())
+	}, nil
+}
+
+type Migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string
+}
+
+// Load reads the migrations in the directory of fsys, ordered by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFileName, entry.Name())
+		}
+		version, err := strconv.Atoi(matches[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: %q must start with a positive version", ErrInvalidFileName, entry.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     matches[2],
+			SQL:      string(data),
+			Checksum: checksum(data),
+		})
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("%w: %d", ErrDuplicateVersion, migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Up applies every migration which has not yet been applied to the database.
+// Migrations which have already been applied are checked against their recorded checksum.
+// The lock is held throughout, unless it is nil.
+func Up(db *sql.DB, migrations []Migration, lock Lock) (err error) {
+	if lock != nil {
+		unlock, err := lock(db)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, unlock()) }()
+	}
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);
+	`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied, err := appliedChecksums(db)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int]struct{}, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = struct{}{}
+	}
+	for version := range applied {
+		if _, ok := known[version]; !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+
+	for _, m := range migrations {
+		appliedChecksum, ok := applied[m.Version]
+		if ok {
+			if appliedChecksum != m.Checksum {
+				return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, m.Version, m.Name)
+			}
+			continue
+		}
+
+		err = apply(db, m)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadAndUp loads the migrations from the directory of fsys and applies them to the database under the lock,
+// which may be nil.
+func LoadAndUp(db *sql.DB, fsys fs.FS, dir string, lock Lock) error {
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return err
+	}
+	return Up(db, migrations, lock)
+}
+
+func appliedChecksums(db *sql.DB) (map[int]string, error) {
+	rows, err := db.Query(`SELECT version, checksum FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var sum string
+		if err = rows.Scan(&version, &sum); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = sum
+	}
+	return applied, rows.Err()
+}
+
+func apply(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.Exec(m.SQL); err != nil {
+		return err
+	}
+
+	insertSQL := `
+	INSERT INTO schema_migrations (version, name, checksum, applied_at)
+	VALUES ($1, $2, $3, $4)
+	`
+	if _, err = tx.Exec(insertSQL, m.Version, m.Name, m.Checksum, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,192 @@
+package migrate_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/migrate"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestLoad(t *testing.T) {
+	tt := map[string]struct {
+		fsys         fstest.MapFS
+		wantVersions []int
+		wantErr      error
+	}{
+		"ordered by version": {
+			fsys: fstest.MapFS{
+				"migrations/0002_second.up.sql": {Data: []byte("SELECT 2;")},
+				"migrations/0010_third.up.sql":  {Data: []byte("SELECT 10;")},
+				"migrations/0001_first.up.sql":  {Data: []byte("SELECT 1;")},
+			},
+			wantVersions: []int{1, 2, 10},
+		},
+		"invalid file name": {
+			fsys: fstest.MapFS{
+				"migrations/first.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: migrate.ErrInvalidFileName,
+		},
+		"zero version": {
+			fsys: fstest.MapFS{
+				"migrations/0000_first.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: migrate.ErrInvalidFileName,
+		},
+		"duplicate version": {
+			fsys: fstest.MapFS{
+				"migrations/0001_first.up.sql": {Data: []byte("SELECT 1;")},
+				"migrations/001_again.up.sql":  {Data: []byte("SELECT 1;")},
+			},
+			wantErr: migrate.ErrDuplicateVersion,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := migrate.Load(tc.fsys, "migrations")
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			versions := make([]int, len(migrations))
+			for i, m := range migrations {
+				versions[i] = m.Version
+			}
+			assert.Equal(t, tc.wantVersions, versions)
+		})
+	}
+}
+
+func TestUp(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"migrations/0001_create_things.up.sql": {Data: []byte("CREATE TABLE things (id INTEGER PRIMARY KEY);")},
+	}
+
+	require.NoError(t, migrate.LoadAndUp(db, fsys, "migrations", nil))
+	// Applying again does nothing since the migration has already been applied.
+	require.NoError(t, migrate.LoadAndUp(db, fsys, "migrations", nil))
+
+	fsys["migrations/0002_add_name.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE things ADD COLUMN name TEXT;")}
+	require.NoError(t, migrate.LoadAndUp(db, fsys, "migrations", nil))
+
+	_, err := db.Exec(`INSERT INTO things (id, name) VALUES (1, 'thing')`)
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestUp_ChecksumMismatch(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"migrations/0001_create_things.up.sql": {Data: []byte("CREATE TABLE things (id INTEGER PRIMARY KEY);")},
+	}
+	require.NoError(t, migrate.LoadAndUp(db, fsys, "migrations", nil))
+
+	fsys["migrations/0001_create_things.up.sql"].Data = []byte("CREATE TABLE things (id TEXT PRIMARY KEY);")
+	err := migrate.LoadAndUp(db, fsys, "migrations", nil)
+	assert.ErrorIs(t, err, migrate.ErrChecksumMismatch)
+}
+
+func TestUp_UnknownVersion(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"migrations/0001_create_things.up.sql": {Data: []byte("CREATE TABLE things (id INTEGER PRIMARY KEY);")},
+		"migrations/0002_add_name.up.sql":      {Data: []byte("ALTER TABLE things ADD COLUMN name TEXT;")},
+	}
+	require.NoError(t, migrate.LoadAndUp(db, fsys, "migrations", nil))
+
+	delete(fsys, "migrations/0002_add_name.up.sql")
+	err := migrate.LoadAndUp(db, fsys, "migrations", nil)
+	assert.ErrorIs(t, err, migrate.ErrUnknownVersion)
+}
+
+func TestUp_FailedMigrationIsRolledBack(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"migrations/0001_create_things.up.sql": {Data: []byte(
+			"CREATE TABLE things (id INTEGER PRIMARY KEY); INSERT INTO missing VALUES (1);")},
+	}
+
+	err := migrate.LoadAndUp(db, fsys, "migrations", nil)
+	require.Error(t, err)
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'things'`).Scan(&count))
+	assert.Zero(t, count)
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Zero(t, count)
+}
+
+func TestUp_Lock(t *testing.T) {
+	errLocked := errors.New("locked")
+	fsys := fstest.MapFS{
+		"migrations/0001_create_things.up.sql": {Data: []byte("CREATE TABLE things (id INTEGER PRIMARY KEY);")},
+	}
+
+	tt := map[string]struct {
+		lockErr      error
+		wantErr      error
+		wantUnlocked bool
+		wantApplied  int
+	}{
+		"migrates while locked": {
+			wantUnlocked: true,
+			wantApplied:  1,
+		},
+		"does not migrate without the lock": {
+			lockErr: errLocked,
+			wantErr: errLocked,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t)
+
+			var unlocked bool
+			lock := func(*sql.DB) (func() error, error) {
+				if tc.lockErr != nil {
+					return nil, tc.lockErr
+				}
+				return func() error {
+					unlocked = true
+					return nil
+				}, nil
+			}
+
+			err := migrate.LoadAndUp(db, fsys, "migrations", lock)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantUnlocked, unlocked)
+
+			var count int
+			require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'things'`).Scan(&count))
+			assert.Equal(t, tc.wantApplied, count)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrNoPeerCertificate = errors.New("server did not present a certificate")
+
+// PinStore persists the public key pin for each server address.
+type PinStore interface {
+	// GetServerPin returns the pin for the server address and whether one exists.
+	GetServerPin(serverAddr string) (string, bool, error)
+	SetServerPin(serverAddr, pin string) error
+}
+
+// PinMismatchError is returned when a server presents a public key which differs from the pinned key.
+type PinMismatchError struct {
+	ServerAddr string
+	Pinned     string
+	Presented  string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("public key of %q does not match the pinned key: pinned %s, presented %s",
+		e.ServerAddr, e.Pinned, e.Presented)
+}
+
+// PublicKeyPin returns the base64 encoded SHA-256 hash of the certificate's public key.
+// Hashing the public key rather than the whole certificate means that the pin survives
+// the certificate being renewed with the same key.
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// PinOnFirstUse returns a function for tls.Config VerifyConnection which trusts the server's public
+// key on the first connection and rejects any later connection which presents a different key.
+// It runs after the usual certificate verification, so it only adds to the checks made.
+func PinOnFirstUse(store PinStore, serverAddr string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return ErrNoPeerCertificate
+		}
+		presented := PublicKeyPin(cs.PeerCertificates[0])
+
+		pinned, ok, err := store.GetServerPin(serverAddr)
+		if err != nil {
+			return fmt.Errorf("failed to get pinned key: %w", err)
+		}
+		if !ok {
+			err = store.SetServerPin(serverAddr, presented)
+			if err != nil {
+				return fmt.Errorf("failed to pin key: %w", err)
+			}
+			return nil
+		}
+
+		if pinned != presented {
+			return &PinMismatchError{
+				ServerAddr: serverAddr,
+				Pinned:     pinned,
+				Presented:  presented,
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,54 @@
+package secure_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/secure"
+)
+
+type mapPinStore map[string]string
+
+func (s mapPinStore) GetServerPin(serverAddr string) (string, bool, error) {
+	pin, ok := s[serverAddr]
+	return pin, ok, nil
+}
+
+func (s mapPinStore) SetServerPin(serverAddr, pin string) error {
+	s[serverAddr] = pin
+	return nil
+}
+
+func loadCertificate(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir(), commonName)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	return cert
+}
+
+func TestPinOnFirstUse(t *testing.T) {
+	store := mapPinStore{}
+	verify := secure.PinOnFirstUse(store, "teatime.example:443")
+	original := loadCertificate(t, "original")
+
+	// The first connection pins the key and later connections with the same key are accepted.
+	require.NoError(t, verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{original}}))
+	assert.Equal(t, secure.PublicKeyPin(original), store["teatime.example:443"])
+	require.NoError(t, verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{original}}))
+
+	err := verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{loadCertificate(t, "rotated")}})
+	var pinErr *secure.PinMismatchError
+	require.ErrorAs(t, err, &pinErr)
+	assert.Equal(t, secure.PublicKeyPin(original), pinErr.Pinned)
+
+	err = verify(tls.ConnectionState{})
+	assert.ErrorIs(t, err, secure.ErrNoPeerCertificate)
+}
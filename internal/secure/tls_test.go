@@ -0,0 +1,112 @@
+package secure_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/secure"
+)
+
+// writeSelfSignedCert writes a new self-signed certificate and its key to the directory
+// and returns the paths of the files.
+func writeSelfSignedCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tt := map[string]struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		"1.2":         {version: "1.2", want: tls.VersionTLS12},
+		"1.3":         {version: "1.3", want: tls.VersionTLS13},
+		"unsupported": {version: "1.1", wantErr: true},
+		"empty":       {version: "", wantErr: true},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			got, err := secure.ParseTLSVersion(tc.version)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "teatime")
+
+	pool, err := secure.LoadCertPool(certFile)
+	require.NoError(t, err)
+	assert.NotNil(t, pool)
+
+	_, err = secure.LoadCertPool(keyFile)
+	assert.ErrorIs(t, err, secure.ErrNoCertificates)
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "first")
+
+	reloader, err := secure.NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+	assertCommonName(t, reloader, "first")
+
+	writeSelfSignedCert(t, dir, "second")
+	require.NoError(t, reloader.Reload())
+	assertCommonName(t, reloader, "second")
+
+	// A failed reload keeps serving the previous certificate.
+	require.NoError(t, os.WriteFile(keyFile, []byte("invalid"), 0o600))
+	require.Error(t, reloader.Reload())
+	assertCommonName(t, reloader, "second")
+}
+
+func assertCommonName(t *testing.T, reloader *secure.CertificateReloader, want string) {
+	t.Helper()
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, want, leaf.Subject.CommonName)
+}
@@ -0,0 +1,233 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// transferWindow is how many chunks of each transfer can be waiting on the server at once.
+const transferWindow = 4
+
+// ErrUnknownTransfer is returned when the server refers to an attachment which is not being transferred.
+var ErrUnknownTransfer = errors.New("attachment is not being transferred")
+
+// TransferProgress is how far the transfer of an attachment has got.
+type TransferProgress struct {
+	Attachment entity.Attachment
+	// Upload is true when the attachment is being sent and false when it is being received.
+	Upload bool
+	// Done is how many bytes of the attachment have been transferred.
+	Done int64
+	// Content is the content of a downloaded attachment. It is only set once the download is complete
+	// and the content has been checked against the checksum of the attachment.
+	Content []byte
+}
+
+// IsComplete reports whether the whole attachment has been transferred.
+func (p TransferProgress) IsComplete() bool {
+	return p.Done == p.Attachment.Size
+}
+
+// transfer is an upload or download of an attachment which is in progress.
+type transfer struct {
+	attachment entity.Attachment
+	upload     bool
+	// content is the whole attachment for uploads, and is filled in as chunks arrive for downloads.
+	content []byte
+	// requested holds whether each chunk has been sent or queried, and done whether the server has handled it.
+	requested []bool
+	done      []bool
+}
+
+func newTransfer(attachment entity.Attachment, upload bool, content []byte) *transfer {
+	return &transfer{
+		attachment: attachment,
+		upload:     upload,
+		content:    content,
+		requested:  make([]bool, attachment.ChunkCount()),
+		done:       make([]bool, attachment.ChunkCount()),
+	}
+}
+
+// nextChunks returns the chunks which should be requested next to keep transferWindow chunks in flight,
+// and marks them as requested.
+func (t *transfer) nextChunks() []int {
+	inFlight := 0
+	for i := range t.done {
+		if t.requested[i] && !t.done[i] {
+			inFlight++
+		}
+	}
+
+	var next []int
+	for i := 0; i < len(t.requested) && inFlight+len(next) < transferWindow; i++ {
+		if !t.requested[i] {
+			t.requested[i] = true
+			next = append(next, i)
+		}
+	}
+	return next
+}
+
+// chunk returns the part of the content which makes up the chunk with the given index.
+func (t *transfer) chunk(index int) []byte {
+	start := index * entity.AttachmentChunkSize
+	return t.content[start : start+t.attachment.ChunkSize(index)]
+}
+
+func (t *transfer) progress() TransferProgress {
+	progress := TransferProgress{Attachment: t.attachment, Upload: t.upload}
+	for i, done := range t.done {
+		if done {
+			progress.Done += int64(t.attachment.ChunkSize(i))
+		}
+	}
+	return progress
+}
+
+// UploadAttachment starts uploading the attachment with the given content. The server answers with the
+// chunks it already has, so an upload which was interrupted resumes from where it got to.
+// The upload continues as the server answers, through HandleAttachmentStatus and HandleChunkAck.
+func (c *Client) UploadAttachment(attachment entity.Attachment, content []byte) error {
+	err := attachment.Validate()
+	if err != nil {
+		return err
+	}
+	err = attachment.Verify(content)
+	if err != nil {
+		return err
+	}
+
+	c.transfersMu.Lock()
+	c.transfers[attachment.ID] = newTransfer(attachment, true, content)
+	c.transfersMu.Unlock()
+
+	return c.request(MsgTypeAttachmentUpload, PayloadAttachmentUpload{Attachment: attachment})
+}
+
+// HandleAttachmentStatus sends the chunks of the upload which the server does not have yet.
+func (c *Client) HandleAttachmentStatus(status PayloadAttachmentStatus) (TransferProgress, error) {
+	c.transfersMu.Lock()
+	t, ok := c.transfers[status.AttachmentID]
+	if !ok || !t.upload {
+		c.transfersMu.Unlock()
+		return TransferProgress{}, ErrUnknownTransfer
+	}
+	for _, index := range status.Received {
+		if index >= 0 && index < len(t.done) {
+			t.requested[index] = true
+			t.done[index] = true
+		}
+	}
+	return c.continueUpload(t)
+}
+
+// HandleChunkAck records that the server has received the chunk, and sends the next chunks of the upload.
+func (c *Client) HandleChunkAck(chunk PayloadAttachmentChunk) (TransferProgress, error) {
+	c.transfersMu.Lock()
+	t, ok := c.transfers[chunk.AttachmentID]
+	if !ok || !t.upload || chunk.Index < 0 || chunk.Index >= len(t.done) {
+		c.transfersMu.Unlock()
+		return TransferProgress{}, ErrUnknownTransfer
+	}
+	t.done[chunk.Index] = true
+	return c.continueUpload(t)
+}
+
+// continueUpload sends the next chunks of the upload and returns its progress.
+// The caller must hold transfersMu, which is released before sending.
+func (c *Client) continueUpload(t *transfer) (TransferProgress, error) {
+	progress := t.progress()
+	if progress.IsComplete() {
+		delete(c.transfers, t.attachment.ID)
+	}
+	var chunks []PayloadAttachmentChunk
+	for _, index := range t.nextChunks() {
+		chunks = append(chunks, PayloadAttachmentChunk{
+			AttachmentID: t.attachment.ID,
+			Index:        index,
+			Data:         t.chunk(index),
+		})
+	}
+	c.transfersMu.Unlock()
+
+	var errs []error
+	for _, chunk := range chunks {
+		errs = append(errs, c.request(MsgTypeAttachmentChunk, chunk))
+	}
+	return progress, errors.Join(errs...)
+}
+
+// DownloadAttachment starts downloading the attachment. The download continues as chunks arrive through HandleChunk.
+func (c *Client) DownloadAttachment(attachment entity.Attachment) error {
+	err := attachment.Validate()
+	if err != nil {
+		return err
+	}
+
+	c.transfersMu.Lock()
+	t := newTransfer(attachment, false, make([]byte, attachment.Size))
+	c.transfers[attachment.ID] = t
+	return c.continueDownload(t)
+}
+
+// HandleChunk stores a chunk of a download and queries the next chunks. Once the download is complete its
+// content is checked against the checksum of the attachment and returned as part of the progress.
+func (c *Client) HandleChunk(chunk PayloadAttachmentChunk) (TransferProgress, error) {
+	c.transfersMu.Lock()
+	t, ok := c.transfers[chunk.AttachmentID]
+	if !ok || t.upload {
+		c.transfersMu.Unlock()
+		return TransferProgress{}, ErrUnknownTransfer
+	}
+	if len(chunk.Data) == 0 || len(chunk.Data) != t.attachment.ChunkSize(chunk.Index) {
+		c.transfersMu.Unlock()
+		return t.progress(), fmt.Errorf("chunk %d of attachment %s has the wrong size", chunk.Index, chunk.AttachmentID)
+	}
+	if !t.done[chunk.Index] {
+		copy(t.content[chunk.Index*entity.AttachmentChunkSize:], chunk.Data)
+		t.done[chunk.Index] = true
+	}
+
+	progress := t.progress()
+	if !progress.IsComplete() {
+		return progress, c.continueDownload(t)
+	}
+
+	delete(c.transfers, t.attachment.ID)
+	c.transfersMu.Unlock()
+	err := t.attachment.Verify(t.content)
+	if err != nil {
+		return progress, err
+	}
+	progress.Content = t.content
+	return progress, nil
+}
+
+// continueDownload queries the next chunks of the download.
+// The caller must hold transfersMu, which is released before querying.
+func (c *Client) continueDownload(t *transfer) error {
+	next := t.nextChunks()
+	c.transfersMu.Unlock()
+
+	var errs []error
+	for _, index := range next {
+		errs = append(errs, c.request(MsgTypeAttachmentChunkQuery, PayloadAttachmentChunkQuery{
+			AttachmentID: t.attachment.ID,
+			Index:        index,
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// CancelTransfer stops uploading or downloading the attachment. Requests which have already been sent are
+// still answered by the server, but are ignored.
+func (c *Client) CancelTransfer(attachmentID uuid.UUID) {
+	c.transfersMu.Lock()
+	defer c.transfersMu.Unlock()
+	delete(c.transfers, attachmentID)
+}
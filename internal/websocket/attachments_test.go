@@ -0,0 +1,120 @@
+package websocket_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+func TestClient_UploadAttachment(t *testing.T) {
+	serverURL, received := newRequestServer(t)
+	client, err := websocket.NewClient(serverURL, "session", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	content := bytes.Repeat([]byte("tea"), entity.AttachmentChunkSize*2)
+	attachment, err := entity.NewAttachment("tea.txt", content)
+	require.NoError(t, err)
+	require.Equal(t, 6, attachment.ChunkCount())
+
+	require.NoError(t, client.UploadAttachment(attachment, content))
+	upload := <-received
+	assert.Equal(t, websocket.MsgTypeAttachmentUpload, upload.Type)
+
+	// Only the chunks which the server does not already have are sent, a window at a time.
+	progress, err := client.HandleAttachmentStatus(websocket.PayloadAttachmentStatus{
+		AttachmentID: attachment.ID,
+		Received:     []int{1},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, entity.AttachmentChunkSize, progress.Done)
+	var chunks []websocket.PayloadAttachmentChunk
+	for range 4 {
+		msg := <-received
+		chunk, ok := msg.Payload.(websocket.PayloadAttachmentChunk)
+		require.True(t, ok)
+		chunks = append(chunks, chunk)
+	}
+	assert.Equal(t, []int{0, 2, 3, 4}, []int{chunks[0].Index, chunks[1].Index, chunks[2].Index, chunks[3].Index})
+	assert.Equal(t, content[:entity.AttachmentChunkSize], chunks[0].Data)
+
+	// Each chunk the server acknowledges makes room for the next.
+	progress, err = client.HandleChunkAck(chunks[0])
+	require.NoError(t, err)
+	assert.False(t, progress.IsComplete())
+	last := (<-received).Payload.(websocket.PayloadAttachmentChunk)
+	assert.Equal(t, 5, last.Index)
+	assert.Equal(t, content[5*entity.AttachmentChunkSize:], last.Data)
+
+	for _, chunk := range append(chunks[1:], last) {
+		progress, err = client.HandleChunkAck(chunk)
+		require.NoError(t, err)
+	}
+	assert.True(t, progress.IsComplete())
+
+	// The upload is forgotten once it is complete.
+	_, err = client.HandleChunkAck(last)
+	assert.ErrorIs(t, err, websocket.ErrUnknownTransfer)
+}
+
+func TestClient_DownloadAttachment(t *testing.T) {
+	content := bytes.Repeat([]byte("tea"), entity.AttachmentChunkSize)
+	attachment, err := entity.NewAttachment("tea.txt", content)
+	require.NoError(t, err)
+	corrupted := bytes.Clone(content)
+	corrupted[0] = 'T'
+
+	tt := map[string]struct {
+		content []byte
+		wantErr error
+	}{
+		"success": {
+			content: content,
+		},
+		"checksum mismatch": {
+			content: corrupted,
+			wantErr: entity.ErrChecksumMismatch,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			serverURL, received := newRequestServer(t)
+			client, err := websocket.NewClient(serverURL, "session", nil)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = client.Close() })
+
+			require.NoError(t, client.DownloadAttachment(attachment))
+			var progress websocket.TransferProgress
+			for range attachment.ChunkCount() {
+				msg := <-received
+				query, ok := msg.Payload.(websocket.PayloadAttachmentChunkQuery)
+				require.True(t, ok)
+
+				start := query.Index * entity.AttachmentChunkSize
+				progress, err = client.HandleChunk(websocket.PayloadAttachmentChunk{
+					AttachmentID: attachment.ID,
+					Index:        query.Index,
+					Data:         tc.content[start : start+attachment.ChunkSize(query.Index)],
+				})
+				if !progress.IsComplete() {
+					require.NoError(t, err)
+				}
+			}
+
+			assert.True(t, progress.IsComplete())
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				assert.Nil(t, progress.Content)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, content, progress.Content)
+		})
+	}
+}
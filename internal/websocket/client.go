@@ -2,14 +2,17 @@ package websocket
 
 import (
 	crand "crypto/rand"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"github.com/Broderick-Westrope/teatime/internal/entity"
@@ -17,21 +20,44 @@ import (
 
 // Client is a struct that represents the websocket client.
 type Client struct {
-	conn      *websocket.Conn
-	mu        *sync.RWMutex
-	uri       string
+	conn   *websocket.Conn
+	mu     *sync.RWMutex
+	uri    string
+	dialer *websocket.Dialer
+
+	// sessionID is guarded by its own mutex since mu can be held for as long as it takes to read a message.
 	sessionID string
+	sessionMu *sync.Mutex
+
+	// pending holds the requests which the server has not yet answered, keyed by request ID.
+	pending   map[string]*pendingRequest
+	pendingMu *sync.Mutex
+
+	// transfers holds the attachments being uploaded or downloaded, keyed by attachment ID.
+	transfers   map[uuid.UUID]*transfer
+	transfersMu *sync.Mutex
 }
 
 // NewClient is a function used to create a new websocket client.
-func NewClient(uri, sessionID string) (*Client, error) {
+// The tlsConfig is used for wss:// connections and may be nil to use the system defaults.
+func NewClient(uri, sessionID string, tlsConfig *tls.Config) (*Client, error) {
 	uri = strings.Replace(uri, "http", "ws", 1)
 
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+
 	c := &Client{
 		conn:      nil,
 		mu:        &sync.RWMutex{},
 		uri:       uri,
+		dialer:    &dialer,
 		sessionID: sessionID,
+		sessionMu: &sync.Mutex{},
+		pending:   make(map[string]*pendingRequest),
+		pendingMu: &sync.Mutex{},
+
+		transfers:   make(map[uuid.UUID]*transfer),
+		transfersMu: &sync.Mutex{},
 	}
 	err := c.connect()
 	return c, err
@@ -42,14 +68,7 @@ func (c *Client) connect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	header := http.Header{}
-	cookie := &http.Cookie{
-		Name:  "session_id",
-		Value: c.sessionID,
-	}
-	header.Add("Cookie", cookie.String())
-
-	conn, resp, err := websocket.DefaultDialer.Dial(c.uri, header)
+	conn, resp, err := c.dialer.Dial(c.uri, c.header())
 	if err != nil {
 		return err
 	}
@@ -59,6 +78,28 @@ func (c *Client) connect() error {
 	return nil
 }
 
+// header returns the headers used to authenticate with the WebSocket server.
+func (c *Client) header() http.Header {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	header := http.Header{}
+	cookie := &http.Cookie{
+		Name:  "session_id",
+		Value: c.sessionID,
+	}
+	header.Add("Cookie", cookie.String())
+	return header
+}
+
+// SetSessionID updates the session ID used when reconnecting.
+// This should be called whenever the session is rotated since the old session ID will no longer be valid.
+func (c *Client) SetSessionID(sessionID string) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	c.sessionID = sessionID
+}
+
 func (c *Client) Reconnect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -69,12 +110,12 @@ func (c *Client) Reconnect() error {
 
 	for attempt := 1; attempt <= 3; attempt++ {
 		var resp *http.Response
-		c.conn, resp, err = websocket.DefaultDialer.Dial(c.uri, nil)
+		c.conn, resp, err = c.dialer.Dial(c.uri, c.header())
 		if resp != nil {
 			resp.Body.Close()
 		}
 		if err == nil {
-			return nil
+			return c.resendPending()
 		}
 
 		// Calculate exponential backoff with jitter
@@ -121,16 +162,77 @@ func (c *Client) ReadMessage() (*Msg, error) {
 	return &msg, err
 }
 
-func (c *Client) SendChatMessage(message entity.Message, conversationMD entity.ConversationMetadata, recipients []string) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// QueryPresence asks the server whether the users are online. The server replies with a presence
+// message, and sends another whenever one of the users connects or disconnects. Users are only
+// reported as online to those who they have exchanged messages with.
+func (c *Client) QueryPresence(usernames []string) error {
+	for batch := range slices.Chunk(usernames, MaxPresenceQueryUsernames) {
+		err := c.request(MsgTypePresenceQuery, PayloadPresenceQuery{Usernames: batch})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendTyping tells the recipients whether the user is typing in the conversation.
+func (c *Client) SendTyping(conversationID uuid.UUID, typing bool, recipients []string) error {
+	return c.request(MsgTypeTyping, PayloadTyping{
+		ConversationID: conversationID,
+		Typing:         typing,
+		Recipients:     recipients,
+	})
+}
 
-	return c.conn.WriteJSON(Msg{
-		Type: MsgTypeSendChatMessage,
-		Payload: PayloadSendChatMessage{
-			ConversationMD: conversationMD,
-			Message:        message,
-			Recipients:     recipients,
-		},
+// SendReceipt tells the author of the messages that they have reached the given status.
+func (c *Client) SendReceipt(
+	conversationID uuid.UUID, messageIDs []uuid.UUID, status entity.MessageStatus, author string,
+) error {
+	return c.request(MsgTypeReceipt, PayloadReceipt{
+		ConversationID: conversationID,
+		MessageIDs:     messageIDs,
+		Status:         status,
+		Recipient:      author,
+	})
+}
+
+// EditMessage replaces the content of a chat message which the user sent, for each of the recipients.
+func (c *Client) EditMessage(
+	conversationID, messageID uuid.UUID, content string, editedAt time.Time, recipients []string,
+) error {
+	return c.request(MsgTypeEditMessage, PayloadEditMessage{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Content:        content,
+		EditedAt:       editedAt,
+		Recipients:     recipients,
+	})
+}
+
+// DeleteMessage deletes a chat message which the user sent, for each of the recipients.
+func (c *Client) DeleteMessage(conversationID, messageID uuid.UUID, recipients []string) error {
+	return c.request(MsgTypeDeleteMessage, PayloadDeleteMessage{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Recipients:     recipients,
+	})
+}
+
+// React adds or removes the user's reaction to a chat message, for each of the recipients.
+func (c *Client) React(conversationID, messageID uuid.UUID, emoji string, reacted bool, recipients []string) error {
+	return c.request(MsgTypeReaction, PayloadReaction{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Emoji:          emoji,
+		Reacted:        reacted,
+		Recipients:     recipients,
+	})
+}
+
+func (c *Client) SendChatMessage(message entity.Message, conversationMD entity.ConversationMetadata, recipients []string) error {
+	return c.request(MsgTypeSendChatMessage, PayloadSendChatMessage{
+		ConversationMD: conversationMD,
+		Message:        message,
+		Recipients:     recipients,
 	})
 }
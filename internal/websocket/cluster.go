@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// Cluster lets hubs running in several server instances deliver messages to each other's connections.
+type Cluster struct {
+	// InstanceID uniquely identifies this server instance within the cluster.
+	InstanceID string
+	Broker     Broker
+	Presence   Presence
+	// HeartbeatInterval is how often the instance refreshes the presence of its users.
+	// It must be shorter than the time the presence registry keeps entries for.
+	HeartbeatInterval time.Duration
+}
+
+// Delivery is a message for users connected to another instance.
+type Delivery struct {
+	Message   []byte   `json:"message"`
+	Usernames []string `json:"usernames"`
+	// Ephemeral deliveries are dropped rather than queued if the users have disconnected.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// Close deliveries have no message. They close the users' connections instead,
+	// except for those opened by the login KeepLoginID, if it is set.
+	Close       bool   `json:"close,omitempty"`
+	KeepLoginID string `json:"keep_login_id,omitempty"`
+}
+
+// Broker relays messages between the hubs of different instances.
+type Broker interface {
+	// Publish sends the delivery to the instance so that it is written to the users' connections on it.
+	// It returns how many subscribers received the delivery, which is zero if the instance is not listening.
+	Publish(ctx context.Context, instanceID string, delivery Delivery) (int64, error)
+	// Subscribe calls deliver for each delivery published to the instance. It blocks until the context is done.
+	Subscribe(ctx context.Context, instanceID string, deliver func(Delivery)) error
+}
+
+// Presence records which instances hold connections for each user.
+type Presence interface {
+	// SetOnline records that the instance holds connections for the users. Entries expire
+	// unless they are refreshed, so that the users of a crashed instance are eventually forgotten.
+	SetOnline(ctx context.Context, instanceID string, usernames ...string) error
+	// SetOffline records that the instance no longer holds any connections for the user.
+	SetOffline(ctx context.Context, instanceID, username string) error
+	// GetInstances returns the instances which hold connections for the user.
+	GetInstances(ctx context.Context, username string) ([]string, error)
+}
@@ -1,27 +1,95 @@
 package websocket
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// writeTimeout is how long a write to a connection may take before the connection is treated as stalled.
+	writeTimeout = 10 * time.Second
+	// userLockCount is how many locks the users of a hub are spread across.
+	userLockCount = 64
+)
+
+// ErrPartialDelivery is returned by Send when the message could not be sent to some of the recipients,
+// but was delivered to, or queued for, the others. Sending it again would duplicate it for those recipients.
+var ErrPartialDelivery = errors.New("message was only delivered to some recipients")
+
+// ErrUnknownRecipient is returned by a MessageQueue for users who do not exist. Messages for them are dropped.
+var ErrUnknownRecipient = errors.New("recipient does not exist")
+
+// MessageQueue stores messages for recipients who are not connected to the hub so that they can be delivered later.
+type MessageQueue interface {
+	// EnqueueMessage stores the message to be delivered to the user once they connect.
+	// It returns an error wrapping ErrUnknownRecipient if the user does not exist.
+	EnqueueMessage(ctx context.Context, username string, message []byte) error
+	// DequeueMessages passes the messages stored for the user to deliver, ordered from oldest to newest, and removes
+	// those which were delivered. It stops at the first message which deliver fails on, keeping it and the rest.
+	DequeueMessages(ctx context.Context, username string, deliver func(message []byte) error) error
+}
+
 type Hub struct {
-	clients  map[string]*websocket.Conn
-	mu       *sync.RWMutex
-	upgrader *websocket.Upgrader
+	// clients holds the connections for each user. A user may be connected from several devices at once.
+	clients map[string]map[*websocket.Conn]*hubConn
+	// mu guards clients. It is only held while reading or changing the map, never during I/O.
+	mu *sync.RWMutex
+	// userLocks serialise sending to a user with adding and removing their connections, so that messages
+	// sent while their queue is flushed are neither written ahead of the queued messages nor queued after
+	// the flush has read the queue. Users share locks by the hash of their username to bound memory.
+	userLocks [userLockCount]sync.Mutex
+	upgrader  *websocket.Upgrader
+	queue     MessageQueue
+	metrics   HubMetrics
+	// cluster is nil unless the hub shares its users with hubs in other instances.
+	cluster *Cluster
+}
+
+// hubConn is a connection which has been added to the hub.
+// WebSocket connections support only one concurrent writer, so writes must go through the mutex.
+type hubConn struct {
+	conn *websocket.Conn
+	mu   *sync.Mutex
+	// loginID identifies the login which the connection was opened by. It may be empty.
+	loginID string
+}
+
+// write writes the message to the connection. A stalled connection fails once writeTimeout has passed
+// rather than blocking the sender indefinitely.
+func (c *hubConn) write(message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, message)
 }
 
 // NewHub initializes a new WebSocket client hub.
-func NewHub() *Hub {
+// The queue is used to store messages for offline recipients. If it is nil then messages to offline recipients are dropped.
+// The metrics are optional and may be nil. The cluster should be nil unless several instances of the server are run,
+// in which case Run must also be called.
+func NewHub(queue MessageQueue, metrics HubMetrics, cluster *Cluster) *Hub {
+	if metrics == nil {
+		metrics = noopHubMetrics{}
+	}
 	return &Hub{
-		clients: make(map[string]*websocket.Conn),
+		clients: make(map[string]map[*websocket.Conn]*hubConn),
 		mu:      &sync.RWMutex{},
 		upgrader: &websocket.Upgrader{
 			CheckOrigin: func(_ *http.Request) bool { return true },
 		},
+		queue:   queue,
+		metrics: metrics,
+		cluster: cluster,
 	}
 }
 
@@ -29,45 +97,437 @@ func (h *Hub) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader htt
 	return h.upgrader.Upgrade(w, r, responseHeader)
 }
 
-// Add adds a WebSocket connection to the hub.
-func (h *Hub) Add(conn *websocket.Conn, username string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.clients[username] = conn
+// Run relays messages published by other instances to the local connections and keeps the presence of
+// local users from expiring. It blocks until the context is done, and returns immediately if the hub is
+// not part of a cluster. Errors which do not stop the hub are passed to handleErr.
+func (h *Hub) Run(ctx context.Context, handleErr func(error)) error {
+	if h.cluster == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(h.cluster.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := h.refreshPresence(ctx)
+				if err != nil {
+					handleErr(fmt.Errorf("error refreshing presence: %w", err))
+				}
+			}
+		}
+	}()
+
+	return h.cluster.Broker.Subscribe(ctx, h.cluster.InstanceID, func(delivery Delivery) {
+		err := h.deliver(ctx, delivery)
+		if err != nil {
+			handleErr(fmt.Errorf("error delivering message from another instance: %w", err))
+		}
+	})
 }
 
-// Remove removes a WebSocket connection from the hub.
-func (h *Hub) Remove(username string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.clients, username)
+func (h *Hub) refreshPresence(ctx context.Context) error {
+	h.mu.RLock()
+	usernames := make([]string, 0, len(h.clients))
+	for username := range h.clients {
+		usernames = append(usernames, username)
+	}
+	h.mu.RUnlock()
+
+	if len(usernames) == 0 {
+		return nil
+	}
+	return h.cluster.Presence.SetOnline(ctx, h.cluster.InstanceID, usernames...)
+}
+
+// userLock returns the lock shared by the user, as described by Hub.userLocks.
+func (h *Hub) userLock(username string) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(username))
+	return &h.userLocks[hash.Sum32()%userLockCount]
 }
 
-// Send sends a message to the provided clients.
-func (h *Hub) Send(message []byte, usernames []string) error {
+// connections returns the connections the user has with this hub.
+func (h *Hub) connections(username string) []*hubConn {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	conns := make([]*hubConn, 0, len(h.clients[username]))
+	for _, hc := range h.clients[username] {
+		conns = append(conns, hc)
+	}
+	return conns
+}
+
+// Add adds a WebSocket connection to the hub and delivers any messages that were queued while the user was offline.
+// Messages for the user wait until the queue has been flushed so that they cannot overtake the queued ones,
+// but messages for other users are not held up. The login ID identifies the login which opened the connection,
+// so that CloseOthers can leave it open. It may be empty.
+func (h *Hub) Add(ctx context.Context, conn *websocket.Conn, username, loginID string) error {
+	userMu := h.userLock(username)
+	userMu.Lock()
+	defer userMu.Unlock()
+
+	hc := &hubConn{conn: conn, mu: &sync.Mutex{}, loginID: loginID}
+	h.mu.Lock()
+	if _, ok := h.clients[username]; !ok {
+		h.clients[username] = make(map[*websocket.Conn]*hubConn)
+	}
+	h.clients[username][conn] = hc
+	isFirst := len(h.clients[username]) == 1
+	h.mu.Unlock()
+	h.metrics.ClientConnected()
+
+	var errs []error
+	if h.cluster != nil && isFirst {
+		err := h.cluster.Presence.SetOnline(ctx, h.cluster.InstanceID, username)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error setting presence: %w", err))
+		}
+	}
+
+	if h.queue == nil {
+		return errors.Join(errs...)
+	}
+
+	// Messages which cannot be written stay queued for the next connection. No other messages can be queued
+	// for this user while the queue is flushed since we hold their lock, so the original order is preserved.
+	err := h.queue.DequeueMessages(ctx, username, func(message []byte) error {
+		if err := hc.write(message); err != nil {
+			h.metrics.WriteError()
+			return fmt.Errorf("error writing queued message: %w", err)
+		}
+		h.metrics.MessageRelayed()
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error dequeuing messages: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Remove removes a single WebSocket connection from the hub.
+// Any other connections for the same user are left untouched.
+func (h *Hub) Remove(ctx context.Context, username string, conn *websocket.Conn) error {
+	// The user's lock stops their presence being cleared after a new connection has set it.
+	userMu := h.userLock(username)
+	userMu.Lock()
+	defer userMu.Unlock()
+
+	h.mu.Lock()
+	if _, ok := h.clients[username][conn]; !ok {
+		h.mu.Unlock()
+		return nil
+	}
+	delete(h.clients[username], conn)
+	isLast := len(h.clients[username]) == 0
+	if isLast {
+		delete(h.clients, username)
+	}
+	h.mu.Unlock()
+	h.metrics.ClientDisconnected()
+
+	if isLast && h.cluster != nil {
+		err := h.cluster.Presence.SetOffline(ctx, h.cluster.InstanceID, username)
+		if err != nil {
+			return fmt.Errorf("error clearing presence: %w", err)
+		}
+	}
+	return nil
+}
+
+// Send sends a message to every connection of the provided clients, including connections held by other
+// instances in the cluster. Messages for recipients who are not connected anywhere are stored in the queue,
+// if there is one. The error wraps ErrPartialDelivery if the message still reached some of the recipients.
+func (h *Hub) Send(ctx context.Context, message []byte, usernames []string) error {
+	return h.send(ctx, message, usernames, false)
+}
+
+// SendEphemeral sends a message in the same way as Send, except that it is dropped for recipients
+// who are not connected. It is used for messages which are only meaningful in the moment, such as presence.
+func (h *Hub) SendEphemeral(ctx context.Context, message []byte, usernames []string) error {
+	return h.send(ctx, message, usernames, true)
+}
+
+func (h *Hub) send(ctx context.Context, message []byte, usernames []string, ephemeral bool) error {
+	var errs []error
+	// delivered counts the recipients who the message was written to or queued for.
+	var delivered int
+	// remote holds the recipients to publish the message to, keyed by the instance holding their connections.
+	remote := make(map[string][]string)
+	// reached holds whether each recipient who is only connected to other instances has been reached by any of them.
+	reached := make(map[string]bool)
 	for _, username := range usernames {
-		conn, exists := h.clients[username]
-		if !exists {
-			// No queuing mechanism is used; if the recipient is offline they will not receive the message.
+		handled, instanceIDs, err := h.sendToUser(ctx, message, username, ephemeral)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if handled {
+			delivered++
+		} else if len(instanceIDs) > 0 {
+			reached[username] = false
+		}
+		for _, instanceID := range instanceIDs {
+			remote[instanceID] = append(remote[instanceID], username)
+		}
+	}
+
+	for instanceID, recipients := range remote {
+		receivers, err := h.cluster.Broker.Publish(ctx, instanceID, Delivery{
+			Message:   message,
+			Usernames: recipients,
+			Ephemeral: ephemeral,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error publishing message to instance %q: %w", instanceID, err))
 			continue
 		}
+		if receivers == 0 {
+			// The instance has stopped, or is resubscribing, without its presence having expired yet.
+			continue
+		}
+		for _, username := range recipients {
+			if _, ok := reached[username]; ok {
+				reached[username] = true
+			}
+		}
+	}
 
-		err := conn.WriteMessage(websocket.TextMessage, message)
+	// Recipients who the message could not be published to are treated as offline.
+	for username, ok := range reached {
+		if ok {
+			delivered++
+			continue
+		}
+		if ephemeral {
+			continue
+		}
+		if err := h.enqueue(ctx, username, message); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+
+	err := errors.Join(errs...)
+	if err != nil && delivered > 0 {
+		return fmt.Errorf("%w: %w", ErrPartialDelivery, err)
+	}
+	return err
+}
+
+// sendToUser writes the message to the user's connections with this hub, reporting whether it was written to any
+// of them or queued, and returns the other instances which hold connections for the user. The message is queued if the user
+// is not connected anywhere, unless it is ephemeral.
+func (h *Hub) sendToUser(
+	ctx context.Context, message []byte, username string, ephemeral bool,
+) (bool, []string, error) {
+	userMu := h.userLock(username)
+	userMu.Lock()
+	defer userMu.Unlock()
+
+	var errs []error
+	deliveredLocally, err := h.writeLocal(message, username)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	var remote []string
+	if h.cluster != nil {
+		instanceIDs, err := h.cluster.Presence.GetInstances(ctx, username)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting presence: %w", err))
+		}
+		for _, instanceID := range instanceIDs {
+			if instanceID == h.cluster.InstanceID {
+				continue
+			}
+			remote = append(remote, instanceID)
+		}
+	}
+
+	handled := deliveredLocally
+	if !deliveredLocally && len(remote) == 0 && !ephemeral {
+		if err = h.enqueue(ctx, username, message); err != nil {
+			errs = append(errs, err)
+		} else {
+			handled = true
+		}
+	}
+	return handled, remote, errors.Join(errs...)
+}
+
+// deliver writes a delivery published by another instance to the local connections of the users.
+// Users who disconnected before it arrived have the message queued instead, unless it is ephemeral.
+// Close deliveries close the local connections of the users instead.
+func (h *Hub) deliver(ctx context.Context, delivery Delivery) error {
+	var errs []error
+	for _, username := range delivery.Usernames {
+		var err error
+		if delivery.Close {
+			err = h.closeLocal(username, delivery.KeepLoginID)
+		} else {
+			err = h.deliverToUser(ctx, delivery, username)
+		}
 		if err != nil {
-			h.Remove(username)
-			conn.Close()
-			return fmt.Errorf("error writing message: %w", err)
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
+}
+
+func (h *Hub) deliverToUser(ctx context.Context, delivery Delivery, username string) error {
+	userMu := h.userLock(username)
+	userMu.Lock()
+	defer userMu.Unlock()
+
+	delivered, err := h.writeLocal(delivery.Message, username)
+	if !delivered && !delivery.Ephemeral {
+		err = errors.Join(err, h.enqueue(ctx, username, delivery.Message))
+	}
+	return err
+}
+
+// Reply writes a message to a single connection of the user, such as the one which sent a request.
+func (h *Hub) Reply(username string, conn *websocket.Conn, message []byte) error {
+	h.mu.RLock()
+	hc, ok := h.clients[username][conn]
+	h.mu.RUnlock()
+	if !ok {
+		return errors.New("connection is not in the hub")
+	}
+	err := hc.write(message)
+	if err != nil {
+		h.metrics.WriteError()
+		return fmt.Errorf("error writing message: %w", err)
+	}
 	return nil
 }
 
-// Close closes a WebSocket connection.
-func (h *Hub) Close(username string) error {
-	return closeConnection(h.clients[username])
+// IsOnline reports whether the user has a connection with this hub or, in a cluster, with any other instance.
+func (h *Hub) IsOnline(ctx context.Context, username string) (bool, error) {
+	h.mu.RLock()
+	_, ok := h.clients[username]
+	h.mu.RUnlock()
+	if ok || h.cluster == nil {
+		return ok, nil
+	}
+
+	instanceIDs, err := h.cluster.Presence.GetInstances(ctx, username)
+	if err != nil {
+		return false, fmt.Errorf("error getting presence: %w", err)
+	}
+	return len(instanceIDs) > 0, nil
+}
+
+// writeLocal writes the message to every connection the user has with this hub, reporting whether
+// it was written to any of them. The caller must hold the user's lock.
+func (h *Hub) writeLocal(message []byte, username string) (bool, error) {
+	var errs []error
+	var delivered bool
+	for _, hc := range h.connections(username) {
+		err := hc.write(message)
+		if err != nil {
+			h.metrics.WriteError()
+			// The read loop for this connection will fail and remove it from the hub.
+			hc.conn.Close()
+			errs = append(errs, fmt.Errorf("error writing message: %w", err))
+			continue
+		}
+		h.metrics.MessageRelayed()
+		delivered = true
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// enqueue stores the message for an offline user, or drops it if the hub has no queue or the user does not exist.
+func (h *Hub) enqueue(ctx context.Context, username string, message []byte) error {
+	if h.queue == nil {
+		h.metrics.MessageOffline(false)
+		return nil
+	}
+	err := h.queue.EnqueueMessage(ctx, username, message)
+	if errors.Is(err, ErrUnknownRecipient) {
+		h.metrics.MessageOffline(false)
+		return nil
+	}
+	if err != nil {
+		h.metrics.MessageOffline(false)
+		return fmt.Errorf("error queuing message: %w", err)
+	}
+	h.metrics.MessageOffline(true)
+	return nil
+}
+
+// Close gracefully closes a WebSocket connection.
+func (h *Hub) Close(conn *websocket.Conn) error {
+	return closeConnection(conn)
+}
+
+// CloseAll closes every WebSocket connection for the user, including those held by other instances in the cluster.
+// The connections are removed from the hub once their read loops notice that they are closed.
+func (h *Hub) CloseAll(ctx context.Context, username string) error {
+	return h.closeUser(ctx, username, "")
+}
+
+// CloseOthers closes the user's WebSocket connections in the same way as CloseAll, except for those opened
+// by the login. Every connection is closed if the login ID is empty.
+func (h *Hub) CloseOthers(ctx context.Context, username, keepLoginID string) error {
+	return h.closeUser(ctx, username, keepLoginID)
+}
+
+func (h *Hub) closeUser(ctx context.Context, username, keepLoginID string) error {
+	errs := []error{h.closeLocal(username, keepLoginID)}
+	if h.cluster == nil {
+		return errors.Join(errs...)
+	}
+
+	instanceIDs, err := h.cluster.Presence.GetInstances(ctx, username)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error getting presence: %w", err))
+	}
+	for _, instanceID := range instanceIDs {
+		if instanceID == h.cluster.InstanceID {
+			continue
+		}
+		_, err = h.cluster.Broker.Publish(ctx, instanceID, Delivery{
+			Usernames:   []string{username},
+			Close:       true,
+			KeepLoginID: keepLoginID,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error publishing close to instance %q: %w", instanceID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeLocal closes every connection the user has with this hub, except for those opened by the login.
+func (h *Hub) closeLocal(username, keepLoginID string) error {
+	var errs []error
+	for _, hc := range h.connections(username) {
+		if keepLoginID != "" && hc.loginID == keepLoginID {
+			continue
+		}
+		err := hc.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second),
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error writing close message: %w", err))
+		}
+		err = hc.conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error closing connection: %w", err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func IsNormalCloseError(err error) bool {
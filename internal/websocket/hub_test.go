@@ -0,0 +1,516 @@
+package websocket_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// memoryCluster is a broker and presence registry shared by the hubs in a test.
+type memoryCluster struct {
+	mu          sync.Mutex
+	subscribers map[string]func(websocket.Delivery)
+	presence    map[string]map[string]bool
+	subscribed  chan string
+}
+
+func newMemoryCluster() *memoryCluster {
+	return &memoryCluster{
+		subscribers: make(map[string]func(websocket.Delivery)),
+		presence:    make(map[string]map[string]bool),
+		subscribed:  make(chan string, 10),
+	}
+}
+
+func (c *memoryCluster) Publish(_ context.Context, instanceID string, delivery websocket.Delivery) (int64, error) {
+	c.mu.Lock()
+	deliver := c.subscribers[instanceID]
+	c.mu.Unlock()
+	if deliver == nil {
+		return 0, nil
+	}
+	deliver(delivery)
+	return 1, nil
+}
+
+func (c *memoryCluster) Subscribe(ctx context.Context, instanceID string, deliver func(websocket.Delivery)) error {
+	c.mu.Lock()
+	c.subscribers[instanceID] = deliver
+	c.mu.Unlock()
+	c.subscribed <- instanceID
+	<-ctx.Done()
+	return nil
+}
+
+func (c *memoryCluster) SetOnline(_ context.Context, instanceID string, usernames ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, username := range usernames {
+		if c.presence[username] == nil {
+			c.presence[username] = make(map[string]bool)
+		}
+		c.presence[username][instanceID] = true
+	}
+	return nil
+}
+
+func (c *memoryCluster) SetOffline(_ context.Context, instanceID, username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.presence[username], instanceID)
+	return nil
+}
+
+func (c *memoryCluster) GetInstances(_ context.Context, username string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var instanceIDs []string
+	for instanceID := range c.presence[username] {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	return instanceIDs, nil
+}
+
+type memoryQueue struct {
+	mu       sync.Mutex
+	messages map[string][][]byte
+}
+
+func (q *memoryQueue) EnqueueMessage(_ context.Context, username string, message []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages[username] = append(q.messages[username], message)
+	return nil
+}
+
+func (q *memoryQueue) DequeueMessages(_ context.Context, username string, deliver func(message []byte) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.messages[username]) > 0 {
+		if err := deliver(q.messages[username][0]); err != nil {
+			return err
+		}
+		q.messages[username] = q.messages[username][1:]
+	}
+	delete(q.messages, username)
+	return nil
+}
+
+// failingQueue is a memoryQueue which fails with err, if it is set, when storing messages for one user.
+type failingQueue struct {
+	*memoryQueue
+	username string
+	err      error
+}
+
+func (q *failingQueue) EnqueueMessage(ctx context.Context, username string, message []byte) error {
+	if username == q.username && q.err != nil {
+		return q.err
+	}
+	return q.memoryQueue.EnqueueMessage(ctx, username, message)
+}
+
+// offlineMetrics records the calls to MessageOffline.
+type offlineMetrics struct {
+	websocket.HubMetrics
+	mu     sync.Mutex
+	queued []bool
+}
+
+func (m *offlineMetrics) MessageOffline(queued bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued = append(m.queued, queued)
+}
+
+// drain removes and returns the messages queued for the user.
+func (q *memoryQueue) drain(t *testing.T, username string) [][]byte {
+	t.Helper()
+
+	var messages [][]byte
+	require.NoError(t, q.DequeueMessages(context.Background(), username, func(message []byte) error {
+		messages = append(messages, message)
+		return nil
+	}))
+	return messages
+}
+
+// newClusterHub starts a hub in the cluster along with a server which adds connections to it.
+// The username is taken from the "username" query parameter.
+func newClusterHub(t *testing.T, c *memoryCluster, queue websocket.MessageQueue, instanceID string) (*websocket.Hub, string) {
+	t.Helper()
+
+	hub := websocket.NewHub(queue, nil, &websocket.Cluster{
+		InstanceID:        instanceID,
+		Broker:            c,
+		Presence:          c,
+		HeartbeatInterval: time.Minute,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = hub.Run(ctx, func(err error) { t.Error(err) }) }()
+	require.Equal(t, instanceID, <-c.subscribed)
+
+	return hub, serveHub(t, hub)
+}
+
+// serveHub starts a server which adds connections to the hub, and returns its address.
+// The username and login ID are taken from the "username" and "login" query parameters.
+func serveHub(t *testing.T, hub *websocket.Hub) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.URL.Query().Get("username")
+		conn, err := hub.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		assert.NoError(t, hub.Add(r.Context(), conn, username, r.URL.Query().Get("login")))
+		defer func() { assert.NoError(t, hub.Remove(r.Context(), username, conn)) }()
+
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, addr, username string) *gws.Conn {
+	t.Helper()
+
+	return dialLogin(t, addr, username, "")
+}
+
+func dialLogin(t *testing.T, addr, username, loginID string) *gws.Conn {
+	t.Helper()
+
+	conn, _, err := gws.DefaultDialer.Dial(addr+"?username="+username+"&login="+loginID, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *gws.Conn) string {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	return string(message)
+}
+
+func TestHub_Cluster(t *testing.T) {
+	c := newMemoryCluster()
+	queue := &memoryQueue{messages: make(map[string][][]byte)}
+	hubA, _ := newClusterHub(t, c, queue, "a")
+	_, addrB := newClusterHub(t, c, queue, "b")
+
+	bob := dial(t, addrB, "bob")
+	require.Eventually(t, func() bool {
+		instanceIDs, _ := c.GetInstances(context.Background(), "bob")
+		return len(instanceIDs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Bob is connected to the other instance, so the message is relayed through the broker.
+	require.NoError(t, hubA.Send(context.Background(), []byte("hello bob"), []string{"bob"}))
+	assert.Equal(t, "hello bob", readMessage(t, bob))
+
+	// Carol is not connected to any instance, so the message is queued.
+	require.NoError(t, hubA.Send(context.Background(), []byte("hello carol"), []string{"carol"}))
+	assert.Equal(t, [][]byte{[]byte("hello carol")}, queue.drain(t, "carol"))
+
+	// Ephemeral messages are never queued.
+	require.NoError(t, hubA.SendEphemeral(context.Background(), []byte("carol is typing"), []string{"carol"}))
+	assert.Empty(t, queue.drain(t, "carol"))
+
+	// Dave's instance has stopped but his presence has not expired yet, so nothing receives
+	// the published message and it is queued instead.
+	require.NoError(t, c.SetOnline(context.Background(), "dead", "dave"))
+	require.NoError(t, hubA.Send(context.Background(), []byte("hello dave"), []string{"dave"}))
+	assert.Equal(t, [][]byte{[]byte("hello dave")}, queue.drain(t, "dave"))
+
+	isOnline, err := hubA.IsOnline(context.Background(), "bob")
+	require.NoError(t, err)
+	assert.True(t, isOnline)
+
+	// Once bob disconnects his presence is cleared.
+	require.NoError(t, bob.Close())
+	assert.Eventually(t, func() bool {
+		instanceIDs, _ := c.GetInstances(context.Background(), "bob")
+		return len(instanceIDs) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// requireClosed waits for the server to close the connection.
+func requireClosed(t *testing.T, conn *gws.Conn) {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := conn.ReadMessage()
+	require.True(t, websocket.IsNormalCloseError(err), "expected the connection to be closed, got %v", err)
+}
+
+func TestHub_ClusterCloseAll(t *testing.T) {
+	c := newMemoryCluster()
+	queue := &memoryQueue{messages: make(map[string][][]byte)}
+	hubA, addrA := newClusterHub(t, c, queue, "a")
+	_, addrB := newClusterHub(t, c, queue, "b")
+
+	bobA := dial(t, addrA, "bob")
+	bobB := dial(t, addrB, "bob")
+	carolB := dial(t, addrB, "carol")
+	require.Eventually(t, func() bool {
+		bobInstances, _ := c.GetInstances(context.Background(), "bob")
+		carolInstances, _ := c.GetInstances(context.Background(), "carol")
+		return len(bobInstances) == 2 && len(carolInstances) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Bob's connections are closed on every instance, and other users stay connected.
+	require.NoError(t, hubA.CloseAll(context.Background(), "bob"))
+	requireClosed(t, bobA)
+	requireClosed(t, bobB)
+	assert.Eventually(t, func() bool {
+		instanceIDs, _ := c.GetInstances(context.Background(), "bob")
+		return len(instanceIDs) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, hubA.Send(context.Background(), []byte("hello carol"), []string{"carol"}))
+	assert.Equal(t, "hello carol", readMessage(t, carolB))
+}
+
+func TestHub_ClusterCloseOthers(t *testing.T) {
+	c := newMemoryCluster()
+	queue := &memoryQueue{messages: make(map[string][][]byte)}
+	hubA, addrA := newClusterHub(t, c, queue, "a")
+	_, addrB := newClusterHub(t, c, queue, "b")
+
+	laptopA := dialLogin(t, addrA, "bob", "laptop")
+	laptopB := dialLogin(t, addrB, "bob", "laptop")
+	phone := dialLogin(t, addrB, "bob", "phone")
+	require.Eventually(t, func() bool {
+		instanceIDs, _ := c.GetInstances(context.Background(), "bob")
+		return len(instanceIDs) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Only the connections of other logins are closed, wherever they are connected.
+	require.NoError(t, hubA.CloseOthers(context.Background(), "bob", "laptop"))
+	requireClosed(t, phone)
+
+	// The closed connection may not have been removed from its hub yet, in which case writing to it fails.
+	err := hubA.Send(context.Background(), []byte("hello bob"), []string{"bob"})
+	if err != nil {
+		require.ErrorIs(t, err, websocket.ErrPartialDelivery)
+	}
+	assert.Equal(t, "hello bob", readMessage(t, laptopA))
+	assert.Equal(t, "hello bob", readMessage(t, laptopB))
+}
+
+func TestHub_SendEphemeral(t *testing.T) {
+	tt := map[string]struct {
+		// connect connects bob, given the addresses of the sending instance and another instance,
+		// and returns his connection if he should receive the message.
+		connect func(t *testing.T, c *memoryCluster, addr, otherAddr string) *gws.Conn
+	}{
+		"connected to the same instance": {
+			connect: func(t *testing.T, _ *memoryCluster, addr, _ string) *gws.Conn {
+				return dial(t, addr, "bob")
+			},
+		},
+		"connected to another instance": {
+			connect: func(t *testing.T, _ *memoryCluster, _, otherAddr string) *gws.Conn {
+				return dial(t, otherAddr, "bob")
+			},
+		},
+		"offline": {
+			connect: func(*testing.T, *memoryCluster, string, string) *gws.Conn {
+				return nil
+			},
+		},
+		"connected to a stopped instance": {
+			connect: func(t *testing.T, c *memoryCluster, _, _ string) *gws.Conn {
+				require.NoError(t, c.SetOnline(context.Background(), "dead", "bob"))
+				return nil
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			c := newMemoryCluster()
+			queue := &memoryQueue{messages: make(map[string][][]byte)}
+			hub, addr := newClusterHub(t, c, queue, "a")
+			_, otherAddr := newClusterHub(t, c, queue, "b")
+
+			bob := tc.connect(t, c, addr, otherAddr)
+			if bob != nil {
+				require.Eventually(t, func() bool {
+					isOnline, _ := hub.IsOnline(context.Background(), "bob")
+					return isOnline
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+
+			require.NoError(t, hub.SendEphemeral(context.Background(), []byte("alice is typing"), []string{"bob"}))
+			if bob != nil {
+				assert.Equal(t, "alice is typing", readMessage(t, bob))
+			}
+			// Ephemeral messages are dropped rather than queued for recipients who are not connected.
+			assert.Empty(t, queue.drain(t, "bob"))
+		})
+	}
+}
+
+func TestHub_Queue(t *testing.T) {
+	queue := &memoryQueue{messages: make(map[string][][]byte)}
+	hub := websocket.NewHub(queue, nil, nil)
+	addr := serveHub(t, hub)
+
+	// Bob is offline, so the messages are queued until he connects.
+	for _, message := range []string{"1", "2"} {
+		require.NoError(t, hub.Send(context.Background(), []byte(message), []string{"bob"}))
+	}
+	assert.Len(t, queue.messages["bob"], 2)
+
+	bob := dial(t, addr, "bob")
+	assert.Equal(t, "1", readMessage(t, bob))
+	assert.Equal(t, "2", readMessage(t, bob))
+	require.Eventually(t, func() bool {
+		isOnline, _ := hub.IsOnline(context.Background(), "bob")
+		return isOnline
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Once connected, messages are written to him directly and the queue stays empty.
+	require.NoError(t, hub.Send(context.Background(), []byte("3"), []string{"bob"}))
+	assert.Equal(t, "3", readMessage(t, bob))
+	assert.Empty(t, queue.drain(t, "bob"))
+}
+
+func TestHub_MultipleConnections(t *testing.T) {
+	queue := &memoryQueue{messages: make(map[string][][]byte)}
+	hub := websocket.NewHub(queue, nil, nil)
+	addr := serveHub(t, hub)
+	isOnline := func() bool {
+		isOnline, _ := hub.IsOnline(context.Background(), "bob")
+		return isOnline
+	}
+
+	laptop := dial(t, addr, "bob")
+	desktop := dial(t, addr, "bob")
+	require.Eventually(t, isOnline, 5*time.Second, 10*time.Millisecond)
+
+	// Every connection receives the message.
+	require.NoError(t, hub.Send(context.Background(), []byte("1"), []string{"bob"}))
+	assert.Equal(t, "1", readMessage(t, laptop))
+	assert.Equal(t, "1", readMessage(t, desktop))
+
+	// Closing one connection leaves the other connected.
+	require.NoError(t, laptop.Close())
+	assert.Never(t, func() bool { return !isOnline() }, 200*time.Millisecond, 10*time.Millisecond)
+	require.NoError(t, hub.Send(context.Background(), []byte("2"), []string{"bob"}))
+	assert.Equal(t, "2", readMessage(t, desktop))
+
+	// Once the last connection closes, messages are queued.
+	require.NoError(t, desktop.Close())
+	require.Eventually(t, func() bool { return !isOnline() }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, hub.Send(context.Background(), []byte("3"), []string{"bob"}))
+	assert.Equal(t, [][]byte{[]byte("3")}, queue.drain(t, "bob"))
+}
+
+func TestHub_PartialDelivery(t *testing.T) {
+	tt := map[string]struct {
+		recipients  []string
+		wantErr     bool
+		wantPartial bool
+		wantQueued  []string
+	}{
+		"every recipient reached": {
+			recipients: []string{"carol", "dave"},
+			wantQueued: []string{"carol", "dave"},
+		},
+		"no recipient reached": {
+			recipients: []string{"erin"},
+			wantErr:    true,
+		},
+		"some recipients reached": {
+			recipients:  []string{"carol", "erin", "dave"},
+			wantErr:     true,
+			wantPartial: true,
+			wantQueued:  []string{"carol", "dave"},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			queue := &memoryQueue{messages: make(map[string][][]byte)}
+			hub := websocket.NewHub(&failingQueue{
+				memoryQueue: queue,
+				username:    "erin",
+				err:         errors.New("queue unavailable"),
+			}, nil, nil)
+
+			err := hub.Send(context.Background(), []byte("hello"), tc.recipients)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantPartial, errors.Is(err, websocket.ErrPartialDelivery))
+
+			for _, username := range tc.wantQueued {
+				assert.Equal(t, [][]byte{[]byte("hello")}, queue.drain(t, username))
+			}
+		})
+	}
+}
+
+func TestHub_OfflineMetrics(t *testing.T) {
+	tt := map[string]struct {
+		queueErr   error
+		wantErr    bool
+		wantQueued []bool
+	}{
+		"queued": {
+			wantQueued: []bool{true},
+		},
+		"unknown recipient": {
+			queueErr:   fmt.Errorf("%w: no user named bob", websocket.ErrUnknownRecipient),
+			wantQueued: []bool{false},
+		},
+		"queue failure": {
+			queueErr:   errors.New("queue unavailable"),
+			wantErr:    true,
+			wantQueued: []bool{false},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			queue := &memoryQueue{messages: make(map[string][][]byte)}
+			metrics := &offlineMetrics{}
+			hub := websocket.NewHub(&failingQueue{memoryQueue: queue, username: "bob", err: tc.queueErr}, metrics, nil)
+
+			err := hub.Send(context.Background(), []byte("hello"), []string{"bob"})
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantQueued, metrics.queued)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package websocket
+
+// HubMetrics records events inside the Hub, eg. to expose them for monitoring.
+type HubMetrics interface {
+	// ClientConnected is called when a connection is added to the hub.
+	ClientConnected()
+	// ClientDisconnected is called when a connection is removed from the hub.
+	ClientDisconnected()
+	// MessageRelayed is called each time a message is written to a recipient's connection.
+	MessageRelayed()
+	// MessageOffline is called when a message is for a recipient with no connections.
+	// The queued argument reports whether the message was stored for later delivery or dropped.
+	MessageOffline(queued bool)
+	// WriteError is called when a message cannot be written to a connection.
+	WriteError()
+}
+
+// noopHubMetrics is used when the hub is not given any metrics.
+type noopHubMetrics struct{}
+
+func (noopHubMetrics) ClientConnected()    {}
+func (noopHubMetrics) ClientDisconnected() {}
+func (noopHubMetrics) MessageRelayed()     {}
+func (noopHubMetrics) MessageOffline(bool) {}
+func (noopHubMetrics) WriteError()         {}
@@ -2,20 +2,60 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/google/uuid"
 
 	"github.com/Broderick-Westrope/teatime/internal/entity"
 )
 
+// ErrUnknownMsgType is returned when unmarshalling a message whose type is not known.
+var ErrUnknownMsgType = errors.New("unknown MsgType")
+
 type Msg struct {
-	Type    MsgType    `json:"type"`
-	Payload MsgPayload `json:"payload"`
+	Type MsgType `json:"type"`
+	// RequestID identifies a message sent by a client. The server answers each one with either an ack or an error
+	// which has the same request ID, and a client may retry a request using the same ID without it being handled twice.
+	RequestID string     `json:"request_id,omitempty"`
+	Payload   MsgPayload `json:"payload"`
 }
 
 type MsgType int
 
 const (
 	MsgTypeSendChatMessage MsgType = iota
+	// MsgTypePresenceQuery is sent by a client to ask whether users are online,
+	// and to be told whenever they connect or disconnect from then on.
+	MsgTypePresenceQuery
+	// MsgTypePresence is sent by the server with the presence of one or more users.
+	MsgTypePresence
+	// MsgTypeTyping is sent when a user starts or stops typing in a conversation.
+	// It is only delivered to recipients who are connected at the time.
+	MsgTypeTyping
+	// MsgTypeReceipt is sent by a recipient of chat messages to tell the author they were delivered or read.
+	MsgTypeReceipt
+	// MsgTypeAck is sent by the server once it has handled a request.
+	MsgTypeAck
+	// MsgTypeError is sent by the server when it could not handle a request.
+	MsgTypeError
+	// MsgTypeEditMessage is sent by the author of a chat message to replace its content.
+	MsgTypeEditMessage
+	// MsgTypeDeleteMessage is sent by the author of a chat message to delete it for everyone in the conversation.
+	MsgTypeDeleteMessage
+	// MsgTypeReaction is sent when a user adds or removes a reaction to a chat message.
+	MsgTypeReaction
+	// MsgTypeAttachmentUpload is sent by a client to start or resume uploading an attachment.
+	// The server answers with the chunks it already has.
+	MsgTypeAttachmentUpload
+	// MsgTypeAttachmentStatus is sent by the server with the chunks of an attachment which it has received.
+	MsgTypeAttachmentStatus
+	// MsgTypeAttachmentChunk holds part of the content of an attachment. It is sent by a client to upload
+	// the chunk, and by the server in answer to a chunk query.
+	MsgTypeAttachmentChunk
+	// MsgTypeAttachmentChunkQuery is sent by a client to download a chunk of an attachment.
+	MsgTypeAttachmentChunkQuery
 )
 
 type MsgPayload interface {
@@ -30,17 +70,135 @@ type PayloadSendChatMessage struct {
 
 func (PayloadSendChatMessage) isWebSocketMsgPayload() {}
 
+// MaxPresenceQueryUsernames is the most users whose presence can be asked for in a single query.
+const MaxPresenceQueryUsernames = 100
+
+type PayloadPresenceQuery struct {
+	Usernames []string `json:"usernames"`
+}
+
+func (PayloadPresenceQuery) isWebSocketMsgPayload() {}
+
+type PayloadPresence struct {
+	Users []entity.Presence `json:"users"`
+}
+
+func (PayloadPresence) isWebSocketMsgPayload() {}
+
+// MaxTypingRecipients is the most users who can be told about typing in a single message.
+const MaxTypingRecipients = 100
+
+type PayloadTyping struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	// Username is the user who is typing. It is set by the server.
+	Username   string   `json:"username"`
+	Typing     bool     `json:"typing"`
+	Recipients []string `json:"recipients"`
+}
+
+func (PayloadTyping) isWebSocketMsgPayload() {}
+
+type PayloadReceipt struct {
+	ConversationID uuid.UUID            `json:"conversation_id"`
+	MessageIDs     []uuid.UUID          `json:"message_ids"`
+	Status         entity.MessageStatus `json:"status"`
+	// Username is the user who received the messages. It is set by the server.
+	Username string `json:"username"`
+	// Recipient is the author of the messages.
+	Recipient string `json:"recipient"`
+}
+
+func (PayloadReceipt) isWebSocketMsgPayload() {}
+
+type PayloadEditMessage struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+	Content        string    `json:"content"`
+	EditedAt       time.Time `json:"edited_at"`
+	// Username is the user who edited the message. It is set by the server.
+	Username   string   `json:"username"`
+	Recipients []string `json:"recipients"`
+}
+
+func (PayloadEditMessage) isWebSocketMsgPayload() {}
+
+type PayloadDeleteMessage struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+	// Username is the user who deleted the message. It is set by the server.
+	Username   string   `json:"username"`
+	Recipients []string `json:"recipients"`
+}
+
+func (PayloadDeleteMessage) isWebSocketMsgPayload() {}
+
+type PayloadReaction struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+	Emoji          string    `json:"emoji"`
+	// Reacted is true when the reaction is added and false when it is removed.
+	Reacted bool `json:"reacted"`
+	// Username is the user who reacted. It is set by the server.
+	Username   string   `json:"username"`
+	Recipients []string `json:"recipients"`
+}
+
+func (PayloadReaction) isWebSocketMsgPayload() {}
+
+type PayloadAttachmentUpload struct {
+	Attachment entity.Attachment `json:"attachment"`
+}
+
+func (PayloadAttachmentUpload) isWebSocketMsgPayload() {}
+
+type PayloadAttachmentStatus struct {
+	AttachmentID uuid.UUID `json:"attachment_id"`
+	// Received holds the indexes of the chunks which the server has received, in no particular order.
+	Received []int `json:"received"`
+}
+
+func (PayloadAttachmentStatus) isWebSocketMsgPayload() {}
+
+type PayloadAttachmentChunk struct {
+	AttachmentID uuid.UUID `json:"attachment_id"`
+	Index        int       `json:"index"`
+	Data         []byte    `json:"data"`
+}
+
+func (PayloadAttachmentChunk) isWebSocketMsgPayload() {}
+
+type PayloadAttachmentChunkQuery struct {
+	AttachmentID uuid.UUID `json:"attachment_id"`
+	Index        int       `json:"index"`
+}
+
+func (PayloadAttachmentChunkQuery) isWebSocketMsgPayload() {}
+
+type PayloadAck struct{}
+
+func (PayloadAck) isWebSocketMsgPayload() {}
+
+type PayloadError struct {
+	Code    entity.ErrorCode `json:"code"`
+	Message string           `json:"message"`
+}
+
+func (PayloadError) isWebSocketMsgPayload() {}
+
 func (m *Msg) UnmarshalJSON(data []byte) error {
 	var temp struct {
-		Type    MsgType         `json:"type"`
-		Payload json.RawMessage `json:"payload"`
+		Type      MsgType         `json:"type"`
+		RequestID string          `json:"request_id"`
+		Payload   json.RawMessage `json:"payload"`
 	}
 
 	if err := json.Unmarshal(data, &temp); err != nil {
 		return err
 	}
 
+	// These are set even if the payload is invalid so that the error can be reported against the request.
 	m.Type = temp.Type
+	m.RequestID = temp.RequestID
 
 	switch temp.Type {
 	case MsgTypeSendChatMessage:
@@ -49,8 +207,82 @@ func (m *Msg) UnmarshalJSON(data []byte) error {
 			return err
 		}
 		m.Payload = payload
+	case MsgTypePresenceQuery:
+		var payload PayloadPresenceQuery
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypePresence:
+		var payload PayloadPresence
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeTyping:
+		var payload PayloadTyping
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeReceipt:
+		var payload PayloadReceipt
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeEditMessage:
+		var payload PayloadEditMessage
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeDeleteMessage:
+		var payload PayloadDeleteMessage
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeReaction:
+		var payload PayloadReaction
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeAttachmentUpload:
+		var payload PayloadAttachmentUpload
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeAttachmentStatus:
+		var payload PayloadAttachmentStatus
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeAttachmentChunk:
+		var payload PayloadAttachmentChunk
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeAttachmentChunkQuery:
+		var payload PayloadAttachmentChunkQuery
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
+	case MsgTypeAck:
+		m.Payload = PayloadAck{}
+	case MsgTypeError:
+		var payload PayloadError
+		if err := json.Unmarshal(temp.Payload, &payload); err != nil {
+			return err
+		}
+		m.Payload = payload
 	default:
-		return fmt.Errorf("unknown MsgType %v", temp.Type)
+		return fmt.Errorf("%w %v", ErrUnknownMsgType, temp.Type)
 	}
 
 	return nil
@@ -0,0 +1,52 @@
+package websocket_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+func TestMsg_Typing(t *testing.T) {
+	conversationID := uuid.New()
+
+	tt := map[string]struct {
+		msg websocket.Msg
+	}{
+		"started typing": {
+			msg: websocket.Msg{
+				Type:      websocket.MsgTypeTyping,
+				RequestID: "request",
+				Payload: websocket.PayloadTyping{
+					ConversationID: conversationID,
+					Typing:         true,
+					Recipients:     []string{"bob", "carol"},
+				},
+			},
+		},
+		"stopped typing": {
+			msg: websocket.Msg{
+				Type: websocket.MsgTypeTyping,
+				Payload: websocket.PayloadTyping{
+					ConversationID: conversationID,
+					Username:       "alice",
+				},
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			data, err := json.Marshal(tc.msg)
+			require.NoError(t, err)
+
+			var got websocket.Msg
+			require.NoError(t, json.Unmarshal(data, &got))
+			assert.Equal(t, tc.msg, got)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+const (
+	// requestTimeout is how long the client waits for the server to answer a request before retrying it.
+	requestTimeout = 10 * time.Second
+	// maxRequestAttempts is how many times the client sends a request before giving up on it.
+	maxRequestAttempts = 3
+)
+
+// pendingRequest is a request which has been sent to the server but not yet answered.
+type pendingRequest struct {
+	msg      Msg
+	sentAt   time.Time
+	attempts int
+}
+
+// isRetryable reports whether the request should be sent again if the server does not handle it.
+// Requests such as typing indicators are out of date by the time they would be retried.
+func (r *pendingRequest) isRetryable() bool {
+	switch r.msg.Payload.(type) {
+	case PayloadSendChatMessage, PayloadReceipt, PayloadEditMessage, PayloadDeleteMessage, PayloadReaction,
+		PayloadAttachmentUpload, PayloadAttachmentChunk, PayloadAttachmentChunkQuery:
+		return r.attempts < maxRequestAttempts
+	default:
+		return false
+	}
+}
+
+// request sends a new request to the server and tracks it until the server answers.
+// The request is still tracked if it could not be written, so that it is retried later.
+func (c *Client) request(msgType MsgType, payload MsgPayload) error {
+	msg := Msg{
+		Type:      msgType,
+		RequestID: uuid.NewString(),
+		Payload:   payload,
+	}
+
+	c.pendingMu.Lock()
+	c.pending[msg.RequestID] = &pendingRequest{
+		msg:      msg,
+		sentAt:   time.Now(),
+		attempts: 1,
+	}
+	c.pendingMu.Unlock()
+
+	return c.write(msg)
+}
+
+// write sends the message over the current connection.
+func (c *Client) write(msg Msg) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.conn.WriteJSON(msg)
+}
+
+// Resolve stops tracking the request with the given ID since the server has handled it.
+// It returns the original request, or false if no such request is pending.
+func (c *Client) Resolve(requestID string) (Msg, bool) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	req, ok := c.pending[requestID]
+	if !ok {
+		return Msg{}, false
+	}
+	delete(c.pending, requestID)
+	return req.msg, true
+}
+
+// HandleError deals with the server failing to handle the request with the given ID. Requests which failed
+// because of a problem with the server are retried by the next call to RetryRequests, if they are retryable.
+// It returns the original request and true if the client has given up on it.
+func (c *Client) HandleError(requestID string, code entity.ErrorCode) (Msg, bool) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	req, ok := c.pending[requestID]
+	if !ok {
+		return Msg{}, false
+	}
+	if code == entity.ErrorCodeInternal && req.isRetryable() {
+		// The zero time means the request is retried straight away rather than waiting for it to time out.
+		req.sentAt = time.Time{}
+		return Msg{}, false
+	}
+	delete(c.pending, requestID)
+	return req.msg, true
+}
+
+// RetryRequests sends the requests which the server has not answered in time again, using the same request ID
+// so that the server does not handle them twice. It returns the requests which the client has given up on.
+// This should be called periodically.
+func (c *Client) RetryRequests() ([]Msg, error) {
+	c.pendingMu.Lock()
+	var retries []Msg
+	var failed []Msg
+	for requestID, req := range c.pending {
+		if time.Since(req.sentAt) < requestTimeout {
+			continue
+		}
+		if !req.isRetryable() {
+			delete(c.pending, requestID)
+			failed = append(failed, req.msg)
+			continue
+		}
+		req.sentAt = time.Now()
+		req.attempts++
+		retries = append(retries, req.msg)
+	}
+	c.pendingMu.Unlock()
+
+	var errs []error
+	for _, msg := range retries {
+		errs = append(errs, c.write(msg))
+	}
+	return failed, errors.Join(errs...)
+}
+
+// resendPending sends every pending request again over a new connection. The caller must hold mu.
+func (c *Client) resendPending() error {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	var errs []error
+	for _, req := range c.pending {
+		req.sentAt = time.Now()
+		errs = append(errs, c.conn.WriteJSON(req.msg))
+	}
+	return errors.Join(errs...)
+}
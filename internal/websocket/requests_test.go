@@ -0,0 +1,76 @@
+package websocket_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	gws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// newRequestServer starts a WebSocket server which sends every message it receives to the returned channel.
+func newRequestServer(t *testing.T) (string, <-chan websocket.Msg) {
+	t.Helper()
+
+	received := make(chan websocket.Msg, 10)
+	upgrader := gws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg websocket.Msg
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, received
+}
+
+func TestClient_Requests(t *testing.T) {
+	serverURL, received := newRequestServer(t)
+	client, err := websocket.NewClient(serverURL, "session", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	message := entity.Message{ID: uuid.New(), Content: "hello", Author: "alice"}
+	require.NoError(t, client.SendChatMessage(message, entity.ConversationMetadata{}, []string{"bob"}))
+	require.NoError(t, client.SendTyping(uuid.New(), true, []string{"bob"}))
+	chatReq := <-received
+	typingReq := <-received
+	require.NotEmpty(t, chatReq.RequestID)
+	require.NotEqual(t, chatReq.RequestID, typingReq.RequestID)
+
+	// Chat messages which fail because of the server are retried with the same request ID.
+	_, hasFailed := client.HandleError(chatReq.RequestID, entity.ErrorCodeInternal)
+	assert.False(t, hasFailed)
+	failed, err := client.RetryRequests()
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	retry := <-received
+	assert.Equal(t, chatReq.RequestID, retry.RequestID)
+
+	req, ok := client.Resolve(chatReq.RequestID)
+	require.True(t, ok)
+	payload, ok := req.Payload.(websocket.PayloadSendChatMessage)
+	require.True(t, ok)
+	assert.Equal(t, message.ID, payload.Message.ID)
+	_, ok = client.Resolve(chatReq.RequestID)
+	assert.False(t, ok)
+
+	// Other requests are not retried.
+	req, hasFailed = client.HandleError(typingReq.RequestID, entity.ErrorCodeInternal)
+	assert.True(t, hasFailed)
+	assert.Equal(t, websocket.MsgTypeTyping, req.Type)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	gws "github.com/gorilla/websocket"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+// startUpload records the attachment which the user is uploading and tells them which chunks the server
+// already has, so that an upload which was interrupted can be resumed.
+func (app *application) startUpload(
+	ctx context.Context, conn *gws.Conn, username string, payload websocket.PayloadAttachmentUpload,
+) error {
+	err := payload.Attachment.Validate()
+	if err != nil {
+		return &entity.APIError{Code: entity.ErrorCodeInvalidRequest, Message: err.Error()}
+	}
+
+	err = app.repo.CreateAttachment(ctx, username, payload.Attachment)
+	if errors.Is(err, db.ErrAlreadyExists) {
+		return &entity.APIError{Code: entity.ErrorCodeForbidden, Message: "attachment ID is already in use"}
+	} else if errors.Is(err, db.ErrLimitExceeded) {
+		return &entity.APIError{Code: entity.ErrorCodeRateLimited, Message: err.Error()}
+	} else if err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+
+	received, err := app.repo.GetReceivedChunks(ctx, payload.Attachment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get received chunks: %w", err)
+	}
+	app.reply(ctx, conn, username, websocket.Msg{
+		Type: websocket.MsgTypeAttachmentStatus,
+		Payload: websocket.PayloadAttachmentStatus{
+			AttachmentID: payload.Attachment.ID,
+			Received:     received,
+		},
+	})
+	return nil
+}
+
+// saveChunk stores a chunk of an attachment which the user is uploading.
+func (app *application) saveChunk(ctx context.Context, username string, payload websocket.PayloadAttachmentChunk) error {
+	stored, err := app.repo.GetAttachment(ctx, payload.AttachmentID)
+	if errors.Is(err, db.ErrNotFound) {
+		return &entity.APIError{Code: entity.ErrorCodeNotFound, Message: "attachment upload has not been started"}
+	} else if err != nil {
+		return fmt.Errorf("failed to get attachment: %w", err)
+	}
+	if stored.Owner != username {
+		return &entity.APIError{Code: entity.ErrorCodeForbidden, Message: "only the owner of an attachment may upload it"}
+	}
+	if stored.Complete {
+		return &entity.APIError{Code: entity.ErrorCodeForbidden, Message: "attachment has already been uploaded"}
+	}
+
+	size := stored.Attachment.ChunkSize(payload.Index)
+	if size == 0 || len(payload.Data) != size {
+		return &entity.APIError{
+			Code:    entity.ErrorCodeInvalidRequest,
+			Message: fmt.Sprintf("chunk %d of the attachment must be %d bytes", payload.Index, size),
+		}
+	}
+
+	err = app.repo.SaveAttachmentChunk(ctx, payload.AttachmentID, payload.Index, payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to save chunk: %w", err)
+	}
+	return nil
+}
+
+// sendChunk answers a query for a chunk of an attachment which the user has access to.
+func (app *application) sendChunk(
+	ctx context.Context, conn *gws.Conn, username string, payload websocket.PayloadAttachmentChunkQuery,
+) error {
+	// Attachments which the user cannot access are reported as not found so that their IDs are not revealed.
+	hasAccess, err := app.repo.HasAttachmentAccess(ctx, payload.AttachmentID, username)
+	if err != nil {
+		return fmt.Errorf("failed to check attachment access: %w", err)
+	}
+	if !hasAccess {
+		return &entity.APIError{Code: entity.ErrorCodeNotFound, Message: "attachment is unknown or has expired"}
+	}
+
+	data, err := app.repo.GetAttachmentChunk(ctx, payload.AttachmentID, payload.Index)
+	if errors.Is(err, db.ErrNotFound) {
+		return &entity.APIError{Code: entity.ErrorCodeNotFound, Message: "chunk is unknown or has expired"}
+	} else if err != nil {
+		return fmt.Errorf("failed to get chunk: %w", err)
+	}
+
+	app.reply(ctx, conn, username, websocket.Msg{
+		Type: websocket.MsgTypeAttachmentChunk,
+		Payload: websocket.PayloadAttachmentChunk{
+			AttachmentID: payload.AttachmentID,
+			Index:        payload.Index,
+			Data:         data,
+		},
+	})
+	return nil
+}
+
+// checkAttachmentUploaded returns an API error unless the user has uploaded all of the attachment
+// and its content matches its checksum. Once it has been verified the attachment is marked as complete,
+// so that its content cannot be changed after it has been shared.
+func (app *application) checkAttachmentUploaded(ctx context.Context, username string, attachment entity.Attachment) error {
+	stored, err := app.repo.GetAttachment(ctx, attachment.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		return &entity.APIError{Code: entity.ErrorCodeNotFound, Message: "attachment has not been uploaded"}
+	} else if err != nil {
+		return fmt.Errorf("failed to get attachment: %w", err)
+	}
+	if stored.Owner != username || stored.Attachment != attachment {
+		return &entity.APIError{Code: entity.ErrorCodeForbidden, Message: "attachment was uploaded by another user"}
+	}
+	if stored.Complete {
+		return nil
+	}
+
+	content, err := app.repo.GetAttachmentContent(ctx, attachment)
+	if errors.Is(err, db.ErrNotFound) {
+		return &entity.APIError{Code: entity.ErrorCodeInvalidRequest, Message: "attachment has not been fully uploaded"}
+	} else if err != nil {
+		return fmt.Errorf("failed to get attachment content: %w", err)
+	}
+	err = attachment.Verify(content)
+	if err != nil {
+		app.log.WarnContext(ctx, "uploaded attachment does not match its checksum",
+			slog.String("username", username), slog.String("attachment_id", attachment.ID.String()))
+		return &entity.APIError{Code: entity.ErrorCodeInvalidRequest, Message: err.Error()}
+	}
+
+	err = app.repo.CompleteAttachment(ctx, attachment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to complete attachment: %w", err)
+	}
+	return nil
+}
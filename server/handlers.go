@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -18,17 +17,36 @@ import (
 func (app *application) handleSignup() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		outcome := authOutcomeError
+		defer func() { app.metrics.recordAuthAttempt("signup", outcome) }()
 
 		var creds entity.Credentials
-		err := json.NewDecoder(r.Body).Decode(&creds)
+		if !app.decodeJSON(w, r, &creds) {
+			outcome = authOutcomeInvalid
+			return
+		}
+
+		err := entity.ValidateUsername(creds.Username)
 		if err != nil {
-			app.log.ErrorContext(ctx, "failed to unmarshal signup request body", slog.Any("error", err))
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			app.log.DebugContext(ctx, "invalid username for signup", slog.Any("error", err))
+			outcome = authOutcomeInvalid
+			app.writeError(ctx, w, http.StatusBadRequest, entity.ErrorCodeInvalidUsername, err.Error())
+			return
+		}
+		if creds.Password == "" {
+			outcome = authOutcomeInvalid
+			app.writeError(ctx, w, http.StatusBadRequest, entity.ErrorCodeInvalidRequest, "Password is required")
 			return
 		}
 
 		err = app.repo.CreateUser(creds.Username, creds.Password)
 		if err != nil {
+			if errors.Is(err, db.ErrAlreadyExists) {
+				app.log.DebugContext(ctx, "username is already taken", slog.Any("error", err))
+				outcome = authOutcomeFailure
+				app.writeError(ctx, w, http.StatusConflict, entity.ErrorCodeUsernameTaken, "Username is already taken")
+				return
+			}
 			app.writeInternalServerError(ctx, w, "failed to create user", err)
 			return
 		}
@@ -39,6 +57,7 @@ func (app *application) handleSignup() http.HandlerFunc {
 			return
 		}
 
+		outcome = authOutcomeSuccess
 		w.WriteHeader(http.StatusCreated)
 		_, err = w.Write([]byte("User created"))
 		if err != nil {
@@ -50,38 +69,165 @@ func (app *application) handleSignup() http.HandlerFunc {
 func (app *application) handleLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		outcome := authOutcomeError
+		defer func() { app.metrics.recordAuthAttempt("login", outcome) }()
 
 		var creds entity.Credentials
-		err := json.NewDecoder(r.Body).Decode(&creds)
+		if !app.decodeJSON(w, r, &creds) {
+			outcome = authOutcomeInvalid
+			return
+		}
+
+		// The attempt is counted as failed before the password is checked so that concurrent
+		// attempts cannot make more guesses than the lockout allows.
+		ip := app.clientIP(r)
+		attempt, err := app.repo.ReserveLoginAttempt(ctx, creds.Username, ip)
 		if err != nil {
-			app.log.ErrorContext(ctx, "failed to unmarshal login request body", slog.Any("error", err))
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			app.writeInternalServerError(ctx, w, "failed to reserve login attempt", err)
+			return
+		}
+		if attempt.Lockout > 0 {
+			app.log.DebugContext(ctx, "login attempt during lockout", slog.String("username", creds.Username),
+				slog.String("ip", ip), slog.Duration("lockout", attempt.Lockout))
+			outcome = authOutcomeLockedOut
+			app.writeTooManyRequests(ctx, w, attempt.Lockout)
 			return
 		}
 
 		isAuthenticated, err := app.repo.AuthenticateUser(creds.Username, creds.Password)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			app.writeInternalServerError(ctx, w, "failed to perform user authentication", err)
+			return
+		}
+
+		if !isAuthenticated {
+			app.log.DebugContext(ctx, "user failed authentication", slog.Any("error", err))
+			outcome = authOutcomeFailure
+			if attempt.LockoutOnFailure > 0 {
+				app.writeTooManyRequests(ctx, w, attempt.LockoutOnFailure)
+				return
+			}
+			app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeInvalidCredentials, "Failed authentication")
+			return
+		}
+
+		err = app.repo.ReleaseLoginAttempt(ctx, attempt)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to release login attempt", slog.Any("error", err))
+		}
+
+		isTOTPEnabled, err := app.repo.IsTOTPEnabled(creds.Username)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to check if totp is enabled", err)
+			return
+		}
+		if isTOTPEnabled {
+			// The session is only issued once the second factor has also been verified.
+			var token string
+			token, err = app.repo.CreateMFAChallenge(ctx, creds.Username)
+			if err != nil {
+				app.writeInternalServerError(ctx, w, "failed to create mfa challenge", err)
+				return
+			}
+			outcome = authOutcomeTOTPRequired
+			app.writeJSON(ctx, w, http.StatusAccepted, entity.LoginChallenge{MFAToken: token})
+			return
+		}
+
+		err = app.repo.ResetFailedLogins(ctx, creds.Username)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to reset failed logins", slog.Any("error", err))
+		}
+
+		err = app.addNewSessionID(r.Context(), w, creds.Username)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to set session ID", err)
+			return
+		}
+
+		outcome = authOutcomeSuccess
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte("Logged in"))
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to write response", slog.Any("error", err))
+		}
+	}
+}
+
+func (app *application) handleLoginTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		outcome := authOutcomeError
+		defer func() { app.metrics.recordAuthAttempt("login_totp", outcome) }()
+
+		var login entity.TOTPLogin
+		if !app.decodeJSON(w, r, &login) {
+			outcome = authOutcomeInvalid
+			return
+		}
+
+		username, err := app.repo.GetMFAChallengeUsername(ctx, login.MFAToken)
 		if err != nil {
 			if errors.Is(err, db.ErrNotFound) {
-				app.log.DebugContext(ctx, "user failed authentication", slog.Any("error", err))
-				http.Error(w, "Failed authentication", http.StatusUnauthorized)
+				app.log.DebugContext(ctx, "unknown mfa challenge", slog.Any("error", err))
+				outcome = authOutcomeFailure
+				app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeInvalidCredentials, "Failed authentication")
 				return
 			}
-			app.writeInternalServerError(ctx, w, "failed to perform user authentication", err)
+			app.writeInternalServerError(ctx, w, "failed to get mfa challenge", err)
 			return
 		}
 
-		if !isAuthenticated {
-			app.log.DebugContext(ctx, "user failed authentication")
-			http.Error(w, "Failed authentication", http.StatusUnauthorized)
+		ip := app.clientIP(r)
+		attempt, err := app.repo.ReserveLoginAttempt(ctx, username, ip)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to reserve login attempt", err)
+			return
+		}
+		if attempt.Lockout > 0 {
+			outcome = authOutcomeLockedOut
+			app.writeTooManyRequests(ctx, w, attempt.Lockout)
 			return
 		}
 
-		err = app.addNewSessionID(r.Context(), w, creds.Username)
+		isVerified, err := app.repo.VerifySecondFactor(ctx, username, login.Code)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to verify second factor", err)
+			return
+		}
+		if !isVerified {
+			app.log.DebugContext(ctx, "user failed second factor authentication", slog.String("username", username))
+			outcome = authOutcomeFailure
+			if attempt.LockoutOnFailure > 0 {
+				app.writeTooManyRequests(ctx, w, attempt.LockoutOnFailure)
+				return
+			}
+			app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeInvalidCredentials, "Failed authentication")
+			return
+		}
+
+		err = app.repo.ReleaseLoginAttempt(ctx, attempt)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to release login attempt", slog.Any("error", err))
+		}
+
+		err = app.repo.DeleteMFAChallenge(ctx, login.MFAToken)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to delete mfa challenge", err)
+			return
+		}
+		err = app.repo.ResetFailedLogins(ctx, username)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to reset failed logins", slog.Any("error", err))
+		}
+
+		err = app.addNewSessionID(ctx, w, username)
 		if err != nil {
 			app.writeInternalServerError(ctx, w, "failed to set session ID", err)
 			return
 		}
 
+		outcome = authOutcomeSuccess
 		w.WriteHeader(http.StatusOK)
 		_, err = w.Write([]byte("Logged in"))
 		if err != nil {
@@ -90,19 +236,80 @@ func (app *application) handleLogin() http.HandlerFunc {
 	}
 }
 
-func (app *application) handleLogout() http.HandlerFunc {
+func (app *application) handleEnrollTOTP() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		username, ok := r.Context().Value(ctxKeyUsername).(string)
+		username, ok := ctx.Value(ctxKeyUsername).(string)
 		if !ok {
 			app.writeInternalServerError(ctx, w, "failed to cast username to string", nil)
 			return
 		}
 
-		err := app.repo.DeleteUserSessions(r.Context(), username)
+		uri, err := app.repo.BeginTOTPEnrollment(username)
 		if err != nil {
-			app.writeInternalServerError(ctx, w, "failed to delete user sessions", err)
+			if errors.Is(err, db.ErrTOTPAlreadyEnabled) {
+				app.writeError(ctx, w, http.StatusConflict, entity.ErrorCodeTOTPAlreadyEnabled,
+					"Two-factor authentication is already enabled")
+				return
+			}
+			app.writeInternalServerError(ctx, w, "failed to begin totp enrollment", err)
+			return
+		}
+
+		app.writeJSON(ctx, w, http.StatusOK, entity.TOTPEnrollment{URI: uri})
+	}
+}
+
+func (app *application) handleConfirmTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		username, ok := ctx.Value(ctxKeyUsername).(string)
+		if !ok {
+			app.writeInternalServerError(ctx, w, "failed to cast username to string", nil)
+			return
+		}
+
+		var code entity.TOTPCode
+		if !app.decodeJSON(w, r, &code) {
+			return
+		}
+
+		recoveryCodes, err := app.repo.ConfirmTOTPEnrollment(username, code.Code)
+		if err != nil {
+			switch {
+			case errors.Is(err, db.ErrInvalidCode):
+				app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeInvalidCode, "Invalid code")
+			case errors.Is(err, db.ErrTOTPAlreadyEnabled):
+				app.writeError(ctx, w, http.StatusConflict, entity.ErrorCodeTOTPAlreadyEnabled,
+					"Two-factor authentication is already enabled")
+			case errors.Is(err, db.ErrTOTPNotEnrolled):
+				app.writeError(ctx, w, http.StatusBadRequest, entity.ErrorCodeTOTPNotEnrolled,
+					"Two-factor authentication enrollment has not been started")
+			default:
+				app.writeInternalServerError(ctx, w, "failed to confirm totp enrollment", err)
+			}
+			return
+		}
+
+		app.writeJSON(ctx, w, http.StatusOK, entity.TOTPRecoveryCodes{RecoveryCodes: recoveryCodes})
+	}
+}
+
+func (app *application) handleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		sessionID, ok := ctx.Value(ctxKeySessionID).(string)
+		if !ok {
+			app.writeInternalServerError(ctx, w, "failed to cast session ID to string", nil)
+			return
+		}
+
+		err := app.repo.DeleteSession(ctx, sessionID)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to delete session", err)
 			return
 		}
 
@@ -116,6 +323,149 @@ func (app *application) handleLogout() http.HandlerFunc {
 	}
 }
 
+func (app *application) handleChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		username, ok := ctx.Value(ctxKeyUsername).(string)
+		if !ok {
+			app.writeInternalServerError(ctx, w, "failed to cast username to string", nil)
+			return
+		}
+
+		var change entity.PasswordChange
+		if !app.decodeJSON(w, r, &change) {
+			return
+		}
+		if change.NewPassword == "" {
+			app.writeError(ctx, w, http.StatusBadRequest, entity.ErrorCodeInvalidRequest, "New password is required")
+			return
+		}
+
+		isAuthenticated, err := app.repo.AuthenticateUser(username, change.OldPassword)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to perform user authentication", err)
+			return
+		}
+		if !isAuthenticated {
+			app.log.DebugContext(ctx, "user failed authentication when changing password")
+			app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeInvalidCredentials, "Failed authentication")
+			return
+		}
+
+		loginID, err := app.getLoginID(ctx)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to get login", err)
+			return
+		}
+
+		err = app.repo.UpdatePassword(ctx, username, change.NewPassword)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to update password", err)
+			return
+		}
+
+		// Every session was revoked along with the old password, so the user is given a new one to continue with.
+		sessionID, err := app.repo.GetNewSessionIDForLogin(ctx, username, loginID)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to set session ID", err)
+			return
+		}
+		app.setSessionID(w, sessionID)
+
+		// Devices which were logged in with the old password are disconnected too, leaving only this one.
+		err = app.hub.CloseOthers(ctx, username, loginID)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to close connections of other devices",
+				slog.String("username", username), slog.Any("error", err))
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte("Password changed"))
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to write response", slog.Any("error", err))
+		}
+	}
+}
+
+func (app *application) handleDeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		username, ok := ctx.Value(ctxKeyUsername).(string)
+		if !ok {
+			app.writeInternalServerError(ctx, w, "failed to cast username to string", nil)
+			return
+		}
+
+		var deletion entity.AccountDeletion
+		if !app.decodeJSON(w, r, &deletion) {
+			return
+		}
+
+		isAuthenticated, err := app.repo.AuthenticateUser(username, deletion.Password)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to perform user authentication", err)
+			return
+		}
+		if !isAuthenticated {
+			app.log.DebugContext(ctx, "user failed authentication when deleting account")
+			app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeInvalidCredentials, "Failed authentication")
+			return
+		}
+
+		err = app.repo.DeleteUser(ctx, username)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to delete user", err)
+			return
+		}
+
+		err = app.hub.CloseAll(ctx, username)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to close connections for deleted user",
+				slog.String("username", username), slog.Any("error", err))
+		}
+		app.deleteSessionID(w)
+
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte("Account deleted"))
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to write response", slog.Any("error", err))
+		}
+	}
+}
+
+func (app *application) handleRefresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		sessionID, ok := ctx.Value(ctxKeySessionID).(string)
+		if !ok {
+			app.writeInternalServerError(ctx, w, "failed to cast session ID to string", nil)
+			return
+		}
+
+		newSessionID, err := app.repo.RotateSessionID(ctx, sessionID)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrSessionChanged) {
+				app.log.DebugContext(ctx, "failed to rotate session", slog.Any("error", err))
+				app.writeError(ctx, w, http.StatusUnauthorized, entity.ErrorCodeUnauthenticated, "Session is invalid or has expired")
+				return
+			}
+			app.writeInternalServerError(ctx, w, "failed to rotate session", err)
+			return
+		}
+
+		app.setSessionID(w, newSessionID)
+
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte("Session refreshed"))
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to write response", slog.Any("error", err))
+		}
+	}
+}
+
 func (app *application) handleWebSocket(workCtx context.Context, wg *sync.WaitGroup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -128,16 +478,46 @@ func (app *application) handleWebSocket(workCtx context.Context, wg *sync.WaitGr
 			return
 		}
 
+		// New connections are turned away while draining so that clients reconnect to another instance.
+		if app.draining.Load() {
+			app.writeError(ctx, w, http.StatusServiceUnavailable, entity.ErrorCodeUnavailable, "Server is shutting down")
+			return
+		}
+
+		loginID, err := app.getLoginID(ctx)
+		if err != nil {
+			app.writeInternalServerError(ctx, w, "failed to get login", err)
+			return
+		}
+
 		conn, err := app.hub.Upgrade(w, r, nil)
 		if err != nil {
 			return
 		}
 		defer conn.Close()
 
-		app.hub.Add(conn, username)
+		err = app.hub.Add(ctx, conn, username, loginID)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to deliver queued messages",
+				slog.String("username", username), slog.Any("error", err))
+		}
 		app.log.InfoContext(ctx, "new client connected", slog.String("username", username))
+		err = app.broadcastPresence(ctx, username, true)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to broadcast presence",
+				slog.String("username", username), slog.Any("error", err))
+		}
 		defer func() {
-			app.hub.Remove(username)
+			err := app.hub.Remove(ctx, username, conn)
+			if err != nil {
+				app.log.ErrorContext(ctx, "failed to remove client from hub",
+					slog.String("username", username), slog.Any("error", err))
+			}
+			err = app.broadcastPresence(ctx, username, false)
+			if err != nil {
+				app.log.ErrorContext(ctx, "failed to broadcast presence",
+					slog.String("username", username), slog.Any("error", err))
+			}
 			app.log.InfoContext(ctx, "client disconnected", slog.String("username", username))
 		}()
 
@@ -145,7 +525,14 @@ func (app *application) handleWebSocket(workCtx context.Context, wg *sync.WaitGr
 	}
 }
 
+// maxWebSocketMessageSize is the largest message which clients may send over the WebSocket, in bytes.
+// The largest expected message is an attachment chunk, which is base64 encoded in the JSON payload,
+// so this allows for one of those along with the rest of the message.
+const maxWebSocketMessageSize = (entity.AttachmentChunkSize+2)/3*4 + 16<<10
+
 func (app *application) processWebSocketMessages(ctx, workCtx context.Context, conn *gws.Conn, username string) {
+	// Messages over the limit fail the read below, which closes the connection.
+	conn.SetReadLimit(maxWebSocketMessageSize)
 	msgCh := make(chan []byte)
 	errCh := make(chan error)
 	go func() {
@@ -164,7 +551,7 @@ func (app *application) processWebSocketMessages(ctx, workCtx context.Context, c
 		select {
 		case <-workCtx.Done():
 			app.log.InfoContext(ctx, "closing connection", slog.String("username", username))
-			err := app.hub.Close(username)
+			err := app.hub.Close(conn)
 			if err != nil {
 				app.log.ErrorContext(ctx, "failed to send close message",
 					slog.String("username", username), slog.Any("error", err))
@@ -177,33 +564,13 @@ func (app *application) processWebSocketMessages(ctx, workCtx context.Context, c
 					slog.String("username", username), slog.String("value", err.Error()))
 				return
 			}
+			app.metrics.ReadError()
 			app.log.ErrorContext(ctx, "error reading message",
 				slog.String("username", username), slog.Any("error", err))
 			return
 
 		case msgData := <-msgCh:
-			var msg websocket.Msg
-			if err := json.Unmarshal(msgData, &msg); err != nil {
-				app.log.ErrorContext(ctx, "error unmarshalling JSON",
-					slog.String("username", username), slog.Any("error", err))
-				return
-			}
-
-			switch payload := msg.Payload.(type) {
-			case websocket.PayloadSendChatMessage:
-				app.log.DebugContext(ctx, "sending message",
-					slog.Any("recipients", payload.Recipients))
-				err := app.hub.Send(msgData, payload.Recipients)
-				if err != nil {
-					app.log.ErrorContext(ctx, "failed to send message",
-						slog.String("username", username), slog.Any("error", err))
-				}
-
-			default:
-				app.log.ErrorContext(ctx, "message type has no handler",
-					slog.String("username", username), slog.Any("msg", msg))
-				return
-			}
+			app.handleWebSocketRequest(ctx, conn, username, msgData)
 		}
 	}
 }
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+// newTestRouter creates an application with the user alice, whose password is "password", and returns its routes.
+func newTestRouter(t *testing.T) (*application, http.Handler) {
+	t.Helper()
+
+	app := newTestApp(t)
+	require.NoError(t, app.repo.CreateUser("alice", "password"))
+	return app, app.setupRouter(context.Background(), &sync.WaitGroup{})
+}
+
+// newJSONRequest returns a POST request with the JSON body, using the session if it is set.
+func newJSONRequest(path, body, sessionID string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if sessionID != "" {
+		r.AddCookie(&http.Cookie{Name: cookieNameSessionID, Value: sessionID})
+	}
+	return r
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+// assertResponse checks the status of the response and, if the code is set, that it is an error with the code.
+func assertResponse(t *testing.T, rec *httptest.ResponseRecorder, wantStatus int, wantCode entity.ErrorCode) {
+	t.Helper()
+
+	assert.Equal(t, wantStatus, rec.Code)
+	if wantCode == "" {
+		return
+	}
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var resp entity.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, wantCode, resp.Error.Code)
+}
+
+// assertRetryAfter checks that the response tells the client to wait for no longer than the lockout.
+func assertRetryAfter(t *testing.T, rec *httptest.ResponseRecorder, lockout time.Duration) {
+	t.Helper()
+
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Positive(t, retryAfter)
+	assert.LessOrEqual(t, retryAfter, int(lockout.Seconds()))
+}
+
+// getSessionID returns the session ID which the response sets, or an empty string if it does not set one.
+func getSessionID(rec *httptest.ResponseRecorder) string {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == cookieNameSessionID {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// enrollTOTP enables TOTP for the user, returning their secret and recovery codes.
+func enrollTOTP(t *testing.T, app *application, username string) (string, []string) {
+	t.Helper()
+
+	uri, err := app.repo.BeginTOTPEnrollment(username)
+	require.NoError(t, err)
+	key, err := otp.NewKeyFromURL(uri)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := app.repo.ConfirmTOTPEnrollment(username, code)
+	require.NoError(t, err)
+	return key.Secret(), recoveryCodes
+}
+
+func TestHandleSignup(t *testing.T) {
+	tt := map[string]struct {
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    entity.ErrorCode
+	}{
+		"created": {
+			body:       `{"username":"bob","password":"password"}`,
+			wantStatus: http.StatusCreated,
+		},
+		"username taken": {
+			body:       `{"username":"alice","password":"password"}`,
+			wantStatus: http.StatusConflict,
+			wantCode:   entity.ErrorCodeUsernameTaken,
+		},
+		"username taken in another case": {
+			body:       `{"username":"Alice","password":"password"}`,
+			wantStatus: http.StatusConflict,
+			wantCode:   entity.ErrorCodeUsernameTaken,
+		},
+		"invalid username": {
+			body:       `{"username":"b o b","password":"password"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   entity.ErrorCodeInvalidUsername,
+		},
+		"missing password": {
+			body:       `{"username":"bob"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   entity.ErrorCodeInvalidRequest,
+		},
+		"invalid JSON": {
+			body:       `{"username":`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   entity.ErrorCodeInvalidRequest,
+		},
+		"not JSON": {
+			contentType: "text/plain",
+			body:        `{"username":"bob","password":"password"}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantCode:    entity.ErrorCodeUnsupportedMediaType,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			_, router := newTestRouter(t)
+
+			r := newJSONRequest("/v1/auth/signup", tc.body, "")
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			rec := serve(router, r)
+
+			assertResponse(t, rec, tc.wantStatus, tc.wantCode)
+			assert.Equal(t, tc.wantCode == "", getSessionID(rec) != "", "session cookie")
+		})
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	tt := map[string]struct {
+		// failures is how many times logging in with the wrong password fails beforehand.
+		failures      int
+		totpEnabled   bool
+		body          string
+		wantStatus    int
+		wantCode      entity.ErrorCode
+		wantSession   bool
+		wantChallenge bool
+	}{
+		"logged in": {
+			body:        `{"username":"alice","password":"password"}`,
+			wantStatus:  http.StatusOK,
+			wantSession: true,
+		},
+		"TOTP required": {
+			totpEnabled:   true,
+			body:          `{"username":"alice","password":"password"}`,
+			wantStatus:    http.StatusAccepted,
+			wantChallenge: true,
+		},
+		"wrong password": {
+			body:       `{"username":"alice","password":"wrong"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   entity.ErrorCodeInvalidCredentials,
+		},
+		"unknown user": {
+			body:       `{"username":"bob","password":"password"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   entity.ErrorCodeInvalidCredentials,
+		},
+		"failure which starts a lockout": {
+			failures:   4,
+			body:       `{"username":"alice","password":"wrong"}`,
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   entity.ErrorCodeRateLimited,
+		},
+		"locked out": {
+			failures:   5,
+			body:       `{"username":"alice","password":"password"}`,
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   entity.ErrorCodeRateLimited,
+		},
+		"invalid JSON": {
+			body:       `{"username":`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   entity.ErrorCodeInvalidRequest,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			app, router := newTestRouter(t)
+			if tc.totpEnabled {
+				enrollTOTP(t, app, "alice")
+			}
+			for range tc.failures {
+				rec := serve(router, newJSONRequest("/v1/auth/login", `{"username":"alice","password":"wrong"}`, ""))
+				require.Contains(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests}, rec.Code)
+			}
+
+			rec := serve(router, newJSONRequest("/v1/auth/login", tc.body, ""))
+
+			assertResponse(t, rec, tc.wantStatus, tc.wantCode)
+			assert.Equal(t, tc.wantSession, getSessionID(rec) != "", "session cookie")
+			if tc.wantStatus == http.StatusTooManyRequests {
+				assertRetryAfter(t, rec, 30*time.Second)
+			}
+			if tc.wantChallenge {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				var challenge entity.LoginChallenge
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&challenge))
+				assert.NotEmpty(t, challenge.MFAToken)
+			}
+		})
+	}
+}
+
+func TestHandleLoginTOTP(t *testing.T) {
+	tt := map[string]struct {
+		// failures is how many times logging in with the wrong code fails beforehand.
+		failures int
+		// code returns the code to log in with, given the TOTP secret and recovery codes of the user.
+		code         func(t *testing.T, secret string, recoveryCodes []string) string
+		unknownToken bool
+		wantStatus   int
+		wantCode     entity.ErrorCode
+	}{
+		"TOTP code": {
+			code: func(t *testing.T, secret string, _ []string) string {
+				code, err := totp.GenerateCode(secret, time.Now())
+				require.NoError(t, err)
+				return code
+			},
+			wantStatus: http.StatusOK,
+		},
+		"recovery code": {
+			code: func(_ *testing.T, _ string, recoveryCodes []string) string {
+				return recoveryCodes[0]
+			},
+			wantStatus: http.StatusOK,
+		},
+		"wrong code": {
+			code:       func(*testing.T, string, []string) string { return "000000" },
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   entity.ErrorCodeInvalidCredentials,
+		},
+		"unknown token": {
+			code: func(_ *testing.T, _ string, recoveryCodes []string) string {
+				return recoveryCodes[0]
+			},
+			unknownToken: true,
+			wantStatus:   http.StatusUnauthorized,
+			wantCode:     entity.ErrorCodeInvalidCredentials,
+		},
+		"failure which starts a lockout": {
+			failures:   4,
+			code:       func(*testing.T, string, []string) string { return "000000" },
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   entity.ErrorCodeRateLimited,
+		},
+		"locked out": {
+			failures: 5,
+			code: func(_ *testing.T, _ string, recoveryCodes []string) string {
+				return recoveryCodes[0]
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   entity.ErrorCodeRateLimited,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			app, router := newTestRouter(t)
+			secret, recoveryCodes := enrollTOTP(t, app, "alice")
+
+			rec := serve(router, newJSONRequest("/v1/auth/login", `{"username":"alice","password":"password"}`, ""))
+			require.Equal(t, http.StatusAccepted, rec.Code)
+			var challenge entity.LoginChallenge
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&challenge))
+			if tc.unknownToken {
+				challenge.MFAToken = "unknown"
+			}
+
+			loginBody := func(code string) string {
+				body, err := json.Marshal(entity.TOTPLogin{MFAToken: challenge.MFAToken, Code: code})
+				require.NoError(t, err)
+				return string(body)
+			}
+			for range tc.failures {
+				rec = serve(router, newJSONRequest("/v1/auth/login/totp", loginBody("000000"), ""))
+				require.Contains(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests}, rec.Code)
+			}
+
+			rec = serve(router, newJSONRequest("/v1/auth/login/totp", loginBody(tc.code(t, secret, recoveryCodes)), ""))
+
+			assertResponse(t, rec, tc.wantStatus, tc.wantCode)
+			assert.Equal(t, tc.wantCode == "", getSessionID(rec) != "", "session cookie")
+			if tc.wantStatus == http.StatusTooManyRequests {
+				assertRetryAfter(t, rec, 30*time.Second)
+			}
+		})
+	}
+}
+
+func TestHandleChangePassword(t *testing.T) {
+	tt := map[string]struct {
+		signedOut  bool
+		body       string
+		wantStatus int
+		wantCode   entity.ErrorCode
+	}{
+		"changed": {
+			body:       `{"old_password":"password","new_password":"new password"}`,
+			wantStatus: http.StatusOK,
+		},
+		"signed out": {
+			signedOut:  true,
+			body:       `{"old_password":"password","new_password":"new password"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   entity.ErrorCodeUnauthenticated,
+		},
+		"wrong old password": {
+			body:       `{"old_password":"wrong","new_password":"new password"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   entity.ErrorCodeInvalidCredentials,
+		},
+		"missing new password": {
+			body:       `{"old_password":"password"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   entity.ErrorCodeInvalidRequest,
+		},
+		"invalid JSON": {
+			body:       `{"old_password":`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   entity.ErrorCodeInvalidRequest,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			app, router := newTestRouter(t)
+			sessionID, err := app.repo.GetNewSessionID(ctx, "alice")
+			require.NoError(t, err)
+			if tc.signedOut {
+				sessionID = ""
+			}
+
+			rec := serve(router, newJSONRequest("/v1/auth/password", tc.body, sessionID))
+
+			assertResponse(t, rec, tc.wantStatus, tc.wantCode)
+			isChanged, err := app.repo.AuthenticateUser("alice", "new password")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCode == "", isChanged, "password changed")
+
+			// The user is given a new session to replace the one revoked along with the old password.
+			newSessionID := getSessionID(rec)
+			if tc.wantCode != "" {
+				assert.Empty(t, newSessionID)
+				return
+			}
+			require.NotEmpty(t, newSessionID)
+			assert.NotEqual(t, sessionID, newSessionID)
+			username, err := app.repo.GetUsernameWithSessionID(ctx, newSessionID)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", username)
+		})
+	}
+}
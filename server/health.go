@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout limits how long the readiness check waits for each dependency.
+const readinessTimeout = 2 * time.Second
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusError    = "error"
+	healthStatusDraining = "draining"
+)
+
+type readinessResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]dependencyStatus `json:"checks"`
+}
+
+// dependencyStatus is the result of checking a dependency. Errors are only logged, since the
+// response is public and they may reveal details of the deployment.
+type dependencyStatus struct {
+	// Backend is the kind of store which was checked, eg. "postgres" or "redis".
+	Backend string `json:"backend"`
+	Status  string `json:"status"`
+}
+
+// handleLiveness reports that the process is running and able to serve requests.
+func (app *application) handleLiveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.writeJSON(r.Context(), w, http.StatusOK, map[string]string{"status": healthStatusOK})
+	}
+}
+
+// handleReadiness reports whether the server should receive traffic. It is not ready if
+// any of its dependencies cannot be reached, or once it has started to shut down.
+func (app *application) handleReadiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		resp := readinessResponse{
+			Status: healthStatusOK,
+			Checks: map[string]dependencyStatus{
+				"user_store":       app.checkDependency(ctx, app.userStore, app.repo.PingUserStore),
+				"session_store":    app.checkDependency(ctx, app.sessionStore, app.repo.PingSessionStore),
+				"attachment_store": app.checkDependency(ctx, attachmentStoreFile, app.repo.PingAttachmentStore),
+			},
+		}
+		for _, check := range resp.Checks {
+			if check.Status != healthStatusOK {
+				resp.Status = healthStatusError
+			}
+		}
+		if app.draining.Load() {
+			resp.Status = healthStatusDraining
+		}
+
+		status := http.StatusOK
+		if resp.Status != healthStatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		app.writeJSON(ctx, w, status, resp)
+	}
+}
+
+func (app *application) checkDependency(ctx context.Context, backend string, ping func(context.Context) error) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	err := ping(ctx)
+	if err != nil {
+		app.log.WarnContext(ctx, "readiness check failed", slog.String("backend", backend), slog.Any("error", err))
+		return dependencyStatus{Backend: backend, Status: healthStatusError}
+	}
+	return dependencyStatus{Backend: backend, Status: healthStatusOK}
+}
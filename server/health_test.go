@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+func TestHandleReadiness(t *testing.T) {
+	tt := map[string]struct {
+		// prepare puts the application into the state to check its readiness in.
+		prepare    func(t *testing.T, app *application)
+		wantStatus int
+		wantResp   readinessResponse
+	}{
+		"ready": {
+			prepare:    func(*testing.T, *application) {},
+			wantStatus: http.StatusOK,
+			wantResp: readinessResponse{
+				Status: healthStatusOK,
+				Checks: map[string]dependencyStatus{
+					"user_store":       {Backend: userStoreMemory, Status: healthStatusOK},
+					"session_store":    {Backend: sessionStoreMemory, Status: healthStatusOK},
+					"attachment_store": {Backend: attachmentStoreFile, Status: healthStatusOK},
+				},
+			},
+		},
+		"draining": {
+			prepare: func(_ *testing.T, app *application) {
+				app.draining.Store(true)
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantResp: readinessResponse{
+				Status: healthStatusDraining,
+				Checks: map[string]dependencyStatus{
+					"user_store":       {Backend: userStoreMemory, Status: healthStatusOK},
+					"session_store":    {Backend: sessionStoreMemory, Status: healthStatusOK},
+					"attachment_store": {Backend: attachmentStoreFile, Status: healthStatusOK},
+				},
+			},
+		},
+		"user store closed": {
+			prepare: func(t *testing.T, app *application) {
+				require.NoError(t, app.repo.Close())
+				users, err := db.NewSQLiteUserStore(filepath.Join(t.TempDir(), "server.db"))
+				require.NoError(t, err)
+				attachments, err := db.NewFileAttachmentStore(app.attachmentDir)
+				require.NoError(t, err)
+				app.repo = db.NewRepository(users, db.NewMemorySessionStore(), attachments, time.Hour, 10, nil)
+				app.userStore = userStoreSQLite
+				require.NoError(t, users.Close())
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantResp: readinessResponse{
+				Status: healthStatusError,
+				Checks: map[string]dependencyStatus{
+					"user_store":       {Backend: userStoreSQLite, Status: healthStatusError},
+					"session_store":    {Backend: sessionStoreMemory, Status: healthStatusOK},
+					"attachment_store": {Backend: attachmentStoreFile, Status: healthStatusOK},
+				},
+			},
+		},
+		"attachment directory removed": {
+			prepare: func(t *testing.T, app *application) {
+				require.NoError(t, os.RemoveAll(app.attachmentDir))
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantResp: readinessResponse{
+				Status: healthStatusError,
+				Checks: map[string]dependencyStatus{
+					"user_store":       {Backend: userStoreMemory, Status: healthStatusOK},
+					"session_store":    {Backend: sessionStoreMemory, Status: healthStatusOK},
+					"attachment_store": {Backend: attachmentStoreFile, Status: healthStatusError},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			app := newTestApp(t)
+			tc.prepare(t, app)
+			router := app.setupRouter(context.Background(), &sync.WaitGroup{})
+
+			rec := serve(router, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var resp readinessResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tc.wantResp, resp)
+
+			// The server stays live whether or not it is ready, so that it is not restarted while draining.
+			rec = serve(router, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+		})
+	}
+}
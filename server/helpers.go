@@ -2,9 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
 )
 
 const cookieNameSessionID = "session_id"
@@ -15,23 +25,156 @@ func (app *application) addNewSessionID(ctx context.Context, w http.ResponseWrit
 		return err
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    cookieNameSessionID,
-		Value:   sessionID,
-		Expires: time.Now().Add(24 * time.Hour),
-	})
-	return err
+	app.setSessionID(w, sessionID)
+	return nil
+}
+
+// getLoginID returns the login which the session of the request belongs to. The ID is empty for
+// sessions which were created before logins were recorded.
+func (app *application) getLoginID(ctx context.Context) (string, error) {
+	sessionID, ok := ctx.Value(ctxKeySessionID).(string)
+	if !ok {
+		return "", errors.New("failed to get session ID from request context")
+	}
+
+	loginID, err := app.repo.GetSessionLoginID(ctx, sessionID)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", nil
+	}
+	return loginID, err
+}
+
+// setSessionID sets the session cookie to expire at the same time as the session.
+func (app *application) setSessionID(w http.ResponseWriter, sessionID string) {
+	cookie := app.newSessionCookie(sessionID)
+	cookie.Expires = time.Now().Add(db.SessionExpiration)
+	http.SetCookie(w, cookie)
 }
 
 func (app *application) deleteSessionID(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name:   cookieNameSessionID,
-		Value:  "",
-		MaxAge: -1,
-	})
+	cookie := app.newSessionCookie("")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// newSessionCookie returns the session cookie with the attributes it must always be set with, so that
+// deleting the cookie replaces the one which was set.
+func (app *application) newSessionCookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     cookieNameSessionID,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		// The cookie is only sent over TLS when the server is serving TLS itself.
+		Secure:   app.tlsCertFile != "",
+		SameSite: http.SameSiteStrictMode,
+	}
 }
 
 func (app *application) writeInternalServerError(ctx context.Context, w http.ResponseWriter, msg string, err error) {
 	app.log.ErrorContext(ctx, msg, slog.Any("error", err))
-	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	app.writeError(ctx, w, http.StatusInternalServerError, entity.ErrorCodeInternal, "Internal server error")
+}
+
+// writeTooManyRequests tells the client to wait for the lockout to end before trying again.
+func (app *application) writeTooManyRequests(ctx context.Context, w http.ResponseWriter, lockout time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockout.Seconds()))))
+	app.writeError(ctx, w, http.StatusTooManyRequests, entity.ErrorCodeRateLimited, "Too many failed attempts")
+}
+
+// writeError writes the JSON error envelope with the given status code.
+func (app *application) writeError(ctx context.Context, w http.ResponseWriter, status int, code entity.ErrorCode, msg string) {
+	app.writeJSON(ctx, w, status, entity.ErrorResponse{
+		Error: entity.APIError{Code: code, Message: msg},
+	})
+}
+
+// decodeJSON decodes the request body into v. If this fails then an error response
+// is written and false is returned.
+func (app *application) decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		app.log.DebugContext(r.Context(), "failed to unmarshal request body",
+			slog.String("path", r.URL.Path), slog.Any("error", err))
+		app.writeError(r.Context(), w, http.StatusBadRequest, entity.ErrorCodeInvalidRequest, "Invalid request payload")
+		return false
+	}
+	return true
+}
+
+// clientIP returns the IP address of the client which made the request. This is the address of the connection
+// unless it comes from a trusted proxy, in which case it is the last address in the X-Forwarded-For header
+// which was not added by a trusted proxy. Without any trusted proxies, every client behind a reverse proxy
+// shares the proxy's address and so its login limits.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !app.isTrustedProxy(addr) {
+		return host
+	}
+
+	// Each proxy appends the address it received the request from, so the header is read from the end
+	// and only as far back as the addresses added by trusted proxies go.
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		if !app.isTrustedProxy(hop) {
+			return hop.Unmap().String()
+		}
+		host = hop.Unmap().String()
+	}
+	return host
+}
+
+// isTrustedProxy reports whether the address belongs to one of the TRUSTED_PROXIES.
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// writeJSON writes the value as the JSON response body with the given status code.
+func (app *application) writeJSON(ctx context.Context, w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		app.log.ErrorContext(ctx, "failed to write response", slog.Any("error", err))
+	}
 }
@@ -0,0 +1,115 @@
+// Package cluster implements the Redis backed broker and presence registry which let
+// several instances of the server share their WebSocket users.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+var (
+	_ websocket.Broker   = &RedisBroker{}
+	_ websocket.Presence = &RedisPresence{}
+)
+
+// RedisBroker relays messages between instances using Redis pub/sub, with one channel per instance.
+type RedisBroker struct {
+	redis         *redis.Client
+	channelPrefix string
+}
+
+// NewRedisBroker creates a broker which publishes through the given Redis client.
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{
+		redis:         client,
+		channelPrefix: "hub:",
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, instanceID string, delivery websocket.Delivery) (int64, error) {
+	payload, err := json.Marshal(delivery)
+	if err != nil {
+		return 0, err
+	}
+	return b.redis.Publish(ctx, b.channelPrefix+instanceID, payload).Result()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, instanceID string, deliver func(websocket.Delivery)) error {
+	sub := b.redis.Subscribe(ctx, b.channelPrefix+instanceID)
+	defer sub.Close()
+
+	// Wait for the subscription to be confirmed so that connection errors are returned to the caller.
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var delivery websocket.Delivery
+			if err = json.Unmarshal([]byte(msg.Payload), &delivery); err != nil {
+				// A malformed message should not stop delivery of the ones after it.
+				continue
+			}
+			deliver(delivery)
+		}
+	}
+}
+
+// RedisPresence records the instances connected to each user in a sorted set, scored by when the entry expires.
+type RedisPresence struct {
+	redis     *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewRedisPresence creates a presence registry whose entries expire after the ttl unless refreshed.
+func NewRedisPresence(client *redis.Client, ttl time.Duration) *RedisPresence {
+	return &RedisPresence{
+		redis:     client,
+		keyPrefix: "presence:",
+		ttl:       ttl,
+	}
+}
+
+func (p *RedisPresence) SetOnline(ctx context.Context, instanceID string, usernames ...string) error {
+	now := time.Now()
+	expiresAt := float64(now.Add(p.ttl).UnixMilli())
+
+	_, err := p.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, username := range usernames {
+			key := p.keyPrefix + username
+			pipe.ZAdd(ctx, key, redis.Z{Score: expiresAt, Member: instanceID})
+			// Drop entries left behind by instances which stopped without clearing them.
+			pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+			pipe.Expire(ctx, key, p.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (p *RedisPresence) SetOffline(ctx context.Context, instanceID, username string) error {
+	return p.redis.ZRem(ctx, p.keyPrefix+username, instanceID).Err()
+}
+
+func (p *RedisPresence) GetInstances(ctx context.Context, username string) ([]string, error) {
+	return p.redis.ZRangeByScore(ctx, p.keyPrefix+username, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
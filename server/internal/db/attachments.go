@@ -0,0 +1,278 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+)
+
+const (
+	// attachmentTTL is how long an attachment is kept after its upload starts, which limits how long
+	// recipients who are offline have to download it.
+	attachmentTTL = 7 * 24 * time.Hour
+	// maxOpenUploads is how many attachments each user may be uploading at once.
+	maxOpenUploads = 4
+	// maxStoredAttachments and maxStoredAttachmentBytes limit the number and total size of the attachments
+	// which each user may have stored at once.
+	maxStoredAttachments     = 200
+	maxStoredAttachmentBytes = 20 * entity.MaxAttachmentSize
+)
+
+// StoredAttachment is an attachment which has been, or is being, uploaded to the server.
+type StoredAttachment struct {
+	Attachment entity.Attachment `json:"attachment"`
+	// Owner is the user who uploaded the attachment.
+	Owner string `json:"owner"`
+	// Complete is set once every chunk has been uploaded and the content has been verified.
+	// The content of a complete attachment cannot be changed.
+	Complete bool `json:"complete"`
+}
+
+// CreateAttachment records that the owner is uploading the attachment. Creating the same attachment again
+// is allowed so that uploads can be resumed, but ErrAlreadyExists is returned if its ID is used by another
+// user or for a different file. ErrLimitExceeded is returned if the owner has too many uploads in progress
+// or the attachment would take them over their storage limit.
+func (r *Repository) CreateAttachment(ctx context.Context, owner string, attachment entity.Attachment) error {
+	existing, err := r.GetAttachment(ctx, attachment.ID)
+	if err == nil {
+		return checkSameAttachment(existing, owner, attachment)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	err = r.checkAttachmentLimits(ctx, owner, attachment.Size)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(StoredAttachment{Attachment: attachment, Owner: owner})
+	if err != nil {
+		return err
+	}
+	isSet, err := r.sessions.SetValueIfAbsent(ctx, r.attachmentKey(attachment.ID), string(value), attachmentTTL)
+	if err != nil {
+		return err
+	}
+	if !isSet {
+		// Another request created the attachment first.
+		existing, err = r.GetAttachment(ctx, attachment.ID)
+		if err != nil {
+			return err
+		}
+		return checkSameAttachment(existing, owner, attachment)
+	}
+
+	// The list does not reach its maximum length in practice, since expired attachments are removed
+	// from it whenever the limits are checked.
+	return r.sessions.Push(ctx, r.attachmentOwnerPrefix+owner, []byte(attachment.ID.String()),
+		maxStoredAttachments, attachmentTTL)
+}
+
+// checkSameAttachment returns ErrAlreadyExists unless the stored attachment is the one being created.
+func checkSameAttachment(existing StoredAttachment, owner string, attachment entity.Attachment) error {
+	if existing.Owner != owner || existing.Attachment != attachment {
+		return fmt.Errorf("%w: attachment ID is already in use", ErrAlreadyExists)
+	}
+	return nil
+}
+
+// checkAttachmentLimits returns ErrLimitExceeded if the owner cannot start uploading another attachment of the size.
+// Attachments which have expired are forgotten along the way.
+func (r *Repository) checkAttachmentLimits(ctx context.Context, owner string, size int64) error {
+	key := r.attachmentOwnerPrefix + owner
+	entries, err := r.sessions.GetList(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var openUploads, storedAttachments int
+	storedBytes := size
+	expired := make(map[string]bool)
+	for _, entry := range entries {
+		attachmentID, err := uuid.ParseBytes(entry)
+		if err != nil {
+			expired[string(entry)] = true
+			continue
+		}
+		stored, err := r.GetAttachment(ctx, attachmentID)
+		if errors.Is(err, ErrNotFound) {
+			expired[string(entry)] = true
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !stored.Complete {
+			openUploads++
+		}
+		storedAttachments++
+		storedBytes += stored.Attachment.Size
+	}
+
+	if len(expired) > 0 {
+		_, err = r.sessions.RemoveFromList(ctx, key, func(entry []byte) bool { return expired[string(entry)] })
+		if err != nil {
+			return err
+		}
+	}
+
+	if openUploads >= maxOpenUploads {
+		return fmt.Errorf("%w: at most %d attachments may be uploaded at once", ErrLimitExceeded, maxOpenUploads)
+	}
+	if storedAttachments >= maxStoredAttachments {
+		return fmt.Errorf("%w: at most %d attachments may be stored at once", ErrLimitExceeded, maxStoredAttachments)
+	}
+	if storedBytes > maxStoredAttachmentBytes {
+		return fmt.Errorf("%w: at most %d MiB of attachments may be stored at once",
+			ErrLimitExceeded, maxStoredAttachmentBytes>>20)
+	}
+	return nil
+}
+
+// GetAttachment returns the attachment with the given ID, or ErrNotFound.
+func (r *Repository) GetAttachment(ctx context.Context, attachmentID uuid.UUID) (StoredAttachment, error) {
+	value, err := r.sessions.GetValue(ctx, r.attachmentKey(attachmentID))
+	if err != nil {
+		return StoredAttachment{}, err
+	}
+
+	var stored StoredAttachment
+	err = json.Unmarshal([]byte(value), &stored)
+	if err != nil {
+		return StoredAttachment{}, fmt.Errorf("failed to unmarshal attachment: %w", err)
+	}
+	return stored, nil
+}
+
+// SaveAttachmentChunk stores part of the content of an attachment. The caller is responsible for
+// checking that the chunk belongs to the attachment and that the attachment is not complete.
+func (r *Repository) SaveAttachmentChunk(ctx context.Context, attachmentID uuid.UUID, index int, data []byte) error {
+	err := r.attachments.SaveChunk(ctx, attachmentID, index, data)
+	if err != nil {
+		return err
+	}
+	return r.sessions.AddToSet(ctx, r.attachmentReceivedPrefix+attachmentID.String(), attachmentTTL, strconv.Itoa(index))
+}
+
+// GetReceivedChunks returns the indexes of the chunks of an attachment which have been stored, in no particular order.
+func (r *Repository) GetReceivedChunks(ctx context.Context, attachmentID uuid.UUID) ([]int, error) {
+	members, err := r.sessions.GetSet(ctx, r.attachmentReceivedPrefix+attachmentID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(members))
+	for _, member := range members {
+		index, err := strconv.Atoi(member)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk index %q: %w", member, err)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// GetAttachmentChunk returns part of the content of an attachment, or ErrNotFound if it has not been stored.
+func (r *Repository) GetAttachmentChunk(ctx context.Context, attachmentID uuid.UUID, index int) ([]byte, error) {
+	return r.attachments.GetChunk(ctx, attachmentID, index)
+}
+
+// GetAttachmentContent joins every chunk of the attachment. ErrNotFound is returned if any chunk is missing.
+func (r *Repository) GetAttachmentContent(ctx context.Context, attachment entity.Attachment) ([]byte, error) {
+	content := make([]byte, 0, attachment.Size)
+	for index := range attachment.ChunkCount() {
+		chunk, err := r.GetAttachmentChunk(ctx, attachment.ID, index)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, chunk...)
+	}
+	return content, nil
+}
+
+// CompleteAttachment marks the attachment as complete once its content has been verified,
+// so that its chunks can no longer be replaced.
+func (r *Repository) CompleteAttachment(ctx context.Context, attachmentID uuid.UUID) error {
+	key := r.attachmentKey(attachmentID)
+	stored, err := r.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		return err
+	}
+	ttl, err := r.sessions.GetTTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrNotFound
+	}
+
+	stored.Complete = true
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	// The attachment keeps its original expiry so that completing it does not extend how long it is kept.
+	return r.sessions.SetValue(ctx, key, string(value), ttl)
+}
+
+// PruneAttachments deletes the content of attachments which have expired and returns how many were deleted.
+func (r *Repository) PruneAttachments(ctx context.Context) (int, error) {
+	attachmentIDs, err := r.attachments.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned int
+	for _, attachmentID := range attachmentIDs {
+		_, err = r.GetAttachment(ctx, attachmentID)
+		if err == nil {
+			continue
+		} else if !errors.Is(err, ErrNotFound) {
+			return pruned, err
+		}
+
+		err = r.attachments.Delete(ctx, attachmentID)
+		if err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// GrantAttachmentAccess allows the users to download the attachment.
+func (r *Repository) GrantAttachmentAccess(ctx context.Context, attachmentID uuid.UUID, usernames ...string) error {
+	return r.sessions.AddToSet(ctx, r.attachmentAccessPrefix+attachmentID.String(), attachmentTTL, usernames...)
+}
+
+// HasAttachmentAccess reports whether the user may download the attachment.
+// The owner of an attachment always may, and other users only once they have been granted access.
+func (r *Repository) HasAttachmentAccess(ctx context.Context, attachmentID uuid.UUID, username string) (bool, error) {
+	stored, err := r.GetAttachment(ctx, attachmentID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if stored.Owner == username {
+		return true, nil
+	}
+
+	usernames, err := r.sessions.GetSet(ctx, r.attachmentAccessPrefix+attachmentID.String())
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(usernames, username), nil
+}
+
+func (r *Repository) attachmentKey(attachmentID uuid.UUID) string {
+	return r.attachmentPrefix + attachmentID.String()
+}
@@ -5,5 +5,12 @@ import (
 )
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound       = errors.New("not found")
+	ErrAlreadyExists  = errors.New("already exists")
+	ErrSessionChanged = errors.New("session changed during update")
+	ErrLimitExceeded  = errors.New("limit exceeded")
+
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp enrollment has not been started")
+	ErrInvalidCode        = errors.New("invalid code")
 )
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// fileAttachmentStore keeps each attachment in its own directory, with one file per chunk.
+type fileAttachmentStore struct {
+	dir string
+}
+
+// NewFileAttachmentStore stores attachments in the directory, creating it if it does not exist.
+// Every instance of the server must use the same directory when running in cluster mode.
+func NewFileAttachmentStore(dir string) (AttachmentStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachments directory: %w", err)
+	}
+	return &fileAttachmentStore{dir: dir}, nil
+}
+
+func (s *fileAttachmentStore) SaveChunk(_ context.Context, attachmentID uuid.UUID, index int, data []byte) error {
+	dir := filepath.Join(s.dir, attachmentID.String())
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	// The chunk is written to a temporary file first so that a partially written chunk is never read.
+	tmp, err := os.CreateTemp(dir, "chunk-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.chunkPath(attachmentID, index))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *fileAttachmentStore) GetChunk(_ context.Context, attachmentID uuid.UUID, index int) ([]byte, error) {
+	data, err := os.ReadFile(s.chunkPath(attachmentID, index))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *fileAttachmentStore) Delete(_ context.Context, attachmentID uuid.UUID) error {
+	return os.RemoveAll(filepath.Join(s.dir, attachmentID.String()))
+}
+
+func (s *fileAttachmentStore) List(_ context.Context) ([]uuid.UUID, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentIDs := make([]uuid.UUID, 0, len(entries))
+	for _, entry := range entries {
+		attachmentID, err := uuid.Parse(entry.Name())
+		if err != nil || !entry.IsDir() {
+			// Anything else in the directory was not created by the store.
+			continue
+		}
+		attachmentIDs = append(attachmentIDs, attachmentID)
+	}
+	return attachmentIDs, nil
+}
+
+func (s *fileAttachmentStore) Ping(_ context.Context) error {
+	_, err := os.Stat(s.dir)
+	return err
+}
+
+func (s *fileAttachmentStore) Close() error {
+	return nil
+}
+
+func (s *fileAttachmentStore) chunkPath(attachmentID uuid.UUID, index int) string {
+	return filepath.Join(s.dir, attachmentID.String(), strconv.Itoa(index))
+}
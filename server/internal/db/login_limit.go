@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+const (
+	// loginFailureWindow is how long failed login attempts are remembered.
+	loginFailureWindow = 24 * time.Hour
+	// loginLockoutBase is the lockout applied once a threshold is reached. It doubles with each further failure.
+	loginLockoutBase = 30 * time.Second
+	// loginLockoutMax is the longest lockout that will be applied.
+	loginLockoutMax = time.Hour
+)
+
+// loginLimit describes how failed logins are counted for a single scope, eg. per username or per IP address.
+type loginLimit struct {
+	failuresPrefix string
+	lockoutPrefix  string
+	threshold      int64
+}
+
+var (
+	usernameLoginLimit = loginLimit{
+		failuresPrefix: "login_failures:user:",
+		lockoutPrefix:  "login_lockout:user:",
+		threshold:      5,
+	}
+	// The IP limit is more lenient since several users may share an address.
+	ipLoginLimit = loginLimit{
+		failuresPrefix: "login_failures:ip:",
+		lockoutPrefix:  "login_lockout:ip:",
+		threshold:      20,
+	}
+)
+
+// LoginAttempt is an attempt to log in which has been counted as failed before the credentials are checked,
+// so that concurrent attempts cannot get past the limits together.
+type LoginAttempt struct {
+	// Lockout is how long until attempts are allowed again. If it is set then the attempt was not
+	// counted and must be rejected without checking the credentials.
+	Lockout time.Duration
+	// LockoutOnFailure is how long further attempts are locked out for because of this attempt.
+	// It applies as soon as the attempt is reserved and is lifted if the attempt is released.
+	LockoutOnFailure time.Duration
+
+	username string
+	ip       string
+	// userLocked and ipLocked are set if reserving the attempt locked out the username or the IP address.
+	userLocked bool
+	ipLocked   bool
+}
+
+// GetLoginLockout returns how long until login attempts are allowed for the username from the IP address.
+// Zero is returned if there is no active lockout.
+func (r *Repository) GetLoginLockout(ctx context.Context, username, ip string) (time.Duration, error) {
+	userTTL, err := r.sessions.GetTTL(ctx, usernameLoginLimit.lockoutPrefix+username)
+	if err != nil {
+		return 0, err
+	}
+	ipTTL, err := r.sessions.GetTTL(ctx, ipLoginLimit.lockoutPrefix+ip)
+	if err != nil {
+		return 0, err
+	}
+	return max(userTTL, ipTTL), nil
+}
+
+// ReserveLoginAttempt counts an attempt to log in as the username from the IP address as failed, unless
+// attempts are already locked out. If either count reaches its threshold then further attempts are locked
+// out, with the lockout growing exponentially for each failure. The attempt must be released with
+// ReleaseLoginAttempt once the credentials have been verified.
+func (r *Repository) ReserveLoginAttempt(ctx context.Context, username, ip string) (LoginAttempt, error) {
+	attempt := LoginAttempt{username: username, ip: ip}
+	var err error
+	attempt.Lockout, err = r.GetLoginLockout(ctx, username, ip)
+	if err != nil || attempt.Lockout > 0 {
+		return attempt, err
+	}
+
+	var userLockout, ipLockout time.Duration
+	userLockout, attempt.userLocked, err = r.reserveAttempt(ctx, usernameLoginLimit, username)
+	if err != nil {
+		return attempt, err
+	}
+	if userLockout > 0 && !attempt.userLocked {
+		attempt.Lockout = userLockout
+		return attempt, nil
+	}
+	ipLockout, attempt.ipLocked, err = r.reserveAttempt(ctx, ipLoginLimit, ip)
+	if err != nil {
+		return attempt, err
+	}
+	if ipLockout > 0 && !attempt.ipLocked {
+		attempt.Lockout = ipLockout
+		return attempt, nil
+	}
+
+	attempt.LockoutOnFailure = max(userLockout, ipLockout)
+	return attempt, nil
+}
+
+// ReleaseLoginAttempt undoes the reservation of an attempt whose credentials were correct,
+// including any lockout which reserving it caused.
+func (r *Repository) ReleaseLoginAttempt(ctx context.Context, attempt LoginAttempt) error {
+	var lockoutKeys []string
+	if attempt.userLocked {
+		lockoutKeys = append(lockoutKeys, usernameLoginLimit.lockoutPrefix+attempt.username)
+	}
+	if attempt.ipLocked {
+		lockoutKeys = append(lockoutKeys, ipLoginLimit.lockoutPrefix+attempt.ip)
+	}
+	if len(lockoutKeys) > 0 {
+		err := r.sessions.Delete(ctx, lockoutKeys...)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := r.sessions.Increment(ctx, usernameLoginLimit.failuresPrefix+attempt.username, -1, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+	_, err = r.sessions.Increment(ctx, ipLoginLimit.failuresPrefix+attempt.ip, -1, loginFailureWindow)
+	return err
+}
+
+// ResetFailedLogins forgets the failed login attempts for the username once they have logged in.
+// Failures for the IP address are kept so that attempts across many usernames are still limited.
+func (r *Repository) ResetFailedLogins(ctx context.Context, username string) error {
+	return r.sessions.Delete(ctx, usernameLoginLimit.failuresPrefix+username)
+}
+
+// reserveAttempt counts an attempt against the limit. Once the threshold is reached it locks out further
+// attempts and returns the lockout, along with whether this attempt set it. If a concurrent attempt set
+// the lockout first then the remainder of that lockout is returned and this attempt must be rejected.
+func (r *Repository) reserveAttempt(ctx context.Context, limit loginLimit, id string) (time.Duration, bool, error) {
+	failures, err := r.sessions.Increment(ctx, limit.failuresPrefix+id, 1, loginFailureWindow)
+	if err != nil {
+		return 0, false, err
+	}
+
+	excess := failures - limit.threshold
+	if excess < 0 {
+		return 0, false, nil
+	}
+
+	lockout := loginLockoutMax
+	if excess < 16 { // avoid overflowing the shift
+		lockout = min(loginLockoutBase<<excess, loginLockoutMax)
+	}
+	key := limit.lockoutPrefix + id
+	isSet, err := r.sessions.SetValueIfAbsent(ctx, key, strconv.FormatInt(failures, 10), lockout)
+	if err != nil {
+		return 0, false, err
+	}
+	if !isSet {
+		lockout, err = r.sessions.GetTTL(ctx, key)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return lockout, isSet, nil
+}
@@ -0,0 +1,357 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryCleanupInterval is how often expired entries are removed from the memorySessionStore.
+const memoryCleanupInterval = time.Minute
+
+var _ SessionStore = &memorySessionStore{}
+
+// memoryEntry is a value held by the memorySessionStore along with when it expires.
+type memoryEntry struct {
+	value     string
+	list      [][]byte
+	set       map[string]struct{}
+	expiresAt time.Time
+}
+
+func (e memoryEntry) isExpired(now time.Time) bool {
+	return !now.Before(e.expiresAt)
+}
+
+// memorySessionStore keeps all state in memory. Everything is lost when the server stops,
+// which logs out every user and drops the messages queued for offline users.
+type memorySessionStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	// sessions maps each session ID to its username, with the username's sessions tracked in userSessions.
+	sessions     map[string]memoryEntry
+	userSessions map[string]map[string]struct{}
+
+	stopCleanup chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewMemorySessionStore returns a SessionStore which does not need any external services.
+func NewMemorySessionStore() SessionStore {
+	s := &memorySessionStore{
+		entries:      make(map[string]memoryEntry),
+		sessions:     make(map[string]memoryEntry),
+		userSessions: make(map[string]map[string]struct{}),
+		stopCleanup:  make(chan struct{}),
+	}
+	go s.cleanup()
+	return s
+}
+
+func (s *memorySessionStore) CreateSession(_ context.Context, sessionID, username string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addSession(sessionID, username, ttl)
+	return nil
+}
+
+func (s *memorySessionStore) GetSessionUsername(_ context.Context, sessionID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username, ok := s.getSession(sessionID)
+	if !ok {
+		return "", fmt.Errorf("%w: session does not exist", ErrNotFound)
+	}
+	return username, nil
+}
+
+func (s *memorySessionStore) RotateSession(_ context.Context, oldSessionID, newSessionID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username, ok := s.getSession(oldSessionID)
+	if !ok {
+		return fmt.Errorf("%w: session does not exist", ErrNotFound)
+	}
+	s.removeSession(oldSessionID)
+	s.addSession(newSessionID, username, ttl)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteSession(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeSession(sessionID)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteUserSessions(_ context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sessionID := range s.userSessions[username] {
+		delete(s.sessions, sessionID)
+	}
+	delete(s.userSessions, username)
+	return nil
+}
+
+func (s *memorySessionStore) SetValue(_ context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = memoryEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memorySessionStore) SetValueIfAbsent(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.getEntry(key); ok {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (s *memorySessionStore) GetValue(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.getEntry(key)
+	if !ok {
+		return "", fmt.Errorf("%w: key %q does not exist", ErrNotFound, key)
+	}
+	return entry.value, nil
+}
+
+func (s *memorySessionStore) GetTTL(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.getEntry(key)
+	if !ok {
+		return 0, nil
+	}
+	return time.Until(entry.expiresAt), nil
+}
+
+func (s *memorySessionStore) Increment(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	if entry, ok := s.getEntry(key); ok {
+		var err error
+		count, err = strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of key %q is not an integer: %w", key, err)
+		}
+	}
+	count += delta
+	s.entries[key] = memoryEntry{value: strconv.FormatInt(count, 10), expiresAt: time.Now().Add(ttl)}
+	return count, nil
+}
+
+func (s *memorySessionStore) Delete(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *memorySessionStore) Push(_ context.Context, key string, entry []byte, maxLength int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, _ := s.getEntry(key)
+	list := append(existing.list, entry)
+	if int64(len(list)) > maxLength {
+		list = list[int64(len(list))-maxLength:]
+	}
+	s.entries[key] = memoryEntry{list: list, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memorySessionStore) GetList(_ context.Context, key string) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, _ := s.getEntry(key)
+	return append([][]byte(nil), entry.list...), nil
+}
+
+func (s *memorySessionStore) TrimList(_ context.Context, key string, count int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.getEntry(key)
+	if !ok {
+		return nil
+	}
+	if count >= int64(len(entry.list)) {
+		delete(s.entries, key)
+		return nil
+	}
+	entry.list = entry.list[count:]
+	s.entries[key] = entry
+	return nil
+}
+
+func (s *memorySessionStore) RemoveFromList(_ context.Context, key string, remove func(entry []byte) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.getEntry(key)
+	if !ok {
+		return 0, nil
+	}
+	list := make([][]byte, 0, len(entry.list))
+	for _, value := range entry.list {
+		if !remove(value) {
+			list = append(list, value)
+		}
+	}
+	removed := len(entry.list) - len(list)
+	entry.list = list
+	s.entries[key] = entry
+	return removed, nil
+}
+
+func (s *memorySessionStore) AddToSet(_ context.Context, key string, ttl time.Duration, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, _ := s.getEntry(key)
+	set := existing.set
+	if set == nil {
+		set = make(map[string]struct{}, len(members))
+	}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	s.entries[key] = memoryEntry{set: set, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memorySessionStore) GetSet(_ context.Context, key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, _ := s.getEntry(key)
+	members := make([]string, 0, len(entry.set))
+	for member := range entry.set {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (s *memorySessionStore) RemoveFromSet(_ context.Context, key string, members ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.getEntry(key)
+	if !ok {
+		return nil
+	}
+	for _, member := range members {
+		delete(entry.set, member)
+	}
+	return nil
+}
+
+func (s *memorySessionStore) Ping(_ context.Context) error {
+	return nil
+}
+
+func (s *memorySessionStore) Close() error {
+	s.closeOnce.Do(func() { close(s.stopCleanup) })
+	return nil
+}
+
+// cleanup periodically removes expired entries which would otherwise only be removed when next read.
+func (s *memorySessionStore) cleanup() {
+	ticker := time.NewTicker(memoryCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCleanup:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, entry := range s.entries {
+				if entry.isExpired(now) {
+					delete(s.entries, key)
+				}
+			}
+			for sessionID, session := range s.sessions {
+				if session.isExpired(now) {
+					s.removeSession(sessionID)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// getEntry returns the entry for the key, removing it if it has expired. The caller must hold the lock.
+func (s *memorySessionStore) getEntry(key string) (memoryEntry, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if entry.isExpired(time.Now()) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+// getSession returns the username for the session, removing it if it has expired. The caller must hold the lock.
+func (s *memorySessionStore) getSession(sessionID string) (string, bool) {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return "", false
+	}
+	if session.isExpired(time.Now()) {
+		s.removeSession(sessionID)
+		return "", false
+	}
+	return session.value, true
+}
+
+// addSession stores the session for the user. The caller must hold the lock.
+func (s *memorySessionStore) addSession(sessionID, username string, ttl time.Duration) {
+	s.sessions[sessionID] = memoryEntry{value: username, expiresAt: time.Now().Add(ttl)}
+	if s.userSessions[username] == nil {
+		s.userSessions[username] = make(map[string]struct{})
+	}
+	s.userSessions[username][sessionID] = struct{}{}
+}
+
+// removeSession deletes the session if it exists. The caller must hold the lock.
+func (s *memorySessionStore) removeSession(sessionID string) {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return
+	}
+	delete(s.sessions, sessionID)
+	delete(s.userSessions[session.value], sessionID)
+	if len(s.userSessions[session.value]) == 0 {
+		delete(s.userSessions, session.value)
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var _ UserStore = &memoryUserStore{}
+
+// memoryUserStore keeps users in memory. Everything is lost when the server stops,
+// so it is only suitable for tests and short-lived deployments.
+type memoryUserStore struct {
+	mu sync.Mutex
+	// users is keyed by the lowercase username so that usernames are unique regardless of case.
+	users map[string]User
+	// recoveryCodes maps each username to the set of its recovery code hashes.
+	recoveryCodes map[string]map[string]struct{}
+}
+
+func NewMemoryUserStore() UserStore {
+	return &memoryUserStore{
+		users:         make(map[string]User),
+		recoveryCodes: make(map[string]map[string]struct{}),
+	}
+}
+
+func (s *memoryUserStore) InsertUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(user.Username)
+	if _, ok := s.users[key]; ok {
+		return fmt.Errorf("%w: user with username %q", ErrAlreadyExists, user.Username)
+	}
+	s.users[key] = *user
+	return nil
+}
+
+func (s *memoryUserStore) GetUser(username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.getUser(username)
+	if !ok {
+		return nil, fmt.Errorf("%w: no user found with username %q", ErrNotFound, username)
+	}
+	return &user, nil
+}
+
+func (s *memoryUserStore) UpdateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.getUser(user.Username); ok {
+		s.users[strings.ToLower(user.Username)] = *user
+	}
+	return nil
+}
+
+func (s *memoryUserStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.getUser(username); ok {
+		delete(s.users, strings.ToLower(username))
+		delete(s.recoveryCodes, username)
+	}
+	return nil
+}
+
+func (s *memoryUserStore) EnableTOTP(user *User, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.getUser(user.Username); !ok {
+		return nil
+	}
+	s.users[strings.ToLower(user.Username)] = *user
+
+	codes := make(map[string]struct{}, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		codes[codeHash] = struct{}{}
+	}
+	s.recoveryCodes[user.Username] = codes
+	return nil
+}
+
+func (s *memoryUserStore) UseRecoveryCode(username, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := s.recoveryCodes[username]
+	if _, ok := codes[codeHash]; !ok {
+		return false, nil
+	}
+	delete(codes, codeHash)
+	return true, nil
+}
+
+func (s *memoryUserStore) Ping(_ context.Context) error {
+	return nil
+}
+
+func (s *memoryUserStore) Close() error {
+	return nil
+}
+
+// getUser matches the username exactly, like the SQL stores do. The caller must hold the lock.
+func (s *memoryUserStore) getUser(username string) (User, bool) {
+	user, ok := s.users[strings.ToLower(username)]
+	if !ok || user.Username != username {
+		return User{}, false
+	}
+	return user, true
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// messageAuthorTTL is how long the author of a chat message is remembered, which limits how long it can be edited.
+const messageAuthorTTL = 30 * 24 * time.Hour
+
+// RecordMessageAuthor records who sent the chat message so that only they can change it later.
+// ErrAlreadyExists is returned if the message ID has already been used by someone else.
+func (r *Repository) RecordMessageAuthor(ctx context.Context, conversationID, messageID, author string) error {
+	key := r.messageAuthorKey(conversationID, messageID)
+	isSet, err := r.sessions.SetValueIfAbsent(ctx, key, author, messageAuthorTTL)
+	if err != nil {
+		return err
+	}
+	if isSet {
+		return nil
+	}
+
+	existing, err := r.sessions.GetValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	if existing != author {
+		return fmt.Errorf("%w: message was sent by another user", ErrAlreadyExists)
+	}
+	return nil
+}
+
+// GetMessageAuthor returns who sent the chat message. ErrNotFound is returned if the
+// message is unknown, either because it was never relayed or because it has been forgotten.
+func (r *Repository) GetMessageAuthor(ctx context.Context, conversationID, messageID string) (string, error) {
+	return r.sessions.GetValue(ctx, r.messageAuthorKey(conversationID, messageID))
+}
+
+func (r *Repository) messageAuthorKey(conversationID, messageID string) string {
+	return r.messageAuthorPrefix + conversationID + ":" + messageID
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Metrics records how long the repository spends hashing passwords and calling its stores.
+type Metrics interface {
+	// ObservePasswordHash records the duration of an Argon2 operation. The op is "hash" or "verify".
+	ObservePasswordHash(op string, duration time.Duration)
+	// ObserveStoreCall records the duration of a call to a store. The store is "user", "session" or
+	// "attachment" and the op is the name of the method which was called.
+	ObserveStoreCall(store, op string, duration time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObservePasswordHash(string, time.Duration)      {}
+func (noopMetrics) ObserveStoreCall(string, string, time.Duration) {}
+
+const (
+	storeLabelUser       = "user"
+	storeLabelSession    = "session"
+	storeLabelAttachment = "attachment"
+)
+
+// instrumentedUserStore times every call to the underlying UserStore.
+type instrumentedUserStore struct {
+	next    UserStore
+	metrics Metrics
+}
+
+func (s *instrumentedUserStore) observe(op string) func() {
+	start := time.Now()
+	return func() { s.metrics.ObserveStoreCall(storeLabelUser, op, time.Since(start)) }
+}
+
+func (s *instrumentedUserStore) InsertUser(user *User) error {
+	defer s.observe("InsertUser")()
+	return s.next.InsertUser(user)
+}
+
+func (s *instrumentedUserStore) GetUser(username string) (*User, error) {
+	defer s.observe("GetUser")()
+	return s.next.GetUser(username)
+}
+
+func (s *instrumentedUserStore) UpdateUser(user *User) error {
+	defer s.observe("UpdateUser")()
+	return s.next.UpdateUser(user)
+}
+
+func (s *instrumentedUserStore) DeleteUser(username string) error {
+	defer s.observe("DeleteUser")()
+	return s.next.DeleteUser(username)
+}
+
+func (s *instrumentedUserStore) EnableTOTP(user *User, recoveryCodeHashes []string) error {
+	defer s.observe("EnableTOTP")()
+	return s.next.EnableTOTP(user, recoveryCodeHashes)
+}
+
+func (s *instrumentedUserStore) UseRecoveryCode(username, codeHash string) (bool, error) {
+	defer s.observe("UseRecoveryCode")()
+	return s.next.UseRecoveryCode(username, codeHash)
+}
+
+func (s *instrumentedUserStore) Ping(ctx context.Context) error {
+	defer s.observe("Ping")()
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedUserStore) Close() error {
+	return s.next.Close()
+}
+
+// instrumentedSessionStore times every call to the underlying SessionStore.
+type instrumentedSessionStore struct {
+	next    SessionStore
+	metrics Metrics
+}
+
+func (s *instrumentedSessionStore) observe(op string) func() {
+	start := time.Now()
+	return func() { s.metrics.ObserveStoreCall(storeLabelSession, op, time.Since(start)) }
+}
+
+func (s *instrumentedSessionStore) CreateSession(ctx context.Context, sessionID, username string, ttl time.Duration) error {
+	defer s.observe("CreateSession")()
+	return s.next.CreateSession(ctx, sessionID, username, ttl)
+}
+
+func (s *instrumentedSessionStore) GetSessionUsername(ctx context.Context, sessionID string) (string, error) {
+	defer s.observe("GetSessionUsername")()
+	return s.next.GetSessionUsername(ctx, sessionID)
+}
+
+func (s *instrumentedSessionStore) RotateSession(
+	ctx context.Context, oldSessionID, newSessionID string, ttl time.Duration,
+) error {
+	defer s.observe("RotateSession")()
+	return s.next.RotateSession(ctx, oldSessionID, newSessionID, ttl)
+}
+
+func (s *instrumentedSessionStore) DeleteSession(ctx context.Context, sessionID string) error {
+	defer s.observe("DeleteSession")()
+	return s.next.DeleteSession(ctx, sessionID)
+}
+
+func (s *instrumentedSessionStore) DeleteUserSessions(ctx context.Context, username string) error {
+	defer s.observe("DeleteUserSessions")()
+	return s.next.DeleteUserSessions(ctx, username)
+}
+
+func (s *instrumentedSessionStore) SetValue(ctx context.Context, key, value string, ttl time.Duration) error {
+	defer s.observe("SetValue")()
+	return s.next.SetValue(ctx, key, value, ttl)
+}
+
+func (s *instrumentedSessionStore) SetValueIfAbsent(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	defer s.observe("SetValueIfAbsent")()
+	return s.next.SetValueIfAbsent(ctx, key, value, ttl)
+}
+
+func (s *instrumentedSessionStore) GetValue(ctx context.Context, key string) (string, error) {
+	defer s.observe("GetValue")()
+	return s.next.GetValue(ctx, key)
+}
+
+func (s *instrumentedSessionStore) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	defer s.observe("GetTTL")()
+	return s.next.GetTTL(ctx, key)
+}
+
+func (s *instrumentedSessionStore) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	defer s.observe("Increment")()
+	return s.next.Increment(ctx, key, delta, ttl)
+}
+
+func (s *instrumentedSessionStore) Delete(ctx context.Context, keys ...string) error {
+	defer s.observe("Delete")()
+	return s.next.Delete(ctx, keys...)
+}
+
+func (s *instrumentedSessionStore) Push(ctx context.Context, key string, entry []byte, maxLength int64, ttl time.Duration) error {
+	defer s.observe("Push")()
+	return s.next.Push(ctx, key, entry, maxLength, ttl)
+}
+
+func (s *instrumentedSessionStore) GetList(ctx context.Context, key string) ([][]byte, error) {
+	defer s.observe("GetList")()
+	return s.next.GetList(ctx, key)
+}
+
+func (s *instrumentedSessionStore) TrimList(ctx context.Context, key string, count int64) error {
+	defer s.observe("TrimList")()
+	return s.next.TrimList(ctx, key, count)
+}
+
+func (s *instrumentedSessionStore) RemoveFromList(
+	ctx context.Context, key string, remove func(entry []byte) bool,
+) (int, error) {
+	defer s.observe("RemoveFromList")()
+	return s.next.RemoveFromList(ctx, key, remove)
+}
+
+func (s *instrumentedSessionStore) AddToSet(ctx context.Context, key string, ttl time.Duration, members ...string) error {
+	defer s.observe("AddToSet")()
+	return s.next.AddToSet(ctx, key, ttl, members...)
+}
+
+func (s *instrumentedSessionStore) GetSet(ctx context.Context, key string) ([]string, error) {
+	defer s.observe("GetSet")()
+	return s.next.GetSet(ctx, key)
+}
+
+func (s *instrumentedSessionStore) RemoveFromSet(ctx context.Context, key string, members ...string) error {
+	defer s.observe("RemoveFromSet")()
+	return s.next.RemoveFromSet(ctx, key, members...)
+}
+
+func (s *instrumentedSessionStore) Ping(ctx context.Context) error {
+	defer s.observe("Ping")()
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedSessionStore) Close() error {
+	return s.next.Close()
+}
+
+// instrumentedAttachmentStore times every call to the underlying AttachmentStore.
+type instrumentedAttachmentStore struct {
+	next    AttachmentStore
+	metrics Metrics
+}
+
+func (s *instrumentedAttachmentStore) observe(op string) func() {
+	start := time.Now()
+	return func() { s.metrics.ObserveStoreCall(storeLabelAttachment, op, time.Since(start)) }
+}
+
+func (s *instrumentedAttachmentStore) SaveChunk(ctx context.Context, attachmentID uuid.UUID, index int, data []byte) error {
+	defer s.observe("SaveChunk")()
+	return s.next.SaveChunk(ctx, attachmentID, index, data)
+}
+
+func (s *instrumentedAttachmentStore) GetChunk(ctx context.Context, attachmentID uuid.UUID, index int) ([]byte, error) {
+	defer s.observe("GetChunk")()
+	return s.next.GetChunk(ctx, attachmentID, index)
+}
+
+func (s *instrumentedAttachmentStore) Delete(ctx context.Context, attachmentID uuid.UUID) error {
+	defer s.observe("Delete")()
+	return s.next.Delete(ctx, attachmentID)
+}
+
+func (s *instrumentedAttachmentStore) List(ctx context.Context) ([]uuid.UUID, error) {
+	defer s.observe("List")()
+	return s.next.List(ctx)
+}
+
+func (s *instrumentedAttachmentStore) Ping(ctx context.Context) error {
+	defer s.observe("Ping")()
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedAttachmentStore) Close() error {
+	return s.next.Close()
+}
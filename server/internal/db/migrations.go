@@ -0,0 +1,8 @@
+package db
+
+import "embed"
+
+// migrationsFS holds the schema migrations for each database, in the postgres and sqlite directories.
+//
+//go:embed migrations
+var migrationsFS embed.FS
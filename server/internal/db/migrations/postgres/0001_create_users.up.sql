@@ -0,0 +1,11 @@
+-- Databases created before migrations were introduced already have these objects,
+-- so this migration must be safe to apply on top of them.
+CREATE TABLE IF NOT EXISTS users (
+	username TEXT PRIMARY KEY,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW(),
+	updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Usernames must be unique regardless of case.
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx ON users (LOWER(username));
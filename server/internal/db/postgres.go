@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/Broderick-Westrope/teatime/internal/migrate"
+)
+
+// pqUniqueViolation is the Postgres error code for a unique constraint violation.
+const pqUniqueViolation = "23505"
+
+// NewPostgresUserStore connects to the Postgres database and applies any pending migrations.
+func NewPostgresUserStore(dbConn string) (UserStore, error) {
+	db, err := sql.Open("postgres", dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrate.LoadAndUp(db, migrationsFS, "migrations/postgres", migrate.PostgresLock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return &sqlUserStore{
+		db: db,
+		isUniqueViolation: func(err error) bool {
+			var pqErr *pq.Error
+			return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation
+		},
+	}, nil
+}
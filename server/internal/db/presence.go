@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// presenceTTL is how long the server remembers who watches a user's presence, who they have messaged
+// and when they were last seen.
+const presenceTTL = 30 * 24 * time.Hour
+
+// RecordContacts records that the sender has messaged each of the recipients.
+func (r *Repository) RecordContacts(ctx context.Context, sender string, recipients []string) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	err := r.sessions.AddToSet(ctx, r.contactsPrefix+sender, presenceTTL, recipients...)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, recipient := range recipients {
+		err = r.sessions.AddToSet(ctx, r.contactedByPrefix+recipient, presenceTTL, sender)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetMutualContacts returns those of the users who the user has messaged and who have messaged them back.
+func (r *Repository) GetMutualContacts(ctx context.Context, username string, usernames []string) ([]string, error) {
+	messaged, err := r.sessions.GetSet(ctx, r.contactsPrefix+username)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutual []string
+	for _, other := range usernames {
+		if other == username || !slices.Contains(messaged, other) || slices.Contains(mutual, other) {
+			continue
+		}
+		theirs, err := r.sessions.GetSet(ctx, r.contactsPrefix+other)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(theirs, username) {
+			mutual = append(mutual, other)
+		}
+	}
+	return mutual, nil
+}
+
+// WatchPresence records that the watcher wants to be told when any of the users connect or disconnect.
+// The caller is responsible for checking that the watcher may see the presence of the users.
+func (r *Repository) WatchPresence(ctx context.Context, watcher string, usernames []string) error {
+	var errs []error
+	for _, username := range usernames {
+		if username == watcher {
+			continue
+		}
+		err := r.sessions.AddToSet(ctx, r.watchersPrefix+username, presenceTTL, watcher)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := r.sessions.AddToSet(ctx, r.watchingPrefix+watcher, presenceTTL, usernames...)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// forgetPresence deletes the presence of the user, and removes them from the contacts and presence watchers
+// of other users so that a later user with the same name does not inherit them.
+func (r *Repository) forgetPresence(ctx context.Context, username string) error {
+	contactedBy, err := r.sessions.GetSet(ctx, r.contactedByPrefix+username)
+	if err != nil {
+		return err
+	}
+	for _, other := range contactedBy {
+		err = r.sessions.RemoveFromSet(ctx, r.contactsPrefix+other, username)
+		if err != nil {
+			return err
+		}
+	}
+
+	watching, err := r.sessions.GetSet(ctx, r.watchingPrefix+username)
+	if err != nil {
+		return err
+	}
+	for _, other := range watching {
+		err = r.sessions.RemoveFromSet(ctx, r.watchersPrefix+other, username)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.sessions.Delete(ctx,
+		r.watchersPrefix+username, r.watchingPrefix+username,
+		r.contactsPrefix+username, r.contactedByPrefix+username,
+		r.lastSeenPrefix+username)
+}
+
+// GetPresenceWatchers returns the users who want to be told when the user connects or disconnects.
+func (r *Repository) GetPresenceWatchers(ctx context.Context, username string) ([]string, error) {
+	return r.sessions.GetSet(ctx, r.watchersPrefix+username)
+}
+
+// SetLastSeen records when the user was last connected.
+func (r *Repository) SetLastSeen(ctx context.Context, username string, lastSeen time.Time) error {
+	return r.sessions.SetValue(ctx, r.lastSeenPrefix+username, lastSeen.UTC().Format(time.RFC3339), presenceTTL)
+}
+
+// GetLastSeen returns when the user was last connected. The zero time is returned if this is not known.
+func (r *Repository) GetLastSeen(ctx context.Context, username string) (time.Time, error) {
+	value, err := r.sessions.GetValue(ctx, r.lastSeenPrefix+username)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// queuedMessage is a message waiting in the queue of an offline recipient.
+type queuedMessage struct {
+	QueuedAt time.Time       `json:"queued_at"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// EnqueueMessage stores the message until the user connects.
+// Only the newest messages are kept once the queue reaches its maximum length. Messages for users who do not
+// exist are rejected with an error wrapping both ErrNotFound and websocket.ErrUnknownRecipient, so that a queue
+// cannot be created for an arbitrary name.
+func (r *Repository) EnqueueMessage(ctx context.Context, username string, message []byte) error {
+	_, err := r.users.GetUser(username)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: %w", websocket.ErrUnknownRecipient, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	entry, err := json.Marshal(queuedMessage{
+		QueuedAt: time.Now(),
+		Data:     message,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued message: %w", err)
+	}
+
+	return r.sessions.Push(ctx, r.queuePrefix+username, entry, r.queueMaxLength, r.queueTTL)
+}
+
+// DequeueMessages passes the queued messages for the user to deliver, ordered from oldest to newest, and then
+// removes those which were delivered. It stops at the first message which deliver fails on, leaving it and every
+// newer message queued. Messages which have been queued for longer than the retention TTL are discarded, as are
+// entries which cannot be decoded, which are reported in the returned error once the rest have been delivered.
+func (r *Repository) DequeueMessages(
+	ctx context.Context, username string, deliver func(message []byte) error,
+) error {
+	key := r.queuePrefix + username
+	entries, err := r.sessions.GetList(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	cutoff := time.Now().Add(-r.queueTTL)
+	var handled int64
+	for _, entry := range entries {
+		var qm queuedMessage
+		if err = json.Unmarshal(entry, &qm); err != nil {
+			// The entry can never be delivered, so it is dropped rather than holding up the rest of the queue.
+			errs = append(errs, fmt.Errorf("failed to unmarshal queued message: %w", err))
+		} else if !qm.QueuedAt.Before(cutoff) {
+			if err = deliver(qm.Data); err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
+		handled++
+	}
+
+	if handled > 0 {
+		err = r.sessions.TrimList(ctx, key, handled)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove delivered messages: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveQueuedMessages removes the messages queued for the user for which remove returns true,
+// and returns how many were removed.
+func (r *Repository) RemoveQueuedMessages(
+	ctx context.Context, username string, remove func(message []byte) bool,
+) (int, error) {
+	return r.sessions.RemoveFromList(ctx, r.queuePrefix+username, func(entry []byte) bool {
+		var qm queuedMessage
+		if err := json.Unmarshal(entry, &qm); err != nil {
+			return false
+		}
+		return remove(qm.Data)
+	})
+}
+
+// PurgeMessages removes all messages queued for the user.
+func (r *Repository) PurgeMessages(ctx context.Context, username string) error {
+	return r.sessions.Delete(ctx, r.queuePrefix+username)
+}
@@ -0,0 +1,13 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// hashRecoveryCode returns the value stored for a recovery code.
+// Recovery codes are random with high entropy, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
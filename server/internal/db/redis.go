@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var _ SessionStore = &redisSessionStore{}
+
+type redisSessionStore struct {
+	redis              *redis.Client
+	redisUserPrefix    string
+	redisSessionPrefix string
+}
+
+// NewRedisSessionStore connects to the Redis instance at the given address.
+func NewRedisSessionStore(redisAddr string) SessionStore {
+	return &redisSessionStore{
+		redis: redis.NewClient(&redis.Options{
+			Addr: redisAddr,
+		}),
+		redisUserPrefix:    "user:",
+		redisSessionPrefix: "session:",
+	}
+}
+
+func (s *redisSessionStore) CreateSession(ctx context.Context, sessionID, username string, ttl time.Duration) error {
+	userKey := s.redisUserPrefix + username
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.redisSessionPrefix+sessionID, username, ttl)
+		pipe.SAdd(ctx, userKey, sessionID)
+		// The set of sessions lives as long as the newest session.
+		pipe.Expire(ctx, userKey, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *redisSessionStore) GetSessionUsername(ctx context.Context, sessionID string) (string, error) {
+	username, err := s.redis.Get(ctx, s.redisSessionPrefix+sessionID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", fmt.Errorf("%w: session does not exist: %w", ErrNotFound, err)
+		}
+		return "", err
+	}
+
+	isMember, err := s.redis.SIsMember(ctx, s.redisUserPrefix+username, sessionID).Result()
+	switch {
+	case err != nil:
+		return "", err
+	case !isMember:
+		return "", fmt.Errorf("%w: session does not belong to the stored user sessions", ErrNotFound)
+	}
+	return username, nil
+}
+
+func (s *redisSessionStore) RotateSession(ctx context.Context, oldSessionID, newSessionID string, ttl time.Duration) error {
+	oldSessionKey := s.redisSessionPrefix + oldSessionID
+	err := s.redis.Watch(ctx, func(tx *redis.Tx) error {
+		username, txErr := tx.Get(ctx, oldSessionKey).Result()
+		if txErr != nil {
+			return txErr
+		}
+
+		userKey := s.redisUserPrefix + username
+		_, txErr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, oldSessionKey)
+			pipe.SRem(ctx, userKey, oldSessionID)
+			pipe.Set(ctx, s.redisSessionPrefix+newSessionID, username, ttl)
+			pipe.SAdd(ctx, userKey, newSessionID)
+			pipe.Expire(ctx, userKey, ttl)
+			return nil
+		})
+		return txErr
+	}, oldSessionKey)
+
+	switch {
+	case errors.Is(err, redis.TxFailedErr):
+		return ErrSessionChanged
+	case errors.Is(err, redis.Nil):
+		return fmt.Errorf("%w: session does not exist: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+func (s *redisSessionStore) DeleteSession(ctx context.Context, sessionID string) error {
+	username, err := s.redis.Get(ctx, s.redisSessionPrefix+sessionID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.redisSessionPrefix+sessionID)
+		pipe.SRem(ctx, s.redisUserPrefix+username, sessionID)
+		return nil
+	})
+	return err
+}
+
+func (s *redisSessionStore) DeleteUserSessions(ctx context.Context, username string) error {
+	userKey := s.redisUserPrefix + username
+	sessionIDs, err := s.redis.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, sessionID := range sessionIDs {
+			pipe.Del(ctx, s.redisSessionPrefix+sessionID)
+		}
+		pipe.Del(ctx, userKey)
+		return nil
+	})
+	return err
+}
+
+func (s *redisSessionStore) SetValue(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.redis.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *redisSessionStore) SetValueIfAbsent(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.redis.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (s *redisSessionStore) GetValue(ctx context.Context, key string) (string, error) {
+	value, err := s.redis.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("%w: key %q does not exist: %w", ErrNotFound, key, err)
+	}
+	return value, err
+}
+
+func (s *redisSessionStore) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.redis.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL returns a negative duration when the key does not exist.
+	return max(ttl, 0), nil
+}
+
+func (s *redisSessionStore) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	var count *redis.IntCmd
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.IncrBy(ctx, key, delta)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (s *redisSessionStore) Delete(ctx context.Context, keys ...string) error {
+	return s.redis.Del(ctx, keys...).Err()
+}
+
+func (s *redisSessionStore) Push(ctx context.Context, key string, entry []byte, maxLength int64, ttl time.Duration) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, entry)
+		pipe.LTrim(ctx, key, -maxLength, -1)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *redisSessionStore) GetList(ctx context.Context, key string) ([][]byte, error) {
+	entries, err := s.redis.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, len(entries))
+	for i, entry := range entries {
+		result[i] = []byte(entry)
+	}
+	return result, nil
+}
+
+func (s *redisSessionStore) TrimList(ctx context.Context, key string, count int64) error {
+	// Redis deletes the list once every entry has been trimmed from it.
+	return s.redis.LTrim(ctx, key, count, -1).Err()
+}
+
+func (s *redisSessionStore) RemoveFromList(
+	ctx context.Context, key string, remove func(entry []byte) bool,
+) (int, error) {
+	entries, err := s.redis.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Entries are removed by value so that the list can safely change in the meantime.
+	var removed int
+	for _, entry := range entries {
+		if !remove([]byte(entry)) {
+			continue
+		}
+		count, err := s.redis.LRem(ctx, key, 1, entry).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += int(count)
+	}
+	return removed, nil
+}
+
+func (s *redisSessionStore) AddToSet(ctx context.Context, key string, ttl time.Duration, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		values := make([]any, len(members))
+		for i, member := range members {
+			values[i] = member
+		}
+		pipe.SAdd(ctx, key, values...)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *redisSessionStore) GetSet(ctx context.Context, key string) ([]string, error) {
+	return s.redis.SMembers(ctx, key).Result()
+}
+
+func (s *redisSessionStore) RemoveFromSet(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	values := make([]any, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return s.redis.SRem(ctx, key, values...).Err()
+}
+
+func (s *redisSessionStore) Ping(ctx context.Context) error {
+	return s.redis.Ping(ctx).Err()
+}
+
+func (s *redisSessionStore) Close() error {
+	return s.redis.Close()
+}
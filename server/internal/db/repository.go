@@ -2,37 +2,74 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/alexedwards/argon2id"
-	"github.com/redis/go-redis/v9"
+	"github.com/google/uuid"
 
 	"github.com/Broderick-Westrope/teatime/internal/secure"
-
-	// Postgres database driver.
-	_ "github.com/lib/pq"
 )
 
+// SessionExpiration is how long a session remains valid after it is created or refreshed.
+const SessionExpiration = 3 * time.Hour
+
 type Repository struct {
-	db          *sql.DB
+	users       UserStore
+	sessions    SessionStore
+	attachments AttachmentStore
 	argonParams *argon2id.Params
+	metrics     Metrics
+
+	mfaPrefix   string
+	totpPrefix  string
+	queuePrefix string
+	// loginPrefix is used for the login each session belongs to, which stays the same as the session is rotated.
+	loginPrefix string
+	// watchersPrefix, contactsPrefix and lastSeenPrefix are used for the presence of each user.
+	// watchingPrefix and contactedByPrefix index the other users' sets which each user is a member of.
+	watchersPrefix    string
+	watchingPrefix    string
+	contactsPrefix    string
+	contactedByPrefix string
+	lastSeenPrefix    string
+	// requestPrefix is used to recognise WebSocket requests which are retried after being handled.
+	requestPrefix string
+	// messageAuthorPrefix is used for the author of each relayed chat message.
+	messageAuthorPrefix string
+	// attachmentPrefix, attachmentReceivedPrefix and attachmentAccessPrefix are used for each uploaded
+	// attachment, the indexes of its chunks received so far and who may download it.
+	attachmentPrefix         string
+	attachmentReceivedPrefix string
+	attachmentAccessPrefix   string
+	// attachmentOwnerPrefix is used for the attachments each user has uploaded, which count towards their limits.
+	attachmentOwnerPrefix string
 
-	redis              *redis.Client
-	redisUserPrefix    string
-	redisSessionPrefix string
+	queueTTL       time.Duration
+	queueMaxLength int64
 }
 
-func NewRepository(dbConn, redisAddr string) (*Repository, error) {
-	db, err := initDB(dbConn)
-	if err != nil {
-		return nil, err
+// NewRepository creates a repository backed by the given stores.
+// The queueTTL and queueMaxLength limit how long and how many messages are kept for each offline user.
+// The metrics are optional and may be nil. When given, every store call and password hash is timed.
+func NewRepository(
+	users UserStore, sessions SessionStore, attachments AttachmentStore,
+	queueTTL time.Duration, queueMaxLength int64, metrics Metrics,
+) *Repository {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	} else {
+		users = &instrumentedUserStore{next: users, metrics: metrics}
+		sessions = &instrumentedSessionStore{next: sessions, metrics: metrics}
+		attachments = &instrumentedAttachmentStore{next: attachments, metrics: metrics}
 	}
 
 	return &Repository{
-		db: db,
+		users:       users,
+		sessions:    sessions,
+		attachments: attachments,
+		metrics:     metrics,
 		argonParams: &argon2id.Params{
 			Memory:      64 * 1024,
 			Iterations:  3,
@@ -40,43 +77,56 @@ func NewRepository(dbConn, redisAddr string) (*Repository, error) {
 			SaltLength:  16,
 			KeyLength:   32,
 		},
-		redis: redis.NewClient(&redis.Options{
-			Addr: redisAddr,
-		}),
-		redisUserPrefix:    "user:",
-		redisSessionPrefix: "session:",
-	}, nil
+		mfaPrefix:                "mfa:",
+		totpPrefix:               "totp_used:",
+		queuePrefix:              "queue:",
+		loginPrefix:              "session_login:",
+		watchersPrefix:           "presence_watchers:",
+		watchingPrefix:           "presence_watching:",
+		contactsPrefix:           "contacts:",
+		contactedByPrefix:        "contacted_by:",
+		lastSeenPrefix:           "last_seen:",
+		requestPrefix:            "ws_request:",
+		messageAuthorPrefix:      "message_author:",
+		attachmentPrefix:         "attachment:",
+		attachmentReceivedPrefix: "attachment_received:",
+		attachmentAccessPrefix:   "attachment_access:",
+		attachmentOwnerPrefix:    "attachment_owner:",
+		queueTTL:                 queueTTL,
+		queueMaxLength:           queueMaxLength,
+	}
 }
 
-func initDB(dbConn string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dbConn)
-	if err != nil {
-		return nil, err
-	}
+// Close closes each of the underlying stores.
+func (r *Repository) Close() error {
+	return errors.Join(r.users.Close(), r.sessions.Close(), r.attachments.Close())
+}
 
-	// Create the user_conversations table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		username TEXT PRIMARY KEY,
-		password_hash TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW(),
-		updated_at TIMESTAMP DEFAULT NOW()
-	);
-	`
-	if _, err = db.Exec(createTableSQL); err != nil {
-		return nil, fmt.Errorf("create table failed: %w", err)
-	}
-	return db, nil
+// PingUserStore checks that the user store can be reached.
+func (r *Repository) PingUserStore(ctx context.Context) error {
+	return r.users.Ping(ctx)
 }
 
+// PingSessionStore checks that the session store can be reached.
+func (r *Repository) PingSessionStore(ctx context.Context) error {
+	return r.sessions.Ping(ctx)
+}
+
+// PingAttachmentStore checks that the attachment store can be reached.
+func (r *Repository) PingAttachmentStore(ctx context.Context) error {
+	return r.attachments.Ping(ctx)
+}
+
+// CreateUser registers a new user. ErrAlreadyExists is returned if the username is
+// already taken, including by a user whose username differs only in case.
 func (r *Repository) CreateUser(username, password string) error {
-	passwordHash, err := argon2id.CreateHash(password, r.argonParams)
+	passwordHash, err := r.createPasswordHash(password)
 	if err != nil {
 		return err
 	}
 
 	now := time.Now()
-	return insertUser(r.db, &User{
+	return r.users.InsertUser(&User{
 		Username:     username,
 		PasswordHash: passwordHash,
 		CreatedAt:    now,
@@ -85,72 +135,146 @@ func (r *Repository) CreateUser(username, password string) error {
 }
 
 func (r *Repository) AuthenticateUser(username, password string) (bool, error) {
-	user, err := getUser(r.db, username)
+	user, err := r.users.GetUser(username)
 	if err != nil {
 		return false, err
 	}
 
+	start := time.Now()
 	match, _, err := argon2id.CheckHash(password, user.PasswordHash)
+	r.metrics.ObservePasswordHash("verify", time.Since(start))
 	return match, err
 }
 
-func (r *Repository) GetNewSessionID(ctx context.Context, username string) (string, error) {
-	oldSessionID, err := r.redis.Get(ctx, r.redisUserPrefix+username).Result()
-	if err == nil {
-		// Delete the old session ID
-		err = r.redis.Del(ctx, r.redisSessionPrefix+oldSessionID).Err()
-		if err != nil {
-			return "", err
-		}
+// UpdatePassword replaces the password hash of an existing user and revokes all of their sessions,
+// so that anyone who logged in with the old password is logged out.
+// The caller is responsible for verifying the old password beforehand.
+func (r *Repository) UpdatePassword(ctx context.Context, username, newPassword string) error {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return err
 	}
 
-	sessionID, err := secure.GenerateSessionID()
+	user.PasswordHash, err = r.createPasswordHash(newPassword)
 	if err != nil {
-		return "", err
+		return err
+	}
+	user.UpdatedAt = time.Now()
+	err = r.users.UpdateUser(user)
+	if err != nil {
+		return err
 	}
 
-	sessionExpiration := time.Hour * 3
-	err = r.redis.Set(ctx, r.redisSessionPrefix+sessionID, username, sessionExpiration).Err()
+	err = r.DeleteUserSessions(ctx, username)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) createPasswordHash(password string) (string, error) {
+	start := time.Now()
+	defer func() { r.metrics.ObservePasswordHash("hash", time.Since(start)) }()
+	return argon2id.CreateHash(password, r.argonParams)
+}
+
+// DeleteUser removes the user along with all of their sessions, queued messages and presence, and removes them
+// from the contacts and presence watchers of other users. The user itself is deleted last, so that if anything
+// fails the account still exists and can be deleted again.
+func (r *Repository) DeleteUser(ctx context.Context, username string) error {
+	err := r.DeleteUserSessions(ctx, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user sessions: %w", err)
 	}
 
-	err = r.redis.Set(ctx, r.redisUserPrefix+username, sessionID, sessionExpiration).Err()
+	err = r.PurgeMessages(ctx, username)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to purge queued messages: %w", err)
 	}
-	return sessionID, nil
+
+	err = r.forgetPresence(ctx, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete presence: %w", err)
+	}
+
+	err = r.users.DeleteUser(username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
 }
 
-func (r *Repository) GetUsernameWithSessionID(ctx context.Context, sessionID string) (string, error) {
-	username, err := r.redis.Get(ctx, r.redisSessionPrefix+sessionID).Result()
+// GetNewSessionID creates a new session for the user, as part of a new login.
+// Existing sessions are left untouched so that the user can stay logged in on several devices at once.
+func (r *Repository) GetNewSessionID(ctx context.Context, username string) (string, error) {
+	return r.GetNewSessionIDForLogin(ctx, username, "")
+}
+
+// GetNewSessionIDForLogin creates a new session for the user as part of an existing login, such as after
+// the user's sessions have been revoked. A new login is started if the login ID is empty.
+func (r *Repository) GetNewSessionIDForLogin(ctx context.Context, username, loginID string) (string, error) {
+	sessionID, err := secure.GenerateSessionID()
 	if err != nil {
 		return "", err
 	}
+	if loginID == "" {
+		loginID = uuid.NewString()
+	}
 
-	storedSessionID, err := r.redis.Get(ctx, r.redisUserPrefix+username).Result()
-	switch {
-	case err != nil:
+	err = r.sessions.SetValue(ctx, r.loginPrefix+sessionID, loginID, SessionExpiration)
+	if err != nil {
+		return "", fmt.Errorf("failed to record login: %w", err)
+	}
+	err = r.sessions.CreateSession(ctx, sessionID, username, SessionExpiration)
+	if err != nil {
 		return "", err
-	case storedSessionID != sessionID:
-		return "", errors.New("provided session ID does not match stored session ID")
 	}
-	return username, nil
+	return sessionID, nil
 }
 
-func (r *Repository) DeleteUserSessions(ctx context.Context, username string) error {
-	sessionID, err := r.redis.Get(ctx, r.redisUserPrefix+username).Result()
+// GetSessionLoginID returns the ID of the login which the session belongs to. Unlike the session ID it stays
+// the same when the session is rotated, so it can be used to recognise the connections of a single device.
+// ErrNotFound is returned for sessions which were created before logins were recorded.
+func (r *Repository) GetSessionLoginID(ctx context.Context, sessionID string) (string, error) {
+	return r.sessions.GetValue(ctx, r.loginPrefix+sessionID)
+}
+
+func (r *Repository) GetUsernameWithSessionID(ctx context.Context, sessionID string) (string, error) {
+	return r.sessions.GetSessionUsername(ctx, sessionID)
+}
+
+// RotateSessionID replaces the given session with a new one which has a full expiration.
+// The old session stops working as soon as the new one is created. If the old session
+// is changed by another request during the rotation then ErrSessionChanged is returned.
+func (r *Repository) RotateSessionID(ctx context.Context, sessionID string) (string, error) {
+	newSessionID, err := secure.GenerateSessionID()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	err = r.redis.Del(ctx, r.redisUserPrefix+username).Err()
-	if err != nil {
-		return err
+	// The login is recorded for the new session before it exists so that rotating cannot lose it. The login of
+	// the old session is left to expire, since it is no use without the session.
+	loginID, err := r.GetSessionLoginID(ctx, sessionID)
+	if err == nil {
+		err = r.sessions.SetValue(ctx, r.loginPrefix+newSessionID, loginID, SessionExpiration)
 	}
-	err = r.redis.Del(ctx, r.redisSessionPrefix+sessionID).Err()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", fmt.Errorf("failed to record login: %w", err)
+	}
+
+	err = r.sessions.RotateSession(ctx, sessionID, newSessionID, SessionExpiration)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return newSessionID, nil
+}
+
+// DeleteSession revokes a single session, leaving any other sessions for the same user active.
+func (r *Repository) DeleteSession(ctx context.Context, sessionID string) error {
+	return r.sessions.DeleteSession(ctx, sessionID)
+}
+
+// DeleteUserSessions revokes every session belonging to the user.
+func (r *Repository) DeleteUserSessions(ctx context.Context, username string) error {
+	return r.sessions.DeleteUserSessions(ctx, username)
 }
@@ -0,0 +1,1299 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+func newTestRepository(t *testing.T) *db.Repository {
+	t.Helper()
+
+	return newTestRepositoryWithStores(t, db.NewMemorySessionStore(), nil)
+}
+
+// newTestRepositoryWithStores creates a repository using the session store, and storing attachments
+// in a temporary directory. The metrics may be nil.
+func newTestRepositoryWithStores(t *testing.T, sessions db.SessionStore, metrics db.Metrics) *db.Repository {
+	t.Helper()
+
+	attachments, err := db.NewFileAttachmentStore(t.TempDir())
+	require.NoError(t, err)
+	repo := db.NewRepository(db.NewMemoryUserStore(), sessions, attachments, time.Hour, 10, metrics)
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestRepository_Ping(t *testing.T) {
+	tt := map[string]struct {
+		// breakStores makes a store unreachable, given the user store and attachment directory.
+		breakStores            func(t *testing.T, users db.UserStore, attachmentDir string)
+		wantUserStoreErr       bool
+		wantAttachmentStoreErr bool
+	}{
+		"every store reachable": {
+			breakStores: func(*testing.T, db.UserStore, string) {},
+		},
+		"user store closed": {
+			breakStores: func(t *testing.T, users db.UserStore, _ string) {
+				require.NoError(t, users.Close())
+			},
+			wantUserStoreErr: true,
+		},
+		"attachment directory removed": {
+			breakStores: func(t *testing.T, _ db.UserStore, attachmentDir string) {
+				require.NoError(t, os.RemoveAll(attachmentDir))
+			},
+			wantAttachmentStoreErr: true,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			users, err := db.NewSQLiteUserStore(filepath.Join(t.TempDir(), "server.db"))
+			require.NoError(t, err)
+			attachmentDir := filepath.Join(t.TempDir(), "attachments")
+			attachments, err := db.NewFileAttachmentStore(attachmentDir)
+			require.NoError(t, err)
+			repo := db.NewRepository(users, db.NewMemorySessionStore(), attachments, time.Hour, 10, nil)
+			t.Cleanup(func() { _ = repo.Close() })
+
+			tc.breakStores(t, users, attachmentDir)
+
+			assert.Equal(t, tc.wantUserStoreErr, repo.PingUserStore(ctx) != nil)
+			assert.NoError(t, repo.PingSessionStore(ctx))
+			assert.Equal(t, tc.wantAttachmentStoreErr, repo.PingAttachmentStore(ctx) != nil)
+		})
+	}
+}
+
+func TestRepository_Users(t *testing.T) {
+	repo := newTestRepository(t)
+
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	assert.ErrorIs(t, repo.CreateUser("Alice", "password"), db.ErrAlreadyExists)
+
+	tt := map[string]struct {
+		username string
+		password string
+		want     bool
+		wantErr  error
+	}{
+		"correct password": {
+			username: "alice",
+			password: "password",
+			want:     true,
+		},
+		"wrong password": {
+			username: "alice",
+			password: "wrong",
+			want:     false,
+		},
+		"unknown user": {
+			username: "bob",
+			password: "password",
+			want:     false,
+			wantErr:  db.ErrNotFound,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			got, err := repo.AuthenticateUser(tc.username, tc.password)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	require.NoError(t, repo.UpdatePassword(context.Background(), "alice", "new-password"))
+	got, err := repo.AuthenticateUser("alice", "new-password")
+	require.NoError(t, err)
+	assert.True(t, got)
+}
+
+func TestRepository_MultipleSessions(t *testing.T) {
+	tt := map[string]struct {
+		revoke func(ctx context.Context, repo *db.Repository, sessionIDs []string) error
+		want   []bool
+	}{
+		"nothing revoked": {
+			revoke: func(context.Context, *db.Repository, []string) error { return nil },
+			want:   []bool{true, true, true},
+		},
+		"one session revoked": {
+			revoke: func(ctx context.Context, repo *db.Repository, sessionIDs []string) error {
+				return repo.DeleteSession(ctx, sessionIDs[1])
+			},
+			want: []bool{true, false, true},
+		},
+		"every session revoked": {
+			revoke: func(ctx context.Context, repo *db.Repository, _ []string) error {
+				return repo.DeleteUserSessions(ctx, "alice")
+			},
+			want: []bool{false, false, false},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepository(t)
+			require.NoError(t, repo.CreateUser("alice", "password"))
+			require.NoError(t, repo.CreateUser("bob", "password"))
+			bobSessionID, err := repo.GetNewSessionID(ctx, "bob")
+			require.NoError(t, err)
+
+			// Logging in again does not end the earlier sessions.
+			sessionIDs := make([]string, len(tc.want))
+			for i := range sessionIDs {
+				sessionIDs[i], err = repo.GetNewSessionID(ctx, "alice")
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, tc.revoke(ctx, repo, sessionIDs))
+
+			for i, sessionID := range sessionIDs {
+				username, err := repo.GetUsernameWithSessionID(ctx, sessionID)
+				if !tc.want[i] {
+					assert.ErrorIs(t, err, db.ErrNotFound)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, "alice", username)
+			}
+
+			// Sessions of other users are never affected.
+			username, err := repo.GetUsernameWithSessionID(ctx, bobSessionID)
+			require.NoError(t, err)
+			assert.Equal(t, "bob", username)
+		})
+	}
+}
+
+func TestRepository_UpdatePassword(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	require.NoError(t, repo.CreateUser("bob", "password"))
+
+	aliceSessionID, err := repo.GetNewSessionID(ctx, "alice")
+	require.NoError(t, err)
+	bobSessionID, err := repo.GetNewSessionID(ctx, "bob")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.UpdatePassword(ctx, "alice", "new-password"))
+	assert.ErrorIs(t, repo.UpdatePassword(ctx, "carol", "password"), db.ErrNotFound)
+
+	tt := map[string]struct {
+		username    string
+		password    string
+		sessionID   string
+		want        bool
+		wantSession bool
+	}{
+		"new password": {
+			username:  "alice",
+			password:  "new-password",
+			sessionID: aliceSessionID,
+			want:      true,
+		},
+		"old password": {
+			username:  "alice",
+			password:  "password",
+			sessionID: aliceSessionID,
+			want:      false,
+		},
+		"other user": {
+			username:    "bob",
+			password:    "password",
+			sessionID:   bobSessionID,
+			want:        true,
+			wantSession: true,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			got, err := repo.AuthenticateUser(tc.username, tc.password)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+
+			username, err := repo.GetUsernameWithSessionID(ctx, tc.sessionID)
+			if !tc.wantSession {
+				assert.ErrorIs(t, err, db.ErrNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.username, username)
+		})
+	}
+}
+
+func TestRepository_Sessions(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+
+	sessionID, err := repo.GetNewSessionID(ctx, "alice")
+	require.NoError(t, err)
+	otherSessionID, err := repo.GetNewSessionID(ctx, "alice")
+	require.NoError(t, err)
+
+	newSessionID, err := repo.RotateSessionID(ctx, sessionID)
+	require.NoError(t, err)
+	_, err = repo.GetUsernameWithSessionID(ctx, sessionID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	username, err := repo.GetUsernameWithSessionID(ctx, newSessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+
+	require.NoError(t, repo.DeleteUser(ctx, "alice"))
+	for _, id := range []string{newSessionID, otherSessionID} {
+		_, err = repo.GetUsernameWithSessionID(ctx, id)
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	}
+}
+
+func TestRepository_RotateSessionID(t *testing.T) {
+	tt := map[string]struct {
+		// prepare is given an active session and returns the session to rotate.
+		prepare func(ctx context.Context, t *testing.T, repo *db.Repository, sessionID string) string
+		wantErr error
+	}{
+		"active session": {
+			prepare: func(_ context.Context, _ *testing.T, _ *db.Repository, sessionID string) string {
+				return sessionID
+			},
+		},
+		"rotated session": {
+			prepare: func(ctx context.Context, t *testing.T, repo *db.Repository, sessionID string) string {
+				_, err := repo.RotateSessionID(ctx, sessionID)
+				require.NoError(t, err)
+				return sessionID
+			},
+			wantErr: db.ErrNotFound,
+		},
+		"revoked session": {
+			prepare: func(ctx context.Context, t *testing.T, repo *db.Repository, sessionID string) string {
+				require.NoError(t, repo.DeleteSession(ctx, sessionID))
+				return sessionID
+			},
+			wantErr: db.ErrNotFound,
+		},
+		"unknown session": {
+			prepare: func(context.Context, *testing.T, *db.Repository, string) string {
+				return "unknown"
+			},
+			wantErr: db.ErrNotFound,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepository(t)
+			require.NoError(t, repo.CreateUser("alice", "password"))
+			sessionID, err := repo.GetNewSessionID(ctx, "alice")
+			require.NoError(t, err)
+
+			oldSessionID := tc.prepare(ctx, t, repo, sessionID)
+			newSessionID, err := repo.RotateSessionID(ctx, oldSessionID)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEqual(t, oldSessionID, newSessionID)
+
+			// The old session stops working as soon as it is rotated.
+			_, err = repo.GetUsernameWithSessionID(ctx, oldSessionID)
+			assert.ErrorIs(t, err, db.ErrNotFound)
+			username, err := repo.GetUsernameWithSessionID(ctx, newSessionID)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", username)
+
+			// The new session is still revoked along with the rest of the user's sessions.
+			require.NoError(t, repo.DeleteUserSessions(ctx, "alice"))
+			_, err = repo.GetUsernameWithSessionID(ctx, newSessionID)
+			assert.ErrorIs(t, err, db.ErrNotFound)
+		})
+	}
+}
+
+func TestRepository_SessionLogins(t *testing.T) {
+	tt := map[string]struct {
+		// next creates another session from the first, and reports whether it belongs to the same login.
+		next func(ctx context.Context, t *testing.T, repo *db.Repository, sessionID, loginID string) (string, bool)
+	}{
+		"rotated session": {
+			next: func(ctx context.Context, t *testing.T, repo *db.Repository, sessionID, _ string) (string, bool) {
+				newSessionID, err := repo.RotateSessionID(ctx, sessionID)
+				require.NoError(t, err)
+				return newSessionID, true
+			},
+		},
+		"session reissued after a password change": {
+			next: func(ctx context.Context, t *testing.T, repo *db.Repository, _, loginID string) (string, bool) {
+				require.NoError(t, repo.UpdatePassword(ctx, "alice", "new-password"))
+				newSessionID, err := repo.GetNewSessionIDForLogin(ctx, "alice", loginID)
+				require.NoError(t, err)
+				return newSessionID, true
+			},
+		},
+		"new login": {
+			next: func(ctx context.Context, t *testing.T, repo *db.Repository, _, _ string) (string, bool) {
+				newSessionID, err := repo.GetNewSessionID(ctx, "alice")
+				require.NoError(t, err)
+				return newSessionID, false
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepository(t)
+			require.NoError(t, repo.CreateUser("alice", "password"))
+			sessionID, err := repo.GetNewSessionID(ctx, "alice")
+			require.NoError(t, err)
+			loginID, err := repo.GetSessionLoginID(ctx, sessionID)
+			require.NoError(t, err)
+			require.NotEmpty(t, loginID)
+
+			newSessionID, wantSameLogin := tc.next(ctx, t, repo, sessionID, loginID)
+			got, err := repo.GetSessionLoginID(ctx, newSessionID)
+			require.NoError(t, err)
+			assert.Equal(t, wantSameLogin, got == loginID)
+		})
+	}
+}
+
+func TestRepository_RotateSessionIDConcurrently(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	sessionID, err := repo.GetNewSessionID(ctx, "alice")
+	require.NoError(t, err)
+
+	// A session can only be rotated once, so a stolen session cannot be refreshed alongside the real one.
+	var wg sync.WaitGroup
+	var rotated atomic.Int64
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.RotateSessionID(ctx, sessionID); err == nil {
+				rotated.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), rotated.Load())
+}
+
+func TestRepository_LoginLockout(t *testing.T) {
+	tt := map[string]struct {
+		failures int
+		want     time.Duration
+	}{
+		"below the threshold": {
+			failures: 4,
+		},
+		"at the threshold": {
+			failures: 5,
+			want:     30 * time.Second,
+		},
+		"doubles for each further failure": {
+			failures: 7,
+			want:     2 * time.Minute,
+		},
+		"capped at the maximum": {
+			failures: 30,
+			want:     time.Hour,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sessions := db.NewMemorySessionStore()
+			repo := newTestRepositoryWithStores(t, sessions, nil)
+
+			var attempt db.LoginAttempt
+			for range tc.failures {
+				// Each lockout is ended early so that the next failure can be made.
+				require.NoError(t, sessions.Delete(ctx, "login_lockout:user:alice", "login_lockout:ip:127.0.0.1"))
+
+				var err error
+				attempt, err = repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+				require.NoError(t, err)
+				require.Zero(t, attempt.Lockout)
+			}
+			assert.Equal(t, tc.want, attempt.LockoutOnFailure)
+
+			lockout, err := repo.GetLoginLockout(ctx, "alice", "127.0.0.1")
+			require.NoError(t, err)
+			assert.InDelta(t, tc.want, lockout, float64(time.Second))
+		})
+	}
+}
+
+func TestRepository_LoginLockoutRejectsAttempts(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	for range 5 {
+		attempt, err := repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+		require.NoError(t, err)
+		require.Zero(t, attempt.Lockout)
+	}
+
+	// Attempts are rejected during the lockout, but only for the locked out username.
+	attempt, err := repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Positive(t, attempt.Lockout)
+	attempt, err = repo.ReserveLoginAttempt(ctx, "bob", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, attempt.Lockout)
+
+	// Failures from an address are limited across usernames.
+	for i := range 20 {
+		attempt, err = repo.ReserveLoginAttempt(ctx, fmt.Sprintf("user%d", i), "127.0.0.2")
+		require.NoError(t, err)
+		require.Zero(t, attempt.Lockout)
+	}
+	attempt, err = repo.ReserveLoginAttempt(ctx, "carol", "127.0.0.2")
+	require.NoError(t, err)
+	assert.Positive(t, attempt.Lockout)
+}
+
+func TestRepository_LoginLockoutConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, err := repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+			assert.NoError(t, err)
+			if err == nil && attempt.Lockout == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only as many attempts as the threshold may be verified, however many are made at once.
+	assert.Equal(t, int64(5), allowed.Load())
+}
+
+func TestRepository_ReleaseLoginAttempt(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	for range 4 {
+		_, err := repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+		require.NoError(t, err)
+	}
+
+	// The attempt which reaches the threshold locks out others until it is found to be correct.
+	attempt, err := repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+	require.NoError(t, err)
+	require.Positive(t, attempt.LockoutOnFailure)
+	lockout, err := repo.GetLoginLockout(ctx, "alice", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Positive(t, lockout)
+
+	require.NoError(t, repo.ReleaseLoginAttempt(ctx, attempt))
+	lockout, err = repo.GetLoginLockout(ctx, "alice", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, lockout)
+
+	// Releasing the attempt does not forget the earlier failures, but logging in does.
+	attempt, err = repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Positive(t, attempt.LockoutOnFailure)
+	require.NoError(t, repo.ReleaseLoginAttempt(ctx, attempt))
+	require.NoError(t, repo.ResetFailedLogins(ctx, "alice"))
+	attempt, err = repo.ReserveLoginAttempt(ctx, "alice", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, attempt.LockoutOnFailure)
+}
+
+// dequeueAll removes and returns the messages queued for the user.
+func dequeueAll(t *testing.T, repo *db.Repository, username string) [][]byte {
+	t.Helper()
+
+	var messages [][]byte
+	require.NoError(t, repo.DequeueMessages(context.Background(), username, func(message []byte) error {
+		messages = append(messages, message)
+		return nil
+	}))
+	return messages
+}
+
+func TestRepository_Queue(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+
+	for _, msg := range []string{`"1"`, `"2"`, `"3"`} {
+		require.NoError(t, repo.EnqueueMessage(ctx, "alice", []byte(msg)))
+	}
+
+	removed, err := repo.RemoveQueuedMessages(ctx, "alice", func(message []byte) bool {
+		return string(message) == `"2"`
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	messages := dequeueAll(t, repo, "alice")
+	require.Len(t, messages, 2)
+	assert.JSONEq(t, `"1"`, string(messages[0]))
+	assert.JSONEq(t, `"3"`, string(messages[1]))
+
+	assert.Empty(t, dequeueAll(t, repo, "alice"))
+}
+
+func TestRepository_QueueMaxLength(t *testing.T) {
+	tt := map[string]struct {
+		queued int
+		want   []string
+	}{
+		"below the maximum length": {
+			queued: 3,
+			want:   []string{"0", "1", "2"},
+		},
+		"above the maximum length": {
+			queued: 12,
+			want:   []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "11"},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepository(t)
+			require.NoError(t, repo.CreateUser("alice", "password"))
+
+			for i := range tc.queued {
+				require.NoError(t, repo.EnqueueMessage(ctx, "alice", []byte(strconv.Itoa(i))))
+			}
+
+			var got []string
+			for _, message := range dequeueAll(t, repo, "alice") {
+				got = append(got, string(message))
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRepository_QueueUnknownUser(t *testing.T) {
+	ctx := context.Background()
+	sessions := db.NewMemorySessionStore()
+	repo := newTestRepositoryWithStores(t, sessions, nil)
+
+	err := repo.EnqueueMessage(ctx, "mallory", []byte(`"1"`))
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	assert.ErrorIs(t, err, websocket.ErrUnknownRecipient)
+
+	entries, err := sessions.GetList(ctx, "queue:mallory")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRepository_DeleteUserPurgesQueue(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	require.NoError(t, repo.EnqueueMessage(ctx, "alice", []byte(`"1"`)))
+
+	require.NoError(t, repo.DeleteUser(ctx, "alice"))
+
+	// A new account with the same name must not receive messages queued for the old one.
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	assert.Empty(t, dequeueAll(t, repo, "alice"))
+}
+
+// failingDeleteStore is a session store which fails to delete keys.
+type failingDeleteStore struct {
+	db.SessionStore
+}
+
+func (s failingDeleteStore) Delete(context.Context, ...string) error {
+	return errors.New("delete failed")
+}
+
+func TestRepository_DeleteUserKeepsAccountOnFailure(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepositoryWithStores(t, failingDeleteStore{db.NewMemorySessionStore()}, nil)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+
+	require.Error(t, repo.DeleteUser(ctx, "alice"))
+
+	// The account must remain so that deleting it can be retried.
+	_, err := repo.AuthenticateUser("alice", "password")
+	assert.NoError(t, err)
+}
+
+func TestRepository_DeleteUser(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	sessionIDs := make(map[string]string)
+	for _, username := range []string{"alice", "bob"} {
+		require.NoError(t, repo.CreateUser(username, "password"))
+		sessionID, err := repo.GetNewSessionID(ctx, username)
+		require.NoError(t, err)
+		sessionIDs[username] = sessionID
+		require.NoError(t, repo.EnqueueMessage(ctx, username, []byte(`"1"`)))
+		require.NoError(t, repo.SetLastSeen(ctx, username, time.Now()))
+		require.NoError(t, repo.RecordContacts(ctx, username, []string{"carol"}))
+		require.NoError(t, repo.WatchPresence(ctx, username, []string{"carol"}))
+	}
+	require.NoError(t, repo.RecordContacts(ctx, "carol", []string{"alice", "bob"}))
+	require.NoError(t, repo.WatchPresence(ctx, "carol", []string{"alice", "bob"}))
+
+	require.NoError(t, repo.DeleteUser(ctx, "alice"))
+
+	// Each check reports whether the data is still held for the user.
+	tt := map[string]func(t *testing.T, username string) bool{
+		"account": func(t *testing.T, username string) bool {
+			_, err := repo.AuthenticateUser(username, "password")
+			if errors.Is(err, db.ErrNotFound) {
+				return false
+			}
+			require.NoError(t, err)
+			return true
+		},
+		"session": func(t *testing.T, username string) bool {
+			_, err := repo.GetUsernameWithSessionID(ctx, sessionIDs[username])
+			if errors.Is(err, db.ErrNotFound) {
+				return false
+			}
+			require.NoError(t, err)
+			return true
+		},
+		"queued messages": func(t *testing.T, username string) bool {
+			return len(dequeueAll(t, repo, username)) > 0
+		},
+		"last seen": func(t *testing.T, username string) bool {
+			lastSeen, err := repo.GetLastSeen(ctx, username)
+			require.NoError(t, err)
+			return !lastSeen.IsZero()
+		},
+		"presence watchers": func(t *testing.T, username string) bool {
+			watchers, err := repo.GetPresenceWatchers(ctx, username)
+			require.NoError(t, err)
+			return len(watchers) > 0
+		},
+		"contacts": func(t *testing.T, username string) bool {
+			mutual, err := repo.GetMutualContacts(ctx, username, []string{"carol"})
+			require.NoError(t, err)
+			return len(mutual) > 0
+		},
+		"watching others": func(t *testing.T, username string) bool {
+			watchers, err := repo.GetPresenceWatchers(ctx, "carol")
+			require.NoError(t, err)
+			return slices.Contains(watchers, username)
+		},
+	}
+
+	for name, isHeld := range tt {
+		t.Run(name, func(t *testing.T) {
+			assert.False(t, isHeld(t, "alice"), "deleted user")
+			assert.True(t, isHeld(t, "bob"), "other user")
+		})
+	}
+
+	// A new account with the same name must not inherit the contacts of the old one.
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	require.NoError(t, repo.RecordContacts(ctx, "alice", []string{"carol"}))
+	mutual, err := repo.GetMutualContacts(ctx, "alice", []string{"carol"})
+	require.NoError(t, err)
+	assert.Empty(t, mutual)
+}
+
+func TestRepository_DequeueMessages(t *testing.T) {
+	errWrite := errors.New("write failed")
+	entryAt := func(queuedAt time.Time, data string) string {
+		return fmt.Sprintf(`{"queued_at":%q,"data":%s}`, queuedAt.Format(time.RFC3339Nano), data)
+	}
+	entry := func(data string) string {
+		return entryAt(time.Now(), data)
+	}
+
+	tt := map[string]struct {
+		// entries are pushed to the queue as they are, so that corrupt entries can be included.
+		entries   []string
+		failOn    string
+		wantErr   bool
+		delivered []string
+		remaining []string
+	}{
+		"delivers every message": {
+			entries:   []string{entry(`"1"`), entry(`"2"`)},
+			delivered: []string{`"1"`, `"2"`},
+		},
+		"skips corrupt entries": {
+			entries:   []string{entry(`"1"`), `not json`, entry(`"2"`)},
+			wantErr:   true,
+			delivered: []string{`"1"`, `"2"`},
+		},
+		"skips expired messages": {
+			entries:   []string{entryAt(time.Now().Add(-2*time.Hour), `"1"`), entry(`"2"`)},
+			delivered: []string{`"2"`},
+		},
+		"keeps messages which were not delivered": {
+			entries:   []string{entry(`"1"`), entry(`"2"`), entry(`"3"`)},
+			failOn:    `"2"`,
+			wantErr:   true,
+			delivered: []string{`"1"`},
+			remaining: []string{`"2"`, `"3"`},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sessions := db.NewMemorySessionStore()
+			repo := newTestRepositoryWithStores(t, sessions, nil)
+
+			for _, entry := range tc.entries {
+				require.NoError(t, sessions.Push(ctx, "queue:alice", []byte(entry), 10, time.Hour))
+			}
+
+			var delivered []string
+			err := repo.DequeueMessages(ctx, "alice", func(message []byte) error {
+				if string(message) == tc.failOn {
+					return errWrite
+				}
+				delivered = append(delivered, string(message))
+				return nil
+			})
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.delivered, delivered)
+
+			var remaining []string
+			for _, message := range dequeueAll(t, repo, "alice") {
+				remaining = append(remaining, string(message))
+			}
+			assert.Equal(t, tc.remaining, remaining)
+		})
+	}
+}
+
+func TestRepository_TOTP(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("alice", "password"))
+
+	uri, err := repo.BeginTOTPEnrollment("alice")
+	require.NoError(t, err)
+	key, err := otp.NewKeyFromURL(uri)
+	require.NoError(t, err)
+	secret := key.Secret()
+
+	_, err = repo.ConfirmTOTPEnrollment("alice", "000000")
+	assert.ErrorIs(t, err, db.ErrInvalidCode)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := repo.ConfirmTOTPEnrollment("alice", code)
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+
+	isEnabled, err := repo.IsTOTPEnabled("alice")
+	require.NoError(t, err)
+	assert.True(t, isEnabled)
+
+	// Each TOTP code and recovery code can only be used once.
+	for _, secondFactor := range []string{code, recoveryCodes[0]} {
+		isVerified, err := repo.VerifySecondFactor(ctx, "alice", secondFactor)
+		require.NoError(t, err)
+		assert.True(t, isVerified)
+		isVerified, err = repo.VerifySecondFactor(ctx, "alice", secondFactor)
+		require.NoError(t, err)
+		assert.False(t, isVerified)
+	}
+}
+
+type recordingMetrics struct {
+	passwordHashes []string
+	storeCalls     []string
+}
+
+func (m *recordingMetrics) ObservePasswordHash(op string, _ time.Duration) {
+	m.passwordHashes = append(m.passwordHashes, op)
+}
+
+func (m *recordingMetrics) ObserveStoreCall(store, op string, _ time.Duration) {
+	m.storeCalls = append(m.storeCalls, store+"."+op)
+}
+
+// enrollTOTP enables TOTP for the user, returning their secret and recovery codes.
+func enrollTOTP(t *testing.T, repo *db.Repository, username string) (string, []string) {
+	t.Helper()
+
+	uri, err := repo.BeginTOTPEnrollment(username)
+	require.NoError(t, err)
+	key, err := otp.NewKeyFromURL(uri)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := repo.ConfirmTOTPEnrollment(username, code)
+	require.NoError(t, err)
+	return key.Secret(), recoveryCodes
+}
+
+func TestRepository_TOTPEnrollment(t *testing.T) {
+	tt := map[string]struct {
+		// prepare is called before the enrollment is confirmed, and returns the code to confirm it with.
+		prepare func(t *testing.T, repo *db.Repository) string
+		wantErr error
+	}{
+		"valid code": {
+			prepare: func(t *testing.T, repo *db.Repository) string {
+				uri, err := repo.BeginTOTPEnrollment("alice")
+				require.NoError(t, err)
+				key, err := otp.NewKeyFromURL(uri)
+				require.NoError(t, err)
+				code, err := totp.GenerateCode(key.Secret(), time.Now())
+				require.NoError(t, err)
+				return code
+			},
+		},
+		"invalid code": {
+			prepare: func(t *testing.T, repo *db.Repository) string {
+				_, err := repo.BeginTOTPEnrollment("alice")
+				require.NoError(t, err)
+				return "invalid"
+			},
+			wantErr: db.ErrInvalidCode,
+		},
+		"not enrolled": {
+			prepare: func(*testing.T, *db.Repository) string {
+				return "123456"
+			},
+			wantErr: db.ErrTOTPNotEnrolled,
+		},
+		"already enabled": {
+			prepare: func(t *testing.T, repo *db.Repository) string {
+				secret, _ := enrollTOTP(t, repo, "alice")
+				_, err := repo.BeginTOTPEnrollment("alice")
+				assert.ErrorIs(t, err, db.ErrTOTPAlreadyEnabled)
+				code, err := totp.GenerateCode(secret, time.Now())
+				require.NoError(t, err)
+				return code
+			},
+			wantErr: db.ErrTOTPAlreadyEnabled,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			require.NoError(t, repo.CreateUser("alice", "password"))
+
+			code := tc.prepare(t, repo)
+			recoveryCodes, err := repo.ConfirmTOTPEnrollment("alice", code)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, recoveryCodes, 10)
+
+			isEnabled, err := repo.IsTOTPEnabled("alice")
+			require.NoError(t, err)
+			assert.True(t, isEnabled)
+		})
+	}
+}
+
+func TestRepository_VerifySecondFactor(t *testing.T) {
+	// codes are given the user's TOTP code, their recovery codes and those of another user,
+	// and return the codes to verify in order.
+	type codes func(totpCode string, recoveryCodes, otherRecoveryCodes []string) []string
+
+	tt := map[string]struct {
+		codes codes
+		want  []bool
+	}{
+		"totp code": {
+			codes: func(totpCode string, _, _ []string) []string { return []string{totpCode} },
+			want:  []bool{true},
+		},
+		"replayed totp code": {
+			codes: func(totpCode string, _, _ []string) []string { return []string{totpCode, totpCode} },
+			want:  []bool{true, false},
+		},
+		"invalid code": {
+			codes: func(string, []string, []string) []string { return []string{"invalid"} },
+			want:  []bool{false},
+		},
+		"recovery code": {
+			codes: func(_ string, recoveryCodes, _ []string) []string { return []string{recoveryCodes[0]} },
+			want:  []bool{true},
+		},
+		"reused recovery code": {
+			codes: func(_ string, recoveryCodes, _ []string) []string {
+				return []string{recoveryCodes[0], recoveryCodes[0]}
+			},
+			want: []bool{true, false},
+		},
+		"several recovery codes": {
+			codes: func(_ string, recoveryCodes, _ []string) []string {
+				return []string{recoveryCodes[0], recoveryCodes[1]}
+			},
+			want: []bool{true, true},
+		},
+		"reformatted recovery code": {
+			codes: func(_ string, recoveryCodes, _ []string) []string {
+				return []string{strings.ToLower(strings.ReplaceAll(recoveryCodes[0], "-", ""))}
+			},
+			want: []bool{true},
+		},
+		"recovery code of another user": {
+			codes: func(_ string, _, otherRecoveryCodes []string) []string { return []string{otherRecoveryCodes[0]} },
+			want:  []bool{false},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepository(t)
+			require.NoError(t, repo.CreateUser("alice", "password"))
+			require.NoError(t, repo.CreateUser("bob", "password"))
+			secret, recoveryCodes := enrollTOTP(t, repo, "alice")
+			_, otherRecoveryCodes := enrollTOTP(t, repo, "bob")
+
+			totpCode, err := totp.GenerateCode(secret, time.Now())
+			require.NoError(t, err)
+
+			var got []bool
+			for _, code := range tc.codes(totpCode, recoveryCodes, otherRecoveryCodes) {
+				isVerified, err := repo.VerifySecondFactor(ctx, "alice", code)
+				require.NoError(t, err)
+				got = append(got, isVerified)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	repo := newTestRepository(t)
+	require.NoError(t, repo.CreateUser("carol", "password"))
+	_, err := repo.VerifySecondFactor(context.Background(), "carol", "123456")
+	assert.ErrorIs(t, err, db.ErrTOTPNotEnrolled)
+}
+
+func TestRepository_MFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	token, err := repo.CreateMFAChallenge(ctx, "alice")
+	require.NoError(t, err)
+	username, err := repo.GetMFAChallengeUsername(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+
+	// Each challenge can only complete one login.
+	require.NoError(t, repo.DeleteMFAChallenge(ctx, token))
+	_, err = repo.GetMFAChallengeUsername(ctx, token)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestRepository_Metrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	repo := newTestRepositoryWithStores(t, db.NewMemorySessionStore(), metrics)
+
+	require.NoError(t, repo.CreateUser("alice", "password"))
+	_, err := repo.AuthenticateUser("alice", "password")
+	require.NoError(t, err)
+	_, err = repo.GetNewSessionID(context.Background(), "alice")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"hash", "verify"}, metrics.passwordHashes)
+	assert.Equal(t,
+		[]string{"user.InsertUser", "user.GetUser", "session.SetValue", "session.CreateSession"}, metrics.storeCalls)
+}
+
+func TestRepository_Presence(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	require.NoError(t, repo.WatchPresence(ctx, "alice", []string{"alice", "bob", "carol"}))
+	require.NoError(t, repo.WatchPresence(ctx, "dave", []string{"bob"}))
+
+	watchers, err := repo.GetPresenceWatchers(ctx, "bob")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice", "dave"}, watchers)
+
+	// Users do not watch themselves.
+	watchers, err = repo.GetPresenceWatchers(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, watchers)
+
+	lastSeen, err := repo.GetLastSeen(ctx, "bob")
+	require.NoError(t, err)
+	assert.True(t, lastSeen.IsZero())
+
+	now := time.Now().Truncate(time.Second)
+	require.NoError(t, repo.SetLastSeen(ctx, "bob", now))
+	lastSeen, err = repo.GetLastSeen(ctx, "bob")
+	require.NoError(t, err)
+	assert.True(t, now.Equal(lastSeen))
+}
+
+func TestRepository_MutualContacts(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	require.NoError(t, repo.RecordContacts(ctx, "alice", []string{"bob", "carol"}))
+	require.NoError(t, repo.RecordContacts(ctx, "bob", []string{"alice"}))
+	require.NoError(t, repo.RecordContacts(ctx, "dave", []string{"alice"}))
+
+	tt := map[string]struct {
+		username  string
+		usernames []string
+		want      []string
+	}{
+		"messaged each other": {
+			username:  "alice",
+			usernames: []string{"bob"},
+			want:      []string{"bob"},
+		},
+		"only messaged them": {
+			username:  "alice",
+			usernames: []string{"carol"},
+		},
+		"only messaged by them": {
+			username:  "alice",
+			usernames: []string{"dave"},
+		},
+		"never messaged": {
+			username:  "carol",
+			usernames: []string{"bob", "dave"},
+		},
+		"only contacts are returned": {
+			username:  "bob",
+			usernames: []string{"alice", "bob", "carol", "alice"},
+			want:      []string{"alice"},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			contacts, err := repo.GetMutualContacts(ctx, tc.username, tc.usernames)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, contacts)
+		})
+	}
+}
+
+func TestRepository_Requests(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	isClaimed, err := repo.ClaimRequest(ctx, "alice", "request-1")
+	require.NoError(t, err)
+	assert.True(t, isClaimed)
+
+	// Retries of the same request are recognised, but only for the same user.
+	isClaimed, err = repo.ClaimRequest(ctx, "alice", "request-1")
+	require.NoError(t, err)
+	assert.False(t, isClaimed)
+	isClaimed, err = repo.ClaimRequest(ctx, "bob", "request-1")
+	require.NoError(t, err)
+	assert.True(t, isClaimed)
+
+	require.NoError(t, repo.ReleaseRequest(ctx, "alice", "request-1"))
+	isClaimed, err = repo.ClaimRequest(ctx, "alice", "request-1")
+	require.NoError(t, err)
+	assert.True(t, isClaimed)
+}
+
+func TestRepository_MessageAuthors(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	_, err := repo.GetMessageAuthor(ctx, "conversation", "message")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, repo.RecordMessageAuthor(ctx, "conversation", "message", "alice"))
+	// Recording the same author again is allowed since requests can be retried.
+	require.NoError(t, repo.RecordMessageAuthor(ctx, "conversation", "message", "alice"))
+	err = repo.RecordMessageAuthor(ctx, "conversation", "message", "bob")
+	assert.ErrorIs(t, err, db.ErrAlreadyExists)
+
+	author, err := repo.GetMessageAuthor(ctx, "conversation", "message")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", author)
+}
+
+func TestRepository_Attachments(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	content := []byte(strings.Repeat("tea", entity.AttachmentChunkSize))
+	attachment, err := entity.NewAttachment("tea.txt", content)
+	require.NoError(t, err)
+
+	_, err = repo.GetAttachment(ctx, attachment.ID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, repo.CreateAttachment(ctx, "alice", attachment))
+	// Creating the same attachment again is allowed so that uploads can be resumed.
+	require.NoError(t, repo.CreateAttachment(ctx, "alice", attachment))
+	assert.ErrorIs(t, repo.CreateAttachment(ctx, "bob", attachment), db.ErrAlreadyExists)
+
+	stored, err := repo.GetAttachment(ctx, attachment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.StoredAttachment{Attachment: attachment, Owner: "alice"}, stored)
+
+	_, err = repo.GetAttachmentContent(ctx, attachment)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	for _, index := range []int{2, 0, 1} {
+		start := index * entity.AttachmentChunkSize
+		chunk := content[start : start+attachment.ChunkSize(index)]
+		require.NoError(t, repo.SaveAttachmentChunk(ctx, attachment.ID, index, chunk))
+	}
+	received, err := repo.GetReceivedChunks(ctx, attachment.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{0, 1, 2}, received)
+	got, err := repo.GetAttachmentContent(ctx, attachment)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	// Only the owner may download the attachment until others are granted access.
+	hasAccess, err := repo.HasAttachmentAccess(ctx, attachment.ID, "alice")
+	require.NoError(t, err)
+	assert.True(t, hasAccess)
+	hasAccess, err = repo.HasAttachmentAccess(ctx, attachment.ID, "bob")
+	require.NoError(t, err)
+	assert.False(t, hasAccess)
+
+	require.NoError(t, repo.GrantAttachmentAccess(ctx, attachment.ID, "bob"))
+	hasAccess, err = repo.HasAttachmentAccess(ctx, attachment.ID, "bob")
+	require.NoError(t, err)
+	assert.True(t, hasAccess)
+
+	require.NoError(t, repo.CompleteAttachment(ctx, attachment.ID))
+	stored, err = repo.GetAttachment(ctx, attachment.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.Complete)
+	// Completing an attachment does not stop it from being resumed by its owner.
+	require.NoError(t, repo.CreateAttachment(ctx, "alice", attachment))
+
+	// Content is only pruned once the attachment has expired.
+	pruned, err := repo.PruneAttachments(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, pruned)
+}
+
+func TestRepository_AttachmentLimits(t *testing.T) {
+	newAttachment := func(t *testing.T, size int) entity.Attachment {
+		attachment, err := entity.NewAttachment("tea.txt", make([]byte, size))
+		require.NoError(t, err)
+		return attachment
+	}
+
+	tt := map[string]struct {
+		// complete is how many attachments the user has already uploaded, each of the maximum size.
+		complete int
+		// open is how many attachments of one byte the user is uploading.
+		open    int
+		wantErr error
+	}{
+		"within limits": {
+			complete: 2,
+			open:     3,
+		},
+		"too many open uploads": {
+			open:    4,
+			wantErr: db.ErrLimitExceeded,
+		},
+		"too many bytes stored": {
+			complete: 20,
+			wantErr:  db.ErrLimitExceeded,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepository(t)
+
+			for range tc.complete {
+				attachment := newAttachment(t, entity.MaxAttachmentSize)
+				require.NoError(t, repo.CreateAttachment(ctx, "alice", attachment))
+				require.NoError(t, repo.CompleteAttachment(ctx, attachment.ID))
+			}
+			for range tc.open {
+				require.NoError(t, repo.CreateAttachment(ctx, "alice", newAttachment(t, 1)))
+			}
+
+			err := repo.CreateAttachment(ctx, "alice", newAttachment(t, 1))
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// Other users have their own limits.
+			assert.NoError(t, repo.CreateAttachment(ctx, "bob", newAttachment(t, 1)))
+		})
+	}
+}
+
+func TestRepository_PruneAttachments(t *testing.T) {
+	ctx := context.Background()
+	sessions := db.NewMemorySessionStore()
+	repo := newTestRepositoryWithStores(t, sessions, nil)
+
+	attachment, err := entity.NewAttachment("tea.txt", []byte("tea"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateAttachment(ctx, "alice", attachment))
+	require.NoError(t, repo.SaveAttachmentChunk(ctx, attachment.ID, 0, []byte("tea")))
+
+	// The content is deleted once the attachment has expired from the session store.
+	require.NoError(t, sessions.Delete(ctx, "attachment:"+attachment.ID.String()))
+	pruned, err := repo.PruneAttachments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+
+	_, err = repo.GetAttachmentChunk(ctx, attachment.ID, 0)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// requestTTL is how long a handled WebSocket request is remembered, which must be longer than clients keep retrying it.
+const requestTTL = 10 * time.Minute
+
+// ClaimRequest records that the user's request is being handled. It returns false if the request
+// has already been claimed, in which case it is a retry and should not be handled again.
+func (r *Repository) ClaimRequest(ctx context.Context, username, requestID string) (bool, error) {
+	return r.sessions.SetValueIfAbsent(ctx, r.requestKey(username, requestID), "1", requestTTL)
+}
+
+// ReleaseRequest forgets a claimed request so that it can be handled again when retried.
+// This should be used when handling the request failed.
+func (r *Repository) ReleaseRequest(ctx context.Context, username, requestID string) error {
+	return r.sessions.Delete(ctx, r.requestKey(username, requestID))
+}
+
+func (r *Repository) requestKey(username, requestID string) string {
+	return r.requestPrefix + username + ":" + requestID
+}
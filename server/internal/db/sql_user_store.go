@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ UserStore = &sqlUserStore{}
+
+// sqlUserStore implements UserStore for any SQL database which accepts $n placeholders.
+// The schema is created by the constructor for each database.
+type sqlUserStore struct {
+	db *sql.DB
+	// isUniqueViolation reports whether the error was caused by a unique constraint.
+	isUniqueViolation func(err error) bool
+}
+
+// dbtx is implemented by both *sql.DB and *sql.Tx so that queries can be run inside or outside a transaction.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *sqlUserStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlUserStore) InsertUser(user *User) error {
+	query := `
+	INSERT INTO users (username, password_hash, created_at, updated_at)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err := s.db.Exec(query, user.Username, user.PasswordHash, user.CreatedAt, user.UpdatedAt)
+	if err != nil && s.isUniqueViolation(err) {
+		return fmt.Errorf("%w: user with username %q: %w", ErrAlreadyExists, user.Username, err)
+	}
+	return err
+}
+
+func (s *sqlUserStore) GetUser(username string) (*User, error) {
+	query := `
+	SELECT username, password_hash, totp_secret, totp_enabled, created_at, updated_at
+	FROM users
+	WHERE username = $1
+	`
+	row := s.db.QueryRow(query, username)
+
+	var result User
+	err := row.Scan(&result.Username, &result.PasswordHash, &result.TOTPSecret, &result.TOTPEnabled,
+		&result.CreatedAt, &result.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: no user found with username %q: %w", ErrNotFound, username, err)
+		}
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *sqlUserStore) UpdateUser(user *User) error {
+	return updateUser(s.db, user)
+}
+
+func (s *sqlUserStore) DeleteUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE username = $1`, username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM users WHERE username = $1`, username)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlUserStore) EnableTOTP(user *User, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = updateUser(tx, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE username = $1`, user.Username)
+	if err != nil {
+		return err
+	}
+	insertSQL := `
+	INSERT INTO recovery_codes (username, code_hash, created_at)
+	VALUES ($1, $2, $3)
+	`
+	now := time.Now()
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(insertSQL, user.Username, codeHash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlUserStore) UseRecoveryCode(username, codeHash string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM recovery_codes WHERE username = $1 AND code_hash = $2`,
+		username, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *sqlUserStore) Close() error {
+	return s.db.Close()
+}
+
+func updateUser(db dbtx, user *User) error {
+	updateSQL := `
+	UPDATE users
+	SET password_hash = $1, totp_secret = $2, totp_enabled = $3, updated_at = $4
+	WHERE username = $5
+	`
+	_, err := db.Exec(updateSQL, user.PasswordHash, user.TOTPSecret, user.TOTPEnabled, user.UpdatedAt, user.Username)
+	return err
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/Broderick-Westrope/teatime/internal/migrate"
+)
+
+// NewSQLiteUserStore opens the SQLite database file and applies any pending migrations.
+// This allows the server to run without an external database.
+func NewSQLiteUserStore(dbPath string) (UserStore, error) {
+	// Foreign keys are enabled for each connection so that recovery codes are removed with their user.
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite databases are only used by a single server, so there is nothing to lock against.
+	err = migrate.LoadAndUp(db, migrationsFS, "migrations/sqlite", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return &sqlUserStore{
+		db: db,
+		isUniqueViolation: func(err error) bool {
+			var sqliteErr sqlite3.Error
+			return errors.As(err, &sqliteErr) &&
+				(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+					sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+		},
+	}, nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserStore persists user accounts and their recovery codes.
+type UserStore interface {
+	// InsertUser stores a new user. ErrAlreadyExists is returned if the username is
+	// already taken, including by a user whose username differs only in case.
+	InsertUser(user *User) error
+	// GetUser returns the user with the given username, or ErrNotFound.
+	GetUser(username string) (*User, error)
+	// UpdateUser replaces the password hash, TOTP fields and update time of an existing user.
+	UpdateUser(user *User) error
+	// DeleteUser removes the user and their recovery codes.
+	DeleteUser(username string) error
+
+	// EnableTOTP saves the user and replaces their recovery codes in a single operation
+	// so that TOTP is never enabled without the recovery codes being stored.
+	EnableTOTP(user *User, recoveryCodeHashes []string) error
+	// UseRecoveryCode deletes the recovery code so that it cannot be used again.
+	// It returns false if the user has no matching recovery code.
+	UseRecoveryCode(username, codeHash string) (bool, error)
+
+	// Ping checks that the store can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// SessionStore holds short-lived state which expires on its own: sessions, login
+// challenges, failed login counters and the messages queued for offline users.
+// Keys given to the store are opaque and must not be interpreted by implementations.
+type SessionStore interface {
+	// CreateSession stores a session for the user which expires after the ttl.
+	// The user's other sessions are left untouched.
+	CreateSession(ctx context.Context, sessionID, username string, ttl time.Duration) error
+	// GetSessionUsername returns the username for an active session, or ErrNotFound.
+	GetSessionUsername(ctx context.Context, sessionID string) (string, error)
+	// RotateSession atomically replaces the old session with the new one.
+	// ErrNotFound is returned if the old session does not exist and ErrSessionChanged
+	// is returned if it was modified by another request during the rotation.
+	RotateSession(ctx context.Context, oldSessionID, newSessionID string, ttl time.Duration) error
+	// DeleteSession removes a single session.
+	DeleteSession(ctx context.Context, sessionID string) error
+	// DeleteUserSessions removes every session belonging to the user.
+	DeleteUserSessions(ctx context.Context, username string) error
+
+	// SetValue stores the value under the key until the ttl elapses.
+	SetValue(ctx context.Context, key, value string, ttl time.Duration) error
+	// SetValueIfAbsent stores the value only if the key does not exist, reporting whether it was stored.
+	SetValueIfAbsent(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// GetValue returns the value stored under the key, or ErrNotFound.
+	GetValue(ctx context.Context, key string) (string, error)
+	// GetTTL returns how long until the key expires. Zero is returned if it does not exist.
+	GetTTL(ctx context.Context, key string) (time.Duration, error)
+	// Increment adds the delta to the counter under the key, resets its expiry to the ttl and returns the new count.
+	Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Delete removes the keys. Keys which do not exist are ignored.
+	Delete(ctx context.Context, keys ...string) error
+
+	// Push appends the entry to the list under the key, keeping only the newest maxLength
+	// entries, and resets the expiry of the list to the ttl.
+	Push(ctx context.Context, key string, entry []byte, maxLength int64, ttl time.Duration) error
+	// GetList returns every entry in the list under the key, oldest first.
+	GetList(ctx context.Context, key string) ([][]byte, error)
+	// TrimList removes the oldest count entries from the list under the key.
+	TrimList(ctx context.Context, key string, count int64) error
+	// RemoveFromList removes every entry in the list under the key for which remove returns true,
+	// and returns how many were removed. Entries pushed while this is running are kept.
+	RemoveFromList(ctx context.Context, key string, remove func(entry []byte) bool) (int, error)
+
+	// AddToSet adds the members to the set under the key and resets the expiry of the set to the ttl.
+	AddToSet(ctx context.Context, key string, ttl time.Duration, members ...string) error
+	// GetSet returns the members of the set under the key in no particular order.
+	GetSet(ctx context.Context, key string) ([]string, error)
+	// RemoveFromSet removes the members from the set under the key, leaving its expiry unchanged.
+	RemoveFromSet(ctx context.Context, key string, members ...string) error
+
+	// Ping checks that the store can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// AttachmentStore holds the content of uploaded attachments, which is too large to keep in the SessionStore.
+// The metadata of each attachment is kept in the SessionStore, which decides when the content expires.
+type AttachmentStore interface {
+	// SaveChunk stores part of the content of the attachment, replacing any chunk with the same index.
+	SaveChunk(ctx context.Context, attachmentID uuid.UUID, index int, data []byte) error
+	// GetChunk returns part of the content of the attachment, or ErrNotFound.
+	GetChunk(ctx context.Context, attachmentID uuid.UUID, index int) ([]byte, error)
+	// Delete removes all of the content of the attachment.
+	Delete(ctx context.Context, attachmentID uuid.UUID) error
+	// List returns the IDs of the attachments which have content stored, in no particular order.
+	List(ctx context.Context) ([]uuid.UUID, error)
+
+	// Ping checks that the store can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
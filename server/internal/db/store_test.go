@@ -0,0 +1,235 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+// newUserStores returns every UserStore which can run in-process.
+func newUserStores(t *testing.T) map[string]db.UserStore {
+	t.Helper()
+
+	sqliteStore, err := db.NewSQLiteUserStore(filepath.Join(t.TempDir(), "server.db"))
+	require.NoError(t, err)
+
+	stores := map[string]db.UserStore{
+		"memory": db.NewMemoryUserStore(),
+		"sqlite": sqliteStore,
+	}
+	for _, store := range stores {
+		t.Cleanup(func() { _ = store.Close() })
+	}
+	return stores
+}
+
+func TestUserStore(t *testing.T) {
+	for name, store := range newUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now().UTC().Truncate(time.Second)
+			user := &db.User{
+				Username:     "alice",
+				PasswordHash: "hash",
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			}
+			require.NoError(t, store.InsertUser(user))
+
+			err := store.InsertUser(&db.User{Username: "ALICE", PasswordHash: "hash", CreatedAt: now, UpdatedAt: now})
+			assert.ErrorIs(t, err, db.ErrAlreadyExists)
+
+			got, err := store.GetUser("alice")
+			require.NoError(t, err)
+			assert.Equal(t, "hash", got.PasswordHash)
+			assert.False(t, got.TOTPEnabled)
+
+			got.PasswordHash = "new-hash"
+			got.TOTPSecret = "secret"
+			require.NoError(t, store.UpdateUser(got))
+			got, err = store.GetUser("alice")
+			require.NoError(t, err)
+			assert.Equal(t, "new-hash", got.PasswordHash)
+			assert.Equal(t, "secret", got.TOTPSecret)
+
+			got.TOTPEnabled = true
+			require.NoError(t, store.EnableTOTP(got, []string{"code-1", "code-2"}))
+			got, err = store.GetUser("alice")
+			require.NoError(t, err)
+			assert.True(t, got.TOTPEnabled)
+
+			isUsed, err := store.UseRecoveryCode("alice", "code-1")
+			require.NoError(t, err)
+			assert.True(t, isUsed)
+			isUsed, err = store.UseRecoveryCode("alice", "code-1")
+			require.NoError(t, err)
+			assert.False(t, isUsed)
+
+			require.NoError(t, store.DeleteUser("alice"))
+			_, err = store.GetUser("alice")
+			assert.ErrorIs(t, err, db.ErrNotFound)
+			isUsed, err = store.UseRecoveryCode("alice", "code-2")
+			require.NoError(t, err)
+			assert.False(t, isUsed)
+		})
+	}
+}
+
+func TestMemorySessionStore_Sessions(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemorySessionStore()
+	t.Cleanup(func() { _ = store.Close() })
+
+	require.NoError(t, store.CreateSession(ctx, "session-1", "alice", time.Hour))
+	require.NoError(t, store.CreateSession(ctx, "session-2", "alice", time.Hour))
+	require.NoError(t, store.CreateSession(ctx, "expired", "alice", -time.Second))
+
+	username, err := store.GetSessionUsername(ctx, "session-1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	_, err = store.GetSessionUsername(ctx, "expired")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, store.RotateSession(ctx, "session-1", "session-3", time.Hour))
+	_, err = store.GetSessionUsername(ctx, "session-1")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	err = store.RotateSession(ctx, "session-1", "session-4", time.Hour)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, store.DeleteSession(ctx, "session-2"))
+	_, err = store.GetSessionUsername(ctx, "session-2")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, store.DeleteUserSessions(ctx, "alice"))
+	_, err = store.GetSessionUsername(ctx, "session-3")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestMemorySessionStore_Values(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemorySessionStore()
+	t.Cleanup(func() { _ = store.Close() })
+
+	isSet, err := store.SetValueIfAbsent(ctx, "key", "value", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, isSet)
+	isSet, err = store.SetValueIfAbsent(ctx, "key", "other", time.Hour)
+	require.NoError(t, err)
+	assert.False(t, isSet)
+
+	value, err := store.GetValue(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	ttl, err := store.GetTTL(ctx, "key")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, ttl, float64(time.Second))
+	ttl, err = store.GetTTL(ctx, "missing")
+	require.NoError(t, err)
+	assert.Zero(t, ttl)
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := store.Increment(ctx, "counter", 1, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, want, count)
+	}
+	count, err := store.Increment(ctx, "counter", -2, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	require.NoError(t, store.Delete(ctx, "key", "counter"))
+	_, err = store.GetValue(ctx, "key")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, store.SetValue(ctx, "expired", "value", -time.Second))
+	_, err = store.GetValue(ctx, "expired")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestMemorySessionStore_List(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemorySessionStore()
+	t.Cleanup(func() { _ = store.Close() })
+
+	for _, entry := range []string{"1", "2", "3"} {
+		require.NoError(t, store.Push(ctx, "list", []byte(entry), 2, time.Hour))
+	}
+
+	removed, err := store.RemoveFromList(ctx, "list", func(entry []byte) bool { return string(entry) == "2" })
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.NoError(t, store.Push(ctx, "list", []byte("4"), 2, time.Hour))
+
+	entries, err := store.GetList(ctx, "list")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("4")}, entries)
+
+	require.NoError(t, store.TrimList(ctx, "list", 1))
+	entries, err = store.GetList(ctx, "list")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("4")}, entries)
+
+	require.NoError(t, store.TrimList(ctx, "list", 1))
+	entries, err = store.GetList(ctx, "list")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMemorySessionStore_Set(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemorySessionStore()
+	t.Cleanup(func() { _ = store.Close() })
+
+	require.NoError(t, store.AddToSet(ctx, "set", time.Hour, "a", "b"))
+	require.NoError(t, store.AddToSet(ctx, "set", time.Hour, "b", "c"))
+
+	members, err := store.GetSet(ctx, "set")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, members)
+
+	require.NoError(t, store.RemoveFromSet(ctx, "set", "a", "d"))
+	require.NoError(t, store.RemoveFromSet(ctx, "missing", "a"))
+	members, err = store.GetSet(ctx, "set")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b", "c"}, members)
+
+	require.NoError(t, store.AddToSet(ctx, "expired", -time.Second, "a"))
+	members, err = store.GetSet(ctx, "expired")
+	require.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestFileAttachmentStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := db.NewFileAttachmentStore(filepath.Join(t.TempDir(), "attachments"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	require.NoError(t, store.Ping(ctx))
+
+	attachmentID := uuid.New()
+	_, err = store.GetChunk(ctx, attachmentID, 0)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	require.NoError(t, store.SaveChunk(ctx, attachmentID, 0, []byte("first")))
+	require.NoError(t, store.SaveChunk(ctx, attachmentID, 0, []byte("replaced")))
+	chunk, err := store.GetChunk(ctx, attachmentID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replaced"), chunk)
+
+	attachmentIDs, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{attachmentID}, attachmentIDs)
+
+	require.NoError(t, store.Delete(ctx, attachmentID))
+	_, err = store.GetChunk(ctx, attachmentID, 0)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	attachmentIDs, err = store.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, attachmentIDs)
+}
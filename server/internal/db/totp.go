@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+
+	"github.com/Broderick-Westrope/teatime/internal/secure"
+)
+
+const (
+	totpIssuer = "TeaTime"
+	// mfaChallengeExpiration is how long the user has to provide their second factor after their password.
+	mfaChallengeExpiration = 5 * time.Minute
+	// totpReplayWindow covers every period that a TOTP code is accepted for, including allowed clock skew.
+	totpReplayWindow  = 90 * time.Second
+	recoveryCodeCount = 10
+)
+
+// BeginTOTPEnrollment generates a new TOTP secret for the user and returns its otpauth:// URI.
+// The secret is not required at login until the enrollment has been confirmed.
+func (r *Repository) BeginTOTPEnrollment(username string) (string, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+	if user.TOTPEnabled {
+		return "", ErrTOTPAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: username,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate totp key: %w", err)
+	}
+
+	user.TOTPSecret = key.Secret()
+	user.UpdatedAt = time.Now()
+	err = r.users.UpdateUser(user)
+	if err != nil {
+		return "", err
+	}
+	return key.URL(), nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP for the user once they have proven that they can generate valid codes.
+// A new set of recovery codes is returned which can each be used once in place of a TOTP code.
+func (r *Repository) ConfirmTOTPEnrollment(username, code string) ([]string, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case user.TOTPEnabled:
+		return nil, ErrTOTPAlreadyEnabled
+	case user.TOTPSecret == "":
+		return nil, ErrTOTPNotEnrolled
+	case !totp.Validate(code, user.TOTPSecret):
+		return nil, ErrInvalidCode
+	}
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		recoveryCodes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+	}
+
+	codeHashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		codeHashes[i] = hashRecoveryCode(normaliseRecoveryCode(code))
+	}
+
+	user.TOTPEnabled = true
+	user.UpdatedAt = time.Now()
+	err = r.users.EnableTOTP(user, codeHashes)
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// IsTOTPEnabled reports whether the user must provide a second factor to log in.
+func (r *Repository) IsTOTPEnabled(username string) (bool, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return false, err
+	}
+	return user.TOTPEnabled, nil
+}
+
+// VerifySecondFactor checks the code against the user's TOTP secret and then their unused recovery codes.
+// TOTP codes cannot be reused and recovery codes are consumed when used.
+func (r *Repository) VerifySecondFactor(ctx context.Context, username, code string) (bool, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return false, err
+	}
+	if !user.TOTPEnabled {
+		return false, ErrTOTPNotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if totp.Validate(code, user.TOTPSecret) {
+		// Claim the code so that it cannot be replayed while it is still valid.
+		var isFirstUse bool
+		isFirstUse, err = r.sessions.SetValueIfAbsent(ctx, r.totpPrefix+username+":"+code, "1", totpReplayWindow)
+		if err != nil {
+			return false, err
+		}
+		return isFirstUse, nil
+	}
+
+	return r.users.UseRecoveryCode(username, hashRecoveryCode(normaliseRecoveryCode(code)))
+}
+
+// CreateMFAChallenge records that the user has provided a correct password and returns a
+// token which can be used to complete the login with a second factor.
+func (r *Repository) CreateMFAChallenge(ctx context.Context, username string) (string, error) {
+	token, err := secure.GenerateSessionID()
+	if err != nil {
+		return "", err
+	}
+	err = r.sessions.SetValue(ctx, r.mfaPrefix+token, username, mfaChallengeExpiration)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetMFAChallengeUsername returns the username which the MFA challenge token was issued to.
+func (r *Repository) GetMFAChallengeUsername(ctx context.Context, token string) (string, error) {
+	return r.sessions.GetValue(ctx, r.mfaPrefix+token)
+}
+
+// DeleteMFAChallenge removes the token so that it cannot be used again.
+func (r *Repository) DeleteMFAChallenge(ctx context.Context, token string) error {
+	return r.sessions.Delete(ctx, r.mfaPrefix+token)
+}
+
+// generateRecoveryCode returns a random code formatted as four groups of four characters, eg. ABCD-EFGH-IJKL-MNOP.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10) // 80 bits
+	_, err := crand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(b)
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// normaliseRecoveryCode removes formatting so that codes are accepted regardless of case and separators.
+func normaliseRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -1,9 +1,6 @@
 package db
 
 import (
-	"database/sql"
-	"errors"
-	"fmt"
 	"time"
 )
 
@@ -11,55 +8,10 @@ type User struct {
 	Username     string
 	PasswordHash string
 
+	// TOTPSecret is set once TOTP enrollment has begun, but is only required at login once TOTPEnabled is true.
+	TOTPSecret  string
+	TOTPEnabled bool
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
-
-func insertUser(db *sql.DB, user *User) error {
-	query := `
-	INSERT INTO users (username, password_hash, created_at, updated_at)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT(username) DO UPDATE SET
-		password_hash=excluded.password_hash,
-		updated_at=excluded.updated_at;
-	`
-	_, err := db.Exec(query, user.Username, user.PasswordHash, user.CreatedAt, user.UpdatedAt)
-	return err
-}
-
-func getUser(db *sql.DB, username string) (*User, error) {
-	query := `
-	SELECT username, password_hash, created_at, updated_at
-	FROM users
-	WHERE username = $1
-	`
-	row := db.QueryRow(query, username)
-
-	var result User
-	err := row.Scan(&result.Username, &result.PasswordHash, &result.CreatedAt, &result.UpdatedAt)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("%w: no user found with username %q: %w", ErrNotFound, username, err)
-		}
-		return nil, err
-	}
-	return &result, nil
-}
-
-//nolint:unused // will be used for changing password
-func updateUser(db *sql.DB, user *User) error {
-	updateSQL := `
-	UPDATE users
-	SET password_hash = $1, updated_at = $2
-	WHERE username = $3
-	`
-	_, err := db.Exec(updateSQL, user.PasswordHash, user.UpdatedAt, user.Username)
-	return err
-}
-
-//nolint:unused // will be used for deleting account
-func deleteUser(db *sql.DB, username string) error {
-	deleteSQL := `DELETE FROM users WHERE username = $1`
-	_, err := db.Exec(deleteSQL, username)
-	return err
-}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 
+	"github.com/Broderick-Westrope/teatime/internal/secure"
 	"github.com/Broderick-Westrope/teatime/internal/websocket"
+	"github.com/Broderick-Westrope/teatime/server/internal/cluster"
 	"github.com/Broderick-Westrope/teatime/server/internal/db"
 )
 
@@ -32,8 +41,20 @@ func run() int {
 		return 1
 	}
 
+	tlsConfig, err := app.newTLSConfig()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to setup TLS: %s", err)
+		return 1
+	}
+
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go app.pruneAttachments(ctx, wg)
+	if app.clusterMode {
+		wg.Add(1)
+		go app.runHub(ctx, wg)
+	}
 	server := &http.Server{
 		Addr:              app.serverAddr,
 		Handler:           app.setupRouter(ctx, wg),
@@ -42,37 +63,77 @@ func run() int {
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       30 * time.Second,
 		MaxHeaderBytes:    1 << 20, // 1 MB
+		TLSConfig:         tlsConfig,
 	}
 
 	go app.startServer(server)
-	app.handleShutdown(server, cancelCtx, wg)
+	metricsServer := app.newMetricsServer()
+	if metricsServer != nil {
+		go app.startMetricsServer(metricsServer)
+	}
+	if app.certReloader != nil {
+		go app.handleCertificateReload()
+	}
+	app.handleShutdown(server, metricsServer, cancelCtx, wg)
 	return 0
 }
 
 type application struct {
-	hub  *websocket.Hub
-	log  *slog.Logger
-	repo *db.Repository
+	hub     *websocket.Hub
+	log     *slog.Logger
+	repo    *db.Repository
+	metrics *metrics
+	// draining is set once shutdown starts so that the server reports it is no longer ready.
+	draining atomic.Bool
+
+	serverAddr     string
+	metricsAddr    string
+	userStore      string
+	sessionStore   string
+	redisAddr      string
+	dbConn         string
+	sqlitePath     string
+	attachmentDir  string
+	logLevel       slog.Level
+	queueTTL       time.Duration
+	queueMaxLength int64
+	// drainPeriod is how long the server keeps serving after it starts reporting that it is not ready,
+	// so that load balancers notice before the server stops accepting connections.
+	drainPeriod time.Duration
+
+	// clusterMode is true when several instances of the server share their WebSocket users through Redis.
+	clusterMode  bool
+	instanceID   string
+	clusterRedis *redis.Client
 
-	serverAddr string
-	redisAddr  string
-	dbConn     string
-	logLevel   slog.Level
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsClientCAFile string
+	tlsMinVersion   uint16
+	certReloader    *secure.CertificateReloader
+
+	// trustedProxies are the addresses of reverse proxies whose X-Forwarded-For header is trusted.
+	trustedProxies []netip.Prefix
 }
 
 func newApp() (*application, error) {
-	app := &application{
-		hub: websocket.NewHub(),
-	}
+	app := &application{}
 	err := app.loadEnvVars()
 	if err != nil {
 		return nil, err
 	}
 
-	app.repo, err = db.NewRepository(app.dbConn, app.redisAddr)
+	users, err := app.newUserStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup user store: %w", err)
+	}
+	attachments, err := db.NewFileAttachmentStore(app.attachmentDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to setup database repository: %w", err)
+		return nil, fmt.Errorf("failed to setup attachment store: %w", err)
 	}
+	app.metrics = newMetrics()
+	app.repo = db.NewRepository(users, app.newSessionStore(), attachments, app.queueTTL, app.queueMaxLength, app.metrics)
+	app.hub = websocket.NewHub(app.repo, app.metrics, app.newCluster())
 	app.log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: app.logLevel}))
 
 	return app, nil
@@ -88,12 +149,52 @@ func (app *application) loadEnvVars() error {
 	if app.serverAddr, found = os.LookupEnv("SERVER_ADDR"); !found {
 		return fmt.Errorf("SERVER_ADDR env variable is required")
 	}
-	if app.redisAddr, found = os.LookupEnv("REDIS_ADDR"); !found {
-		return fmt.Errorf("REDIS_ADDR env variable is required")
+
+	// Metrics are served separately from the API so that they are not exposed to clients.
+	app.metricsAddr = os.Getenv("METRICS_ADDR")
+
+	app.userStore = os.Getenv("USER_STORE")
+	switch app.userStore {
+	case "", userStorePostgres:
+		app.userStore = userStorePostgres
+		if app.dbConn, found = os.LookupEnv("DB_CONN"); !found {
+			return fmt.Errorf("DB_CONN env variable is required when USER_STORE is %q", userStorePostgres)
+		}
+	case userStoreSQLite:
+		if app.sqlitePath, found = os.LookupEnv("SQLITE_PATH"); !found {
+			return fmt.Errorf("SQLITE_PATH env variable is required when USER_STORE is %q", userStoreSQLite)
+		}
+	case userStoreMemory:
+	default:
+		return fmt.Errorf("USER_STORE env variable must be one of %q, %q or %q",
+			userStorePostgres, userStoreSQLite, userStoreMemory)
+	}
+
+	app.sessionStore = os.Getenv("SESSION_STORE")
+	switch app.sessionStore {
+	case "", sessionStoreRedis:
+		app.sessionStore = sessionStoreRedis
+		if app.redisAddr, found = os.LookupEnv("REDIS_ADDR"); !found {
+			return fmt.Errorf("REDIS_ADDR env variable is required when SESSION_STORE is %q", sessionStoreRedis)
+		}
+	case sessionStoreMemory:
+	default:
+		return fmt.Errorf("SESSION_STORE env variable must be one of %q or %q", sessionStoreRedis, sessionStoreMemory)
+	}
+
+	app.drainPeriod = 5 * time.Second
+	if drainPeriod := os.Getenv("DRAIN_PERIOD"); drainPeriod != "" {
+		app.drainPeriod, err = time.ParseDuration(drainPeriod)
+		if err != nil || app.drainPeriod < 0 {
+			return fmt.Errorf("DRAIN_PERIOD env variable must be a non-negative duration")
+		}
 	}
-	if app.dbConn, found = os.LookupEnv("DB_CONN"); !found {
-		return fmt.Errorf("DB_CONN env variable is required")
+
+	app.attachmentDir = os.Getenv("ATTACHMENT_DIR")
+	if app.attachmentDir == "" {
+		app.attachmentDir = "attachments"
 	}
+
 	logLevel := os.Getenv("LOG_LEVEL")
 	switch logLevel {
 	case "":
@@ -106,38 +207,310 @@ func (app *application) loadEnvVars() error {
 		}
 		app.logLevel = slog.Level(intLevel)
 	}
+
+	app.queueTTL = 7 * 24 * time.Hour
+	if queueTTL := os.Getenv("QUEUE_TTL"); queueTTL != "" {
+		app.queueTTL, err = time.ParseDuration(queueTTL)
+		if err != nil || app.queueTTL <= 0 {
+			return fmt.Errorf("QUEUE_TTL env variable must be a positive duration")
+		}
+	}
+	app.queueMaxLength = 1000
+	if queueMaxLength := os.Getenv("QUEUE_MAX_LENGTH"); queueMaxLength != "" {
+		app.queueMaxLength, err = strconv.ParseInt(queueMaxLength, 10, 64)
+		if err != nil || app.queueMaxLength <= 0 {
+			return fmt.Errorf("QUEUE_MAX_LENGTH env variable must be a positive integer")
+		}
+	}
+
+	if clusterMode := os.Getenv("CLUSTER_MODE"); clusterMode != "" {
+		app.clusterMode, err = strconv.ParseBool(clusterMode)
+		if err != nil {
+			return fmt.Errorf("CLUSTER_MODE env variable must be a boolean")
+		}
+	}
+	if app.clusterMode {
+		if app.sessionStore != sessionStoreRedis {
+			return fmt.Errorf("SESSION_STORE env variable must be %q when CLUSTER_MODE is enabled", sessionStoreRedis)
+		}
+		if app.userStore != userStorePostgres {
+			return fmt.Errorf("USER_STORE env variable must be %q when CLUSTER_MODE is enabled", userStorePostgres)
+		}
+		app.instanceID = os.Getenv("INSTANCE_ID")
+		if app.instanceID == "" {
+			app.instanceID, err = generateInstanceID()
+			if err != nil {
+				return fmt.Errorf("failed to generate instance ID: %w", err)
+			}
+		}
+	}
+
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		app.trustedProxies, err = parseTrustedProxies(trustedProxies)
+		if err != nil {
+			return fmt.Errorf("TRUSTED_PROXIES env variable must be a comma separated list of IP addresses "+
+				"and CIDR ranges: %w", err)
+		}
+	}
+
+	app.tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	app.tlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (app.tlsCertFile == "") != (app.tlsKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE env variables must be set together")
+	}
+	app.tlsClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	if app.tlsClientCAFile != "" && app.tlsCertFile == "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE env variable requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	app.tlsMinVersion = tls.VersionTLS12
+	if tlsMinVersion := os.Getenv("TLS_MIN_VERSION"); tlsMinVersion != "" {
+		app.tlsMinVersion, err = secure.ParseTLSVersion(tlsMinVersion)
+		if err != nil {
+			return fmt.Errorf("TLS_MIN_VERSION env variable is invalid: %w", err)
+		}
+	}
 	return nil
 }
 
+// newTLSConfig returns the TLS config for the server, or nil if TLS is not configured.
+// When a client CA is configured, clients must present a certificate signed by it.
+func (app *application) newTLSConfig() (*tls.Config, error) {
+	if app.tlsCertFile == "" {
+		return nil, nil
+	}
+
+	var err error
+	app.certReloader, err = secure.NewCertificateReloader(app.tlsCertFile, app.tlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     app.tlsMinVersion,
+		GetCertificate: app.certReloader.GetCertificate,
+	}
+	if app.tlsClientCAFile != "" {
+		pem, err := os.ReadFile(app.tlsClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %q", app.tlsClientCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+const (
+	userStorePostgres  = "postgres"
+	userStoreSQLite    = "sqlite"
+	userStoreMemory    = "memory"
+	sessionStoreRedis  = "redis"
+	sessionStoreMemory = "memory"
+	// attachmentStoreFile is the only kind of attachment store, which is reported by the readiness check.
+	attachmentStoreFile = "file"
+)
+
+func (app *application) newUserStore() (db.UserStore, error) {
+	switch app.userStore {
+	case userStoreSQLite:
+		return db.NewSQLiteUserStore(app.sqlitePath)
+	case userStoreMemory:
+		return db.NewMemoryUserStore(), nil
+	default:
+		return db.NewPostgresUserStore(app.dbConn)
+	}
+}
+
+func (app *application) newSessionStore() db.SessionStore {
+	switch app.sessionStore {
+	case sessionStoreMemory:
+		return db.NewMemorySessionStore()
+	default:
+		return db.NewRedisSessionStore(app.redisAddr)
+	}
+}
+
+// newCluster returns the cluster for the hub, or nil if cluster mode is disabled.
+func (app *application) newCluster() *websocket.Cluster {
+	if !app.clusterMode {
+		return nil
+	}
+
+	app.clusterRedis = redis.NewClient(&redis.Options{Addr: app.redisAddr})
+	return &websocket.Cluster{
+		InstanceID:        app.instanceID,
+		Broker:            cluster.NewRedisBroker(app.clusterRedis),
+		Presence:          cluster.NewRedisPresence(app.clusterRedis, presenceTTL),
+		HeartbeatInterval: presenceTTL / 3,
+	}
+}
+
+// presenceTTL is how long other instances consider a user connected to this instance without a heartbeat.
+const presenceTTL = 30 * time.Second
+
+// generateInstanceID returns an ID based on the hostname, with a random suffix so that
+// instances sharing a hostname do not collide.
+func generateInstanceID() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return hostname + "-" + hex.EncodeToString(suffix), nil
+}
+
+// runHub relays messages between this instance and the rest of the cluster until the context is done.
+// If the connection to the broker fails then it is retried after a delay.
+func (app *application) runHub(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		err := app.hub.Run(ctx, func(err error) {
+			app.log.ErrorContext(ctx, "hub error", slog.Any("error", err))
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		app.log.ErrorContext(ctx, "hub stopped relaying messages between instances, retrying",
+			slog.Any("error", err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// attachmentPruneInterval is how often the content of expired attachments is deleted.
+const attachmentPruneInterval = time.Hour
+
+// pruneAttachments periodically deletes the content of expired attachments until the context is done.
+func (app *application) pruneAttachments(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(attachmentPruneInterval)
+	defer ticker.Stop()
+	for {
+		pruned, err := app.repo.PruneAttachments(ctx)
+		if err != nil && ctx.Err() == nil {
+			app.log.ErrorContext(ctx, "failed to prune attachments", slog.Any("error", err))
+		} else if pruned > 0 {
+			app.log.InfoContext(ctx, "pruned expired attachments", slog.Int("count", pruned))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (app *application) setupRouter(ctx context.Context, wg *sync.WaitGroup) chi.Router {
 	r := chi.NewRouter().With(app.loggerMiddleware(), middleware.Recoverer)
 
-	r.Route("/auth", func(r chi.Router) {
-		r.Get("/signup", app.handleSignup())
-		r.Get("/login", app.handleLogin())
-		r.With(app.authMiddleware()).Get("/logout", app.handleLogout())
-	})
+	r.Get("/healthz", app.handleLiveness())
+	r.Get("/readyz", app.handleReadiness())
+
+	r.Route("/v1", func(r chi.Router) {
+		r.Route("/auth", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireJSONMiddleware())
+				r.Post("/signup", app.handleSignup())
+				r.Post("/login", app.handleLogin())
+				r.Post("/login/totp", app.handleLoginTOTP())
+			})
 
-	r.With(app.authMiddleware()).Get("/ws", app.handleWebSocket(ctx, wg))
+			r.Group(func(r chi.Router) {
+				r.Use(app.authMiddleware())
+				r.Post("/logout", app.handleLogout())
+				r.Post("/refresh", app.handleRefresh())
+				r.Post("/totp/enroll", app.handleEnrollTOTP())
+				r.With(app.requireJSONMiddleware()).Post("/password", app.handleChangePassword())
+				r.With(app.requireJSONMiddleware()).Post("/account/delete", app.handleDeleteAccount())
+				r.With(app.requireJSONMiddleware()).Post("/totp/confirm", app.handleConfirmTOTP())
+			})
+		})
+
+		r.With(app.authMiddleware()).Get("/ws", app.handleWebSocket(ctx, wg))
+	})
 
 	return r
 }
 
 func (app *application) startServer(server *http.Server) {
-	app.log.Info("starting server", slog.String("addr", server.Addr))
-	err := server.ListenAndServe()
+	app.log.Info("starting server", slog.String("addr", server.Addr), slog.Bool("tls", server.TLSConfig != nil))
+	var err error
+	if server.TLSConfig != nil {
+		// The certificate is provided by the TLS config so that it can be reloaded.
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		app.log.Error("HTTP server failed", slog.Any("error", err))
 	}
 	app.log.Info("stopped serving new connections", slog.Any("error", err))
 }
 
-func (app *application) handleShutdown(server *http.Server, cancelCtx context.CancelFunc, wg *sync.WaitGroup) {
+// newMetricsServer returns the server for the metrics endpoint, or nil if METRICS_ADDR is not set.
+func (app *application) newMetricsServer() *http.Server {
+	if app.metricsAddr == "" {
+		return nil
+	}
+
+	r := chi.NewRouter()
+	r.Handle("/metrics", app.metrics.handler())
+	return &http.Server{
+		Addr:              app.metricsAddr,
+		Handler:           r,
+		ReadHeaderTimeout: 3 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+}
+
+func (app *application) startMetricsServer(server *http.Server) {
+	app.log.Info("starting metrics server", slog.String("addr", server.Addr))
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		app.log.Error("metrics server failed", slog.Any("error", err))
+	}
+}
+
+// handleCertificateReload reloads the TLS certificate whenever the process receives SIGHUP.
+func (app *application) handleCertificateReload() {
+	reloadSigCh := make(chan os.Signal, 1)
+	signal.Notify(reloadSigCh, syscall.SIGHUP)
+
+	for range reloadSigCh {
+		err := app.certReloader.Reload()
+		if err != nil {
+			app.log.Error("failed to reload TLS certificate, continuing with the previous certificate",
+				slog.Any("error", err))
+			continue
+		}
+		app.log.Info("reloaded TLS certificate")
+	}
+}
+
+func (app *application) handleShutdown(
+	server, metricsServer *http.Server, cancelCtx context.CancelFunc, wg *sync.WaitGroup,
+) {
 	shutdownSigCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownSigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-shutdownSigCh
 
-	app.log.Info("shutdown signal received")
+	app.log.Info("shutdown signal received, draining", slog.Duration("drain_period", app.drainPeriod))
+	app.draining.Store(true)
+	time.Sleep(app.drainPeriod)
 	cancelCtx()
 	wg.Wait()
 
@@ -148,5 +521,19 @@ func (app *application) handleShutdown(server *http.Server, cancelCtx context.Ca
 		app.log.Error("failed to shutdown HTTP server", slog.Any("error", err))
 		return
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			app.log.Error("failed to shutdown metrics server", slog.Any("error", err))
+		}
+	}
+	if app.clusterRedis != nil {
+		if err := app.clusterRedis.Close(); err != nil {
+			app.log.Error("failed to close cluster Redis client", slog.Any("error", err))
+		}
+	}
+	if err := app.repo.Close(); err != nil {
+		app.log.Error("failed to close database repository", slog.Any("error", err))
+		return
+	}
 	app.log.Info("graceful shutdown complete")
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+// newTestApp creates an application which keeps everything in memory, and attachments in a temporary directory.
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{
+		log:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:       newMetrics(),
+		userStore:     userStoreMemory,
+		sessionStore:  sessionStoreMemory,
+		attachmentDir: t.TempDir(),
+	}
+	attachments, err := db.NewFileAttachmentStore(app.attachmentDir)
+	require.NoError(t, err)
+	app.repo = db.NewRepository(db.NewMemoryUserStore(), db.NewMemorySessionStore(), attachments,
+		time.Hour, 10, app.metrics)
+	app.hub = websocket.NewHub(app.repo, app.metrics, nil)
+	t.Cleanup(func() { _ = app.repo.Close() })
+	return app
+}
+
+// serveApp serves the routes of the application and returns the URL of the server.
+// WebSocket connections are left for the test to close, rather than being closed by the server.
+func serveApp(t *testing.T, app *application) string {
+	t.Helper()
+
+	srv := httptest.NewServer(app.setupRouter(context.Background(), &sync.WaitGroup{}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// signIn creates the user and returns the ID of a new session for them.
+func signIn(t *testing.T, app *application, username string) string {
+	t.Helper()
+
+	require.NoError(t, app.repo.CreateUser(username, "password"))
+	sessionID, err := app.repo.GetNewSessionID(context.Background(), username)
+	require.NoError(t, err)
+	return sessionID
+}
+
+// dialWebSocket connects to the WebSocket of the server at the URL using the session.
+func dialWebSocket(t *testing.T, url, sessionID string) *gws.Conn {
+	t.Helper()
+
+	header := http.Header{}
+	header.Set("Cookie", (&http.Cookie{Name: "session_id", Value: sessionID}).String())
+	conn, resp, err := gws.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/v1/ws", header)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// request sends the message over the connection and returns the reply to it.
+func request(t *testing.T, conn *gws.Conn, msg websocket.Msg) websocket.Msg {
+	t.Helper()
+
+	msgData, err := json.Marshal(msg)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(gws.TextMessage, msgData))
+
+	reply, ok := readMsg(t, conn, 5*time.Second)
+	require.True(t, ok, "no reply to request")
+	require.Equal(t, msg.RequestID, reply.RequestID)
+	return reply
+}
+
+// readMsg returns the next message received on the connection, or false if none arrives within the timeout.
+func readMsg(t *testing.T, conn *gws.Conn, timeout time.Duration) (websocket.Msg, bool) {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+	_, msgData, err := conn.ReadMessage()
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return websocket.Msg{}, false
+	}
+	require.NoError(t, err)
+
+	var msg websocket.Msg
+	require.NoError(t, json.Unmarshal(msgData, &msg))
+	return msg, true
+}
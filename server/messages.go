@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+// maxReactionLength is the most bytes a reaction may take up. It allows for emoji made up of several code points.
+const maxReactionLength = 32
+
+// relayChatMessage sends the chat message to its recipients, queuing it for any who are offline.
+// The author of messages with an ID is recorded so that only they can change the message later.
+// Messages with an attachment are only sent once it has been uploaded, and the recipients are then allowed to download it.
+func (app *application) relayChatMessage(
+	ctx context.Context, username string, payload websocket.PayloadSendChatMessage, msgData []byte,
+) error {
+	if payload.Message.Author != username {
+		return &entity.APIError{Code: entity.ErrorCodeForbidden, Message: "messages can only be sent as yourself"}
+	}
+
+	if payload.Message.Attachment != nil {
+		err := app.checkAttachmentUploaded(ctx, username, *payload.Message.Attachment)
+		if err != nil {
+			return err
+		}
+		err = app.repo.GrantAttachmentAccess(ctx, payload.Message.Attachment.ID, payload.Recipients...)
+		if err != nil {
+			return fmt.Errorf("failed to grant attachment access: %w", err)
+		}
+	}
+
+	if payload.Message.ID != uuid.Nil {
+		err := app.repo.RecordMessageAuthor(ctx,
+			payload.ConversationMD.ID.String(), payload.Message.ID.String(), username)
+		if errors.Is(err, db.ErrAlreadyExists) {
+			return &entity.APIError{Code: entity.ErrorCodeForbidden, Message: "message ID is already in use"}
+		} else if err != nil {
+			return fmt.Errorf("failed to record message author: %w", err)
+		}
+	}
+
+	err := app.repo.RecordContacts(ctx, username, payload.Recipients)
+	if err != nil {
+		return fmt.Errorf("failed to record contacts: %w", err)
+	}
+
+	app.log.DebugContext(ctx, "sending message",
+		slog.Any("recipients", payload.Recipients))
+	err = app.hub.Send(ctx, msgData, payload.Recipients)
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// relayEdit sends the new content of a chat message to its recipients, queuing it for any who are offline.
+// Only the author of the message may edit it.
+func (app *application) relayEdit(ctx context.Context, username string, payload websocket.PayloadEditMessage) error {
+	err := app.checkMessageAuthor(ctx, username, payload.ConversationID, payload.MessageID, "edit")
+	if err != nil {
+		return err
+	}
+
+	// The username is set here so that recipients can check it against the author of their copy of the message.
+	recipients := payload.Recipients
+	payload.Username = username
+	payload.Recipients = nil
+	msgData, err := json.Marshal(websocket.Msg{Type: websocket.MsgTypeEditMessage, Payload: payload})
+	if err != nil {
+		return err
+	}
+	err = app.hub.Send(ctx, msgData, recipients)
+	if err != nil {
+		return fmt.Errorf("failed to send edit: %w", err)
+	}
+	return nil
+}
+
+// relayDelete deletes a chat message for its recipients. Only the author of the message may delete it.
+// Copies of the message, and edits to it, which are still queued for offline recipients are removed,
+// and the deletion is queued in their place so that recipients who already have the message remove it too.
+func (app *application) relayDelete(ctx context.Context, username string, payload websocket.PayloadDeleteMessage) error {
+	err := app.checkMessageAuthor(ctx, username, payload.ConversationID, payload.MessageID, "delete")
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range payload.Recipients {
+		removed, err := app.repo.RemoveQueuedMessages(ctx, recipient, func(message []byte) bool {
+			return isQueuedCopyOf(message, payload.ConversationID, payload.MessageID)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove queued messages: %w", err)
+		}
+		if removed > 0 {
+			app.log.DebugContext(ctx, "removed queued copies of deleted message",
+				slog.String("recipient", recipient), slog.Int("removed", removed))
+		}
+	}
+
+	// The username is set here so that recipients can check it against the author of their copy of the message.
+	recipients := payload.Recipients
+	payload.Username = username
+	payload.Recipients = nil
+	msgData, err := json.Marshal(websocket.Msg{Type: websocket.MsgTypeDeleteMessage, Payload: payload})
+	if err != nil {
+		return err
+	}
+	err = app.hub.Send(ctx, msgData, recipients)
+	if err != nil {
+		return fmt.Errorf("failed to send deletion: %w", err)
+	}
+	return nil
+}
+
+// relayReaction sends a reaction to a chat message to its recipients, queuing it for any who are offline.
+// Only the author of a message which the server has relayed, and users who have messaged each other with
+// the author, may react to it. The reaction is only sent to recipients who have messaged each other with the user.
+func (app *application) relayReaction(ctx context.Context, username string, payload websocket.PayloadReaction) error {
+	if payload.Emoji == "" || len(payload.Emoji) > maxReactionLength {
+		return &entity.APIError{Code: entity.ErrorCodeInvalidRequest, Message: "reaction must be a single emoji"}
+	}
+
+	author, err := app.repo.GetMessageAuthor(ctx, payload.ConversationID.String(), payload.MessageID.String())
+	if errors.Is(err, db.ErrNotFound) {
+		return &entity.APIError{Code: entity.ErrorCodeNotFound, Message: "message is unknown or too old to react to"}
+	} else if err != nil {
+		return fmt.Errorf("failed to get message author: %w", err)
+	}
+	if author != username {
+		var contacts []string
+		contacts, err = app.repo.GetMutualContacts(ctx, username, []string{author})
+		if err != nil {
+			return fmt.Errorf("failed to get contacts: %w", err)
+		}
+		if len(contacts) == 0 {
+			return &entity.APIError{
+				Code:    entity.ErrorCodeForbidden,
+				Message: "only the author of a message and their contacts may react to it",
+			}
+		}
+	}
+
+	recipients, err := app.repo.GetMutualContacts(ctx, username, payload.Recipients)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	// The username is set here so that users cannot react on behalf of someone else.
+	payload.Username = username
+	payload.Recipients = nil
+	msgData, err := json.Marshal(websocket.Msg{Type: websocket.MsgTypeReaction, Payload: payload})
+	if err != nil {
+		return err
+	}
+	err = app.hub.Send(ctx, msgData, recipients)
+	if err != nil {
+		return fmt.Errorf("failed to send reaction: %w", err)
+	}
+	return nil
+}
+
+// checkMessageAuthor returns an API error unless the user is the recorded author of the message.
+// The action is used to describe what the user tried to do in the error message.
+func (app *application) checkMessageAuthor(
+	ctx context.Context, username string, conversationID, messageID uuid.UUID, action string,
+) error {
+	author, err := app.repo.GetMessageAuthor(ctx, conversationID.String(), messageID.String())
+	if errors.Is(err, db.ErrNotFound) {
+		return &entity.APIError{
+			Code:    entity.ErrorCodeNotFound,
+			Message: fmt.Sprintf("message is unknown or too old to %s", action),
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get message author: %w", err)
+	}
+	if author != username {
+		return &entity.APIError{
+			Code:    entity.ErrorCodeForbidden,
+			Message: fmt.Sprintf("only the author of a message may %s it", action),
+		}
+	}
+	return nil
+}
+
+// isQueuedCopyOf reports whether the queued message is the given chat message, or an edit or reaction to it.
+func isQueuedCopyOf(message []byte, conversationID, messageID uuid.UUID) bool {
+	var msg websocket.Msg
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return false
+	}
+
+	switch payload := msg.Payload.(type) {
+	case websocket.PayloadSendChatMessage:
+		return payload.ConversationMD.ID == conversationID && payload.Message.ID == messageID
+	case websocket.PayloadEditMessage:
+		return payload.ConversationID == conversationID && payload.MessageID == messageID
+	case websocket.PayloadReaction:
+		return payload.ConversationID == conversationID && payload.MessageID == messageID
+	default:
+		return false
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	gws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+func TestRelayReaction(t *testing.T) {
+	conversationID := uuid.New()
+	messageID := uuid.New()
+
+	tt := map[string]struct {
+		sender     string
+		messageID  uuid.UUID
+		recipients []string
+		// wantCode is the code of the error sent in reply, or empty if the request should be acknowledged.
+		wantCode entity.ErrorCode
+		// wantReceived is whether each of the other users should be sent the reaction.
+		wantReceived map[string]bool
+	}{
+		"author": {
+			sender:       "alice",
+			messageID:    messageID,
+			recipients:   []string{"bob"},
+			wantReceived: map[string]bool{"bob": true, "carol": false},
+		},
+		"contact of the author": {
+			sender:       "bob",
+			messageID:    messageID,
+			recipients:   []string{"alice", "carol"},
+			wantReceived: map[string]bool{"alice": true, "carol": false},
+		},
+		"not a contact of the author": {
+			sender:       "carol",
+			messageID:    messageID,
+			recipients:   []string{"alice", "bob"},
+			wantCode:     entity.ErrorCodeForbidden,
+			wantReceived: map[string]bool{"alice": false, "bob": false},
+		},
+		"unknown message": {
+			sender:       "alice",
+			messageID:    uuid.New(),
+			recipients:   []string{"bob"},
+			wantCode:     entity.ErrorCodeNotFound,
+			wantReceived: map[string]bool{"bob": false, "carol": false},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			app := newTestApp(t)
+			url := serveApp(t, app)
+
+			// Alice and bob have messaged each other, while carol has only messaged alice.
+			require.NoError(t, app.repo.RecordContacts(ctx, "alice", []string{"bob"}))
+			require.NoError(t, app.repo.RecordContacts(ctx, "bob", []string{"alice"}))
+			require.NoError(t, app.repo.RecordContacts(ctx, "carol", []string{"alice"}))
+			require.NoError(t, app.repo.RecordMessageAuthor(ctx, conversationID.String(), messageID.String(), "alice"))
+
+			sender := dialWebSocket(t, url, signIn(t, app, tc.sender))
+			others := make(map[string]*gws.Conn, len(tc.wantReceived))
+			for username := range tc.wantReceived {
+				others[username] = dialWebSocket(t, url, signIn(t, app, username))
+			}
+
+			reply := request(t, sender, websocket.Msg{
+				Type:      websocket.MsgTypeReaction,
+				RequestID: "reaction",
+				Payload: websocket.PayloadReaction{
+					ConversationID: conversationID,
+					MessageID:      tc.messageID,
+					Emoji:          "👍",
+					Reacted:        true,
+					Recipients:     tc.recipients,
+				},
+			})
+			if tc.wantCode != "" {
+				require.Equal(t, websocket.MsgTypeError, reply.Type)
+				assert.Equal(t, tc.wantCode, reply.Payload.(websocket.PayloadError).Code)
+			} else {
+				assert.Equal(t, websocket.MsgTypeAck, reply.Type)
+			}
+
+			for username, wantReceived := range tc.wantReceived {
+				msg, ok := readMsg(t, others[username], 100*time.Millisecond)
+				require.Equal(t, wantReceived, ok, username)
+				if ok {
+					require.Equal(t, websocket.MsgTypeReaction, msg.Type)
+					assert.Equal(t, tc.sender, msg.Payload.(websocket.PayloadReaction).Username)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "teatime"
+
+// Outcomes of an authentication attempt, used as the outcome label of the auth attempts counter.
+const (
+	authOutcomeSuccess      = "success"
+	authOutcomeFailure      = "failure"
+	authOutcomeTOTPRequired = "totp_required"
+	authOutcomeLockedOut    = "locked_out"
+	authOutcomeInvalid      = "invalid_request"
+	authOutcomeError        = "error"
+)
+
+// metrics holds the Prometheus collectors for the server.
+// It implements both websocket.HubMetrics and db.Metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	connectedClients  prometheus.Gauge
+	messagesRelayed   prometheus.Counter
+	messagesOffline   *prometheus.CounterVec
+	websocketErrors   *prometheus.CounterVec
+	authAttempts      *prometheus.CounterVec
+	passwordHashTime  *prometheus.HistogramVec
+	storeCallDuration *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		connectedClients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "hub_connected_clients",
+			Help:      "Number of WebSocket connections currently in the hub.",
+		}),
+		messagesRelayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hub_messages_relayed_total",
+			Help:      "Number of messages written to recipient connections.",
+		}),
+		messagesOffline: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hub_messages_offline_total",
+			Help:      "Number of messages for offline recipients, by whether they were queued or dropped.",
+		}, []string{"outcome"}),
+		websocketErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_errors_total",
+			Help:      "Number of WebSocket read and write errors.",
+		}, []string{"op"}),
+		authAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "auth_attempts_total",
+			Help:      "Number of authentication attempts, by endpoint and outcome.",
+		}, []string{"endpoint", "outcome"}),
+		passwordHashTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "argon2_duration_seconds",
+			Help:      "Time spent hashing and verifying passwords with Argon2.",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"op"}),
+		storeCallDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_call_duration_seconds",
+			Help:      "Latency of calls to the user store (Postgres/SQLite), session store (Redis) and attachment store.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"store", "op"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connectedClients,
+		m.messagesRelayed,
+		m.messagesOffline,
+		m.websocketErrors,
+		m.authAttempts,
+		m.passwordHashTime,
+		m.storeCallDuration,
+	)
+	return m
+}
+
+// handler serves the metrics in the Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) recordAuthAttempt(endpoint, outcome string) {
+	m.authAttempts.WithLabelValues(endpoint, outcome).Inc()
+}
+
+func (m *metrics) ReadError() {
+	m.websocketErrors.WithLabelValues("read").Inc()
+}
+
+// websocket.HubMetrics ------------------------------
+
+func (m *metrics) ClientConnected() {
+	m.connectedClients.Inc()
+}
+
+func (m *metrics) ClientDisconnected() {
+	m.connectedClients.Dec()
+}
+
+func (m *metrics) MessageRelayed() {
+	m.messagesRelayed.Inc()
+}
+
+func (m *metrics) MessageOffline(queued bool) {
+	outcome := "dropped"
+	if queued {
+		outcome = "queued"
+	}
+	m.messagesOffline.WithLabelValues(outcome).Inc()
+}
+
+func (m *metrics) WriteError() {
+	m.websocketErrors.WithLabelValues("write").Inc()
+}
+
+// db.Metrics ------------------------------
+
+func (m *metrics) ObservePasswordHash(op string, duration time.Duration) {
+	m.passwordHashTime.WithLabelValues(op).Observe(duration.Seconds())
+}
+
+func (m *metrics) ObserveStoreCall(store, op string, duration time.Duration) {
+	m.storeCallDuration.WithLabelValues(store, op).Observe(duration.Seconds())
+}
@@ -4,16 +4,20 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"mime"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
 )
 
 type ctxKey int
 
 const (
 	ctxKeyUsername ctxKey = iota
+	ctxKeySessionID
 )
 
 // Auth ------------------------------
@@ -25,28 +29,49 @@ func (app *application) authMiddleware() func(http.Handler) http.Handler {
 			if err != nil {
 				if errors.Is(err, http.ErrNoCookie) {
 					app.log.Debug("session ID cookie not found", slog.Any("error", err))
-					http.Error(w, "Session ID is required", http.StatusBadRequest)
+					app.writeError(r.Context(), w, http.StatusUnauthorized, entity.ErrorCodeUnauthenticated,
+						"Session ID is required")
 					return
 				}
 
 				app.log.Debug("failed to get session ID cookie", slog.Any("error", err))
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				app.writeError(r.Context(), w, http.StatusUnauthorized, entity.ErrorCodeUnauthenticated,
+					"Session is invalid or has expired")
 				return
 			}
 
 			username, err := app.repo.GetUsernameWithSessionID(r.Context(), cookie.Value)
 			if err != nil {
 				app.log.Debug("failed to get username with session ID", slog.Any("error", err))
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				app.writeError(r.Context(), w, http.StatusUnauthorized, entity.ErrorCodeUnauthenticated,
+					"Session is invalid or has expired")
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), ctxKeyUsername, username)
+			ctx = context.WithValue(ctx, ctxKeySessionID, cookie.Value)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// Content Type ------------------------------
+
+// requireJSONMiddleware rejects requests whose body is not declared as JSON.
+func (app *application) requireJSONMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				app.writeError(r.Context(), w, http.StatusUnsupportedMediaType, entity.ErrorCodeUnsupportedMediaType,
+					"Content-Type must be application/json")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Logging ------------------------------
 
 type slogFormatter struct {
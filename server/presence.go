@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// broadcastPresence tells everyone watching the user that they have connected or disconnected.
+// Disconnects are only broadcast once the user has no connections left on any instance.
+func (app *application) broadcastPresence(ctx context.Context, username string, online bool) error {
+	presence := entity.Presence{Username: username, Online: online}
+	if !online {
+		isOnline, err := app.hub.IsOnline(ctx, username)
+		if err != nil {
+			return err
+		}
+		if isOnline {
+			return nil
+		}
+
+		presence.LastSeen = time.Now()
+		err = app.repo.SetLastSeen(ctx, username, presence.LastSeen)
+		if err != nil {
+			return fmt.Errorf("failed to set last seen: %w", err)
+		}
+	}
+
+	watchers, err := app.repo.GetPresenceWatchers(ctx, username)
+	if err != nil {
+		return fmt.Errorf("failed to get presence watchers: %w", err)
+	}
+	// Watchers are checked again in case they have stopped being contacts since they started watching.
+	watchers, err = app.repo.GetMutualContacts(ctx, username, watchers)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+	if len(watchers) == 0 {
+		return nil
+	}
+
+	return app.relayEphemeral(ctx, websocket.MsgTypePresence,
+		websocket.PayloadPresence{Users: []entity.Presence{presence}}, watchers)
+}
+
+// relayEphemeral sends the payload to the recipients who are connected, without queuing it for the others.
+func (app *application) relayEphemeral(
+	ctx context.Context, msgType websocket.MsgType, payload websocket.MsgPayload, recipients []string,
+) error {
+	msgData, err := json.Marshal(websocket.Msg{Type: msgType, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return app.hub.SendEphemeral(ctx, msgData, recipients)
+}
+
+// relayTyping tells the connected recipients that the user has started or stopped typing. Like presence,
+// this is only shared between users who have messaged each other, so any other recipients are skipped.
+func (app *application) relayTyping(ctx context.Context, username string, payload websocket.PayloadTyping) error {
+	if len(payload.Recipients) > websocket.MaxTypingRecipients {
+		return &entity.APIError{
+			Code:    entity.ErrorCodeInvalidRequest,
+			Message: fmt.Sprintf("at most %d users can be told about typing at once", websocket.MaxTypingRecipients),
+		}
+	}
+
+	recipients, err := app.repo.GetMutualContacts(ctx, username, payload.Recipients)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	// The username is set here so that users cannot claim that someone else is typing.
+	payload.Username = username
+	payload.Recipients = nil
+	err = app.relayEphemeral(ctx, websocket.MsgTypeTyping, payload, recipients)
+	if err != nil {
+		return fmt.Errorf("failed to relay typing indicator: %w", err)
+	}
+	return nil
+}
+
+// answerPresenceQuery replies to the connection with the presence of each user in the query,
+// and subscribes the querying user to any changes in their presence. Presence is only shared between
+// users who have messaged each other, so the others are reported as offline and never seen.
+func (app *application) answerPresenceQuery(
+	ctx context.Context, conn *gws.Conn, username string, query websocket.PayloadPresenceQuery,
+) error {
+	if len(query.Usernames) > websocket.MaxPresenceQueryUsernames {
+		return &entity.APIError{
+			Code:    entity.ErrorCodeInvalidRequest,
+			Message: fmt.Sprintf("at most %d users can be queried at once", websocket.MaxPresenceQueryUsernames),
+		}
+	}
+
+	contacts, err := app.repo.GetMutualContacts(ctx, username, query.Usernames)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+	err = app.repo.WatchPresence(ctx, username, contacts)
+	if err != nil {
+		return fmt.Errorf("failed to watch presence: %w", err)
+	}
+
+	users := make([]entity.Presence, 0, len(query.Usernames))
+	for _, queried := range query.Usernames {
+		presence := entity.Presence{Username: queried}
+		if !slices.Contains(contacts, queried) {
+			users = append(users, presence)
+			continue
+		}
+		presence.Online, err = app.hub.IsOnline(ctx, queried)
+		if err != nil {
+			return err
+		}
+		if !presence.Online {
+			presence.LastSeen, err = app.repo.GetLastSeen(ctx, queried)
+			if err != nil {
+				return fmt.Errorf("failed to get last seen: %w", err)
+			}
+		}
+		users = append(users, presence)
+	}
+
+	msgData, err := json.Marshal(websocket.Msg{
+		Type:    websocket.MsgTypePresence,
+		Payload: websocket.PayloadPresence{Users: users},
+	})
+	if err != nil {
+		return err
+	}
+	return app.hub.Reply(username, conn, msgData)
+}
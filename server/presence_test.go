@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	gws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+func TestRelayTyping(t *testing.T) {
+	tooMany := make([]string, websocket.MaxTypingRecipients+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("user%d", i)
+	}
+
+	tt := map[string]struct {
+		recipients []string
+		// wantCode is the code of the error sent in reply, or empty if the request should be acknowledged.
+		wantCode entity.ErrorCode
+		// wantReceived is whether each of the other users should be told that alice is typing.
+		wantReceived map[string]bool
+	}{
+		"mutual contact": {
+			recipients:   []string{"bob"},
+			wantReceived: map[string]bool{"bob": true, "carol": false, "dave": false},
+		},
+		"not a contact": {
+			recipients:   []string{"carol"},
+			wantReceived: map[string]bool{"bob": false, "carol": false, "dave": false},
+		},
+		"one-sided contact": {
+			recipients:   []string{"dave"},
+			wantReceived: map[string]bool{"bob": false, "carol": false, "dave": false},
+		},
+		"mixed recipients": {
+			recipients:   []string{"bob", "carol", "dave"},
+			wantReceived: map[string]bool{"bob": true, "carol": false, "dave": false},
+		},
+		"too many recipients": {
+			recipients:   tooMany,
+			wantCode:     entity.ErrorCodeInvalidRequest,
+			wantReceived: map[string]bool{"bob": false, "carol": false, "dave": false},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			app := newTestApp(t)
+			url := serveApp(t, app)
+
+			// Alice and bob have messaged each other, while only dave has messaged alice.
+			require.NoError(t, app.repo.RecordContacts(ctx, "alice", []string{"bob"}))
+			require.NoError(t, app.repo.RecordContacts(ctx, "bob", []string{"alice"}))
+			require.NoError(t, app.repo.RecordContacts(ctx, "dave", []string{"alice"}))
+
+			alice := dialWebSocket(t, url, signIn(t, app, "alice"))
+			others := make(map[string]*gws.Conn, len(tc.wantReceived))
+			for username := range tc.wantReceived {
+				others[username] = dialWebSocket(t, url, signIn(t, app, username))
+			}
+
+			reply := request(t, alice, websocket.Msg{
+				Type:      websocket.MsgTypeTyping,
+				RequestID: "typing",
+				Payload: websocket.PayloadTyping{
+					ConversationID: uuid.New(),
+					Username:       "mallory",
+					Typing:         true,
+					Recipients:     tc.recipients,
+				},
+			})
+			if tc.wantCode != "" {
+				require.Equal(t, websocket.MsgTypeError, reply.Type)
+				assert.Equal(t, tc.wantCode, reply.Payload.(websocket.PayloadError).Code)
+			} else {
+				assert.Equal(t, websocket.MsgTypeAck, reply.Type)
+			}
+
+			for username, wantReceived := range tc.wantReceived {
+				msg, ok := readMsg(t, others[username], 100*time.Millisecond)
+				require.Equal(t, wantReceived, ok, username)
+				if ok {
+					require.Equal(t, websocket.MsgTypeTyping, msg.Type)
+					assert.Equal(t, "alice", msg.Payload.(websocket.PayloadTyping).Username)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+	"github.com/Broderick-Westrope/teatime/server/internal/db"
+)
+
+// relayReceipt sends a delivered or read receipt from the user to the author of the messages.
+// Receipts are queued like chat messages so that the author learns about them when they next connect.
+// Receipts are only relayed between users who have messaged each other, and only for messages which the
+// server recorded as sent by the recipient. Other receipts, and message IDs, are dropped without an error.
+func (app *application) relayReceipt(ctx context.Context, username string, payload websocket.PayloadReceipt) error {
+	if payload.Status != entity.MessageStatusDelivered && payload.Status != entity.MessageStatusRead {
+		return &entity.APIError{Code: entity.ErrorCodeInvalidRequest, Message: "receipt status must be delivered or read"}
+	}
+	if payload.Recipient == "" || payload.Recipient == username || len(payload.MessageIDs) == 0 {
+		return nil
+	}
+
+	contacts, err := app.repo.GetMutualContacts(ctx, username, []string{payload.Recipient})
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+	if len(contacts) == 0 {
+		app.log.DebugContext(ctx, "dropping receipt for a user who is not a contact",
+			slog.String("username", username), slog.String("recipient", payload.Recipient))
+		return nil
+	}
+
+	messageIDs := make([]uuid.UUID, 0, len(payload.MessageIDs))
+	for _, messageID := range payload.MessageIDs {
+		author, err := app.repo.GetMessageAuthor(ctx, payload.ConversationID.String(), messageID.String())
+		if errors.Is(err, db.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get message author: %w", err)
+		}
+		if author == payload.Recipient {
+			messageIDs = append(messageIDs, messageID)
+		}
+	}
+	if len(messageIDs) < len(payload.MessageIDs) {
+		app.log.DebugContext(ctx, "dropping receipts for messages which were not sent by the recipient",
+			slog.String("username", username), slog.String("recipient", payload.Recipient),
+			slog.Int("dropped", len(payload.MessageIDs)-len(messageIDs)))
+	}
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	// The username is set here so that users cannot send receipts on behalf of someone else.
+	payload.Username = username
+	payload.MessageIDs = messageIDs
+	msgData, err := json.Marshal(websocket.Msg{Type: websocket.MsgTypeReceipt, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return app.hub.Send(ctx, msgData, []string{payload.Recipient})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+func TestRelayReceipt(t *testing.T) {
+	conversationID := uuid.New()
+	// Alice wrote the first two messages and bob wrote the third.
+	messageIDs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+
+	tt := map[string]struct {
+		sender     string
+		messageIDs []uuid.UUID
+		// wantMessageIDs are those in the receipt sent to alice, or nil if no receipt should be sent.
+		wantMessageIDs []uuid.UUID
+	}{
+		"messages by the recipient": {
+			sender:         "bob",
+			messageIDs:     messageIDs[:2],
+			wantMessageIDs: messageIDs[:2],
+		},
+		"some messages by someone else": {
+			sender:         "bob",
+			messageIDs:     messageIDs,
+			wantMessageIDs: messageIDs[:2],
+		},
+		"messages by someone else": {
+			sender:     "bob",
+			messageIDs: messageIDs[2:],
+		},
+		"unknown message": {
+			sender:     "bob",
+			messageIDs: []uuid.UUID{uuid.New()},
+		},
+		"not a contact": {
+			sender:     "carol",
+			messageIDs: messageIDs[:2],
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			app := newTestApp(t)
+			url := serveApp(t, app)
+
+			// Alice and bob have messaged each other, while carol has only messaged alice.
+			require.NoError(t, app.repo.RecordContacts(ctx, "alice", []string{"bob"}))
+			require.NoError(t, app.repo.RecordContacts(ctx, "bob", []string{"alice"}))
+			require.NoError(t, app.repo.RecordContacts(ctx, "carol", []string{"alice"}))
+			for i, author := range []string{"alice", "alice", "bob"} {
+				require.NoError(t, app.repo.RecordMessageAuthor(ctx,
+					conversationID.String(), messageIDs[i].String(), author))
+			}
+
+			sender := dialWebSocket(t, url, signIn(t, app, tc.sender))
+			alice := dialWebSocket(t, url, signIn(t, app, "alice"))
+
+			reply := request(t, sender, websocket.Msg{
+				Type:      websocket.MsgTypeReceipt,
+				RequestID: "receipt",
+				Payload: websocket.PayloadReceipt{
+					ConversationID: conversationID,
+					MessageIDs:     tc.messageIDs,
+					Status:         entity.MessageStatusRead,
+					Recipient:      "alice",
+				},
+			})
+			assert.Equal(t, websocket.MsgTypeAck, reply.Type)
+
+			msg, ok := readMsg(t, alice, 100*time.Millisecond)
+			require.Equal(t, tc.wantMessageIDs != nil, ok)
+			if ok {
+				require.Equal(t, websocket.MsgTypeReceipt, msg.Type)
+				receipt := msg.Payload.(websocket.PayloadReceipt)
+				assert.Equal(t, tc.sender, receipt.Username)
+				assert.Equal(t, tc.wantMessageIDs, receipt.MessageIDs)
+			}
+		})
+	}
+}
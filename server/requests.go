@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	gws "github.com/gorilla/websocket"
+
+	"github.com/Broderick-Westrope/teatime/internal/entity"
+	"github.com/Broderick-Westrope/teatime/internal/websocket"
+)
+
+// handleWebSocketRequest handles a message from the user and answers it with an ack or an error.
+// Messages without a request ID are from older clients which do not understand these answers, so none is sent.
+// Retried requests which have already been handled are acknowledged again without being handled twice,
+// except for those which are answered with more than an ack since it may be that answer which was lost.
+// Messages which reached only some of their recipients are acknowledged, since retrying them would duplicate them.
+func (app *application) handleWebSocketRequest(ctx context.Context, conn *gws.Conn, username string, msgData []byte) {
+	var msg websocket.Msg
+	err := json.Unmarshal(msgData, &msg)
+	if err != nil {
+		app.log.ErrorContext(ctx, "error unmarshalling JSON",
+			slog.String("username", username), slog.Any("error", err))
+
+		code := entity.ErrorCodeInvalidMessage
+		if errors.Is(err, websocket.ErrUnknownMsgType) {
+			code = entity.ErrorCodeUnknownMessageType
+		}
+		app.replyError(ctx, conn, username, msg.RequestID, &entity.APIError{Code: code, Message: err.Error()})
+		return
+	}
+
+	if msg.RequestID != "" && !hasAnswer(msg.Payload) {
+		isNew, err := app.repo.ClaimRequest(ctx, username, msg.RequestID)
+		if err != nil {
+			app.log.ErrorContext(ctx, "failed to claim request",
+				slog.String("username", username), slog.Any("error", err))
+			app.replyError(ctx, conn, username, msg.RequestID, err)
+			return
+		}
+		if !isNew {
+			app.replyAck(ctx, conn, username, msg.RequestID)
+			return
+		}
+	}
+
+	err = app.handleWebSocketMessage(ctx, conn, username, msg, msgData)
+	if errors.Is(err, websocket.ErrPartialDelivery) {
+		// Some recipients have the message, so it is acknowledged rather than released to be retried,
+		// which would deliver it to them twice.
+		app.log.WarnContext(ctx, "message was only delivered to some recipients",
+			slog.String("username", username), slog.Int("type", int(msg.Type)), slog.Any("error", err))
+		app.replyAck(ctx, conn, username, msg.RequestID)
+		return
+	}
+	if err != nil {
+		app.log.ErrorContext(ctx, "failed to handle message",
+			slog.String("username", username), slog.Int("type", int(msg.Type)), slog.Any("error", err))
+
+		if msg.RequestID != "" && !hasAnswer(msg.Payload) {
+			// The request is released so that it is handled if the client retries it.
+			releaseErr := app.repo.ReleaseRequest(ctx, username, msg.RequestID)
+			if releaseErr != nil {
+				app.log.ErrorContext(ctx, "failed to release request",
+					slog.String("username", username), slog.Any("error", releaseErr))
+			}
+		}
+		app.replyError(ctx, conn, username, msg.RequestID, err)
+		return
+	}
+	app.replyAck(ctx, conn, username, msg.RequestID)
+}
+
+// handleWebSocketMessage performs the action requested by the message. Problems with the message itself
+// are returned as an *entity.APIError, and any other error means the server failed to handle it.
+func (app *application) handleWebSocketMessage(
+	ctx context.Context, conn *gws.Conn, username string, msg websocket.Msg, msgData []byte,
+) error {
+	switch payload := msg.Payload.(type) {
+	case websocket.PayloadSendChatMessage:
+		return app.relayChatMessage(ctx, username, payload, msgData)
+
+	case websocket.PayloadEditMessage:
+		return app.relayEdit(ctx, username, payload)
+
+	case websocket.PayloadDeleteMessage:
+		return app.relayDelete(ctx, username, payload)
+
+	case websocket.PayloadReaction:
+		return app.relayReaction(ctx, username, payload)
+
+	case websocket.PayloadReceipt:
+		return app.relayReceipt(ctx, username, payload)
+
+	case websocket.PayloadTyping:
+		return app.relayTyping(ctx, username, payload)
+
+	case websocket.PayloadAttachmentUpload:
+		return app.startUpload(ctx, conn, username, payload)
+
+	case websocket.PayloadAttachmentChunk:
+		return app.saveChunk(ctx, username, payload)
+
+	case websocket.PayloadAttachmentChunkQuery:
+		return app.sendChunk(ctx, conn, username, payload)
+
+	case websocket.PayloadPresenceQuery:
+		err := app.answerPresenceQuery(ctx, conn, username, payload)
+		if err != nil {
+			return fmt.Errorf("failed to answer presence query: %w", err)
+		}
+		return nil
+
+	default:
+		return &entity.APIError{
+			Code:    entity.ErrorCodeUnknownMessageType,
+			Message: fmt.Sprintf("message type %d cannot be sent by clients", msg.Type),
+		}
+	}
+}
+
+// hasAnswer reports whether requests with the payload are answered with more than an ack.
+// Handling these requests again has no effect besides sending the answer again.
+func hasAnswer(payload websocket.MsgPayload) bool {
+	switch payload.(type) {
+	case websocket.PayloadAttachmentUpload, websocket.PayloadAttachmentChunkQuery:
+		return true
+	default:
+		return false
+	}
+}
+
+// replyAck tells the connection that its request was handled.
+func (app *application) replyAck(ctx context.Context, conn *gws.Conn, username, requestID string) {
+	if requestID == "" {
+		return
+	}
+	app.reply(ctx, conn, username, websocket.Msg{
+		Type:      websocket.MsgTypeAck,
+		RequestID: requestID,
+		Payload:   websocket.PayloadAck{},
+	})
+}
+
+// replyError tells the connection why its request could not be handled. Errors which are not an
+// *entity.APIError are reported as internal errors without any details.
+func (app *application) replyError(ctx context.Context, conn *gws.Conn, username, requestID string, err error) {
+	if requestID == "" {
+		return
+	}
+
+	var apiErr *entity.APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = &entity.APIError{Code: entity.ErrorCodeInternal, Message: "the server failed to handle the message"}
+	}
+	app.reply(ctx, conn, username, websocket.Msg{
+		Type:      websocket.MsgTypeError,
+		RequestID: requestID,
+		Payload:   websocket.PayloadError{Code: apiErr.Code, Message: apiErr.Message},
+	})
+}
+
+func (app *application) reply(ctx context.Context, conn *gws.Conn, username string, msg websocket.Msg) {
+	msgData, err := json.Marshal(msg)
+	if err != nil {
+		app.log.ErrorContext(ctx, "failed to marshal reply",
+			slog.String("username", username), slog.Any("error", err))
+		return
+	}
+	err = app.hub.Reply(username, conn, msgData)
+	if err != nil {
+		app.log.ErrorContext(ctx, "failed to send reply",
+			slog.String("username", username), slog.Any("error", err))
+	}
+}